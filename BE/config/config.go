@@ -0,0 +1,654 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Environment variables recognised by Load
+// Each one overrides the matching value from the configuration file
+const (
+	EnvConfigFile      = "GOMONGOVIZ_CONFIG"
+	EnvStorage         = "GOMONGOVIZ_STORAGE"
+	EnvStorageFixture  = "GOMONGOVIZ_STORAGE_FIXTURE"
+	EnvStoragePath     = "GOMONGOVIZ_STORAGE_PATH"
+	EnvServerAddr      = "GOMONGOVIZ_SERVER_ADDR"
+	EnvAllowedOrigins  = "GOMONGOVIZ_ALLOWED_ORIGINS"
+	EnvMongoURI        = "GOMONGOVIZ_MONGO_URI"
+	EnvMongoUsername   = "GOMONGOVIZ_MONGO_USERNAME"
+	EnvMongoPassword   = "GOMONGOVIZ_MONGO_PASSWORD"
+	EnvMongoDatabase   = "GOMONGOVIZ_MONGO_DATABASE"
+	EnvMongoCollection = "GOMONGOVIZ_MONGO_COLLECTION"
+	EnvMongoTimeSeries = "GOMONGOVIZ_MONGO_TIME_SERIES"
+	EnvMongoGranular   = "GOMONGOVIZ_MONGO_GRANULARITY"
+	EnvQueryTimeout    = "GOMONGOVIZ_MONGO_QUERY_TIMEOUT"
+	EnvAggTimeout      = "GOMONGOVIZ_MONGO_AGGREGATE_TIMEOUT"
+	EnvWriteTimeout    = "GOMONGOVIZ_MONGO_WRITE_TIMEOUT"
+	EnvExportTimeout   = "GOMONGOVIZ_MONGO_EXPORT_TIMEOUT"
+	EnvImportBatchSize = "GOMONGOVIZ_IMPORT_BATCH_SIZE"
+	EnvImportQueue     = "GOMONGOVIZ_IMPORT_QUEUE_DEPTH"
+	EnvImportWorkers   = "GOMONGOVIZ_IMPORT_WORKERS"
+	EnvImportSpoolDir  = "GOMONGOVIZ_IMPORT_SPOOL_DIR"
+	EnvStreamChanges   = "GOMONGOVIZ_STREAM_CHANGE_STREAMS"
+	EnvStreamPoll      = "GOMONGOVIZ_STREAM_POLL_INTERVAL"
+	EnvStreamHeartbeat = "GOMONGOVIZ_STREAM_HEARTBEAT"
+	EnvStreamBuffer    = "GOMONGOVIZ_STREAM_CLIENT_BUFFER"
+	EnvAlertsInterval  = "GOMONGOVIZ_ALERTS_INTERVAL"
+	EnvAnomalyFields   = "GOMONGOVIZ_ANOMALIES_FIELDS"
+	EnvAnomalyMethod   = "GOMONGOVIZ_ANOMALIES_METHOD"
+	EnvIngestBatchSize = "GOMONGOVIZ_INGEST_BATCH_SIZE"
+	EnvIngestFlush     = "GOMONGOVIZ_INGEST_FLUSH_INTERVAL"
+	EnvIngestBuffer    = "GOMONGOVIZ_INGEST_BUFFER_SIZE"
+	EnvMQTTEnabled     = "GOMONGOVIZ_MQTT_ENABLED"
+	EnvMQTTBroker      = "GOMONGOVIZ_MQTT_BROKER"
+	EnvMQTTClientID    = "GOMONGOVIZ_MQTT_CLIENT_ID"
+	EnvMQTTUsername    = "GOMONGOVIZ_MQTT_USERNAME"
+	EnvMQTTPassword    = "GOMONGOVIZ_MQTT_PASSWORD"
+	EnvMQTTTopics      = "GOMONGOVIZ_MQTT_TOPICS"
+	EnvWebhookAttempts = "GOMONGOVIZ_WEBHOOKS_MAX_ATTEMPTS"
+	EnvWebhookTimeout  = "GOMONGOVIZ_WEBHOOKS_TIMEOUT"
+	EnvDerivedMaxGap   = "GOMONGOVIZ_DERIVED_MAX_GAP"
+)
+
+// Config holds all runtime settings of the backend
+// Values are resolved in the order defaults < config file < environment < flags
+type Config struct {
+	Server    ServerConfig    `yaml:"server" toml:"server"`       // HTTP server settings
+	Storage   StorageConfig   `yaml:"storage" toml:"storage"`     // Storage backend selection
+	Mongo     MongoConfig     `yaml:"mongo" toml:"mongo"`         // MongoDB connection settings
+	Import    ImportConfig    `yaml:"import" toml:"import"`       // Streaming import settings
+	Stream    StreamConfig    `yaml:"stream" toml:"stream"`       // Live data streaming settings
+	Alerts    AlertsConfig    `yaml:"alerts" toml:"alerts"`       // Alert rule evaluation settings
+	Anomalies AnomaliesConfig `yaml:"anomalies" toml:"anomalies"` // Anomaly detection on saved readings
+	Ingest    IngestConfig    `yaml:"ingest" toml:"ingest"`       // Device ingestion settings
+	MQTT      MQTTConfig      `yaml:"mqtt" toml:"mqtt"`           // Optional MQTT subscriber feeding device ingestion
+	Webhooks  WebhooksConfig  `yaml:"webhooks" toml:"webhooks"`   // Notifications posted to other systems
+	Derived   DerivedConfig   `yaml:"derived" toml:"derived"`     // Metrics computed from the stored fields
+}
+
+// Storage backends selectable with storage.backend
+const (
+	StorageMongo  = "mongo"  // MongoDB, configured in the mongo section
+	StorageMemory = "memory" // In-memory storage, lost when the server stops
+	StorageBolt   = "bolt"   // Embedded bbolt database in a single local file
+)
+
+// StorageBackends lists the accepted values of storage.backend
+var StorageBackends = []string{StorageMongo, StorageMemory, StorageBolt}
+
+// StorageConfig selects where sensor data and import jobs are stored
+type StorageConfig struct {
+	Backend string `yaml:"backend" toml:"backend"` // One of the Storage* backends
+	Fixture string `yaml:"fixture" toml:"fixture"` // Optional CSV or JSON file loaded into the storage at startup
+	Path    string `yaml:"path" toml:"path"`       // Database file of the bolt backend
+}
+
+// ServerConfig holds the settings of the HTTP server
+type ServerConfig struct {
+	Addr           string   `yaml:"addr" toml:"addr"`                       // Listen address, e.g. ":8080"
+	AllowedOrigins []string `yaml:"allowed_origins" toml:"allowed_origins"` // CORS origins allowed to call the API
+}
+
+// MongoConfig holds the settings used to reach the sensor data collection
+type MongoConfig struct {
+	URI                  string `yaml:"uri" toml:"uri"`                                     // Connection string, e.g. "mongodb+srv://cluster.example.net"
+	Username             string `yaml:"username" toml:"username"`                           // Optional user, applied on top of the URI
+	Password             string `yaml:"password" toml:"password"`                           // Optional password, applied on top of the URI
+	Database             string `yaml:"database" toml:"database"`                           // Database holding the sensor data
+	Collection           string `yaml:"collection" toml:"collection"`                       // Collection holding the sensor data
+	JobsCollection       string `yaml:"jobs_collection" toml:"jobs_collection"`             // Collection holding import job records
+	RulesCollection      string `yaml:"rules_collection" toml:"rules_collection"`           // Collection holding alert rules
+	AlertsCollection     string `yaml:"alerts_collection" toml:"alerts_collection"`         // Collection holding the state of every alert
+	EventsCollection     string `yaml:"events_collection" toml:"events_collection"`         // Collection holding alert firing and resolving events
+	DeliveriesCollection string `yaml:"deliveries_collection" toml:"deliveries_collection"` // Collection holding webhook deliveries
+	FieldsCollection     string `yaml:"fields_collection" toml:"fields_collection"`         // Collection holding user-defined computed fields
+	BaselinesCollection  string `yaml:"baselines_collection" toml:"baselines_collection"`   // Collection holding the anomaly baselines of every port and field
+	AnomaliesCollection  string `yaml:"anomalies_collection" toml:"anomalies_collection"`   // Collection holding the readings flagged as anomalies
+	TimeSeries           bool   `yaml:"time_series" toml:"time_series"`                     // Store the sensor data in a native time-series collection
+	Granularity          string `yaml:"granularity" toml:"granularity"`                     // Time-series bucket granularity: seconds, minutes or hours
+
+	// Deadlines of single database operations, e.g. "15s"; zero means no deadline
+	// Operations also stop as soon as the HTTP request that started them is cancelled
+	QueryTimeout     time.Duration `yaml:"query_timeout" toml:"query_timeout"`         // Finds, listings and lookups
+	AggregateTimeout time.Duration `yaml:"aggregate_timeout" toml:"aggregate_timeout"` // Aggregation pipelines
+	WriteTimeout     time.Duration `yaml:"write_timeout" toml:"write_timeout"`         // Each insert batch, job update or index build
+	ExportTimeout    time.Duration `yaml:"export_timeout" toml:"export_timeout"`       // Whole streaming exports
+}
+
+// Granularities accepted for time-series collections
+var Granularities = []string{"seconds", "minutes", "hours"}
+
+// ImportConfig holds the settings of streaming file imports
+type ImportConfig struct {
+	BatchSize     int    `yaml:"batch_size" toml:"batch_size"`           // Rows written per insert
+	QueueDepth    int    `yaml:"queue_depth" toml:"queue_depth"`         // Parsed batches waiting for insert before reading pauses
+	Workers       int    `yaml:"workers" toml:"workers"`                 // Import jobs processed concurrently
+	MaxQueuedJobs int    `yaml:"max_queued_jobs" toml:"max_queued_jobs"` // Jobs waiting for a worker before new ones are refused
+	SpoolDir      string `yaml:"spool_dir" toml:"spool_dir"`             // Directory holding uploaded files until their job has run
+}
+
+// StreamConfig holds the settings of live data streams
+type StreamConfig struct {
+	ChangeStreams bool          `yaml:"change_streams" toml:"change_streams"` // Use MongoDB change streams when the deployment supports them
+	PollInterval  time.Duration `yaml:"poll_interval" toml:"poll_interval"`   // Delay between queries for new records when polling
+	Heartbeat     time.Duration `yaml:"heartbeat" toml:"heartbeat"`           // Delay between keep-alive messages on idle streams
+
+	ClientBuffer     int `yaml:"client_buffer" toml:"client_buffer"`         // Records queued for a WebSocket client before it is dropped as too slow
+	MaxSubscriptions int `yaml:"max_subscriptions" toml:"max_subscriptions"` // Subscriptions a WebSocket client may hold at once
+}
+
+// AlertsConfig holds the settings of alert rule evaluation
+type AlertsConfig struct {
+	Interval time.Duration `yaml:"interval" toml:"interval"` // Delay between evaluations of the data stored by other means than the service
+}
+
+// AnomalyMethods accepted for anomaly detection
+var AnomalyMethods = []string{"zscore", "ewma", "mad"}
+
+// AnomaliesConfig holds the settings of the anomaly detection run on saved readings
+type AnomaliesConfig struct {
+	Fields    []string `yaml:"fields" toml:"fields"`       // Numeric fields scored as readings are saved; none disables the detection
+	Method    string   `yaml:"method" toml:"method"`       // Baseline of each port and field: zscore, ewma or mad
+	Window    int      `yaml:"window" toml:"window"`       // Readings in the rolling baselines, or the span of the EWMA
+	Threshold float64  `yaml:"threshold" toml:"threshold"` // Absolute score above which a reading is flagged; 0 uses the usual cut-off of the method
+}
+
+// IngestConfig holds the settings of the buffer between device ingestion and storage
+type IngestConfig struct {
+	BatchSize     int           `yaml:"batch_size" toml:"batch_size"`         // Records written per insert
+	FlushInterval time.Duration `yaml:"flush_interval" toml:"flush_interval"` // Longest delay before buffered records are written
+	BufferSize    int           `yaml:"buffer_size" toml:"buffer_size"`       // Records waiting for insert before new ones are refused
+}
+
+// MQTTConfig holds the settings of the optional MQTT subscriber
+// Received readings go through the same buffer as the ingest endpoint
+type MQTTConfig struct {
+	Enabled  bool   `yaml:"enabled" toml:"enabled"`     // Subscribe to the broker at startup
+	Broker   string `yaml:"broker" toml:"broker"`       // Broker URL, e.g. "tcp://localhost:1883" or "ssl://broker:8883"
+	ClientID string `yaml:"client_id" toml:"client_id"` // Client ID of the persistent session kept by the broker
+	Username string `yaml:"username" toml:"username"`   // Optional user
+	Password string `yaml:"password" toml:"password"`   // Optional password
+	QoS      int    `yaml:"qos" toml:"qos"`             // Subscription quality of service: 0, 1 or 2
+
+	// Topic filters to subscribe to, e.g. "sites/+/object/{object_id}/port/{port_num}"
+	// A {field} level matches like + and sets that field of the reading from the topic
+	Topics []string `yaml:"topics" toml:"topics"`
+	// Payload keys renamed to SensorData fields, e.g. {"v": "voltage", "ts": "timestamp"}
+	// Keys that are not mapped are read under their own name
+	Fields map[string]string `yaml:"fields" toml:"fields"`
+}
+
+// WebhooksConfig holds the settings of webhook notifications
+// Deliveries that fail are retried with an exponential backoff from InitialBackoff,
+// doubling up to MaxBackoff, until MaxAttempts attempts have failed
+type WebhooksConfig struct {
+	Endpoints      []WebhookEndpoint `yaml:"endpoints" toml:"endpoints"`             // Receivers of the notifications
+	MaxAttempts    int               `yaml:"max_attempts" toml:"max_attempts"`       // Attempts before a delivery is dead-lettered
+	InitialBackoff time.Duration     `yaml:"initial_backoff" toml:"initial_backoff"` // Delay before the second attempt
+	MaxBackoff     time.Duration     `yaml:"max_backoff" toml:"max_backoff"`         // Longest delay between attempts
+	Timeout        time.Duration     `yaml:"timeout" toml:"timeout"`                 // Deadline of each attempt
+	Workers        int               `yaml:"workers" toml:"workers"`                 // Attempts made at the same time
+}
+
+// WebhookEndpoint is a URL that notifications are posted to
+type WebhookEndpoint struct {
+	Name   string   `yaml:"name" toml:"name"`     // Label of the endpoint in the delivery history
+	URL    string   `yaml:"url" toml:"url"`       // http or https URL receiving the POST requests
+	Secret string   `yaml:"secret" toml:"secret"` // Key of the HMAC-SHA256 signature; requests are not signed when empty
+	Events []string `yaml:"events" toml:"events"` // Events sent to the endpoint, every event when empty
+}
+
+// DerivedConfig holds the settings of the metrics computed from the stored fields
+type DerivedConfig struct {
+	// Longest time between two readings of a port that energy and charge are integrated
+	// over; a longer gap adds nothing, as the port was probably off. Zero integrates any gap
+	MaxGap time.Duration `yaml:"max_gap" toml:"max_gap"`
+}
+
+// WebhookEvents lists the events that endpoints may subscribe to
+var WebhookEvents = []string{"alert.firing", "alert.resolved", "import.succeeded", "import.failed"}
+
+// Default returns the configuration used when nothing else is provided
+// It points at a local MongoDB instance and keeps the historical database and collection names
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:           ":8080",
+			AllowedOrigins: []string{"*"},
+		},
+		Storage: StorageConfig{
+			Backend: StorageMongo,
+			Path:    "gomongoviz.db",
+		},
+		Mongo: MongoConfig{
+			URI:                  "mongodb://localhost:27017",
+			Database:             "gomongoviz",
+			Collection:           "sensor_data",
+			JobsCollection:       "import_jobs",
+			RulesCollection:      "alert_rules",
+			AlertsCollection:     "alerts",
+			EventsCollection:     "alert_events",
+			DeliveriesCollection: "webhook_deliveries",
+			FieldsCollection:     "computed_fields",
+			BaselinesCollection:  "anomaly_baselines",
+			AnomaliesCollection:  "anomalies",
+			Granularity:          "seconds",
+
+			QueryTimeout:     15 * time.Second,
+			AggregateTimeout: time.Minute,
+			WriteTimeout:     time.Minute,
+		},
+		Import: ImportConfig{
+			BatchSize:     1000,
+			QueueDepth:    2,
+			Workers:       2,
+			MaxQueuedJobs: 100,
+			SpoolDir:      filepath.Join(os.TempDir(), "gomongoviz-imports"),
+		},
+		Stream: StreamConfig{
+			ChangeStreams: true,
+			PollInterval:  2 * time.Second,
+			Heartbeat:     15 * time.Second,
+
+			ClientBuffer:     256,
+			MaxSubscriptions: 100,
+		},
+		Alerts: AlertsConfig{
+			Interval: 30 * time.Second,
+		},
+		Anomalies: AnomaliesConfig{
+			Fields: []string{"voc", "supply_volt"},
+			Method: "zscore",
+			Window: 60,
+		},
+		Ingest: IngestConfig{
+			BatchSize:     500,
+			FlushInterval: time.Second,
+			BufferSize:    50000,
+		},
+		MQTT: MQTTConfig{
+			Broker:   "tcp://localhost:1883",
+			ClientID: "gomongoviz",
+			QoS:      1,
+		},
+		Webhooks: WebhooksConfig{
+			MaxAttempts:    8,
+			InitialBackoff: 5 * time.Second,
+			MaxBackoff:     10 * time.Minute,
+			Timeout:        10 * time.Second,
+			Workers:        4,
+		},
+		Derived: DerivedConfig{
+			MaxGap: 5 * time.Minute,
+		},
+	}
+}
+
+// Load builds the configuration from defaults, an optional YAML/TOML file,
+// environment variables and the given command-line arguments, then validates it
+// The configuration file is selected with -config or GOMONGOVIZ_CONFIG
+func Load(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("gomongoviz", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv(EnvConfigFile), "path to a YAML or TOML configuration file")
+	addr := fs.String("addr", "", "HTTP listen address")
+	origins := fs.String("allowed-origins", "", "comma separated list of allowed CORS origins")
+	storage := fs.String("storage", "", "storage backend: mongo, memory or bolt")
+	fixture := fs.String("fixture", "", "CSV or JSON file loaded into the storage at startup")
+	storagePath := fs.String("storage-path", "", "database file of the bolt storage backend")
+	mongoURI := fs.String("mongo-uri", "", "MongoDB connection string")
+	mongoUser := fs.String("mongo-username", "", "MongoDB user name")
+	mongoPass := fs.String("mongo-password", "", "MongoDB password")
+	mongoDB := fs.String("mongo-database", "", "MongoDB database name")
+	mongoColl := fs.String("mongo-collection", "", "MongoDB collection name")
+	timeSeries := fs.Bool("mongo-time-series", false, "store sensor data in a MongoDB time-series collection")
+	batchSize := fs.Int("import-batch-size", 0, "rows written per insert during imports")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+
+	// Apply the configuration file first so that env and flags can override it
+	if *configFile != "" {
+		if err := loadFile(*configFile, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := applyEnv(cfg); err != nil {
+		return nil, err
+	}
+
+	// Only flags that were explicitly set take precedence over earlier sources
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			cfg.Server.Addr = *addr
+		case "allowed-origins":
+			cfg.Server.AllowedOrigins = splitList(*origins)
+		case "storage":
+			cfg.Storage.Backend = *storage
+		case "fixture":
+			cfg.Storage.Fixture = *fixture
+		case "storage-path":
+			cfg.Storage.Path = *storagePath
+		case "mongo-uri":
+			cfg.Mongo.URI = *mongoURI
+		case "mongo-username":
+			cfg.Mongo.Username = *mongoUser
+		case "mongo-password":
+			cfg.Mongo.Password = *mongoPass
+		case "mongo-database":
+			cfg.Mongo.Database = *mongoDB
+		case "mongo-collection":
+			cfg.Mongo.Collection = *mongoColl
+		case "mongo-time-series":
+			cfg.Mongo.TimeSeries = *timeSeries
+		case "import-batch-size":
+			cfg.Import.BatchSize = *batchSize
+		}
+	})
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate checks that the configuration is complete and consistent
+// All problems are reported together so they can be fixed in one pass
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Server.Addr == "" {
+		errs = append(errs, errors.New("server.addr must not be empty"))
+	}
+	if len(c.Server.AllowedOrigins) == 0 {
+		errs = append(errs, errors.New("server.allowed_origins must contain at least one origin"))
+	}
+	if !slices.Contains(StorageBackends, c.Storage.Backend) {
+		errs = append(errs, fmt.Errorf("storage.backend must be one of %s", strings.Join(StorageBackends, ", ")))
+	}
+	if c.Storage.Backend == StorageBolt && c.Storage.Path == "" {
+		errs = append(errs, errors.New("storage.path must not be empty with the bolt backend"))
+	}
+	if c.Mongo.URI == "" {
+		errs = append(errs, errors.New("mongo.uri must not be empty"))
+	} else if !strings.HasPrefix(c.Mongo.URI, "mongodb://") && !strings.HasPrefix(c.Mongo.URI, "mongodb+srv://") {
+		errs = append(errs, errors.New("mongo.uri must start with mongodb:// or mongodb+srv://"))
+	}
+	if c.Mongo.Password != "" && c.Mongo.Username == "" {
+		errs = append(errs, errors.New("mongo.password is set but mongo.username is empty"))
+	}
+	if c.Mongo.Database == "" {
+		errs = append(errs, errors.New("mongo.database must not be empty"))
+	}
+	if c.Mongo.Collection == "" {
+		errs = append(errs, errors.New("mongo.collection must not be empty"))
+	}
+	if !slices.Contains(Granularities, c.Mongo.Granularity) {
+		errs = append(errs, fmt.Errorf("mongo.granularity must be one of %s", strings.Join(Granularities, ", ")))
+	}
+	for _, timeout := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"mongo.query_timeout", c.Mongo.QueryTimeout},
+		{"mongo.aggregate_timeout", c.Mongo.AggregateTimeout},
+		{"mongo.write_timeout", c.Mongo.WriteTimeout},
+		{"mongo.export_timeout", c.Mongo.ExportTimeout},
+	} {
+		if timeout.value < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative", timeout.name))
+		}
+	}
+	if c.Import.BatchSize <= 0 {
+		errs = append(errs, errors.New("import.batch_size must be positive"))
+	}
+	if c.Import.QueueDepth <= 0 {
+		errs = append(errs, errors.New("import.queue_depth must be positive"))
+	}
+	if c.Mongo.JobsCollection == "" {
+		errs = append(errs, errors.New("mongo.jobs_collection must not be empty"))
+	}
+	if c.Mongo.RulesCollection == "" || c.Mongo.AlertsCollection == "" || c.Mongo.EventsCollection == "" {
+		errs = append(errs, errors.New("mongo.rules_collection, mongo.alerts_collection and mongo.events_collection must not be empty"))
+	}
+	if c.Mongo.DeliveriesCollection == "" {
+		errs = append(errs, errors.New("mongo.deliveries_collection must not be empty"))
+	}
+	if c.Mongo.FieldsCollection == "" {
+		errs = append(errs, errors.New("mongo.fields_collection must not be empty"))
+	}
+	if c.Mongo.BaselinesCollection == "" || c.Mongo.AnomaliesCollection == "" {
+		errs = append(errs, errors.New("mongo.baselines_collection and mongo.anomalies_collection must not be empty"))
+	}
+	if c.Import.Workers <= 0 {
+		errs = append(errs, errors.New("import.workers must be positive"))
+	}
+	if c.Import.MaxQueuedJobs <= 0 {
+		errs = append(errs, errors.New("import.max_queued_jobs must be positive"))
+	}
+	if c.Import.SpoolDir == "" {
+		errs = append(errs, errors.New("import.spool_dir must not be empty"))
+	}
+	if c.Stream.PollInterval <= 0 {
+		errs = append(errs, errors.New("stream.poll_interval must be positive"))
+	}
+	if c.Stream.Heartbeat <= 0 {
+		errs = append(errs, errors.New("stream.heartbeat must be positive"))
+	}
+	if c.Stream.ClientBuffer <= 0 {
+		errs = append(errs, errors.New("stream.client_buffer must be positive"))
+	}
+	if c.Stream.MaxSubscriptions <= 0 {
+		errs = append(errs, errors.New("stream.max_subscriptions must be positive"))
+	}
+	if c.Alerts.Interval <= 0 {
+		errs = append(errs, errors.New("alerts.interval must be positive"))
+	}
+	if !slices.Contains(AnomalyMethods, c.Anomalies.Method) {
+		errs = append(errs, fmt.Errorf("anomalies.method must be one of %s", strings.Join(AnomalyMethods, ", ")))
+	}
+	if c.Anomalies.Window < 3 || c.Anomalies.Window > 1000 {
+		errs = append(errs, errors.New("anomalies.window must be between 3 and 1000"))
+	}
+	if c.Anomalies.Threshold < 0 {
+		errs = append(errs, errors.New("anomalies.threshold must not be negative"))
+	}
+	if c.Ingest.BatchSize <= 0 {
+		errs = append(errs, errors.New("ingest.batch_size must be positive"))
+	}
+	if c.Ingest.FlushInterval <= 0 {
+		errs = append(errs, errors.New("ingest.flush_interval must be positive"))
+	}
+	if c.Ingest.BufferSize < c.Ingest.BatchSize {
+		errs = append(errs, errors.New("ingest.buffer_size must be at least ingest.batch_size"))
+	}
+	if c.MQTT.Enabled {
+		if c.MQTT.Broker == "" {
+			errs = append(errs, errors.New("mqtt.broker must not be empty when mqtt.enabled is set"))
+		}
+		if c.MQTT.ClientID == "" {
+			errs = append(errs, errors.New("mqtt.client_id must not be empty when mqtt.enabled is set"))
+		}
+		if len(c.MQTT.Topics) == 0 {
+			errs = append(errs, errors.New("mqtt.topics must contain at least one topic when mqtt.enabled is set"))
+		}
+		if c.MQTT.Password != "" && c.MQTT.Username == "" {
+			errs = append(errs, errors.New("mqtt.password is set but mqtt.username is empty"))
+		}
+	}
+	if c.MQTT.QoS < 0 || c.MQTT.QoS > 2 {
+		errs = append(errs, errors.New("mqtt.qos must be 0, 1 or 2"))
+	}
+	names := make(map[string]bool)
+	for i, endpoint := range c.Webhooks.Endpoints {
+		if endpoint.Name == "" {
+			errs = append(errs, fmt.Errorf("webhooks.endpoints[%d].name must not be empty", i))
+		} else if names[endpoint.Name] {
+			errs = append(errs, fmt.Errorf("webhooks.endpoints[%d].name %q is used twice", i, endpoint.Name))
+		}
+		names[endpoint.Name] = true
+		if u, err := url.Parse(endpoint.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("webhooks.endpoints[%d].url must be an http or https URL", i))
+		}
+		for _, event := range endpoint.Events {
+			if !slices.Contains(WebhookEvents, event) {
+				errs = append(errs, fmt.Errorf("webhooks.endpoints[%d].events: unknown event %q, use %s", i, event, strings.Join(WebhookEvents, ", ")))
+			}
+		}
+	}
+	if c.Webhooks.MaxAttempts <= 0 {
+		errs = append(errs, errors.New("webhooks.max_attempts must be positive"))
+	}
+	if c.Webhooks.InitialBackoff <= 0 || c.Webhooks.MaxBackoff < c.Webhooks.InitialBackoff {
+		errs = append(errs, errors.New("webhooks.initial_backoff must be positive and webhooks.max_backoff at least as long"))
+	}
+	if c.Webhooks.Timeout <= 0 {
+		errs = append(errs, errors.New("webhooks.timeout must be positive"))
+	}
+	if c.Webhooks.Workers <= 0 {
+		errs = append(errs, errors.New("webhooks.workers must be positive"))
+	}
+	if c.Derived.MaxGap < 0 {
+		errs = append(errs, errors.New("derived.max_gap must not be negative"))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// loadFile decodes a YAML or TOML configuration file into cfg
+// The format is chosen from the file extension
+func loadFile(path string, cfg *Config) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, cfg)
+	case ".toml":
+		err = toml.Unmarshal(content, cfg)
+	default:
+		return fmt.Errorf("unsupported config file extension %q: use .yaml, .yml or .toml", filepath.Ext(path))
+	}
+	if err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyEnv overrides cfg with any GOMONGOVIZ_* environment variables that are set
+// It fails if a numeric, boolean or duration variable cannot be parsed
+func applyEnv(cfg *Config) error {
+	var errs []error
+	setString := func(key string, dst *string) {
+		if value, ok := os.LookupEnv(key); ok {
+			*dst = value
+		}
+	}
+	setInt := func(key string, dst *int) {
+		if value, ok := os.LookupEnv(key); ok {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s must be an integer: %w", key, err))
+				return
+			}
+			*dst = parsed
+		}
+	}
+	setBool := func(key string, dst *bool) {
+		if value, ok := os.LookupEnv(key); ok {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s must be true or false: %w", key, err))
+				return
+			}
+			*dst = parsed
+		}
+	}
+
+	setDuration := func(key string, dst *time.Duration) {
+		if value, ok := os.LookupEnv(key); ok {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s must be a duration such as 30s: %w", key, err))
+				return
+			}
+			*dst = parsed
+		}
+	}
+
+	setString(EnvServerAddr, &cfg.Server.Addr)
+	if value, ok := os.LookupEnv(EnvAllowedOrigins); ok {
+		cfg.Server.AllowedOrigins = splitList(value)
+	}
+	setString(EnvStorage, &cfg.Storage.Backend)
+	setString(EnvStorageFixture, &cfg.Storage.Fixture)
+	setString(EnvStoragePath, &cfg.Storage.Path)
+	setString(EnvMongoURI, &cfg.Mongo.URI)
+	setString(EnvMongoUsername, &cfg.Mongo.Username)
+	setString(EnvMongoPassword, &cfg.Mongo.Password)
+	setString(EnvMongoDatabase, &cfg.Mongo.Database)
+	setString(EnvMongoCollection, &cfg.Mongo.Collection)
+	setBool(EnvMongoTimeSeries, &cfg.Mongo.TimeSeries)
+	setString(EnvMongoGranular, &cfg.Mongo.Granularity)
+	setDuration(EnvQueryTimeout, &cfg.Mongo.QueryTimeout)
+	setDuration(EnvAggTimeout, &cfg.Mongo.AggregateTimeout)
+	setDuration(EnvWriteTimeout, &cfg.Mongo.WriteTimeout)
+	setDuration(EnvExportTimeout, &cfg.Mongo.ExportTimeout)
+	setInt(EnvImportBatchSize, &cfg.Import.BatchSize)
+	setInt(EnvImportQueue, &cfg.Import.QueueDepth)
+	setInt(EnvImportWorkers, &cfg.Import.Workers)
+	setString(EnvImportSpoolDir, &cfg.Import.SpoolDir)
+	setBool(EnvStreamChanges, &cfg.Stream.ChangeStreams)
+	setDuration(EnvStreamPoll, &cfg.Stream.PollInterval)
+	setDuration(EnvStreamHeartbeat, &cfg.Stream.Heartbeat)
+	setInt(EnvStreamBuffer, &cfg.Stream.ClientBuffer)
+	setDuration(EnvAlertsInterval, &cfg.Alerts.Interval)
+	if value, ok := os.LookupEnv(EnvAnomalyFields); ok {
+		cfg.Anomalies.Fields = splitList(value)
+	}
+	setString(EnvAnomalyMethod, &cfg.Anomalies.Method)
+	setInt(EnvIngestBatchSize, &cfg.Ingest.BatchSize)
+	setDuration(EnvIngestFlush, &cfg.Ingest.FlushInterval)
+	setInt(EnvIngestBuffer, &cfg.Ingest.BufferSize)
+	setBool(EnvMQTTEnabled, &cfg.MQTT.Enabled)
+	setString(EnvMQTTBroker, &cfg.MQTT.Broker)
+	setString(EnvMQTTClientID, &cfg.MQTT.ClientID)
+	setString(EnvMQTTUsername, &cfg.MQTT.Username)
+	setString(EnvMQTTPassword, &cfg.MQTT.Password)
+	if value, ok := os.LookupEnv(EnvMQTTTopics); ok {
+		cfg.MQTT.Topics = splitList(value)
+	}
+	setInt(EnvWebhookAttempts, &cfg.Webhooks.MaxAttempts)
+	setDuration(EnvWebhookTimeout, &cfg.Webhooks.Timeout)
+	setDuration(EnvDerivedMaxGap, &cfg.Derived.MaxGap)
+
+	return errors.Join(errs...)
+}
+
+// splitList turns a comma separated string into a slice, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -4,28 +4,28 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"net/url"
+
+	"gomongoviz/config"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// ConnectMongo establishes a connection to the MongoDB Atlas cluster
-// It configures the connection with the proper credentials and returns
+// ConnectMongo establishes a connection to the MongoDB cluster described by cfg
+// It applies the optional credentials on top of the connection string and returns
 // a connected MongoDB client that can be used throughout the application
-func ConnectMongo() *mongo.Client {
-	// Get credentials from environment variables
-	mongoUser := "Kumarane2000"
-	mongoPass := url.QueryEscape("Sathiya1981@") // Auto-encode special chars
-
-	// Construct the MongoDB connection string with proper formatting
-	mongoURI := fmt.Sprintf(
-		"mongodb+srv://%s:%s@gomongoviz.6omou9u.mongodb.net/?retryWrites=true&w=majority&appName=gomongoviz",
-		mongoUser, mongoPass,
-	)
-
-	// Configure client options and connect to MongoDB
-	clientOptions := options.Client().ApplyURI(mongoURI)
+func ConnectMongo(cfg config.MongoConfig) *mongo.Client {
+	// Configure client options from the connection string
+	clientOptions := options.Client().ApplyURI(cfg.URI)
+
+	// Credentials are kept out of the URI so special characters need no escaping
+	if cfg.Username != "" {
+		clientOptions.SetAuth(options.Credential{
+			Username: cfg.Username,
+			Password: cfg.Password,
+		})
+	}
+
 	client, err := mongo.Connect(context.TODO(), clientOptions)
 	if err != nil {
 		log.Fatal(err)
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gomongoviz/model"
+	"gomongoviz/repository"
+	"gomongoviz/service"
+
+	"github.com/gorilla/mux"
+)
+
+// ListAlertRules handles HTTP requests to list the alert rules, oldest first
+// URL pattern: /api/alerts/rules
+func (h *Handler) ListAlertRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := h.service.ListAlertRules(r.Context())
+	if err != nil {
+		writeResponse(w, errorStatus(err), err.Error())
+		return
+	}
+
+	writeResponse(w, http.StatusOK, rules)
+}
+
+// CreateAlertRule handles HTTP requests to create an alert rule
+// URL pattern: /api/alerts/rules
+func (h *Handler) CreateAlertRule(w http.ResponseWriter, r *http.Request) {
+	// Handle OPTIONS preflight request; the CORS middleware sets the headers
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	rule, ok := decodeAlertRule(w, r)
+	if !ok {
+		return
+	}
+	created, err := h.service.CreateAlertRule(r.Context(), rule)
+	if err != nil {
+		writeAlertRuleError(w, "", err)
+		return
+	}
+
+	writeResponse(w, http.StatusCreated, created)
+}
+
+// GetAlertRule handles HTTP requests for one alert rule
+// URL pattern: /api/alerts/rules/{id}
+func (h *Handler) GetAlertRule(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	rule, err := h.service.GetAlertRule(r.Context(), id)
+	if err != nil {
+		writeAlertRuleError(w, id, err)
+		return
+	}
+
+	writeResponse(w, http.StatusOK, rule)
+}
+
+// UpdateAlertRule handles HTTP requests to replace an alert rule
+// The alerts of the rule start again from inactive
+// URL pattern: /api/alerts/rules/{id}
+func (h *Handler) UpdateAlertRule(w http.ResponseWriter, r *http.Request) {
+	// Handle OPTIONS preflight request; the CORS middleware sets the headers
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	rule, ok := decodeAlertRule(w, r)
+	if !ok {
+		return
+	}
+	updated, err := h.service.UpdateAlertRule(r.Context(), id, rule)
+	if err != nil {
+		writeAlertRuleError(w, id, err)
+		return
+	}
+
+	writeResponse(w, http.StatusOK, updated)
+}
+
+// DeleteAlertRule handles HTTP requests to remove an alert rule and its alerts
+// The events of the rule are kept
+// URL pattern: /api/alerts/rules/{id}
+func (h *Handler) DeleteAlertRule(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	if err := h.service.DeleteAlertRule(r.Context(), id); err != nil {
+		writeAlertRuleError(w, id, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListAlerts handles HTTP requests for the state of alerts
+// URL pattern: /api/alerts?rule_id=X&state=firing,pending
+func (h *Handler) ListAlerts(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	var states []string
+	for _, state := range strings.Split(params.Get("state"), ",") {
+		if state = strings.TrimSpace(state); state != "" {
+			states = append(states, state)
+		}
+	}
+
+	alerts, err := h.service.ListAlerts(r.Context(), params.Get("rule_id"), states)
+	if err != nil {
+		writeResponse(w, errorStatus(err), err.Error())
+		return
+	}
+
+	writeResponse(w, http.StatusOK, alerts)
+}
+
+// ListAlertEvents handles HTTP requests for the history of alerts firing and resolving,
+// newest first
+// URL pattern: /api/alerts/events?rule_id=X&object_id=Y&limit=100
+func (h *Handler) ListAlertEvents(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query := model.AlertEventQuery{RuleID: params.Get("rule_id")}
+	if value := params.Get("object_id"); value != "" {
+		objectID, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			writeResponse(w, http.StatusBadRequest, "Invalid object ID format")
+			return
+		}
+		query.ObjectID = &objectID
+	}
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit < 0 {
+			writeResponse(w, http.StatusBadRequest, "limit must be a non-negative integer")
+			return
+		}
+		query.Limit = limit
+	}
+
+	events, err := h.service.ListAlertEvents(r.Context(), query)
+	if err != nil {
+		writeResponse(w, errorStatus(err), err.Error())
+		return
+	}
+
+	writeResponse(w, http.StatusOK, events)
+}
+
+// decodeAlertRule reads an alert rule from the request body
+// It writes the error response and returns false if the body is not a rule
+func decodeAlertRule(w http.ResponseWriter, r *http.Request) (model.AlertRule, bool) {
+	var rule model.AlertRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		writeResponse(w, http.StatusBadRequest, map[string]string{
+			"error":   "Invalid JSON format",
+			"message": err.Error(),
+		})
+		return rule, false
+	}
+	return rule, true
+}
+
+// writeAlertRuleError writes the response for a failed alert rule operation
+func writeAlertRuleError(w http.ResponseWriter, id string, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidRule):
+		writeResponse(w, http.StatusBadRequest, map[string]string{
+			"error":   "Invalid alert rule",
+			"message": strings.TrimPrefix(err.Error(), service.ErrInvalidRule.Error()+": "),
+		})
+	case errors.Is(err, repository.ErrNotFound):
+		writeResponse(w, http.StatusNotFound, fmt.Sprintf("alert rule %s not found", id))
+	default:
+		writeResponse(w, errorStatus(err), err.Error())
+	}
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"gomongoviz/model"
+	"gomongoviz/service"
+
+	"github.com/gorilla/mux"
+)
+
+// GetAnomalies handles HTTP requests for the readings of an object that were flagged as
+// standing out from the baseline of their port when they were saved, to overlay them on
+// charts
+// URL pattern: /api/anomalies/{objectId}?port_num=X&from=-24h&to=now&fields=voc,supply_volt&limit=1000
+func (h *Handler) GetAnomalies(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	params := r.URL.Query()
+
+	objectID, err := strconv.Atoi(vars["objectId"])
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, "invalid objectId: must be a number")
+		return
+	}
+	query := model.AnomalyQuery{ObjectID: float64(objectID)}
+
+	if portNum := params.Get("port_num"); portNum != "" {
+		port, err := strconv.ParseFloat(portNum, 64)
+		if err != nil {
+			writeResponse(w, http.StatusBadRequest, "invalid port_num: must be a number")
+			return
+		}
+		query.PortNum = &port
+	}
+
+	query.From, query.To, err = service.ParseTimeRange(params.Get("from"), params.Get("to"), time.Now())
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := service.ParseAnomalyOptions(params.Get("fields"), params.Get("limit"), &query); err != nil {
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	anomalies, err := h.service.ListAnomalies(r.Context(), query)
+	if err != nil {
+		writeResponse(w, errorStatus(err), err.Error())
+		return
+	}
+
+	writeResponse(w, http.StatusOK, anomalies)
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gomongoviz/model"
+	"gomongoviz/repository"
+	"gomongoviz/service"
+
+	"github.com/gorilla/mux"
+)
+
+// ListComputedFields handles HTTP requests to list the computed fields in name order
+// URL pattern: /api/computed-fields
+func (h *Handler) ListComputedFields(w http.ResponseWriter, r *http.Request) {
+	fields, err := h.service.ListComputedFields(r.Context())
+	if err != nil {
+		writeResponse(w, errorStatus(err), err.Error())
+		return
+	}
+
+	writeResponse(w, http.StatusOK, fields)
+}
+
+// CreateComputedField handles HTTP requests to define a computed field
+// URL pattern: /api/computed-fields
+func (h *Handler) CreateComputedField(w http.ResponseWriter, r *http.Request) {
+	// Handle OPTIONS preflight request; the CORS middleware sets the headers
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	field, ok := decodeComputedField(w, r)
+	if !ok {
+		return
+	}
+	created, err := h.service.CreateComputedField(r.Context(), field)
+	if err != nil {
+		writeComputedFieldError(w, field.Name, err)
+		return
+	}
+
+	writeResponse(w, http.StatusCreated, created)
+}
+
+// GetComputedField handles HTTP requests for one computed field
+// URL pattern: /api/computed-fields/{name}
+func (h *Handler) GetComputedField(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	field, err := h.service.GetComputedField(r.Context(), name)
+	if err != nil {
+		writeComputedFieldError(w, name, err)
+		return
+	}
+
+	writeResponse(w, http.StatusOK, field)
+}
+
+// UpdateComputedField handles HTTP requests to replace the formula of a computed field
+// The name in the body, if any, is ignored in favour of the one in the path
+// URL pattern: /api/computed-fields/{name}
+func (h *Handler) UpdateComputedField(w http.ResponseWriter, r *http.Request) {
+	// Handle OPTIONS preflight request; the CORS middleware sets the headers
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	field, ok := decodeComputedField(w, r)
+	if !ok {
+		return
+	}
+	updated, err := h.service.UpdateComputedField(r.Context(), name, field)
+	if err != nil {
+		writeComputedFieldError(w, name, err)
+		return
+	}
+
+	writeResponse(w, http.StatusOK, updated)
+}
+
+// DeleteComputedField handles HTTP requests to remove a computed field
+// URL pattern: /api/computed-fields/{name}
+func (h *Handler) DeleteComputedField(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	if err := h.service.DeleteComputedField(r.Context(), name); err != nil {
+		writeComputedFieldError(w, name, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeComputedField reads a computed field from the request body
+// It writes the error response and returns false if the body is not a computed field
+func decodeComputedField(w http.ResponseWriter, r *http.Request) (model.ComputedField, bool) {
+	var field model.ComputedField
+	if err := json.NewDecoder(r.Body).Decode(&field); err != nil {
+		writeResponse(w, http.StatusBadRequest, map[string]string{
+			"error":   "Invalid JSON format",
+			"message": err.Error(),
+		})
+		return field, false
+	}
+	return field, true
+}
+
+// writeComputedFieldError writes the response for a failed computed field operation
+func writeComputedFieldError(w http.ResponseWriter, name string, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidField):
+		writeResponse(w, http.StatusBadRequest, map[string]string{
+			"error":   "Invalid computed field",
+			"message": strings.TrimPrefix(err.Error(), service.ErrInvalidField.Error()+": "),
+		})
+	case errors.Is(err, service.ErrFieldExists):
+		writeResponse(w, http.StatusConflict, map[string]string{
+			"error":   "Computed field already exists",
+			"message": fmt.Sprintf("a computed field named %s already exists; use PUT to change it", name),
+		})
+	case errors.Is(err, repository.ErrNotFound):
+		writeResponse(w, http.StatusNotFound, fmt.Sprintf("computed field %s not found", name))
+	default:
+		writeResponse(w, errorStatus(err), err.Error())
+	}
+}
@@ -1,8 +1,9 @@
 package handlers
 
 import (
-	"encoding/csv"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -12,6 +13,7 @@ import (
 	"time"
 
 	"gomongoviz/model"
+	"gomongoviz/repository"
 	"gomongoviz/service"
 
 	"github.com/gorilla/mux"
@@ -20,14 +22,17 @@ import (
 // Handler implements the HTTP handlers for the API endpoints
 // It uses the service layer to process business logic
 type Handler struct {
-	service *service.Service // Service for business logic operations
+	service        *service.Service // Service for business logic operations
+	allowedOrigins []string         // Origins allowed to open live WebSocket connections
 }
 
 // NewHandler creates a new handler with the provided service
 // This follows the dependency injection pattern
-func NewHandler(svc *service.Service) *Handler {
+// allowedOrigins are the configured CORS origins, also applied to WebSocket connections
+func NewHandler(svc *service.Service, allowedOrigins []string) *Handler {
 	return &Handler{
-		service: svc,
+		service:        svc,
+		allowedOrigins: allowedOrigins,
 	}
 }
 
@@ -48,7 +53,7 @@ func (h *Handler) GetPorts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ports, err := h.service.GetPorts(id)
+	ports, err := h.service.GetPorts(r.Context(), id)
 	if err != nil {
 		var errMsg string
 		if e, ok := err.(error); ok {
@@ -56,7 +61,7 @@ func (h *Handler) GetPorts(w http.ResponseWriter, r *http.Request) {
 		} else {
 			errMsg = "Internal server error"
 		}
-		writeResponse(w, http.StatusInternalServerError, errMsg)
+		writeResponse(w, errorStatus(err), errMsg)
 		return
 	}
 
@@ -66,9 +71,9 @@ func (h *Handler) GetPorts(w http.ResponseWriter, r *http.Request) {
 // GetUniqueObjectIDs handles HTTP requests to get all unique object IDs
 // URL pattern: /api/objects
 func (h *Handler) GetUniqueObjectIDs(w http.ResponseWriter, r *http.Request) {
-	objectIDs, err := h.service.GetUniqueObjectIDs()
+	objectIDs, err := h.service.GetUniqueObjectIDs(r.Context())
 	if err != nil {
-		writeResponse(w, http.StatusInternalServerError, err.Error())
+		writeResponse(w, errorStatus(err), err.Error())
 		return
 	}
 
@@ -77,8 +82,9 @@ func (h *Handler) GetUniqueObjectIDs(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetDataByObjectID handles HTTP requests to get sensor data for a specific object ID
-// Optionally filtered by port number via query parameter
-// URL pattern: /api/data/{objectId}?port_num=X
+// Optionally filtered by port number and time range, paged with limit/sort/cursor
+// and reduced to the listed fields
+// URL pattern: /api/data/{objectId}?port_num=X&from=-3h&to=now&limit=500&sort=desc&cursor=Y&fields=voltage,current
 func (h *Handler) GetDataByObjectID(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	objectID := vars["objectId"]
@@ -89,17 +95,182 @@ func (h *Handler) GetDataByObjectID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	data, err := h.service.GetDataByObjectID(objectID, portNum)
+	// Parse the optional time range so it can be applied by the database
+	from, to, err := service.ParseTimeRange(r.URL.Query().Get("from"), r.URL.Query().Get("to"), time.Now())
 	if err != nil {
-		writeResponse(w, http.StatusInternalServerError, err.Error())
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	query := model.SensorDataQuery{
+		ObjectID: objectID,
+		PortNum:  portNum,
+		From:     from,
+		To:       to,
+	}
+
+	// Parse the optional paging parameters
+	params := r.URL.Query()
+	if err := service.ParsePaging(params.Get("limit"), params.Get("sort"), params.Get("cursor"), &query); err != nil {
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Parse the optional field projection
+	if err := service.ParseProjection(params.Get("fields"), &query); err != nil {
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	data, err := h.service.GetDataByObjectID(r.Context(), query)
+	if err != nil {
+		writeResponse(w, errorStatus(err), err.Error())
 		return
 	}
 
 	writeResponse(w, http.StatusOK, data)
 }
 
+// GetSeries handles HTTP requests to get downsampled chart series for a specific object ID
+// Each requested field is reduced to at most max_points points using the selected mode
+// URL pattern: /api/series/{objectId}?port_num=X&from=-24h&fields=voltage,current&max_points=1000&mode=lttb
+func (h *Handler) GetSeries(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	objectID := vars["objectId"]
+	params := r.URL.Query()
+
+	if objectID == "" {
+		writeResponse(w, http.StatusBadRequest, "objectId is required")
+		return
+	}
+
+	from, to, err := service.ParseTimeRange(params.Get("from"), params.Get("to"), time.Now())
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	query := model.SeriesQuery{
+		SensorDataQuery: model.SensorDataQuery{
+			ObjectID: objectID,
+			PortNum:  params.Get("port_num"),
+			From:     from,
+			To:       to,
+		},
+	}
+	if err := service.ParseSeriesOptions(params.Get("fields"), params.Get("max_points"), params.Get("mode"), &query); err != nil {
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	series, err := h.service.GetSeries(r.Context(), query)
+	if err != nil {
+		writeResponse(w, errorStatus(err), err.Error())
+		return
+	}
+
+	writeResponse(w, http.StatusOK, series)
+}
+
+// GetAggregates handles HTTP requests to get time-bucketed aggregates for a specific object ID
+// URL pattern: /api/aggregate/{objectId}?port_num=X&from=-7d&interval=1h&fields=voltage,current&functions=avg,max,p95
+func (h *Handler) GetAggregates(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	objectID := vars["objectId"]
+	params := r.URL.Query()
+
+	if objectID == "" {
+		writeResponse(w, http.StatusBadRequest, "objectId is required")
+		return
+	}
+
+	from, to, err := service.ParseTimeRange(params.Get("from"), params.Get("to"), time.Now())
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	query := model.AggregateQuery{
+		SensorDataQuery: model.SensorDataQuery{
+			ObjectID: objectID,
+			PortNum:  params.Get("port_num"),
+			From:     from,
+			To:       to,
+		},
+	}
+	if err := service.ParseAggregateOptions(params.Get("interval"), params.Get("fields"), params.Get("functions"), &query); err != nil {
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	buckets, err := h.service.AggregateData(r.Context(), query)
+	if err != nil {
+		writeResponse(w, errorStatus(err), err.Error())
+		return
+	}
+
+	writeResponse(w, http.StatusOK, buckets)
+}
+
+// ExportData handles HTTP requests to download sensor data for a specific object ID
+// The data is streamed straight from the database as CSV, NDJSON or a JSON array
+// URL pattern: /api/export/{objectId}?format=csv&port_num=X&from=-7d&to=now&fields=voltage,current
+func (h *Handler) ExportData(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	objectID := vars["objectId"]
+	params := r.URL.Query()
+
+	// Bad identifiers are rejected before the headers of the attachment are set
+	if _, err := strconv.ParseFloat(objectID, 64); err != nil {
+		writeResponse(w, http.StatusBadRequest, "invalid objectId: must be a number")
+		return
+	}
+	if portNum := params.Get("port_num"); portNum != "" {
+		if _, err := strconv.ParseFloat(portNum, 64); err != nil {
+			writeResponse(w, http.StatusBadRequest, "invalid port_num: must be a number")
+			return
+		}
+	}
+
+	format, err := service.ParseExportFormat(params.Get("format"))
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	from, to, err := service.ParseTimeRange(params.Get("from"), params.Get("to"), time.Now())
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	query := model.SensorDataQuery{
+		ObjectID: objectID,
+		PortNum:  params.Get("port_num"),
+		From:     from,
+		To:       to,
+	}
+	if err := service.ParseProjection(params.Get("fields"), &query); err != nil {
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := h.service.CheckFields(r.Context(), query.Projection); err != nil {
+		writeResponse(w, errorStatus(err), err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", service.ExportContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", service.ExportFilename(query, format, time.Now())))
+
+	// Once streaming has started the status code can no longer change,
+	// so failures part way through are only logged
+	if err := h.service.ExportData(r.Context(), query, format, w); err != nil {
+		log.Printf("Error exporting data for object %s: %v", objectID, err)
+	}
+}
+
 // UploadCSV handles HTTP requests to upload CSV files containing sensor data
-// URL pattern: /api/upload
+// URL pattern: /api/upload?mode=strict
 // The CSV file should contain properly formatted sensor data with all required fields
 func (h *Handler) UploadCSV(w http.ResponseWriter, r *http.Request) {
 	// Log request content type and method for debugging
@@ -107,20 +278,26 @@ func (h *Handler) UploadCSV(w http.ResponseWriter, r *http.Request) {
 	// The Content-Type should contain 'multipart/form-data' with a boundary parameter
 	log.Printf("Request Content-Type: %s, Method: %s", r.Header.Get("Content-Type"), r.Method)
 
-	// Handle OPTIONS preflight request
+	// Handle OPTIONS preflight request; the CORS middleware sets the headers
 	// Browsers send this before the actual POST request for CORS validation
 	if r.Method == "OPTIONS" {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
+	// mode=valid-only imports only the valid rows and mode=dry-run inserts nothing,
+	// both return a report of every invalid row
+	// duplicates=skip|upsert|error decides what happens to rows that are already stored
+	opts, err := service.ParseImportOptions(r.URL.Query().Get("mode"), r.URL.Query().Get("duplicates"))
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	// Maximum upload size of 10MB
 	// ParseMultipartForm parses the multipart form including file uploads
 	// This fails if the Content-Type header isn't set correctly by the client
-	err := r.ParseMultipartForm(10 << 20)
+	err = r.ParseMultipartForm(10 << 20)
 	if err != nil {
 		// Common issue: Content-Type header is missing or incorrect
 		// This happens when the frontend manually sets the Content-Type header
@@ -172,271 +349,340 @@ func (h *Handler) UploadCSV(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse CSV
-	csvReader := csv.NewReader(file)
+	// Validating modes report every invalid row instead of stopping at the first one
+	if opts.Mode != model.ImportStrict {
+		report, err := h.service.ImportCSV(r.Context(), file, opts)
+		writeResponse(w, importStatus(err), importSummary(report, err))
+		return
+	}
 
-	// Read header
-	header, err := csvReader.Read()
+	// Parse CSV header and check the required columns
+	parser, err := service.NewCSVParser(file)
 	if err != nil {
+		writeCSVHeaderError(w, err)
+		return
+	}
+
+	// Log header for debugging
+	log.Printf("CSV header: %v", parser.Header())
+
+	// Process all rows
+	var sensorData []model.SensorData
+	for {
+		data, err := parser.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeRowError(w, err)
+			return
+		}
+		sensorData = append(sensorData, data)
+	}
+
+	// Check if we have any data to save
+	if len(sensorData) == 0 {
 		writeResponse(w, http.StatusBadRequest, map[string]string{
-			"error":   "Failed to read CSV header",
+			"error":   "No valid data found",
+			"message": "The CSV file contains a header but no valid data rows",
+		})
+		return
+	}
+
+	// Send data to service layer for processing and storage
+	result, err := h.service.SaveSensorData(r.Context(), sensorData, opts.OnDuplicate)
+	if err != nil {
+		writeResponse(w, errorStatus(err), map[string]string{
+			"error":   "Failed to save sensor data",
 			"message": err.Error(),
 		})
 		return
 	}
 
-	// Log header for debugging
-	log.Printf("CSV header: %v", header)
+	writeResponse(w, http.StatusOK, map[string]interface{}{
+		"success":    true,
+		"message":    fmt.Sprintf("Successfully uploaded %d sensor data records, %d duplicates", result.Inserted, result.Duplicates),
+		"count":      result.Inserted,
+		"duplicates": result.Duplicates,
+	})
+}
+
+// UploadCSVStream handles HTTP requests to import large CSV files without size limits
+// URL pattern: /api/upload-stream?progress=true&mode=valid-only
+// The file is read from the "file" part of a multipart form, or from the body when the
+// Content-Type is text/csv, and inserted in batches while it is still being received
+// With progress=true the response is NDJSON with one progress line per inserted batch
+// followed by a final summary line
+func (h *Handler) UploadCSVStream(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Stream Upload Request Content-Type: %s, Method: %s", r.Header.Get("Content-Type"), r.Method)
 
-	// Map column indices
-	headerMap := make(map[string]int)
-	for i, column := range header {
-		headerMap[column] = i
+	// Handle OPTIONS preflight request; the CORS middleware sets the headers
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	body, _, err := csvUploadBody(r)
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, map[string]string{
+			"error":   "Could not get file from request",
+			"message": err.Error(),
+		})
+		return
 	}
 
-	// Validate required fields
-	requiredFields := []string{
-		"timestamp", "object_id", "port_num", "voltage", "current",
-		"supply_current", "supply_volt", "voltage_drop", "voc",
+	opts, err := service.ParseImportOptions(r.URL.Query().Get("mode"), r.URL.Query().Get("duplicates"))
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
-	missingFields := []string{}
-	for _, field := range requiredFields {
-		if _, exists := headerMap[field]; !exists {
-			missingFields = append(missingFields, field)
+	// Stream progress lines as batches are written when requested
+	streamProgress := r.URL.Query().Get("progress") == "true"
+	if streamProgress {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(w)
+		flusher, _ := w.(http.Flusher)
+		opts.Progress = func(p model.ImportProgress) {
+			encoder.Encode(p)
+			if flusher != nil {
+				flusher.Flush()
+			}
 		}
 	}
 
-	if len(missingFields) > 0 {
-		writeResponse(w, http.StatusBadRequest, map[string]string{
-			"error":   "Missing required fields in CSV",
-			"message": fmt.Sprintf("The following required fields are missing: %v", missingFields),
-		})
+	report, err := h.service.ImportCSV(r.Context(), body, opts)
+
+	// The status line has already been sent when progress is streamed
+	if streamProgress {
+		json.NewEncoder(w).Encode(importSummary(report, err))
 		return
 	}
+	writeResponse(w, importStatus(err), importSummary(report, err))
+}
 
-	// Process all rows
-	var sensorData []model.SensorData
-	lineNum := 1 // Start after header
+// importSummary builds the response body of a streaming import from its report
+func importSummary(report model.ImportReport, err error) map[string]interface{} {
+	summary := map[string]interface{}{
+		"success":         err == nil,
+		"mode":            report.Mode,
+		"rowsRead":        report.RowsRead,
+		"rowsInserted":    report.RowsInserted,
+		"rowsRejected":    report.RowsRejected,
+		"rowsDuplicate":   report.RowsDuplicate,
+		"batches":         report.Batches,
+		"count":           report.RowsInserted,
+		"errors":          report.Errors,
+		"errorsTruncated": report.ErrorsTruncated,
+	}
+	switch {
+	case err != nil:
+		summary["error"] = "Import stopped"
+		summary["message"] = err.Error()
+	case report.Mode == model.ImportDryRun:
+		summary["message"] = fmt.Sprintf("Validated %d rows: %d valid, %d invalid",
+			report.RowsRead, report.RowsRead-report.RowsRejected, report.RowsRejected)
+	default:
+		summary["message"] = fmt.Sprintf("Successfully uploaded %d sensor data records, %d duplicates, %d rows rejected",
+			report.RowsInserted, report.RowsDuplicate, report.RowsRejected)
+	}
+	return summary
+}
+
+// importStatus maps the error of a streaming import to an HTTP status code
+// Problems with the file are the client's fault, anything else is a server error
+func importStatus(err error) int {
+	var rowErr *model.RowError
+	var columnsErr *service.MissingColumnsError
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.As(err, &rowErr), errors.As(err, &columnsErr):
+		return http.StatusBadRequest
+	}
+	return errorStatus(err)
+}
+
+// errorStatus maps a service error to an HTTP status code
+// Database operations that ran out of time report a gateway timeout and queries of
+// fields that are not defined a bad request
+func errorStatus(err error) int {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	if errors.Is(err, service.ErrUnknownField) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// csvUploadBody returns a reader for the uploaded CSV and its file name without buffering it
+// Multipart forms are walked part by part until the "file" part is found
+// Raw text/csv bodies take their name from the filename query parameter
+func csvUploadBody(r *http.Request) (io.Reader, string, error) {
+	contentType := strings.ToLower(r.Header.Get("Content-Type"))
+	if strings.HasPrefix(contentType, "text/csv") || strings.HasPrefix(contentType, "application/csv") {
+		return r.Body, r.URL.Query().Get("filename"), nil
+	}
 
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", errors.New("request Content-Type must be multipart/form-data or text/csv")
+	}
 	for {
-		lineNum++
-		record, err := csvReader.Read()
+		part, err := reader.NextPart()
 		if err == io.EOF {
-			break
+			return nil, "", errors.New("no file part found in form")
 		}
 		if err != nil {
-			writeResponse(w, http.StatusBadRequest, map[string]string{
-				"error":   "Failed to read CSV row",
-				"message": fmt.Sprintf("Error at line %d: %s", lineNum, err.Error()),
-			})
-			return
+			return nil, "", err
 		}
-
-		// Parse data from CSV row
-		timestamp, err := time.Parse(time.RFC3339, record[headerMap["timestamp"]])
-		if err != nil {
-			writeResponse(w, http.StatusBadRequest, map[string]string{
-				"error":   "Invalid timestamp format",
-				"message": fmt.Sprintf("Error at line %d: timestamp should be in RFC3339 format", lineNum),
-			})
-			return
-		}
-
-		objectID, err := strconv.ParseFloat(record[headerMap["object_id"]], 64)
-		if err != nil {
-			writeResponse(w, http.StatusBadRequest, map[string]string{
-				"error":   "Invalid object_id",
-				"message": fmt.Sprintf("Error at line %d: object_id should be a number", lineNum),
-			})
-			return
+		if part.FormName() == "file" {
+			log.Printf("Streaming file: %s", part.FileName())
+			return part, part.FileName(), nil
 		}
+	}
+}
 
-		portNum, err := strconv.ParseFloat(record[headerMap["port_num"]], 64)
-		if err != nil {
-			writeResponse(w, http.StatusBadRequest, map[string]string{
-				"error":   "Invalid port_num",
-				"message": fmt.Sprintf("Error at line %d: port_num should be a number", lineNum),
-			})
-			return
-		}
+// SubmitImport handles HTTP requests to start an asynchronous CSV import
+// URL pattern: /api/imports?mode=valid-only
+// The file is accepted like /api/upload-stream and the response carries the job ID
+// straight away; the import itself runs in the background
+func (h *Handler) SubmitImport(w http.ResponseWriter, r *http.Request) {
+	// Handle OPTIONS preflight request; the CORS middleware sets the headers
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
 
-		// Parse numeric fields with error handling
-		parseFloatField := func(field string) (float64, error) {
-			value := record[headerMap[field]]
-			if value == "" {
-				return 0, nil
-			}
-			return strconv.ParseFloat(value, 64)
-		}
+	opts, err := service.ParseImportOptions(r.URL.Query().Get("mode"), r.URL.Query().Get("duplicates"))
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-		voltage, err := parseFloatField("voltage")
-		if err != nil {
-			writeResponse(w, http.StatusBadRequest, map[string]string{
-				"error":   "Invalid voltage",
-				"message": fmt.Sprintf("Error at line %d: voltage should be a number", lineNum),
-			})
-			return
-		}
+	body, filename, err := csvUploadBody(r)
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, map[string]string{
+			"error":   "Could not get file from request",
+			"message": err.Error(),
+		})
+		return
+	}
 
-		current, err := parseFloatField("current")
-		if err != nil {
-			writeResponse(w, http.StatusBadRequest, map[string]string{
-				"error":   "Invalid current",
-				"message": fmt.Sprintf("Error at line %d: current should be a number", lineNum),
-			})
-			return
-		}
+	job, err := h.service.SubmitImport(r.Context(), filename, opts, body)
+	if errors.Is(err, service.ErrImportQueueFull) {
+		writeResponse(w, http.StatusServiceUnavailable, map[string]string{
+			"error":   "Import not queued",
+			"message": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		writeResponse(w, errorStatus(err), map[string]string{
+			"error":   "Import not queued",
+			"message": err.Error(),
+		})
+		return
+	}
 
-		supplyCurrent, err := parseFloatField("supply_current")
-		if err != nil {
-			writeResponse(w, http.StatusBadRequest, map[string]string{
-				"error":   "Invalid supply_current",
-				"message": fmt.Sprintf("Error at line %d: supply_current should be a number", lineNum),
-			})
-			return
-		}
+	w.Header().Set("Location", "/api/imports/"+job.ID)
+	writeResponse(w, http.StatusAccepted, job)
+}
 
-		supplyVolt, err := parseFloatField("supply_volt")
-		if err != nil {
-			writeResponse(w, http.StatusBadRequest, map[string]string{
-				"error":   "Invalid supply_volt",
-				"message": fmt.Sprintf("Error at line %d: supply_volt should be a number", lineNum),
-			})
-			return
-		}
+// GetImportJob handles HTTP requests to get the status of an import job
+// URL pattern: /api/imports/{id}
+func (h *Handler) GetImportJob(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
 
-		voltageDrop, err := parseFloatField("voltage_drop")
-		if err != nil {
-			writeResponse(w, http.StatusBadRequest, map[string]string{
-				"error":   "Invalid voltage_drop",
-				"message": fmt.Sprintf("Error at line %d: voltage_drop should be a number", lineNum),
-			})
-			return
-		}
+	job, err := h.service.GetImportJob(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		writeResponse(w, http.StatusNotFound, fmt.Sprintf("import job %s not found", id))
+		return
+	}
+	if err != nil {
+		writeResponse(w, errorStatus(err), err.Error())
+		return
+	}
 
-		voc, err := parseFloatField("voc")
-		if err != nil {
-			writeResponse(w, http.StatusBadRequest, map[string]string{
-				"error":   "Invalid voc",
-				"message": fmt.Sprintf("Error at line %d: voc should be a number", lineNum),
-			})
-			return
-		}
+	writeResponse(w, http.StatusOK, job)
+}
 
-		// Create new SensorData object
-		data := model.SensorData{
-			Timestamp:     timestamp,
-			ObjectID:      objectID,
-			PortNum:       portNum,
-			Voltage:       voltage,
-			Current:       current,
-			SupplyCurrent: supplyCurrent,
-			SupplyVolt:    supplyVolt,
-			VoltageDrop:   voltageDrop,
-			VOC:           voc,
-			CreatedAt:     time.Now(),
+// ListImportJobs handles HTTP requests to list import jobs, newest first
+// URL pattern: /api/imports?state=running,queued
+func (h *Handler) ListImportJobs(w http.ResponseWriter, r *http.Request) {
+	var states []string
+	for _, state := range strings.Split(r.URL.Query().Get("state"), ",") {
+		if state = strings.TrimSpace(state); state != "" {
+			states = append(states, state)
 		}
+	}
 
-		// Add optional fields if present
-		for _, field := range []string{
-			"state", "controller_error", "ai1", "ai2", "ai3", "ai4", "ai5",
-			"fw_version", "q_charge", "voltage_set_point", "command", "target_q",
-			"vendor_id", "step_number", "lite_id", "voc_mode", "target_voc",
-			"voc_state", "voc_exit",
-		} {
-			if index, exists := headerMap[field]; exists && index < len(record) {
-				value := record[index]
-				switch field {
-				case "fw_version", "vendor_id", "lite_id":
-					// String fields
-					if field == "fw_version" {
-						data.FWVersion = value
-					} else if field == "vendor_id" {
-						data.VendorID = value
-					} else if field == "lite_id" {
-						data.LiteID = value
-					}
-				default:
-					// Numeric fields
-					if value != "" {
-						if floatVal, err := strconv.ParseFloat(value, 64); err == nil {
-							switch field {
-							case "state":
-								data.State = floatVal
-							case "controller_error":
-								data.ControllerError = floatVal
-							case "ai1":
-								data.AI1 = floatVal
-							case "ai2":
-								data.AI2 = floatVal
-							case "ai3":
-								data.AI3 = floatVal
-							case "ai4":
-								data.AI4 = floatVal
-							case "ai5":
-								data.AI5 = floatVal
-							case "q_charge":
-								data.QCharge = floatVal
-							case "voltage_set_point":
-								data.VoltageSetPoint = floatVal
-							case "command":
-								data.Command = floatVal
-							case "target_q":
-								data.TargetQ = floatVal
-							case "step_number":
-								data.StepNumber = floatVal
-							case "voc_mode":
-								data.VOCMode = floatVal
-							case "target_voc":
-								data.TargetVOC = floatVal
-							case "voc_state":
-								data.VOCState = floatVal
-							case "voc_exit":
-								data.VOCExit = floatVal
-							}
-						}
-					}
-				}
-			}
-		}
+	jobs, err := h.service.ListImportJobs(r.Context(), states)
+	if err != nil {
+		writeResponse(w, errorStatus(err), err.Error())
+		return
+	}
 
-		// Handle read_error boolean field if present
-		if index, exists := headerMap["read_error"]; exists && index < len(record) {
-			value := record[index]
-			if value == "true" {
-				data.ReadError = true
-			} else {
-				data.ReadError = false
-			}
-		}
+	writeResponse(w, http.StatusOK, jobs)
+}
 
-		sensorData = append(sensorData, data)
+// GetIndexes handles HTTP requests for the state of the database indexes
+// URL pattern: /api/admin/indexes
+func (h *Handler) GetIndexes(w http.ResponseWriter, r *http.Request) {
+	indexes, err := h.service.IndexStatus(r.Context())
+	if err != nil {
+		writeResponse(w, errorStatus(err), err.Error())
+		return
 	}
 
-	// Check if we have any data to save
-	if len(sensorData) == 0 {
-		writeResponse(w, http.StatusBadRequest, map[string]string{
-			"error":   "No valid data found",
-			"message": "The CSV file contains a header but no valid data rows",
+	writeResponse(w, http.StatusOK, indexes)
+}
+
+// EnsureIndexes handles HTTP requests to create the declared indexes that are missing,
+// for example after removing duplicate records that blocked the unique index at startup
+// URL pattern: /api/admin/indexes
+func (h *Handler) EnsureIndexes(w http.ResponseWriter, r *http.Request) {
+	indexes, err := h.service.EnsureIndexes(r.Context())
+	if err != nil {
+		writeResponse(w, errorStatus(err), map[string]interface{}{
+			"error":   "Failed to create indexes",
+			"message": err.Error(),
+			"indexes": indexes,
 		})
 		return
 	}
 
-	// Send data to service layer for processing and storage
-	err = h.service.SaveSensorData(sensorData)
-	if err != nil {
-		writeResponse(w, http.StatusInternalServerError, map[string]string{
-			"error":   "Failed to save sensor data",
-			"message": err.Error(),
+	writeResponse(w, http.StatusOK, indexes)
+}
+
+// writeCSVHeaderError reports a CSV header that could not be read or lacks required columns
+func writeCSVHeaderError(w http.ResponseWriter, err error) {
+	var columnsErr *service.MissingColumnsError
+	if errors.As(err, &columnsErr) {
+		writeResponse(w, http.StatusBadRequest, map[string]string{
+			"error":   "Missing required fields in CSV",
+			"message": columnsErr.Error(),
 		})
 		return
 	}
+	writeResponse(w, http.StatusBadRequest, map[string]string{
+		"error":   "Failed to read CSV header",
+		"message": err.Error(),
+	})
+}
 
-	writeResponse(w, http.StatusOK, map[string]interface{}{
-		"success": true,
-		"message": fmt.Sprintf("Successfully uploaded %d sensor data records", len(sensorData)),
-		"count":   len(sensorData),
+// writeRowError reports the first CSV row that could not be parsed
+func writeRowError(w http.ResponseWriter, err error) {
+	title := "Failed to read CSV row"
+	var rowErr *model.RowError
+	if errors.As(err, &rowErr) && rowErr.Column != "" {
+		title = "Invalid " + rowErr.Column
+	}
+	writeResponse(w, http.StatusBadRequest, map[string]string{
+		"error":   title,
+		"message": err.Error(),
 	})
 }
 
@@ -446,15 +692,19 @@ func (h *Handler) UploadJSON(w http.ResponseWriter, r *http.Request) {
 	// Log request content type and method for debugging
 	log.Printf("JSON Upload Request Content-Type: %s, Method: %s", r.Header.Get("Content-Type"), r.Method)
 
-	// Handle OPTIONS preflight request
+	// Handle OPTIONS preflight request; the CORS middleware sets the headers
 	if r.Method == "OPTIONS" {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
+	// duplicates=skip|upsert|error decides what happens to records that are already stored
+	onDuplicate, err := service.ParseDuplicatePolicy(r.URL.Query().Get("duplicates"))
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	// Check content type
 	contentType := r.Header.Get("Content-Type")
 	if !strings.Contains(contentType, "application/json") {
@@ -467,7 +717,7 @@ func (h *Handler) UploadJSON(w http.ResponseWriter, r *http.Request) {
 
 	// Read the request body
 	var sensorDataRecords []model.SensorData
-	err := json.NewDecoder(r.Body).Decode(&sensorDataRecords)
+	err = json.NewDecoder(r.Body).Decode(&sensorDataRecords)
 	if err != nil {
 		writeResponse(w, http.StatusBadRequest, map[string]string{
 			"error":   "Failed to parse JSON data",
@@ -490,38 +740,20 @@ func (h *Handler) UploadJSON(w http.ResponseWriter, r *http.Request) {
 		// Set creation time
 		sensorDataRecords[i].CreatedAt = time.Now()
 
-		// Validate timestamp
-		if record.Timestamp.IsZero() {
-			writeResponse(w, http.StatusBadRequest, map[string]string{
-				"error":   "Invalid data",
-				"message": fmt.Sprintf("Record at index %d has missing or invalid timestamp", i),
-			})
-			return
-		}
-
-		// Validate object_id
-		if record.ObjectID == 0 {
-			writeResponse(w, http.StatusBadRequest, map[string]string{
-				"error":   "Invalid data",
-				"message": fmt.Sprintf("Record at index %d has missing object_id", i),
-			})
-			return
-		}
-
-		// Validate port_num
-		if record.PortNum == 0 {
+		// Validate timestamp, object_id and port_num
+		if err := service.ValidateSensorRecord(record); err != nil {
 			writeResponse(w, http.StatusBadRequest, map[string]string{
 				"error":   "Invalid data",
-				"message": fmt.Sprintf("Record at index %d has missing port_num", i),
+				"message": fmt.Sprintf("Record at index %d has %v", i, err),
 			})
 			return
 		}
 	}
 
 	// Save the data to the database
-	err = h.service.SaveSensorData(sensorDataRecords)
+	result, err := h.service.SaveSensorData(r.Context(), sensorDataRecords, onDuplicate)
 	if err != nil {
-		writeResponse(w, http.StatusInternalServerError, map[string]string{
+		writeResponse(w, errorStatus(err), map[string]string{
 			"error":   "Failed to save sensor data",
 			"message": err.Error(),
 		})
@@ -530,21 +762,18 @@ func (h *Handler) UploadJSON(w http.ResponseWriter, r *http.Request) {
 
 	// Return success response
 	writeResponse(w, http.StatusOK, map[string]interface{}{
-		"success": true,
-		"message": fmt.Sprintf("Successfully uploaded %d sensor data records", len(sensorDataRecords)),
-		"count":   len(sensorDataRecords),
+		"success":    true,
+		"message":    fmt.Sprintf("Successfully uploaded %d sensor data records, %d duplicates", result.Inserted, result.Duplicates),
+		"count":      result.Inserted,
+		"duplicates": result.Duplicates,
 	})
 }
 
 // writeResponse is a helper function to write JSON responses
-// It sets appropriate headers, status code, and serializes the data to JSON
+// It sets the content type and status code, and serializes the data to JSON
+// CORS headers are set by the middleware from server.allowed_origins
 func writeResponse(w http.ResponseWriter, code int, data interface{}) {
-	// Set CORS headers to allow cross-origin requests
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, X-Requested-With")
-	w.Header().Set("Access-Control-Max-Age", "86400") // 24 hours
 
 	w.WriteHeader(code)
 
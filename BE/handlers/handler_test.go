@@ -0,0 +1,350 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gomongoviz/config"
+	"gomongoviz/model"
+	"gomongoviz/repository"
+	"gomongoviz/service"
+
+	"github.com/gorilla/mux"
+)
+
+// fixture holds 6 readings one minute apart for each of ports 1 and 2 of object 7
+// Port 1 reads 12 V and 2 A from a 15 V supply, port 2 reads 5 V and 1 A from 8 V
+var fixture = filepath.Join("testdata", "sensor_data.csv")
+
+// newTestHandler returns a handler over an in-memory storage loaded with the fixture
+// configure may change the default configuration before the service starts
+func newTestHandler(t *testing.T, configure ...func(cfg *config.Config)) *Handler {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Storage.Backend = config.StorageMemory
+	cfg.Import.SpoolDir = t.TempDir()
+	cfg.Ingest.FlushInterval = 10 * time.Millisecond
+	for _, fn := range configure {
+		fn(cfg)
+	}
+
+	svc := service.NewService(repository.NewMemoryRepository(), cfg)
+	if _, err := svc.LoadFixture(context.Background(), fixture); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if err := svc.StartWebhooks(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.StartImportWorkers(ctx); err != nil {
+		t.Fatal(err)
+	}
+	svc.StartIngestFlusher(ctx)
+	return NewHandler(svc, cfg.Server.AllowedOrigins)
+}
+
+// serve runs a request through a handler with the route variables of its URL pattern
+func serve(handler http.HandlerFunc, req *http.Request, vars map[string]string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler(rec, mux.SetURLVars(req, vars))
+	return rec
+}
+
+// get runs a GET request for an object through a handler
+func get(handler http.HandlerFunc, target string) *httptest.ResponseRecorder {
+	return serve(handler, httptest.NewRequest(http.MethodGet, target, nil), map[string]string{"objectId": "7"})
+}
+
+// decode reads a JSON response body into v
+func decode(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+}
+
+// dataPage is the JSON form of model.SensorDataRes with projected records
+type dataPage struct {
+	SensorData []map[string]interface{}
+	Total      int64
+	NextCursor string
+}
+
+func TestGetDataByObjectID(t *testing.T) {
+	h := newTestHandler(t)
+
+	tests := []struct {
+		name    string
+		query   string
+		status  int
+		records int
+		total   int64
+		check   func(t *testing.T, page dataPage)
+	}{
+		{name: "all ports", query: "", status: http.StatusOK, records: 12, total: 12},
+		{name: "one port", query: "port_num=2", status: http.StatusOK, records: 6, total: 6},
+		{name: "time range", query: "from=2024-01-01T00:02:00Z&to=2024-01-01T00:03:00Z", status: http.StatusOK, records: 4, total: 4},
+		{
+			name: "first page", query: "port_num=1&limit=4&sort=desc", status: http.StatusOK, records: 4, total: 6,
+			check: func(t *testing.T, page dataPage) {
+				if page.NextCursor == "" {
+					t.Error("no cursor for the next page")
+				}
+				if got := page.SensorData[0]["timestamp"]; got != "2024-01-01T00:05:00Z" {
+					t.Errorf("first record at %v, want the newest", got)
+				}
+			},
+		},
+		{
+			name: "projection with derived field", query: "port_num=1&fields=voltage,power", status: http.StatusOK, records: 6, total: 6,
+			check: func(t *testing.T, page dataPage) {
+				record := page.SensorData[0]
+				if record["power"] != 24.0 || record["voltage"] != 12.0 {
+					t.Errorf("got %v, want voltage 12 and power 24", record)
+				}
+				if _, ok := record["current"]; ok {
+					t.Error("current is returned without being listed")
+				}
+			},
+		},
+		{name: "unknown field", query: "fields=voltage,Bogus", status: http.StatusBadRequest},
+		{name: "undefined computed field", query: "fields=bogus", status: http.StatusBadRequest},
+		{name: "invalid limit", query: "limit=-1", status: http.StatusBadRequest},
+		{name: "invalid time range", query: "from=yesterday", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := get(h.GetDataByObjectID, "/api/data/7?"+tt.query)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var page dataPage
+			decode(t, rec, &page)
+			if len(page.SensorData) != tt.records || page.Total != tt.total {
+				t.Fatalf("got %d records of %d, want %d of %d", len(page.SensorData), page.Total, tt.records, tt.total)
+			}
+			if tt.check != nil {
+				tt.check(t, page)
+			}
+		})
+	}
+}
+
+func TestJSONResponseCORS(t *testing.T) {
+	h := newTestHandler(t)
+
+	// CORS headers are left to the middleware, which answers only the configured origins
+	for _, target := range []string{"/api/data/7", "/api/data/7?limit=-1"} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Origin", "https://elsewhere.example.com")
+		rec := serve(h.GetDataByObjectID, req, map[string]string{"objectId": "7"})
+		for name := range rec.Header() {
+			if strings.HasPrefix(name, "Access-Control-") {
+				t.Errorf("%s: header %s: %q set by the handler", target, name, rec.Header().Get(name))
+			}
+		}
+	}
+}
+
+func TestGetSeries(t *testing.T) {
+	h := newTestHandler(t)
+
+	rec := get(h.GetSeries, "/api/series/7?port_num=1&fields=voltage,ai3&max_points=3")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var series model.SeriesRes
+	decode(t, rec, &series)
+	if series.Mode != "lttb" || series.Total != 6 {
+		t.Errorf("got mode %q over %d records, want lttb over 6", series.Mode, series.Total)
+	}
+	points := series.Series["ai3"]
+	if len(points) != 3 {
+		t.Fatalf("got %d ai3 points, want 3", len(points))
+	}
+	if points[0].Y != 100 || points[2].Y != 105 {
+		t.Errorf("got %v, want the first and last readings kept", points)
+	}
+	if len(series.Series["voltage"]) != 3 {
+		t.Errorf("got %d voltage points, want 3", len(series.Series["voltage"]))
+	}
+
+	for _, query := range []string{"fields=", "fields=voltage&mode=spline", "fields=voltage&max_points=0", "fields=fw_version"} {
+		if rec := get(h.GetSeries, "/api/series/7?"+query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", query, rec.Code)
+		}
+	}
+}
+
+func TestGetAggregates(t *testing.T) {
+	h := newTestHandler(t)
+
+	rec := get(h.GetAggregates, "/api/aggregate/7?interval=hour&fields=voltage,power&functions=avg,max,count")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var buckets []model.AggregateBucket
+	decode(t, rec, &buckets)
+	if len(buckets) != 2 {
+		t.Fatalf("got %d buckets, want one per port: %+v", len(buckets), buckets)
+	}
+	want := map[float64]struct{ voltage, power float64 }{1: {12, 24}, 2: {5, 5}}
+	for _, bucket := range buckets {
+		w, ok := want[bucket.PortNum]
+		if !ok {
+			t.Fatalf("unexpected port %v", bucket.PortNum)
+		}
+		if bucket.Count != 6 || bucket.Values["voltage"]["avg"] != w.voltage || bucket.Values["power"]["max"] != w.power {
+			t.Errorf("port %v: got %+v, want 6 readings of %v V and %v W", bucket.PortNum, bucket, w.voltage, w.power)
+		}
+	}
+
+	for _, query := range []string{"interval=fortnight&fields=voltage", "fields=voltage&functions=mode", "fields=fw_version"} {
+		if rec := get(h.GetAggregates, "/api/aggregate/7?"+query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", query, rec.Code)
+		}
+	}
+}
+
+func TestExportData(t *testing.T) {
+	h := newTestHandler(t)
+
+	t.Run("csv", func(t *testing.T) {
+		rec := get(h.ExportData, "/api/export/7?fields=timestamp,voltage")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/csv") {
+			t.Errorf("Content-Type %q, want text/csv", got)
+		}
+		if got := rec.Header().Get("Content-Disposition"); !strings.Contains(got, "attachment") {
+			t.Errorf("Content-Disposition %q, want an attachment", got)
+		}
+		// CORS headers are left to the middleware and its configured origins
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+			t.Errorf("Access-Control-Allow-Origin %q set by the handler", got)
+		}
+		lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+		if len(lines) != 13 {
+			t.Fatalf("got %d lines, want a header and 12 records", len(lines))
+		}
+		if lines[0] != "timestamp,object_id,port_num,voltage" {
+			t.Errorf("header %q", lines[0])
+		}
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		rec := get(h.ExportData, "/api/export/7?format=ndjson&port_num=2")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+		if len(lines) != 6 {
+			t.Fatalf("got %d records, want 6", len(lines))
+		}
+		var record model.SensorData
+		if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+			t.Fatal(err)
+		}
+		if record.PortNum != 2 || record.Voltage != 5 {
+			t.Errorf("got %+v, want a reading of port 2", record)
+		}
+	})
+
+	tests := []struct {
+		objectID string
+		query    string
+	}{
+		{"7", "format=xml"},
+		{"7", "fields=bogus"},
+		{"7", "port_num=two"},
+		{"seven", ""},
+	}
+	for _, tt := range tests {
+		target := "/api/export/" + tt.objectID + "?" + tt.query
+		rec := serve(h.ExportData, httptest.NewRequest(http.MethodGet, target, nil), map[string]string{"objectId": tt.objectID})
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", target, rec.Code)
+		}
+		if rec.Header().Get("Content-Disposition") != "" {
+			t.Errorf("%s: refused export is sent as a download", target)
+		}
+	}
+}
+
+// multipartCSV builds a multipart form with the CSV in its file part
+func multipartCSV(t *testing.T, csv string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", "readings.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(csv))
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/upload", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+func TestUploadCSV(t *testing.T) {
+	h := newTestHandler(t)
+	header := "timestamp,object_id,port_num,voltage,current,supply_current,supply_volt,voltage_drop,voc\n"
+
+	// One reading is already in the fixture and is skipped
+	rec := serve(h.UploadCSV, multipartCSV(t, header+
+		"2024-01-01T00:05:00Z,7,1,12,2,2,15,0.5,14\n"+
+		"2024-01-01T00:06:00Z,7,1,12.5,2,2,15,0.5,14\n"+
+		"2024-01-01T00:00:00Z,8,1,3,1,1,4,0.5,14\n"), nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var result struct {
+		Count      int `json:"count"`
+		Duplicates int `json:"duplicates"`
+	}
+	decode(t, rec, &result)
+	if result.Count != 2 || result.Duplicates != 1 {
+		t.Errorf("got %+v, want 2 inserted and 1 duplicate", result)
+	}
+
+	var page dataPage
+	decode(t, get(h.GetDataByObjectID, "/api/data/7?port_num=1&limit=1&sort=desc&fields=voltage"), &page)
+	if page.Total != 7 || page.SensorData[0]["voltage"] != 12.5 {
+		t.Errorf("got %d readings ending with %v, want the uploaded one last of 7", page.Total, page.SensorData)
+	}
+
+	tests := []struct {
+		name string
+		req  *http.Request
+	}{
+		{"missing column", multipartCSV(t, "timestamp,object_id,port_num\n2024-01-01T00:00:00Z,7,1\n")},
+		{"invalid row", multipartCSV(t, header+"yesterday,7,1,12,2,2,15,0.5,14\n")},
+		{"no rows", multipartCSV(t, header)},
+		{"invalid mode", func() *http.Request {
+			req := multipartCSV(t, header)
+			req.URL.RawQuery = "mode=maybe"
+			return req
+		}()},
+		{"not a form", httptest.NewRequest(http.MethodPost, "/api/upload", strings.NewReader(header))},
+	}
+	for _, tt := range tests {
+		if rec := serve(h.UploadCSV, tt.req, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400: %s", tt.name, rec.Code, rec.Body)
+		}
+	}
+}
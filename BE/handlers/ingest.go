@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gomongoviz/model"
+	"gomongoviz/service"
+)
+
+// errInvalidRecord stops decoding an ingest request at the first record failing validation
+var errInvalidRecord = errors.New("invalid record")
+
+// ingestContentTypes are the request bodies accepted by Ingest
+var ingestContentTypes = []string{"application/json", "application/x-ndjson", "application/jsonl"}
+
+// Ingest handles devices pushing sensor data continuously
+// The body is a single JSON object, a JSON array or newline-delimited JSON objects,
+// optionally compressed with Content-Encoding: gzip
+// Records are validated like UploadJSON and queued for the next bulk insert; the reply
+// is sent before they are written unless ?wait=true is given
+// URL pattern: /api/ingest
+func (h *Handler) Ingest(w http.ResponseWriter, r *http.Request) {
+	// Handle OPTIONS preflight request; the CORS middleware sets the headers
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	wait := false
+	if value := r.URL.Query().Get("wait"); value != "" {
+		var err error
+		if wait, err = strconv.ParseBool(value); err != nil {
+			writeResponse(w, http.StatusBadRequest, map[string]string{
+				"error":   "Invalid wait",
+				"message": "wait must be true or false",
+			})
+			return
+		}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if !containsFold(ingestContentTypes, mediaType) {
+		writeResponse(w, http.StatusUnsupportedMediaType, map[string]string{
+			"error":   "Invalid content type",
+			"message": "Content-Type must be one of " + strings.Join(ingestContentTypes, ", "),
+		})
+		return
+	}
+
+	var body io.Reader = r.Body
+	switch encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+	case "gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			writeResponse(w, http.StatusBadRequest, map[string]string{
+				"error":   "Failed to decompress data",
+				"message": err.Error(),
+			})
+			return
+		}
+		defer gz.Close()
+		body = gz
+	default:
+		writeResponse(w, http.StatusUnsupportedMediaType, map[string]string{
+			"error":   "Invalid content encoding",
+			"message": fmt.Sprintf("Content-Encoding %q is not supported, use gzip", encoding),
+		})
+		return
+	}
+
+	// Records are queued a batch at a time, so long NDJSON streams are not held in
+	// memory until the request ends
+	chunk := h.service.IngestBatchSize()
+	accepted := 0
+	var pending []model.SensorData
+	var done []<-chan error
+	queue := func() error {
+		result, err := h.service.Ingest(pending)
+		if err != nil {
+			return err
+		}
+		accepted += len(pending)
+		done = append(done, result)
+		pending = pending[:0]
+		return nil
+	}
+
+	index := 0
+	var invalid string // Why the first invalid record was rejected
+	err := decodeRecords(body, func(record model.SensorData) error {
+		// Validate timestamp, object_id and port_num
+		if err := service.ValidateSensorRecord(record); err != nil {
+			invalid = fmt.Sprintf("Record at index %d has %v", index, err)
+			return errInvalidRecord
+		}
+		index++
+		pending = append(pending, record)
+		if len(pending) < chunk {
+			return nil
+		}
+		return queue()
+	})
+
+	// Valid records preceding a bad one are kept, as a device cannot tell which were stored
+	if !errors.Is(err, service.ErrIngestBufferFull) {
+		if queueErr := queue(); queueErr != nil && err == nil {
+			err = queueErr
+		}
+	}
+	switch {
+	case errors.Is(err, service.ErrIngestBufferFull):
+		w.Header().Set("Retry-After", "1")
+		writeResponse(w, http.StatusServiceUnavailable, map[string]interface{}{
+			"error":    "Ingest buffer full",
+			"message":  err.Error(),
+			"accepted": accepted,
+		})
+		return
+	case errors.Is(err, errInvalidRecord):
+		writeResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"error":    "Invalid data",
+			"message":  invalid,
+			"accepted": accepted,
+		})
+		return
+	case err != nil:
+		writeResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"error":    "Failed to parse JSON data",
+			"message":  err.Error(),
+			"accepted": accepted,
+		})
+		return
+	case accepted == 0:
+		writeResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"error":    "No data provided",
+			"message":  "Request body contains no records",
+			"accepted": 0,
+		})
+		return
+	}
+
+	if !wait {
+		writeResponse(w, http.StatusAccepted, map[string]interface{}{
+			"success":  true,
+			"message":  fmt.Sprintf("Queued %d sensor data records", accepted),
+			"accepted": accepted,
+		})
+		return
+	}
+
+	for _, result := range done {
+		var err error
+		select {
+		case err = <-result:
+		case <-r.Context().Done():
+			// The records stay queued; only the client stopped waiting
+			log.Printf("Ingest client left before %d records were written", accepted)
+			return
+		}
+		if err != nil {
+			writeResponse(w, errorStatus(err), map[string]interface{}{
+				"error":    "Failed to save sensor data",
+				"message":  err.Error(),
+				"accepted": accepted,
+			})
+			return
+		}
+	}
+	writeResponse(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"message":  fmt.Sprintf("Stored %d sensor data records", accepted),
+		"accepted": accepted,
+	})
+}
+
+// decodeRecords calls fn for every record of a JSON object, a JSON array of objects or
+// a stream of newline-delimited objects, stopping at the first error
+func decodeRecords(body io.Reader, fn func(model.SensorData) error) error {
+	reader := bufio.NewReader(body)
+	first, err := firstByte(reader)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(reader)
+	if first != '[' {
+		// A single object is a stream of one
+		for {
+			var record model.SensorData
+			if err := decoder.Decode(&record); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := decoder.Token(); err != nil {
+		return err
+	}
+	for decoder.More() {
+		var record model.SensorData
+		if err := decoder.Decode(&record); err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// firstByte returns the first byte of the body that is not white space, leaving it unread
+func firstByte(reader *bufio.Reader) (byte, error) {
+	for {
+		r, _, err := reader.ReadRune()
+		if err != nil {
+			return 0, err
+		}
+		if !unicode.IsSpace(r) {
+			return byte(r), reader.UnreadRune()
+		}
+	}
+}
+
+// containsFold reports whether values contains value, ignoring case
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// ingest posts a body to the ingest endpoint and waits for the records to be stored
+func ingest(h *Handler, contentType string, encoding string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/ingest?wait=true", bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	return serve(h.Ingest, req, nil)
+}
+
+func TestIngest(t *testing.T) {
+	h := newTestHandler(t)
+
+	ndjson := `{"timestamp":"2024-01-01T00:00:00Z","object_id":9,"port_num":1,"voltage":3}
+{"timestamp":"2024-01-01T00:01:00Z","object_id":9,"port_num":1,"voltage":3.5}
+`
+	rec := ingest(h, "application/x-ndjson", "", []byte(ndjson))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var result struct {
+		Accepted int `json:"accepted"`
+	}
+	decode(t, rec, &result)
+	if result.Accepted != 2 {
+		t.Errorf("accepted %d records, want 2", result.Accepted)
+	}
+
+	// A gzip compressed JSON array, including a reading that is already stored
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write([]byte(`[{"timestamp":"2024-01-01T00:01:00Z","object_id":9,"port_num":1,"voltage":3.5},
+		{"timestamp":"2024-01-01T00:02:00Z","object_id":9,"port_num":2,"voltage":4}]`))
+	gz.Close()
+	if rec := ingest(h, "application/json", "gzip", compressed.Bytes()); rec.Code != http.StatusOK {
+		t.Fatalf("gzip: status %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = serve(h.GetDataByObjectID, httptest.NewRequest(http.MethodGet, "/api/data/9", nil), map[string]string{"objectId": "9"})
+	var page dataPage
+	decode(t, rec, &page)
+	if page.Total != 3 {
+		t.Errorf("got %d stored readings, want 3", page.Total)
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		encoding    string
+		body        string
+		status      int
+	}{
+		{"missing object_id", "application/json", "", `{"timestamp":"2024-01-01T00:00:00Z","port_num":1}`, http.StatusBadRequest},
+		{"malformed JSON", "application/x-ndjson", "", `{"timestamp":`, http.StatusBadRequest},
+		{"empty body", "application/json", "", "", http.StatusBadRequest},
+		{"CSV body", "text/csv", "", "timestamp\n", http.StatusUnsupportedMediaType},
+		{"unknown encoding", "application/json", "br", "{}", http.StatusUnsupportedMediaType},
+		{"corrupt gzip", "application/json", "gzip", "not gzip", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := ingest(h, tt.contentType, tt.encoding, []byte(tt.body))
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.status, strings.TrimSpace(rec.Body.String()))
+		}
+	}
+}
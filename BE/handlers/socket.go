@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"gomongoviz/model"
+	"gomongoviz/service"
+
+	"github.com/gorilla/websocket"
+)
+
+// Limits of the live WebSocket connections
+const (
+	socketWriteWait = 10 * time.Second // Time allowed to write one message to the client
+	socketReadLimit = 64 << 10         // Largest message accepted from the client
+	socketReplies   = 16               // Replies queued for the writer before reading pauses
+)
+
+// socketRequest is a message sent by a live WebSocket client
+type socketRequest struct {
+	Type          string               `json:"type"`          // "subscribe" or "unsubscribe"
+	Subscriptions []model.Subscription `json:"subscriptions"` // Object, port and fields tuples to add or remove
+}
+
+// LiveSocket handles WebSocket connections subscribing to the new sensor data of any
+// number of objects and ports
+// Clients send {"type":"subscribe"|"unsubscribe","subscriptions":[{"object_id":1,"port_num":2,"fields":["voltage"]}]}
+// and receive "subscribed" acknowledgements, "reading" messages and "error" messages
+// URL pattern: /api/live
+func (h *Handler) LiveSocket(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{CheckOrigin: h.checkOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already answered the request
+		log.Printf("Error opening live socket: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	client := h.service.NewLiveClient()
+	defer client.Close()
+
+	// The reader handles subscriptions and hands its replies to this goroutine,
+	// which is the only one writing to the connection
+	replies := make(chan interface{}, socketReplies)
+	readErr := make(chan error, 1)
+	closed := make(chan struct{})
+	defer close(closed)
+	go func() {
+		readErr <- h.readSocket(conn, client, replies, closed)
+	}()
+
+	ping := time.NewTicker(h.service.StreamHeartbeat())
+	defer ping.Stop()
+	for {
+		var message interface{}
+		select {
+		case reading := <-client.Readings():
+			var data interface{} = reading.Data
+			if len(reading.Fields) > 0 {
+				data = reading.Data.Project(reading.Fields)
+			}
+			message = map[string]interface{}{"type": "reading", "data": data}
+		case message = <-replies:
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(socketWriteWait)); err != nil {
+				return
+			}
+			continue
+		case <-client.Done():
+			// Only slow clients are dropped by the hub; they may reconnect and resubscribe
+			reason := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, service.ErrSlowConsumer.Error())
+			conn.WriteControl(websocket.CloseMessage, reason, time.Now().Add(socketWriteWait))
+			return
+		case err := <-readErr:
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Printf("Live socket closed: %v", err)
+			}
+			return
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+		if err := conn.WriteJSON(message); err != nil {
+			return
+		}
+	}
+}
+
+// readSocket applies the subscription requests of a client until the connection fails
+// The client must answer pings within two heartbeats
+func (h *Handler) readSocket(conn *websocket.Conn, client *service.LiveClient, replies chan<- interface{}, closed <-chan struct{}) error {
+	timeout := 2*h.service.StreamHeartbeat() + socketWriteWait
+	conn.SetReadLimit(socketReadLimit)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(timeout))
+	})
+
+	for {
+		_, raw, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout))
+
+		var reply interface{}
+		var request socketRequest
+		if err := json.Unmarshal(raw, &request); err != nil {
+			reply = socketError("Invalid message", err)
+		} else {
+			switch request.Type {
+			case "subscribe":
+				subscriptions, err := client.Subscribe(request.Subscriptions)
+				if err != nil {
+					reply = socketError("Invalid subscription", err)
+				} else {
+					reply = map[string]interface{}{"type": "subscribed", "subscriptions": subscriptions}
+				}
+			case "unsubscribe":
+				subscriptions := client.Unsubscribe(request.Subscriptions)
+				reply = map[string]interface{}{"type": "subscribed", "subscriptions": subscriptions}
+			default:
+				reply = socketError("Invalid message", errors.New(`type must be "subscribe" or "unsubscribe"`))
+			}
+		}
+
+		select {
+		case replies <- reply:
+		case <-closed:
+			return nil
+		}
+	}
+}
+
+// socketError builds an error message for a live WebSocket client
+func socketError(title string, err error) map[string]interface{} {
+	return map[string]interface{}{"type": "error", "error": title, "message": err.Error()}
+}
+
+// checkOrigin accepts WebSocket connections from the configured CORS origins
+// Requests without an Origin header do not come from a browser and are accepted
+func (h *Handler) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || slices.Contains(h.allowedOrigins, "*") || slices.Contains(h.allowedOrigins, origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gomongoviz/config"
+	"gomongoviz/model"
+
+	"github.com/gorilla/websocket"
+)
+
+// livePollInterval is how often the live tests poll the in-memory storage for new records
+const livePollInterval = 20 * time.Millisecond
+
+// socketMessage is the JSON form of every message sent to a live WebSocket client
+type socketMessage struct {
+	Type          string
+	Subscriptions []model.Subscription
+	Data          map[string]interface{}
+	Error         string
+	Message       string
+}
+
+// dialLive serves the live socket of a handler over HTTP and connects a client to it
+// The socket buffers of both ends are kept small, so a client that stops reading holds up
+// the server's writes soon
+func dialLive(t *testing.T, h *Handler) *websocket.Conn {
+	t.Helper()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(h.LiveSocket))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conn.(*net.TCPConn).SetWriteBuffer(socketReadLimit)
+		}
+	}
+	server.Start()
+	t.Cleanup(server.Close)
+	dialer := websocket.Dialer{NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := new(net.Dialer).DialContext(ctx, network, addr)
+		if err == nil {
+			err = conn.(*net.TCPConn).SetReadBuffer(socketReadLimit)
+		}
+		return conn, err
+	}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// sendSocket sends a subscription request and returns the reply
+func sendSocket(t *testing.T, conn *websocket.Conn, request socketRequest) socketMessage {
+	t.Helper()
+	if err := conn.WriteJSON(request); err != nil {
+		t.Fatal(err)
+	}
+	return receiveSocket(t, conn)
+}
+
+// receiveSocket returns the next message sent to a client, failing the test after a few seconds
+func receiveSocket(t *testing.T, conn *websocket.Conn) socketMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var message socketMessage
+	if err := conn.ReadJSON(&message); err != nil {
+		t.Fatal(err)
+	}
+	return message
+}
+
+// saveLive stores readings once the live feed has had time to find the
+// newest stored record of the objects just subscribed to
+func saveLive(t *testing.T, h *Handler, data ...model.SensorData) {
+	t.Helper()
+	time.Sleep(10 * livePollInterval)
+	if _, err := h.service.SaveSensorData(context.Background(), data, model.DuplicateSkip); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// liveReading returns a reading of an object and port at a minute after the fixture
+func liveReading(objectID float64, portNum float64, minute int) model.SensorData {
+	return model.SensorData{
+		Timestamp:  time.Date(2024, 1, 1, 0, minute, 0, 0, time.UTC),
+		ObjectID:   objectID,
+		PortNum:    portNum,
+		Voltage:    13,
+		Current:    3,
+		SupplyVolt: 16,
+	}
+}
+
+func TestLiveSocket(t *testing.T) {
+	h := newTestHandler(t, func(cfg *config.Config) { cfg.Stream.PollInterval = livePollInterval })
+	conn := dialLive(t, h)
+	port1 := 1.0
+
+	reply := sendSocket(t, conn, socketRequest{Type: "subscribe", Subscriptions: []model.Subscription{
+		{ObjectID: 7, PortNum: &port1, Fields: []string{"voltage"}},
+		{ObjectID: 7, Fields: []string{"current"}},
+	}})
+	if reply.Type != "subscribed" || len(reply.Subscriptions) != 2 {
+		t.Fatalf("got %+v, want both subscriptions", reply)
+	}
+	// A subscription for the same object and port replaces the previous one
+	reply = sendSocket(t, conn, socketRequest{Type: "subscribe", Subscriptions: []model.Subscription{
+		{ObjectID: 7, PortNum: &port1, Fields: []string{"voltage", "supply_volt"}},
+	}})
+	if len(reply.Subscriptions) != 2 || len(reply.Subscriptions[0].Fields) != 2 {
+		t.Fatalf("got subscriptions %+v, want the fields of port 1 replaced", reply.Subscriptions)
+	}
+
+	// Port 1 matches both subscriptions and gets their fields combined, port 2 only the
+	// fields for every port, and object 8 nothing
+	saveLive(t, h, liveReading(7, 1, 10), liveReading(7, 2, 11), liveReading(8, 1, 12))
+	tests := []struct {
+		portNum float64
+		fields  []string
+		absent  []string
+	}{
+		{portNum: 1, fields: []string{"voltage", "supply_volt", "current"}, absent: []string{"power", "voc"}},
+		{portNum: 2, fields: []string{"current"}, absent: []string{"voltage", "supply_volt"}},
+	}
+	for _, tt := range tests {
+		message := receiveSocket(t, conn)
+		if message.Type != "reading" || message.Data["object_id"] != 7.0 || message.Data["port_num"] != tt.portNum {
+			t.Fatalf("got %+v, want a reading of port %g", message, tt.portNum)
+		}
+		for _, field := range tt.fields {
+			if _, ok := message.Data[field]; !ok {
+				t.Errorf("port %g: %s is missing from %v", tt.portNum, field, message.Data)
+			}
+		}
+		for _, field := range tt.absent {
+			if _, ok := message.Data[field]; ok {
+				t.Errorf("port %g: %s is sent without being subscribed to", tt.portNum, field)
+			}
+		}
+	}
+
+	// Once the subscription for every port is removed, only port 1 is sent, so the
+	// reading of port 2 stored first is skipped
+	reply = sendSocket(t, conn, socketRequest{Type: "unsubscribe", Subscriptions: []model.Subscription{{ObjectID: 7}}})
+	if reply.Type != "subscribed" || len(reply.Subscriptions) != 1 || reply.Subscriptions[0].PortNum == nil {
+		t.Fatalf("got %+v, want the subscription of port 1 left", reply)
+	}
+	saveLive(t, h, liveReading(7, 2, 13), liveReading(7, 1, 14))
+	message := receiveSocket(t, conn)
+	if message.Type != "reading" || message.Data["port_num"] != 1.0 || message.Data["timestamp"] != "2024-01-01T00:14:00Z" {
+		t.Fatalf("got %+v, want the reading of port 1 at 00:14", message)
+	}
+	if _, ok := message.Data["current"]; ok {
+		t.Error("current is sent after its subscription was removed")
+	}
+
+	// Invalid requests are answered with an error and leave the subscriptions as they were
+	invalid := []socketRequest{
+		{Type: "subscribe", Subscriptions: []model.Subscription{{ObjectID: 7, Fields: []string{"bogus"}}}},
+		{Type: "bogus"},
+	}
+	for _, request := range invalid {
+		if reply := sendSocket(t, conn, request); reply.Type != "error" || reply.Message == "" {
+			t.Errorf("got %+v for %+v, want an error", reply, request)
+		}
+	}
+}
+
+func TestLiveSocketSlowConsumer(t *testing.T) {
+	h := newTestHandler(t, func(cfg *config.Config) {
+		cfg.Stream.PollInterval = livePollInterval
+		cfg.Stream.ClientBuffer = 1
+	})
+	conn := dialLive(t, h)
+	if reply := sendSocket(t, conn, socketRequest{Type: "subscribe", Subscriptions: []model.Subscription{{ObjectID: 7}}}); reply.Type != "subscribed" {
+		t.Fatalf("got %+v, want the subscription", reply)
+	}
+
+	// The client reads nothing while more readings than the connection buffers arrive, so
+	// the writer blocks and the hub drops the client once the grace period is over
+	const readings = 5000
+	data := make([]model.SensorData, readings)
+	for i := range data {
+		data[i] = liveReading(7, 1, 10)
+		data[i].Timestamp = data[i].Timestamp.Add(time.Duration(i) * time.Second)
+	}
+	// The hub waits five seconds for a full queue before dropping the client
+	saveLive(t, h, data...)
+	time.Sleep(7 * time.Second)
+
+	// The readings sent before the drop are followed by a close asking to retry later
+	received := 0
+	for {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var message socketMessage
+		err := conn.ReadJSON(&message)
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseTryAgainLater) {
+				t.Fatalf("got %v after %d readings, want a close asking to try again later", err, received)
+			}
+			break
+		}
+		received++
+	}
+	if received >= readings {
+		t.Errorf("got all %d readings, want the client dropped before", received)
+	}
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gomongoviz/model"
+	"gomongoviz/service"
+
+	"github.com/gorilla/mux"
+)
+
+// streamBuffer is the number of records queued for a live stream client before
+// reading new records pauses
+const streamBuffer = 64
+
+// streamRetry is the reconnection delay suggested to EventSource clients
+const streamRetry = 3 * time.Second
+
+// StreamData handles Server-Sent Events requests pushing newly stored sensor data of an object
+// Every record is sent as a "reading" event whose ID is accepted back in the Last-Event-ID
+// header, or the last_event_id parameter, to resume after it when reconnecting
+// URL pattern: /api/stream/{objectId}?port_num=X&fields=voltage,current
+func (h *Handler) StreamData(w http.ResponseWriter, r *http.Request) {
+	objectID := mux.Vars(r)["objectId"]
+	params := r.URL.Query()
+
+	if _, err := strconv.ParseFloat(objectID, 64); err != nil {
+		writeResponse(w, http.StatusBadRequest, "invalid objectId: must be a number")
+		return
+	}
+	query := model.SensorDataQuery{ObjectID: objectID, PortNum: params.Get("port_num")}
+	if query.PortNum != "" {
+		if _, err := strconv.ParseFloat(query.PortNum, 64); err != nil {
+			writeResponse(w, http.StatusBadRequest, "invalid port_num: must be a number")
+			return
+		}
+	}
+	if err := service.ParseProjection(params.Get("fields"), &query); err != nil {
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Virtual and computed fields are computed by the queries of stored data, not for new records
+	for _, field := range query.Projection {
+		if !model.IsField(field) {
+			writeResponse(w, http.StatusBadRequest, fmt.Sprintf("field %q is not a stored field and not available on live streams", field))
+			return
+		}
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = params.Get("last_event_id")
+	}
+	after, err := service.ParseEventID(lastEventID)
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // Keep reverse proxies from holding events back
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry.Milliseconds())
+	if err := rc.Flush(); err != nil {
+		log.Printf("Error starting stream for object %s: %v", objectID, err)
+		return
+	}
+
+	// The watch runs in its own goroutine so that only this one writes to the response
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	events := make(chan service.LiveEvent, streamBuffer)
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- h.service.WatchData(ctx, query, after, func(event service.LiveEvent) error {
+			select {
+			case events <- event:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	heartbeat := time.NewTicker(h.service.StreamHeartbeat())
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event := <-events:
+			var payload interface{} = event.Data
+			if len(query.Projection) > 0 {
+				payload = event.Data.Project(query.Projection)
+			}
+			data, err := json.Marshal(payload)
+			if err != nil {
+				log.Printf("Error encoding stream event: %v", err)
+				return
+			}
+			fmt.Fprintf(w, "id: %s\nevent: reading\ndata: %s\n\n", event.ID, data)
+		case <-heartbeat.C:
+			// Comments keep idle connections open through proxies
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case err := <-watchErr:
+			if !errors.Is(err, context.Canceled) {
+				log.Printf("Error streaming data for object %s: %v", objectID, err)
+				message, _ := json.Marshal(map[string]string{"error": "Stream failed", "message": err.Error()})
+				fmt.Fprintf(w, "event: error\ndata: %s\n\n", message)
+				rc.Flush()
+			}
+			return
+		case <-ctx.Done():
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gomongoviz/config"
+
+	"github.com/gorilla/mux"
+)
+
+// streamEvent is one Server-Sent Event
+type streamEvent struct {
+	ID    string
+	Event string
+	Data  map[string]interface{}
+}
+
+// sseReader reads the events of a live stream response
+type sseReader struct {
+	res     *http.Response
+	scanner *bufio.Scanner
+}
+
+// openStream requests the live stream of object 7 with a Last-Event-ID
+// The stream is closed after a few seconds, so a missing event fails the test
+func openStream(t *testing.T, server *httptest.Server, query string, lastEventID string) *sseReader {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/stream/7?"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	return &sseReader{res: res, scanner: bufio.NewScanner(res.Body)}
+}
+
+// next returns the next event, skipping comments and the retry delay
+func (r *sseReader) next(t *testing.T) streamEvent {
+	t.Helper()
+	var event streamEvent
+	for r.scanner.Scan() {
+		line := r.scanner.Text()
+		if line == "" {
+			if event.Event != "" {
+				return event
+			}
+			continue
+		}
+		name, value, _ := strings.Cut(line, ": ")
+		switch name {
+		case "id":
+			event.ID = value
+		case "event":
+			event.Event = value
+		case "data":
+			if err := json.Unmarshal([]byte(value), &event.Data); err != nil {
+				t.Fatalf("decoding %q: %v", value, err)
+			}
+		}
+	}
+	t.Fatalf("stream ended before the next event: %v", r.scanner.Err())
+	return event
+}
+
+func TestStreamResume(t *testing.T) {
+	h := newTestHandler(t, func(cfg *config.Config) { cfg.Stream.PollInterval = livePollInterval })
+	router := mux.NewRouter()
+	router.HandleFunc("/api/stream/{objectId}", h.StreamData)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	// A new client gets the readings of its port stored after it connects
+	first := openStream(t, server, "port_num=1&fields=voltage", "")
+	saveLive(t, h, liveReading(7, 1, 10), liveReading(7, 2, 11), liveReading(7, 1, 12))
+	received := first.next(t)
+	if received.Event != "reading" || received.ID == "" || received.Data["timestamp"] != "2024-01-01T00:10:00Z" {
+		t.Fatalf("got %+v, want the reading of 00:10", received)
+	}
+	if _, ok := received.Data["current"]; ok {
+		t.Error("current is sent without being listed")
+	}
+
+	// A client reconnecting with the ID of the last event it got is sent what it missed
+	// first, then the readings stored afterwards
+	resumed := openStream(t, server, "port_num=1&fields=voltage", received.ID)
+	if event := resumed.next(t); event.Data["timestamp"] != "2024-01-01T00:12:00Z" {
+		t.Fatalf("got %+v, want the missed reading of 00:12", event)
+	}
+	saveLive(t, h, liveReading(7, 1, 13))
+	if event := resumed.next(t); event.Data["timestamp"] != "2024-01-01T00:13:00Z" {
+		t.Fatalf("got %+v, want the new reading of 00:13", event)
+	}
+
+	// The parameter is accepted from clients that cannot set the header
+	param := openStream(t, server, "port_num=1&last_event_id="+received.ID, "")
+	for _, want := range []string{"2024-01-01T00:12:00Z", "2024-01-01T00:13:00Z"} {
+		if event := param.next(t); event.Data["timestamp"] != want {
+			t.Fatalf("got %+v, want the reading of %s", event, want)
+		}
+	}
+
+	if res := openStream(t, server, "", "bogus").res; res.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status %d for an invalid Last-Event-ID, want %d", res.StatusCode, http.StatusBadRequest)
+	}
+}
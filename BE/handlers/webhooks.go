@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gomongoviz/model"
+	"gomongoviz/repository"
+	"gomongoviz/service"
+
+	"github.com/gorilla/mux"
+)
+
+// ListWebhookDeliveries handles HTTP requests for the webhook delivery history, newest first
+// state=dead lists the dead-letter log
+// URL pattern: /api/webhooks/deliveries?state=pending,dead&endpoint=X&event=alert.firing&limit=100
+func (h *Handler) ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query := model.WebhookDeliveryQuery{Endpoint: params.Get("endpoint"), Event: params.Get("event")}
+	for _, state := range strings.Split(params.Get("state"), ",") {
+		if state = strings.TrimSpace(state); state != "" {
+			query.States = append(query.States, state)
+		}
+	}
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit < 0 {
+			writeResponse(w, http.StatusBadRequest, "limit must be a non-negative integer")
+			return
+		}
+		query.Limit = limit
+	}
+
+	deliveries, err := h.service.ListWebhookDeliveries(r.Context(), query)
+	if err != nil {
+		writeResponse(w, errorStatus(err), err.Error())
+		return
+	}
+
+	writeResponse(w, http.StatusOK, deliveries)
+}
+
+// GetWebhookDelivery handles HTTP requests for one webhook delivery and its attempts
+// URL pattern: /api/webhooks/deliveries/{id}
+func (h *Handler) GetWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	delivery, err := h.service.GetWebhookDelivery(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		writeResponse(w, http.StatusNotFound, fmt.Sprintf("webhook delivery %s not found", id))
+		return
+	}
+	if err != nil {
+		writeResponse(w, errorStatus(err), err.Error())
+		return
+	}
+
+	writeResponse(w, http.StatusOK, delivery)
+}
+
+// RedeliverWebhook handles HTTP requests to send a dead or delivered notification again
+// URL pattern: /api/webhooks/deliveries/{id}/redeliver
+func (h *Handler) RedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	delivery, err := h.service.RedeliverWebhook(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		writeResponse(w, http.StatusNotFound, fmt.Sprintf("webhook delivery %s not found", id))
+		return
+	}
+	if errors.Is(err, service.ErrDeliveryPending) {
+		writeResponse(w, http.StatusConflict, map[string]string{
+			"error":   "Delivery not queued again",
+			"message": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		writeResponse(w, errorStatus(err), err.Error())
+		return
+	}
+
+	writeResponse(w, http.StatusAccepted, delivery)
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gomongoviz/config"
+	"gomongoviz/model"
+)
+
+func TestWebhookDeliveries(t *testing.T) {
+	var requests atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "receiver down", http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	h := newTestHandler(t, func(cfg *config.Config) {
+		cfg.Webhooks.Endpoints = []config.WebhookEndpoint{{Name: "ops", URL: receiver.URL, Secret: "s3cret"}}
+		cfg.Webhooks.MaxAttempts = 2
+		cfg.Webhooks.InitialBackoff = 200 * time.Millisecond
+	})
+
+	// A finished import job notifies the endpoint, which fails every attempt
+	req := httptest.NewRequest(http.MethodPost, "/api/imports?filename=readings.csv", strings.NewReader(
+		"timestamp,object_id,port_num,voltage,current,supply_current,supply_volt,voltage_drop,voc\n"+
+			"2024-01-01T00:00:00Z,8,1,3,1,1,4,0.5,14\n"))
+	req.Header.Set("Content-Type", "text/csv")
+	if rec := serve(h.SubmitImport, req, nil); rec.Code != http.StatusAccepted {
+		t.Fatalf("import: status %d: %s", rec.Code, rec.Body)
+	}
+
+	list := func(query string) []model.WebhookDelivery {
+		t.Helper()
+		rec := serve(h.ListWebhookDeliveries, httptest.NewRequest(http.MethodGet, "/api/webhooks/deliveries?"+query, nil), nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", query, rec.Code, rec.Body)
+		}
+		var deliveries []model.WebhookDelivery
+		decode(t, rec, &deliveries)
+		return deliveries
+	}
+	var dead []model.WebhookDelivery
+	for deadline := time.Now().Add(5 * time.Second); len(dead) == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("no dead letter, history is %+v", list(""))
+		}
+		dead = list("state=dead")
+	}
+
+	delivery := dead[0]
+	if delivery.Endpoint != "ops" || delivery.Event != model.EventImportSucceeded || len(delivery.Attempts) != 2 {
+		t.Fatalf("got %+v, want an import.succeeded delivery to ops with 2 attempts", delivery)
+	}
+	for _, attempt := range delivery.Attempts {
+		if attempt.StatusCode != http.StatusInternalServerError || !strings.Contains(attempt.Error, "receiver down") {
+			t.Errorf("got attempt %+v, want the 500 answered by the receiver", attempt)
+		}
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("receiver got %d requests, want 2", n)
+	}
+
+	// Filters of the history
+	for query, want := range map[string]int{
+		"":                       1,
+		"state=pending,dead":     1,
+		"state=delivered":        0,
+		"endpoint=ops":           1,
+		"endpoint=other":         0,
+		"event=import.succeeded": 1,
+		"event=alert.firing":     0,
+		"limit=1":                1,
+	} {
+		if got := len(list(query)); got != want {
+			t.Errorf("%q: got %d deliveries, want %d", query, got, want)
+		}
+	}
+	if rec := serve(h.ListWebhookDeliveries, httptest.NewRequest(http.MethodGet, "/api/webhooks/deliveries?limit=-1", nil), nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("negative limit: status %d, want 400", rec.Code)
+	}
+
+	// One delivery with its attempts
+	get := func(id string) *httptest.ResponseRecorder {
+		return serve(h.GetWebhookDelivery, httptest.NewRequest(http.MethodGet, "/api/webhooks/deliveries/"+id, nil), map[string]string{"id": id})
+	}
+	rec := get(delivery.ID)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var got model.WebhookDelivery
+	decode(t, rec, &got)
+	if got.ID != delivery.ID || len(got.Attempts) != 2 || string(got.Payload) != string(delivery.Payload) {
+		t.Errorf("got %+v, want %+v", got, delivery)
+	}
+	if rec := get("missing"); rec.Code != http.StatusNotFound {
+		t.Errorf("missing delivery: status %d, want 404", rec.Code)
+	}
+
+	// Redelivery queues the dead letter again, once
+	redeliver := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/webhooks/deliveries/"+id+"/redeliver", nil)
+		return serve(h.RedeliverWebhook, req, map[string]string{"id": id})
+	}
+	if rec := redeliver(delivery.ID); rec.Code != http.StatusAccepted {
+		t.Fatalf("redeliver: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := redeliver(delivery.ID); rec.Code != http.StatusConflict {
+		t.Errorf("redeliver while pending: status %d, want 409", rec.Code)
+	}
+	if rec := redeliver("missing"); rec.Code != http.StatusNotFound {
+		t.Errorf("redeliver missing: status %d, want 404", rec.Code)
+	}
+}
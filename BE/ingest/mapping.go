@@ -0,0 +1,142 @@
+package ingest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gomongoviz/model"
+)
+
+// Fields that are set by the storage and never read from devices
+var storedFields = map[string]bool{"id": true, "created_at": true}
+
+// topicPattern is a configured topic filter whose {field} levels set fields of the
+// readings published on matching topics
+type topicPattern struct {
+	filter string         // Filter subscribed to, with {field} levels replaced by +
+	levels []string       // Levels of the filter
+	fields map[int]string // Fields set from the topic, by level
+}
+
+// parseTopicPattern validates a configured topic such as
+// "sites/+/object/{object_id}/port/{port_num}"
+func parseTopicPattern(pattern string) (topicPattern, error) {
+	if pattern == "" {
+		return topicPattern{}, errors.New("topic must not be empty")
+	}
+	levels := strings.Split(pattern, "/")
+	topic := topicPattern{levels: levels, fields: make(map[int]string)}
+	for i, level := range levels {
+		switch {
+		case level == "+":
+		case level == "#":
+			if i != len(levels)-1 {
+				return topicPattern{}, fmt.Errorf("topic %q: # must be the last level", pattern)
+			}
+		case strings.HasPrefix(level, "{") && strings.HasSuffix(level, "}"):
+			field := level[1 : len(level)-1]
+			if !model.IsField(field) || storedFields[field] {
+				return topicPattern{}, fmt.Errorf("topic %q: unknown field %q", pattern, field)
+			}
+			topic.fields[i] = field
+			levels[i] = "+"
+		case strings.ContainsAny(level, "+#{}"):
+			return topicPattern{}, fmt.Errorf("topic %q: invalid level %q", pattern, level)
+		}
+	}
+	topic.filter = strings.Join(levels, "/")
+	return topic, nil
+}
+
+// match returns the field values carried by a topic and whether it matches the filter
+func (p topicPattern) match(topic string) (map[string]string, bool) {
+	levels := strings.Split(topic, "/")
+	values := make(map[string]string, len(p.fields))
+	for i, level := range p.levels {
+		if level == "#" {
+			return values, true
+		}
+		if i >= len(levels) || (level != "+" && level != levels[i]) {
+			return nil, false
+		}
+		if field, ok := p.fields[i]; ok {
+			values[field] = levels[i]
+		}
+	}
+	return values, len(levels) == len(p.levels)
+}
+
+// validateFieldMap checks that payload keys are mapped to fields devices may set
+func validateFieldMap(fields map[string]string) error {
+	for key, field := range fields {
+		if !model.IsField(field) || storedFields[field] {
+			return fmt.Errorf("payload key %q is mapped to unknown field %q", key, field)
+		}
+	}
+	return nil
+}
+
+// decodePayload turns an MQTT payload holding one JSON object or an array of objects
+// into readings
+// Payload keys are renamed with fields, then the values taken from the topic are applied
+// on top; the readings still have to be validated
+func decodePayload(payload []byte, fields map[string]string, topicValues map[string]string) ([]model.SensorData, error) {
+	var objects []map[string]interface{}
+	if trimmed := strings.TrimSpace(string(payload)); strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal(payload, &objects); err != nil {
+			return nil, err
+		}
+	} else {
+		var object map[string]interface{}
+		if err := json.Unmarshal(payload, &object); err != nil {
+			return nil, err
+		}
+		objects = append(objects, object)
+	}
+
+	records := make([]model.SensorData, 0, len(objects))
+	for _, object := range objects {
+		mapped := make(map[string]interface{}, len(object)+len(topicValues))
+		for key, value := range object {
+			if field, ok := fields[key]; ok {
+				key = field
+			}
+			if !storedFields[key] {
+				mapped[key] = value
+			}
+		}
+		for field, value := range topicValues {
+			switch {
+			case model.IsNumericField(field) || field == "object_id" || field == "port_num":
+				number, err := strconv.ParseFloat(value, 64)
+				if err != nil {
+					return nil, fmt.Errorf("topic level %q is not a number for %s", value, field)
+				}
+				mapped[field] = number
+			case field == "read_error":
+				flag, err := strconv.ParseBool(value)
+				if err != nil {
+					return nil, fmt.Errorf("topic level %q is not true or false for %s", value, field)
+				}
+				mapped[field] = flag
+			default:
+				mapped[field] = value
+			}
+		}
+
+		// Decoding through JSON applies the same rules as the JSON upload
+		raw, err := json.Marshal(mapped)
+		if err != nil {
+			return nil, err
+		}
+		var record model.SensorData
+		if err := json.Unmarshal(raw, &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
@@ -0,0 +1,205 @@
+// Package ingest feeds readings published by field devices into the service
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gomongoviz/config"
+	"gomongoviz/model"
+	"gomongoviz/service"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Timings of the MQTT subscriber
+const (
+	mqttFullRetry  = 200 * time.Millisecond // Delay between attempts to queue a message while the ingest buffer is full
+	mqttDisconnect = 250                    // Milliseconds allowed to finish in-flight work when closing
+)
+
+// MQTTSubscriber subscribes to the configured topics and queues the readings published
+// on them like the ingest endpoint does, so they are written in batches with retries
+// The broker keeps a persistent session for the client ID: messages published while the
+// backend is down are delivered when it reconnects, including those that were not
+// acknowledged yet; a message is acknowledged only once its readings are stored
+type MQTTSubscriber struct {
+	svc    *service.Service
+	cfg    config.MQTTConfig
+	topics []topicPattern
+	client mqtt.Client
+	ctx    context.Context // Cancelled when the subscriber stops
+}
+
+// NewMQTTSubscriber validates the topics and field mapping of cfg
+// The subscriber does not connect until Start is called
+func NewMQTTSubscriber(svc *service.Service, cfg config.MQTTConfig) (*MQTTSubscriber, error) {
+	s := &MQTTSubscriber{svc: svc, cfg: cfg}
+	var errs []error
+	for _, topic := range cfg.Topics {
+		pattern, err := parseTopicPattern(topic)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		s.topics = append(s.topics, pattern)
+	}
+	if err := validateFieldMap(cfg.Fields); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid mqtt configuration: %w", errors.Join(errs...))
+	}
+	return s, nil
+}
+
+// Start connects to the broker in the background and subscribes to the topics every
+// time the connection is established, until ctx is cancelled
+// A broker that cannot be reached is retried rather than failing the startup
+func (s *MQTTSubscriber) Start(ctx context.Context) {
+	s.ctx = ctx
+	opts := mqtt.NewClientOptions().
+		AddBroker(s.cfg.Broker).
+		SetClientID(s.cfg.ClientID).
+		SetUsername(s.cfg.Username).
+		SetPassword(s.cfg.Password).
+		SetCleanSession(false).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetMaxReconnectInterval(time.Minute).
+		SetAutoAckDisabled(true).
+		SetDefaultPublishHandler(s.handle).
+		SetOnConnectHandler(s.subscribe).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("MQTT connection to %s lost, reconnecting: %v", s.cfg.Broker, err)
+		})
+	s.client = mqtt.NewClient(opts)
+	s.client.Connect()
+	log.Printf("MQTT subscriber connecting to %s as %s", s.cfg.Broker, s.cfg.ClientID)
+
+	go func() {
+		<-ctx.Done()
+		s.client.Disconnect(mqttDisconnect)
+	}()
+}
+
+// subscribe subscribes to every topic once the client is connected
+// Messages are routed by handle, including those the broker resends from the session
+// before the subscriptions are renewed
+func (s *MQTTSubscriber) subscribe(client mqtt.Client) {
+	filters := make(map[string]byte, len(s.topics))
+	for _, topic := range s.topics {
+		filters[topic.filter] = byte(s.cfg.QoS)
+	}
+	token := client.SubscribeMultiple(filters, nil)
+	token.Wait()
+	if err := token.Error(); err != nil {
+		log.Printf("Error subscribing to MQTT topics: %v", err)
+		return
+	}
+	log.Printf("Subscribed to %d MQTT topics on %s", len(filters), s.cfg.Broker)
+}
+
+// handle queues the readings of one message and acknowledges it once they are stored
+// Messages that cannot be decoded or hold no valid reading are acknowledged and dropped,
+// as sending them again would not help
+// While the ingest buffer is full it keeps trying, which holds back the following
+// messages until the buffer is written; a message whose readings could not be stored,
+// or still unacknowledged when the subscriber stops, is sent again by the broker when
+// the session resumes
+func (s *MQTTSubscriber) handle(_ mqtt.Client, msg mqtt.Message) {
+	records, err := s.decode(msg)
+	if err != nil {
+		log.Printf("Dropping MQTT message on %s: %v", msg.Topic(), err)
+		msg.Ack()
+		return
+	}
+
+	// Readings are queued a batch at a time so a large message always fits; queueing
+	// part of them again after a restart is harmless as stored readings are skipped
+	size := s.svc.IngestBatchSize()
+	var written []<-chan error
+	for start := 0; start < len(records); start += size {
+		done, ok := s.queue(msg.Topic(), records[start:min(start+size, len(records))])
+		if !ok {
+			return
+		}
+		written = append(written, done)
+	}
+
+	// The writes are awaited aside so the next messages are queued meanwhile; the
+	// buffer is written in order, so messages are still acknowledged in order
+	go func() {
+		for _, done := range written {
+			select {
+			case err := <-done:
+				if err != nil {
+					log.Printf("Leaving MQTT message on %s to the broker: %v", msg.Topic(), err)
+					return
+				}
+			case <-s.ctx.Done():
+				return
+			}
+		}
+		msg.Ack()
+	}()
+}
+
+// queue queues readings, waiting as long as it takes for room in the ingest buffer, and
+// returns the channel reporting their write
+// It returns false if the subscriber stops first
+func (s *MQTTSubscriber) queue(topic string, records []model.SensorData) (<-chan error, bool) {
+	waiting := false
+	for {
+		done, err := s.svc.Ingest(records)
+		if err == nil {
+			return done, true
+		}
+		if !errors.Is(err, service.ErrIngestBufferFull) {
+			log.Printf("Leaving MQTT message on %s to the broker: %v", topic, err)
+			return nil, false
+		}
+		if !waiting {
+			log.Printf("Ingest buffer full, holding MQTT messages on %s until it is written", topic)
+			waiting = true
+		}
+		select {
+		case <-s.ctx.Done():
+			return nil, false
+		case <-time.After(mqttFullRetry):
+		}
+	}
+}
+
+// decode returns the valid readings of a message
+// Invalid readings are logged and skipped
+func (s *MQTTSubscriber) decode(msg mqtt.Message) ([]model.SensorData, error) {
+	for _, topic := range s.topics {
+		values, ok := topic.match(msg.Topic())
+		if !ok {
+			continue
+		}
+		records, err := decodePayload(msg.Payload(), s.cfg.Fields, values)
+		if err != nil {
+			return nil, err
+		}
+
+		valid := records[:0]
+		for i, record := range records {
+			// Validate timestamp, object_id and port_num like the JSON upload
+			if err := service.ValidateSensorRecord(record); err != nil {
+				log.Printf("Skipping reading %d of MQTT message on %s: %v", i, msg.Topic(), err)
+				continue
+			}
+			valid = append(valid, record)
+		}
+		if len(valid) == 0 {
+			return nil, errors.New("no valid reading")
+		}
+		return valid, nil
+	}
+	return nil, errors.New("topic does not match any configured topic")
+}
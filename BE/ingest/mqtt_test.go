@@ -0,0 +1,276 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"gomongoviz/config"
+	"gomongoviz/model"
+	"gomongoviz/repository"
+	"gomongoviz/service"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	broker "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+)
+
+// testTopic is the topic configured in the tests
+const testTopic = "sites/+/object/{object_id}/port/{port_num}"
+
+// batchRecorder is a repository recording the size of every batch of readings saved
+// The first failures writes fail, to test what happens to readings that cannot be stored
+type batchRecorder struct {
+	repository.Repository
+	mu       sync.Mutex
+	batches  []int
+	failures int
+}
+
+func (r *batchRecorder) SaveSensorData(ctx context.Context, data []model.SensorData, onDuplicate string) (model.SaveResult, error) {
+	r.mu.Lock()
+	r.batches = append(r.batches, len(data))
+	fail := r.failures > 0
+	if fail {
+		r.failures--
+	}
+	r.mu.Unlock()
+	if fail {
+		return model.SaveResult{}, errors.New("storage unavailable")
+	}
+	return r.Repository.SaveSensorData(ctx, data, onDuplicate)
+}
+
+// sizes returns the sizes of the batches saved so far
+func (r *batchRecorder) sizes() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.batches)
+}
+
+// startBroker runs an embedded broker on a free local port and returns its URL
+func startBroker(t *testing.T) (*broker.Server, string) {
+	t.Helper()
+	server := broker.New(&broker.Options{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	if err := server.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatal(err)
+	}
+	listener := listeners.NewTCP(listeners.Config{ID: "test", Address: "127.0.0.1:0"})
+	if err := server.AddListener(listener); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Serve(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server, "tcp://" + listener.Address()
+}
+
+// newTestService returns a service on in-memory storage recording the batches it saves
+// configure may change the default configuration before the service is created
+func newTestService(t *testing.T, url string, configure func(cfg *config.Config)) (*service.Service, *batchRecorder, config.MQTTConfig) {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Storage.Backend = config.StorageMemory
+	cfg.Ingest.FlushInterval = 20 * time.Millisecond
+	cfg.MQTT.Broker = url
+	cfg.MQTT.ClientID = "gomongoviz-test"
+	cfg.MQTT.Topics = []string{testTopic}
+	cfg.MQTT.Fields = map[string]string{"v": "voltage", "ts": "timestamp"}
+	if configure != nil {
+		configure(cfg)
+	}
+	repo := &batchRecorder{Repository: repository.NewMemoryRepository()}
+	return service.NewService(repo, cfg), repo, cfg.MQTT
+}
+
+// startSubscriber starts a subscriber and waits until the broker routes the test topic
+// to it
+func startSubscriber(t *testing.T, ctx context.Context, server *broker.Server, svc *service.Service, cfg config.MQTTConfig) {
+	t.Helper()
+	subscriber, err := NewMQTTSubscriber(svc, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscriber.Start(ctx)
+	waitFor(t, "the subscription", func() bool {
+		client, ok := server.Clients.Get(cfg.ClientID)
+		return ok && !client.Closed() && len(server.Topics.Subscribers("sites/any/object/1/port/1").Subscriptions) > 0
+	})
+}
+
+// publish sends messages to the broker at QoS 1 from a device client
+func publish(t *testing.T, url string, messages map[string]string) {
+	t.Helper()
+	client := mqtt.NewClient(mqtt.NewClientOptions().AddBroker(url).SetClientID("device"))
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	defer client.Disconnect(0)
+	for topic, payload := range messages {
+		if token := client.Publish(topic, 1, false, payload); token.Wait() && token.Error() != nil {
+			t.Fatal(token.Error())
+		}
+	}
+}
+
+// waitFor polls condition until it holds, failing the test after a few seconds
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// stored returns the readings stored for an object, in time order
+func stored(t *testing.T, svc *service.Service, objectID int) []model.SensorData {
+	t.Helper()
+	res, err := svc.GetDataByObjectID(context.Background(), model.SensorDataQuery{ObjectID: strconv.Itoa(objectID)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.SensorData
+}
+
+func TestMQTTSubscriber(t *testing.T) {
+	server, url := startBroker(t)
+	svc, repo, cfg := newTestService(t, url, func(cfg *config.Config) { cfg.Ingest.BatchSize = 3 })
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	objectID := 8.0
+	if _, err := svc.CreateAlertRule(ctx, model.AlertRule{Name: "overvoltage", ObjectID: &objectID, Field: "voltage", Operator: ">", Threshold: 20}); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.StartAlerts(ctx); err != nil {
+		t.Fatal(err)
+	}
+	svc.StartIngestFlusher(ctx)
+	startSubscriber(t, ctx, server, svc, cfg)
+
+	publish(t, url, map[string]string{
+		// One reading with mapped keys, the object and port coming from the topic
+		"sites/north/object/7/port/2": `{"ts": "2024-01-01T00:00:00Z", "v": 12.5}`,
+		// Five readings, with an unmapped key read under its own name
+		"sites/south/object/8/port/1": `[
+			{"ts": "2024-01-01T00:00:00Z", "v": 10, "current": 1},
+			{"ts": "2024-01-01T00:01:00Z", "v": 11, "current": 1},
+			{"ts": "2024-01-01T00:02:00Z", "v": 21, "current": 2},
+			{"ts": "2024-01-01T00:03:00Z", "v": 22, "current": 2},
+			{"ts": "2024-01-01T00:04:00Z", "v": 23, "current": 2}
+		]`,
+		// Dropped: not JSON, and no valid reading
+		"sites/north/object/7/port/3": `not json`,
+		"sites/north/object/7/port/4": `{"v": 1}`,
+	})
+
+	waitFor(t, "the readings", func() bool { return len(stored(t, svc, 7)) == 1 && len(stored(t, svc, 8)) == 5 })
+	reading := stored(t, svc, 7)[0]
+	if reading.PortNum != 2 || reading.Voltage != 12.5 || !reading.Timestamp.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("got reading %+v, want 12.5 V on port 2 at midnight", reading)
+	}
+	for _, reading := range stored(t, svc, 8) {
+		if reading.PortNum != 1 || reading.Current == 0 {
+			t.Errorf("got reading %+v, want port 1 with a current", reading)
+		}
+	}
+
+	// Readings are written in batches of ingest.batch_size at most
+	total := 0
+	for _, size := range repo.sizes() {
+		if size > 3 {
+			t.Errorf("saved a batch of %d readings, want at most 3", size)
+		}
+		total += size
+	}
+	if total != 6 {
+		t.Errorf("saved %d readings in batches %v, want 6", total, repo.sizes())
+	}
+
+	// The batches went through the service, which evaluated them against the rules
+	waitFor(t, "the alert", func() bool {
+		alerts, err := svc.ListAlerts(ctx, "", []string{model.AlertFiring})
+		return err == nil && len(alerts) == 1 && alerts[0].FiredAt.Equal(time.Date(2024, 1, 1, 0, 2, 0, 0, time.UTC))
+	})
+}
+
+func TestMQTTBufferFull(t *testing.T) {
+	server, url := startBroker(t)
+	svc, _, cfg := newTestService(t, url, func(cfg *config.Config) {
+		cfg.Ingest.BatchSize = 2
+		cfg.Ingest.BufferSize = 2
+	})
+
+	// Without a flusher the first message fills the buffer and the second one waits
+	first, stop := context.WithCancel(context.Background())
+	t.Cleanup(stop)
+	startSubscriber(t, first, server, svc, cfg)
+	publish(t, url, map[string]string{
+		"sites/north/object/7/port/1": `[{"ts": "2024-01-01T00:00:00Z", "v": 1}, {"ts": "2024-01-01T00:01:00Z", "v": 2}]`,
+	})
+	publish(t, url, map[string]string{
+		"sites/north/object/7/port/2": `[{"ts": "2024-01-01T00:00:00Z", "v": 3}, {"ts": "2024-01-01T00:01:00Z", "v": 4}, {"ts": "2024-01-01T00:02:00Z", "v": 5}]`,
+	})
+	time.Sleep(300 * time.Millisecond)
+
+	// The waiting message is not acknowledged when the subscriber stops, so the broker
+	// sends it again when the session resumes
+	stop()
+	waitFor(t, "the disconnection", func() bool {
+		client, ok := server.Clients.Get(cfg.ClientID)
+		return ok && client.Closed()
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	svc.StartIngestFlusher(ctx)
+	startSubscriber(t, ctx, server, svc, cfg)
+
+	// The three readings of the second message are queued a batch at a time, so a
+	// message larger than the buffer is stored too
+	waitFor(t, "the readings", func() bool { return len(stored(t, svc, 7)) == 5 })
+}
+
+func TestMQTTWriteFailure(t *testing.T) {
+	server, url := startBroker(t)
+	svc, repo, cfg := newTestService(t, url, nil)
+	repo.failures = 3 // Every attempt of the first flush
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	svc.StartIngestFlusher(ctx)
+
+	first, stop := context.WithCancel(context.Background())
+	t.Cleanup(stop)
+	startSubscriber(t, first, server, svc, cfg)
+	publish(t, url, map[string]string{
+		"sites/north/object/7/port/1": `{"ts": "2024-01-01T00:00:00Z", "v": 1}`,
+	})
+	// The flusher waits 1 then 2 seconds between its attempts
+	deadline := time.Now().Add(10 * time.Second)
+	for len(repo.sizes()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := len(stored(t, svc, 7)); n != 0 {
+		t.Fatalf("got %d stored readings, want the writes to fail", n)
+	}
+
+	// The message was not acknowledged, so the broker sends it again once the session
+	// resumes and the reading is stored then
+	stop()
+	waitFor(t, "the disconnection", func() bool {
+		client, ok := server.Clients.Get(cfg.ClientID)
+		return ok && client.Closed()
+	})
+	startSubscriber(t, ctx, server, svc, cfg)
+	waitFor(t, "the reading", func() bool { return len(stored(t, svc, 7)) == 1 })
+}
@@ -2,12 +2,18 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"gomongoviz/config"
 	"gomongoviz/database"
 	"gomongoviz/handlers"
+	"gomongoviz/ingest"
 	domain "gomongoviz/repository"
 	"gomongoviz/service"
 
@@ -15,31 +21,120 @@ import (
 	"github.com/rs/cors"
 )
 
+// shutdownTimeout is how long requests in progress may run once the server is asked to
+// stop; streams that are still open after it are closed
+const shutdownTimeout = 10 * time.Second
+
 func main() {
-	// Initialize MongoDB connection
-	// This establishes a connection to the MongoDB Atlas cluster
-	mongoClient := database.ConnectMongo()
-
-	// Ensure the MongoDB connection is properly closed when the application shuts down
-	defer func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-		if err := mongoClient.Disconnect(ctx); err != nil {
-			log.Printf("Error disconnecting from MongoDB: %v", err)
-		}
-	}()
+	// "migrate-timeseries" moves the sensor data into a time-series collection and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate-timeseries" {
+		migrateTimeSeries(os.Args[2:])
+		return
+	}
+
+	// Load configuration from defaults, config file, environment and flags
+	// Invalid settings stop the server before any connection is attempted
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Initialize the application layers following the Clean Architecture pattern:
 	// Database -> Repository -> Service -> Handler -> Router
 
-	// Set up the repository layer with database connection
-	repositoryDb := domain.NewRepositoryDefault(mongoClient)
+	// Set up the repository layer for the configured storage backend
+	var repositoryDb domain.Repository
+	switch cfg.Storage.Backend {
+	case config.StorageMemory:
+		repositoryDb = domain.NewMemoryRepository()
+		log.Printf("Using in-memory storage, data is lost when the server stops")
+	case config.StorageBolt:
+		boltRepo, err := domain.NewBoltRepository(cfg.Storage.Path)
+		if err != nil {
+			log.Fatalf("Error opening the storage file: %v", err)
+		}
+		defer boltRepo.Close()
+		repositoryDb = boltRepo
+		log.Printf("Using embedded storage in %s", cfg.Storage.Path)
+	default:
+		// Initialize MongoDB connection
+		// This establishes a connection to the configured MongoDB cluster
+		mongoClient := database.ConnectMongo(cfg.Mongo)
+
+		// Ensure the MongoDB connection is properly closed when the application shuts down
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := mongoClient.Disconnect(ctx); err != nil {
+				log.Printf("Error disconnecting from MongoDB: %v", err)
+			}
+		}()
+
+		// Make sure the sensor data collection matches the configured storage
+		// A plain collection must be migrated before time-series storage can be used
+		if err := domain.EnsureSensorCollection(context.Background(), mongoClient, cfg.Mongo); err != nil {
+			log.Fatalf("Error preparing the sensor data collection: %v", err)
+		}
+
+		repositoryDb = domain.NewRepositoryDefault(mongoClient, cfg.Mongo)
+	}
 
 	// Set up the service layer with repository
-	svc := service.NewService(repositoryDb)
+	svc := service.NewService(repositoryDb, cfg)
+
+	// Create the indexes the queries rely on if they are missing
+	// Existing duplicate records prevent the unique index from being created, so failures
+	// are logged rather than fatal and can be retried through /api/admin/indexes
+	if _, err := svc.EnsureIndexes(context.Background()); err != nil {
+		log.Printf("Error ensuring indexes: %v", err)
+	}
+
+	// Preload the storage from a fixture file, mostly useful with in-memory storage
+	if cfg.Storage.Fixture != "" {
+		result, err := svc.LoadFixture(context.Background(), cfg.Storage.Fixture)
+		if err != nil {
+			log.Fatalf("Error loading fixture: %v", err)
+		}
+		log.Printf("Loaded %d records from %s, %d already stored", result.Inserted, cfg.Storage.Fixture, result.Duplicates)
+	}
+
+	// Resume pending webhook deliveries before anything can notify new events
+	if err := svc.StartWebhooks(context.Background()); err != nil {
+		log.Fatalf("Error starting webhook notifications: %v", err)
+	}
+
+	// Start the background workers that process asynchronous imports
+	if err := svc.StartImportWorkers(context.Background()); err != nil {
+		log.Fatalf("Error starting import workers: %v", err)
+	}
+
+	// Load the alert rules and evaluate them periodically against stored data
+	if err := svc.StartAlerts(context.Background()); err != nil {
+		log.Fatalf("Error starting alert evaluation: %v", err)
+	}
+
+	// Load the anomaly baselines so saved readings are scored
+	if err := svc.StartAnomalies(context.Background()); err != nil {
+		log.Fatalf("Error starting anomaly detection: %v", err)
+	}
+
+	// Start writing the records pushed to the ingest endpoint in bulk
+	// The flusher is stopped last on shutdown so the records already accepted are written
+	flushCtx, stopFlusher := context.WithCancel(context.Background())
+	flushed := svc.StartIngestFlusher(flushCtx)
+
+	// Subscribe to the MQTT broker of the field devices when configured
+	mqttCtx, stopMQTT := context.WithCancel(context.Background())
+	if cfg.MQTT.Enabled {
+		subscriber, err := ingest.NewMQTTSubscriber(svc, cfg.MQTT)
+		if err != nil {
+			log.Fatalf("Error configuring MQTT subscriber: %v", err)
+		}
+		subscriber.Start(mqttCtx)
+	}
 
 	// Set up the handler layer with service
-	h := handlers.NewHandler(svc)
+	h := handlers.NewHandler(svc, cfg.Server.AllowedOrigins)
 
 	// Initialize router with Gorilla Mux
 	router := mux.NewRouter()
@@ -47,10 +142,10 @@ func main() {
 	// Configure CORS middleware to allow cross-origin requests
 	// This is essential for the frontend to communicate with the API
 	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},                                                                     // Allow all origins - in production, restrict to your frontend domain
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "HEAD"},                       // Must include OPTIONS for preflight requests
-		AllowedHeaders:   []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Requested-With"}, // Content-Type is crucial for file uploads
-		ExposedHeaders:   []string{"Content-Length", "Content-Type"},
+		AllowedOrigins:   cfg.Server.AllowedOrigins,                                                                                              // Configured origins - in production, restrict to your frontend domain
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "HEAD"},                                                            // Must include OPTIONS for preflight requests
+		AllowedHeaders:   []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Requested-With", "Last-Event-ID", "Content-Encoding"}, // Content-Type is crucial for file uploads
+		ExposedHeaders:   []string{"Content-Length", "Content-Type", "Content-Disposition", "Location"},
 		AllowCredentials: true,  // Allow credentials such as cookies
 		MaxAge:           86400, // 24 hours for preflight cache - reduces OPTIONS requests
 	})
@@ -66,15 +161,53 @@ func main() {
 
 	// Define API routes with their corresponding handlers
 	api := router.PathPrefix("/api").Subrouter()
-	api.HandleFunc("/objects", h.GetUniqueObjectIDs).Methods("GET")        // Get all unique object IDs
-	api.HandleFunc("/ports/{objectId}", h.GetPorts).Methods("GET")         // Get ports for a specific object
-	api.HandleFunc("/data/{objectId}", h.GetDataByObjectID).Methods("GET") // Get data for a specific object
+	api.HandleFunc("/objects", h.GetUniqueObjectIDs).Methods("GET")         // Get all unique object IDs
+	api.HandleFunc("/ports/{objectId}", h.GetPorts).Methods("GET")          // Get ports for a specific object
+	api.HandleFunc("/data/{objectId}", h.GetDataByObjectID).Methods("GET")  // Get data for a specific object
+	api.HandleFunc("/series/{objectId}", h.GetSeries).Methods("GET")        // Get downsampled chart series
+	api.HandleFunc("/aggregate/{objectId}", h.GetAggregates).Methods("GET") // Get time-bucketed aggregates
+	api.HandleFunc("/export/{objectId}", h.ExportData).Methods("GET")       // Download data as CSV, NDJSON or JSON
+	api.HandleFunc("/anomalies/{objectId}", h.GetAnomalies).Methods("GET")  // Get readings flagged as standing out from the baseline of their port
+	api.HandleFunc("/stream/{objectId}", h.StreamData).Methods("GET")       // Push new data as Server-Sent Events
+	api.HandleFunc("/live", h.LiveSocket).Methods("GET")                    // Subscribe to new data of many objects over a WebSocket
 
 	// Special handling for the upload endpoint
 	// For file uploads, we need to handle both POST and OPTIONS methods
 	// OPTIONS is used for CORS preflight requests from the browser
-	api.HandleFunc("/upload", h.UploadCSV).Methods("POST", "OPTIONS")       // Upload and process CSV data
-	api.HandleFunc("/upload-json", h.UploadJSON).Methods("POST", "OPTIONS") // Upload and process JSON data
+	api.HandleFunc("/upload", h.UploadCSV).Methods("POST", "OPTIONS")              // Upload and process CSV data
+	api.HandleFunc("/upload-json", h.UploadJSON).Methods("POST", "OPTIONS")        // Upload and process JSON data
+	api.HandleFunc("/upload-stream", h.UploadCSVStream).Methods("POST", "OPTIONS") // Stream large CSV files in batches
+	api.HandleFunc("/ingest", h.Ingest).Methods("POST", "OPTIONS")                 // Accept readings pushed by devices
+
+	// Asynchronous imports return a job ID immediately and run in the background
+	api.HandleFunc("/imports", h.SubmitImport).Methods("POST", "OPTIONS") // Queue a CSV import job
+	api.HandleFunc("/imports", h.ListImportJobs).Methods("GET")           // List import jobs
+	api.HandleFunc("/imports/{id}", h.GetImportJob).Methods("GET")        // Get the status of an import job
+
+	// Formulas over the fields of readings that queries can request by name
+	api.HandleFunc("/computed-fields", h.ListComputedFields).Methods("GET")                    // List computed fields
+	api.HandleFunc("/computed-fields", h.CreateComputedField).Methods("POST", "OPTIONS")       // Define a computed field
+	api.HandleFunc("/computed-fields/{name}", h.GetComputedField).Methods("GET")               // Get a computed field
+	api.HandleFunc("/computed-fields/{name}", h.UpdateComputedField).Methods("PUT", "OPTIONS") // Replace the formula of a computed field
+	api.HandleFunc("/computed-fields/{name}", h.DeleteComputedField).Methods("DELETE")         // Remove a computed field
+
+	// Alert rules and the alerts they raise
+	api.HandleFunc("/alerts", h.ListAlerts).Methods("GET")                            // Get the state of alerts
+	api.HandleFunc("/alerts/events", h.ListAlertEvents).Methods("GET")                // Get the history of alerts firing and resolving
+	api.HandleFunc("/alerts/rules", h.ListAlertRules).Methods("GET")                  // List alert rules
+	api.HandleFunc("/alerts/rules", h.CreateAlertRule).Methods("POST", "OPTIONS")     // Create an alert rule
+	api.HandleFunc("/alerts/rules/{id}", h.GetAlertRule).Methods("GET")               // Get an alert rule
+	api.HandleFunc("/alerts/rules/{id}", h.UpdateAlertRule).Methods("PUT", "OPTIONS") // Replace an alert rule
+	api.HandleFunc("/alerts/rules/{id}", h.DeleteAlertRule).Methods("DELETE")         // Remove an alert rule and its alerts
+
+	// Webhook notifications sent to other systems
+	api.HandleFunc("/webhooks/deliveries", h.ListWebhookDeliveries).Methods("GET")            // Get the delivery history and dead letters
+	api.HandleFunc("/webhooks/deliveries/{id}", h.GetWebhookDelivery).Methods("GET")          // Get a delivery and its attempts
+	api.HandleFunc("/webhooks/deliveries/{id}/redeliver", h.RedeliverWebhook).Methods("POST") // Send a notification again
+
+	// Administration
+	api.HandleFunc("/admin/indexes", h.GetIndexes).Methods("GET")     // Get the state of the database indexes
+	api.HandleFunc("/admin/indexes", h.EnsureIndexes).Methods("POST") // Create missing database indexes
 
 	// Test route to check if the API is working
 	api.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
@@ -85,9 +218,61 @@ func main() {
 	// Apply CORS middleware to the router
 	handler := c.Handler(router)
 
-	// Start the HTTP server on port 8080
-	log.Printf("Starting server on :8080")
-	if err := http.ListenAndServe(":8080", handler); err != nil {
+	// Start the HTTP server on the configured address until SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	server := &http.Server{Addr: cfg.Server.Addr, Handler: handler}
+	go func() {
+		log.Printf("Starting server on %s", cfg.Server.Addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+	<-ctx.Done()
+	stop()
+
+	// Stop accepting requests and readings, then write the records already accepted
+	log.Printf("Shutting down, waiting up to %s for requests in progress", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Closing connections still open: %v", err)
+		server.Close()
+	}
+	stopMQTT()
+	stopFlusher()
+	<-flushed
+	log.Printf("Server stopped")
+}
+
+// migrateTimeSeries copies the configured sensor data collection into a new time-series
+// collection of the same name, keeping the original as a backup
+// It accepts the same configuration flags as the server
+func migrateTimeSeries(args []string) {
+	cfg, err := config.Load(args)
+	if err != nil {
 		log.Fatal(err)
 	}
+
+	mongoClient := database.ConnectMongo(cfg.Mongo)
+	defer mongoClient.Disconnect(context.Background())
+
+	// Ctrl+C stops the copy between batches; the backup collection keeps the data
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	backup, copied, err := domain.MigrateToTimeSeries(ctx, mongoClient, cfg.Mongo, cfg.Import.BatchSize)
+	if err != nil {
+		if backup != "" {
+			log.Fatalf("Migration failed after copying %d documents, the original data is kept in %s: %v", copied, backup, err)
+		}
+		log.Fatalf("Migration failed: %v", err)
+	}
+	if backup == "" {
+		log.Printf("Created empty time-series collection %s", cfg.Mongo.Collection)
+		return
+	}
+	log.Printf("Copied %d documents into time-series collection %s", copied, cfg.Mongo.Collection)
+	log.Printf("The original collection is kept as %s; drop it once the data has been checked", backup)
+	log.Printf("Set mongo.time_series to true before starting the server")
 }
@@ -0,0 +1,175 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration written to JSON as a string such as "2m30s"
+// Plain numbers are read as seconds
+type Duration time.Duration
+
+// MarshalJSON writes the duration as a Go duration string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON reads a Go duration string or a number of seconds
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		*d = Duration(seconds * float64(time.Second))
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return errors.New("duration must be a string such as \"2m\" or a number of seconds")
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Comparison operators of alert rules
+var AlertOperators = []string{">", ">=", "<", "<=", "==", "!="}
+
+// AlertRule raises an alert when a field of the readings of an object and port crosses a
+// threshold, e.g. voltage > 14.5 for 2 minutes on object 7 port 3
+type AlertRule struct {
+	ID        string   `bson:"_id" json:"id"`                                  // Rule identifier
+	Name      string   `bson:"name" json:"name"`                               // Label shown with the alerts of the rule
+	ObjectID  *float64 `bson:"object_id,omitempty" json:"object_id,omitempty"` // Object watched, every object when omitted
+	PortNum   *float64 `bson:"port_num,omitempty" json:"port_num,omitempty"`   // Port watched, every port when omitted
+	Field     string   `bson:"field" json:"field"`                             // Numeric field compared with the threshold
+	Operator  string   `bson:"operator" json:"operator"`                       // One of AlertOperators
+	Threshold float64  `bson:"threshold" json:"threshold"`                     // Value the field is compared with
+
+	// For is how long the condition must hold, in reading timestamps, before the alert fires
+	For Duration `bson:"for" json:"for"`
+	// Hysteresis is how far past the threshold the field must come back before a firing
+	// alert resolves, so a value hovering around the threshold does not flap
+	Hysteresis float64 `bson:"hysteresis" json:"hysteresis"`
+
+	Disabled  bool      `bson:"disabled" json:"disabled"`     // Rule kept but not evaluated
+	CreatedAt time.Time `bson:"created_at" json:"created_at"` // Time the rule was created
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"` // Time the rule was last changed
+}
+
+// Validate checks that the rule can be evaluated
+func (r AlertRule) Validate() error {
+	var errs []error
+	if strings.TrimSpace(r.Name) == "" {
+		errs = append(errs, errors.New("name must not be empty"))
+	}
+	if !IsNumericField(r.Field) {
+		errs = append(errs, fmt.Errorf("unknown numeric field %q", r.Field))
+	}
+	if !slices.Contains(AlertOperators, r.Operator) {
+		errs = append(errs, fmt.Errorf("operator must be one of %s", strings.Join(AlertOperators, " ")))
+	}
+	if r.For < 0 {
+		errs = append(errs, errors.New("for must not be negative"))
+	}
+	if r.Hysteresis < 0 {
+		errs = append(errs, errors.New("hysteresis must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// Matches reports whether a reading belongs to the objects and ports watched by the rule
+func (r AlertRule) Matches(d *SensorData) bool {
+	return (r.ObjectID == nil || d.ObjectID == *r.ObjectID) && (r.PortNum == nil || d.PortNum == *r.PortNum)
+}
+
+// Breached reports whether a value meets the condition of the rule
+func (r AlertRule) Breached(value float64) bool {
+	switch r.Operator {
+	case ">":
+		return value > r.Threshold
+	case ">=":
+		return value >= r.Threshold
+	case "<":
+		return value < r.Threshold
+	case "<=":
+		return value <= r.Threshold
+	case "==":
+		return value == r.Threshold
+	case "!=":
+		return value != r.Threshold
+	}
+	return false
+}
+
+// Recovered reports whether a value is far enough back from the threshold to resolve a
+// firing alert; equality rules ignore the hysteresis
+func (r AlertRule) Recovered(value float64) bool {
+	switch r.Operator {
+	case ">":
+		return value <= r.Threshold-r.Hysteresis
+	case ">=":
+		return value < r.Threshold-r.Hysteresis
+	case "<":
+		return value >= r.Threshold+r.Hysteresis
+	case "<=":
+		return value > r.Threshold+r.Hysteresis
+	}
+	return !r.Breached(value)
+}
+
+// Alert states
+const (
+	AlertInactive = "inactive" // The condition does not hold
+	AlertPending  = "pending"  // The condition holds but not yet for the duration of the rule
+	AlertFiring   = "firing"   // The condition has held for the duration of the rule
+	AlertResolved = "resolved" // The alert fired and the value has recovered since
+)
+
+// Alert is the state of a rule for one object and port
+type Alert struct {
+	ID            string    `bson:"_id" json:"id"`                        // Rule ID, object and port
+	RuleID        string    `bson:"rule_id" json:"rule_id"`               // Rule evaluated
+	ObjectID      float64   `bson:"object_id" json:"object_id"`           // Object of the readings
+	PortNum       float64   `bson:"port_num" json:"port_num"`             // Port of the readings
+	State         string    `bson:"state" json:"state"`                   // One of the Alert* states
+	Value         float64   `bson:"value" json:"value"`                   // Field value of the last reading evaluated
+	LastTimestamp time.Time `bson:"last_timestamp" json:"last_timestamp"` // Timestamp of the last reading evaluated
+	Since         time.Time `bson:"since" json:"since"`                   // Timestamp from which the condition has held, while pending or firing
+	FiredAt       time.Time `bson:"fired_at" json:"fired_at"`             // Timestamp of the reading that last fired the alert
+	ResolvedAt    time.Time `bson:"resolved_at" json:"resolved_at"`       // Timestamp of the reading that last resolved the alert
+}
+
+// AlertID returns the ID of the alert of a rule for an object and port
+func AlertID(ruleID string, objectID float64, portNum float64) string {
+	return fmt.Sprintf("%s:%g:%g", ruleID, objectID, portNum)
+}
+
+// AlertEvent records an alert firing or resolving
+type AlertEvent struct {
+	ID        string    `bson:"_id" json:"id"`                // Event identifier
+	AlertID   string    `bson:"alert_id" json:"alert_id"`     // Alert that changed state
+	RuleID    string    `bson:"rule_id" json:"rule_id"`       // Rule of the alert
+	RuleName  string    `bson:"rule_name" json:"rule_name"`   // Name of the rule at the time
+	ObjectID  float64   `bson:"object_id" json:"object_id"`   // Object of the reading
+	PortNum   float64   `bson:"port_num" json:"port_num"`     // Port of the reading
+	Field     string    `bson:"field" json:"field"`           // Field compared
+	Operator  string    `bson:"operator" json:"operator"`     // Comparison of the rule at the time
+	Threshold float64   `bson:"threshold" json:"threshold"`   // Threshold of the rule at the time
+	State     string    `bson:"state" json:"state"`           // AlertFiring or AlertResolved
+	Value     float64   `bson:"value" json:"value"`           // Field value of the reading
+	Timestamp time.Time `bson:"timestamp" json:"timestamp"`   // Timestamp of the reading
+	CreatedAt time.Time `bson:"created_at" json:"created_at"` // Time the transition was detected
+}
+
+// AlertEventQuery filters the alert history
+type AlertEventQuery struct {
+	RuleID   string   // Only events of this rule unless empty
+	ObjectID *float64 // Only events of this object unless nil
+	Limit    int64    // Maximum number of events, newest first; 0 returns everything
+}
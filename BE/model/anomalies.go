@@ -0,0 +1,73 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// Anomaly detection methods
+const (
+	AnomalyZScore = "zscore" // Distance from the rolling mean, in rolling standard deviations
+	AnomalyEWMA   = "ewma"   // Distance from the exponentially weighted mean, in weighted standard deviations
+	AnomalyMAD    = "mad"    // Distance from the rolling median, in scaled median absolute deviations
+)
+
+// AnomalyMethods lists the accepted anomaly detection methods
+var AnomalyMethods = []string{AnomalyZScore, AnomalyEWMA, AnomalyMAD}
+
+// AnomalyQuery filters the stored anomalies of an object
+type AnomalyQuery struct {
+	ObjectID float64   // Object of the readings
+	PortNum  *float64  // Only anomalies of this port unless nil
+	Fields   []string  // Only anomalies of these fields unless empty
+	From     time.Time // Only anomalies at or after this time unless zero
+	To       time.Time // Only anomalies at or before this time unless zero
+	Limit    int64     // Maximum number of anomalies, oldest first; 0 returns everything
+}
+
+// Anomaly is a reading whose field was far from the baseline of its port when it was saved
+type Anomaly struct {
+	ID        string    `bson:"_id" json:"-"`                 // Object, port, field and timestamp
+	ObjectID  float64   `bson:"object_id" json:"object_id"`   // Object of the reading
+	PortNum   float64   `bson:"port_num" json:"port_num"`     // Port of the reading
+	Field     string    `bson:"field" json:"field"`           // Field that is out of line
+	Timestamp time.Time `bson:"timestamp" json:"timestamp"`   // Timestamp of the reading
+	Value     float64   `bson:"value" json:"value"`           // Value of the field
+	Method    string    `bson:"method" json:"method"`         // Method of the baseline
+	Baseline  float64   `bson:"baseline" json:"baseline"`     // Expected value: rolling mean, EWMA or rolling median
+	Spread    float64   `bson:"spread" json:"spread"`         // Standard deviation or scaled MAD of the baseline
+	Score     float64   `bson:"score" json:"score"`           // (value - baseline) / spread; negative below the baseline
+	CreatedAt time.Time `bson:"created_at" json:"created_at"` // Time the reading was flagged
+}
+
+// AnomalyID returns the ID of the anomaly of a field of a reading
+func AnomalyID(objectID float64, portNum float64, field string, timestamp time.Time) string {
+	return fmt.Sprintf("%g:%g:%s:%d", objectID, portNum, field, timestamp.UnixMilli())
+}
+
+// AnomalyRes is the response structure for anomaly queries
+type AnomalyRes struct {
+	Anomalies []Anomaly `json:"anomalies"` // Anomalies in timestamp order
+	Truncated bool      `json:"truncated"` // True when more anomalies follow the last one returned
+}
+
+// AnomalyBaseline is the stored baseline of one field of one port, so scoring goes on
+// where it stopped when the server restarts
+type AnomalyBaseline struct {
+	ID            string    `bson:"_id" json:"id"`                        // Object, port and field
+	ObjectID      float64   `bson:"object_id" json:"object_id"`           // Object of the readings
+	PortNum       float64   `bson:"port_num" json:"port_num"`             // Port of the readings
+	Field         string    `bson:"field" json:"field"`                   // Field of the readings
+	Method        string    `bson:"method" json:"method"`                 // Method the baseline was built for
+	Window        int       `bson:"window" json:"window"`                 // Window the baseline was built for
+	Values        []float64 `bson:"values" json:"values"`                 // Readings of the rolling window, oldest first
+	Count         int       `bson:"count" json:"count"`                   // Readings seen by the EWMA
+	Mean          float64   `bson:"mean" json:"mean"`                     // Weighted mean of the EWMA
+	Variance      float64   `bson:"variance" json:"variance"`             // Weighted variance of the EWMA
+	LastTimestamp time.Time `bson:"last_timestamp" json:"last_timestamp"` // Timestamp of the last reading added
+}
+
+// AnomalyBaselineID returns the ID of the baseline of a field of a port
+func AnomalyBaselineID(objectID float64, portNum float64, field string) string {
+	return fmt.Sprintf("%g:%g:%s", objectID, portNum, field)
+}
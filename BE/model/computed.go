@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+// ComputedField is a formula over the numeric fields of a reading, stored under a name
+// that queries can request like any stored field
+type ComputedField struct {
+	Name        string    `bson:"_id" json:"name"`                                    // Name requested in the fields of queries
+	Expression  string    `bson:"expression" json:"expression"`                       // Formula, e.g. "(supply_volt - voltage) / supply_current"
+	Description string    `bson:"description,omitempty" json:"description,omitempty"` // Optional explanation for other users
+	Fields      []string  `bson:"fields" json:"fields"`                               // Fields read by the formula, set by the server
+	CreatedAt   time.Time `bson:"created_at" json:"created_at"`                       // Time the field was created
+	UpdatedAt   time.Time `bson:"updated_at" json:"updated_at"`                       // Time the field was last changed
+}
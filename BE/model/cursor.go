@@ -0,0 +1,35 @@
+package model
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// PageCursor marks the position of the last record returned in a page of sensor data
+// Records are ordered by (timestamp, _id), so the pair uniquely identifies a position
+type PageCursor struct {
+	Timestamp time.Time `json:"t"`           // Timestamp of the last returned record
+	ID        string    `json:"id"`          // Document ID of the last returned record
+	Desc      bool      `json:"d,omitempty"` // Sort direction the cursor was produced with
+}
+
+// EncodePageCursor serializes a cursor into the opaque token handed to clients
+func EncodePageCursor(c PageCursor) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// DecodePageCursor parses an opaque token produced by EncodePageCursor
+func DecodePageCursor(token string) (*PageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	var c PageCursor
+	if err := json.Unmarshal(raw, &c); err != nil || c.Timestamp.IsZero() || c.ID == "" {
+		return nil, errors.New("invalid cursor")
+	}
+	return &c, nil
+}
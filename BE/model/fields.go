@@ -0,0 +1,134 @@
+package model
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// numericFields maps the JSON/BSON name of every numeric measurement in SensorData
+// to an accessor, so fields can be selected by name in queries
+var numericFields = map[string]func(*SensorData) float64{
+	"state":             func(d *SensorData) float64 { return d.State },
+	"controller_error":  func(d *SensorData) float64 { return d.ControllerError },
+	"ai1":               func(d *SensorData) float64 { return d.AI1 },
+	"ai2":               func(d *SensorData) float64 { return d.AI2 },
+	"ai3":               func(d *SensorData) float64 { return d.AI3 },
+	"ai4":               func(d *SensorData) float64 { return d.AI4 },
+	"ai5":               func(d *SensorData) float64 { return d.AI5 },
+	"q_charge":          func(d *SensorData) float64 { return d.QCharge },
+	"voltage":           func(d *SensorData) float64 { return d.Voltage },
+	"voltage_set_point": func(d *SensorData) float64 { return d.VoltageSetPoint },
+	"command":           func(d *SensorData) float64 { return d.Command },
+	"target_q":          func(d *SensorData) float64 { return d.TargetQ },
+	"current":           func(d *SensorData) float64 { return d.Current },
+	"supply_current":    func(d *SensorData) float64 { return d.SupplyCurrent },
+	"step_number":       func(d *SensorData) float64 { return d.StepNumber },
+	"voltage_drop":      func(d *SensorData) float64 { return d.VoltageDrop },
+	"voc_mode":          func(d *SensorData) float64 { return d.VOCMode },
+	"voc":               func(d *SensorData) float64 { return d.VOC },
+	"target_voc":        func(d *SensorData) float64 { return d.TargetVOC },
+	"supply_volt":       func(d *SensorData) float64 { return d.SupplyVolt },
+	"voc_state":         func(d *SensorData) float64 { return d.VOCState },
+	"voc_exit":          func(d *SensorData) float64 { return d.VOCExit },
+}
+
+// otherFields maps the JSON/BSON name of the non-numeric SensorData fields to an accessor
+var otherFields = map[string]func(*SensorData) interface{}{
+	"id":         func(d *SensorData) interface{} { return d.ID },
+	"timestamp":  func(d *SensorData) interface{} { return d.Timestamp },
+	"object_id":  func(d *SensorData) interface{} { return d.ObjectID },
+	"port_num":   func(d *SensorData) interface{} { return d.PortNum },
+	"created_at": func(d *SensorData) interface{} { return d.CreatedAt },
+	"fw_version": func(d *SensorData) interface{} { return d.FWVersion },
+	"vendor_id":  func(d *SensorData) interface{} { return d.VendorID },
+	"lite_id":    func(d *SensorData) interface{} { return d.LiteID },
+	"read_error": func(d *SensorData) interface{} { return d.ReadError },
+}
+
+// CSVColumns lists the CSV columns accepted by the CSV upload, in export order
+var CSVColumns = []string{
+	"timestamp", "object_id", "port_num", "voltage", "current",
+	"supply_current", "supply_volt", "voltage_drop", "voc",
+	"state", "controller_error", "ai1", "ai2", "ai3", "ai4", "ai5",
+	"fw_version", "q_charge", "voltage_set_point", "command", "target_q",
+	"vendor_id", "step_number", "lite_id", "voc_mode", "target_voc",
+	"voc_state", "voc_exit", "read_error",
+}
+
+// KeyFields are always returned by projected queries so every record stays identifiable
+var KeyFields = []string{"id", "timestamp", "object_id", "port_num"}
+
+// percentilePattern matches percentile aggregate functions such as "p50" or "p99"
+var percentilePattern = regexp.MustCompile(`^p(\d{1,2})$`)
+
+// IsNumericField reports whether name is a numeric SensorData measurement
+func IsNumericField(name string) bool {
+	_, ok := numericFields[name]
+	return ok
+}
+
+// IsField reports whether name is any SensorData field, numeric or not
+func IsField(name string) bool {
+	_, ok := otherFields[name]
+	return ok || IsNumericField(name)
+}
+
+// NumericFieldNames returns the names of all numeric SensorData measurements in sorted order
+func NumericFieldNames() []string {
+	names := make([]string, 0, len(numericFields))
+	for name := range numericFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NumericValue returns the value of the named numeric measurement or virtual field
+// The second result is false if the field does not exist or was not computed
+func (d *SensorData) NumericValue(field string) (float64, bool) {
+	get, ok := numericFields[field]
+	if !ok {
+		value, ok := d.Derived[field]
+		return value, ok
+	}
+	return get(d), true
+}
+
+// FieldValue returns the value of the named field as it appears in JSON
+// The second result is false if the field does not exist
+func (d *SensorData) FieldValue(field string) (interface{}, bool) {
+	if get, ok := otherFields[field]; ok {
+		return get(d), true
+	}
+	if get, ok := numericFields[field]; ok {
+		return get(d), true
+	}
+	if value, ok := d.Derived[field]; ok {
+		return value, true
+	}
+	return nil, false
+}
+
+// Project returns the key fields and the listed fields of the record as a JSON-ready map
+func (d *SensorData) Project(fields []string) map[string]interface{} {
+	row := make(map[string]interface{}, len(KeyFields)+len(fields))
+	for _, field := range KeyFields {
+		row[field], _ = d.FieldValue(field)
+	}
+	for _, field := range fields {
+		row[field], _ = d.FieldValue(field)
+	}
+	return row
+}
+
+// PercentileOf returns the percentile of a pNN aggregate function as a fraction between 0 and 1
+// The second result is false if function is not a percentile
+func PercentileOf(function string) (float64, bool) {
+	match := percentilePattern.FindStringSubmatch(function)
+	if match == nil {
+		return 0, false
+	}
+	n, _ := strconv.Atoi(match[1])
+	return float64(n) / 100, true
+}
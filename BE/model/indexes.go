@@ -0,0 +1,37 @@
+package model
+
+// IndexKey is one field of an index
+type IndexKey struct {
+	Field     string      `json:"field"`     // Indexed field
+	Direction interface{} `json:"direction"` // 1 for ascending, -1 for descending or the index type such as "text"
+}
+
+// IndexSpec declares an index the application relies on
+type IndexSpec struct {
+	Collection string     // Collection the index belongs to
+	Name       string     // Index name
+	Keys       []IndexKey // Indexed fields in order
+	Unique     bool       // Whether the index rejects duplicate keys
+	Purpose    string     // Queries the index supports
+}
+
+// Index states reported by the index manager
+const (
+	IndexPresent = "present" // The index exists in the database
+	IndexCreated = "created" // The index was missing and has just been created
+	IndexMissing = "missing" // The index is declared but does not exist
+	IndexFailed  = "failed"  // Creating the index failed
+	IndexExtra   = "extra"   // The index exists but is not declared by the application
+)
+
+// IndexStatus reports the state of one index of the application collections
+type IndexStatus struct {
+	Collection string     `json:"collection"`        // Collection the index belongs to
+	Name       string     `json:"name"`              // Index name in the database, or the declared name if missing
+	Keys       []IndexKey `json:"keys"`              // Indexed fields in order
+	Unique     bool       `json:"unique"`            // Whether the index rejects duplicate keys
+	Declared   bool       `json:"declared"`          // Whether the application declares the index
+	State      string     `json:"state"`             // One of the Index* states
+	Purpose    string     `json:"purpose,omitempty"` // Queries the index supports
+	Error      string     `json:"error,omitempty"`   // Why the index could not be created
+}
@@ -0,0 +1,22 @@
+package model
+
+// Subscription selects the live records of one object, optionally one port, and the
+// fields sent for them
+type Subscription struct {
+	ObjectID float64  `json:"object_id"`          // Object whose new records are sent
+	PortNum  *float64 `json:"port_num,omitempty"` // Port of the object, every port when omitted
+	Fields   []string `json:"fields,omitempty"`   // Fields sent in addition to KeyFields, all fields when empty
+}
+
+// Matches reports whether a record belongs to the object and port of the subscription
+func (s Subscription) Matches(d *SensorData) bool {
+	return d.ObjectID == s.ObjectID && (s.PortNum == nil || d.PortNum == *s.PortNum)
+}
+
+// SameTarget reports whether two subscriptions select the same object and port
+func (s Subscription) SameTarget(other Subscription) bool {
+	if s.ObjectID != other.ObjectID || (s.PortNum == nil) != (other.PortNum == nil) {
+		return false
+	}
+	return s.PortNum == nil || *s.PortNum == *other.PortNum
+}
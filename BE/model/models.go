@@ -1,43 +1,57 @@
 package model
 
 import (
+	"encoding/json"
+	"fmt"
 	"time"
 )
 
 // SensorData represents the time series data collected from sensors
 // It contains various metrics and measurements from the monitoring system
 type SensorData struct {
-	ID              string    `bson:"_id,omitempty" json:"id"`                    // MongoDB document ID
-	Timestamp       time.Time `bson:"timestamp" json:"timestamp"`                 // Time when the data was recorded
-	ObjectID        float64   `bson:"object_id" json:"object_id"`                 // Identifier for the monitored object
-	CreatedAt       time.Time `bson:"created_at" json:"created_at"`               // Time when the record was created
-	State           float64   `bson:"state" json:"state"`                         // Current state of the object
-	ControllerError float64   `bson:"controller_error" json:"controller_error"`   // Error value from the controller
-	AI3             float64   `bson:"ai3" json:"ai3"`                             // Analog input 3 reading
-	AI5             float64   `bson:"ai5" json:"ai5"`                             // Analog input 5 reading
-	FWVersion       string    `bson:"fw_version" json:"fw_version"`               // Firmware version
-	PortNum         float64   `bson:"port_num" json:"port_num"`                   // Port number
-	QCharge         float64   `bson:"q_charge" json:"q_charge"`                   // Charge value
-	Voltage         float64   `bson:"voltage" json:"voltage"`                     // Current voltage
-	VoltageSetPoint float64   `bson:"voltage_set_point" json:"voltage_set_point"` // Target voltage value
-	Command         float64   `bson:"command" json:"command"`                     // Command value
-	AI1             float64   `bson:"ai1" json:"ai1"`                             // Analog input 1 reading
-	TargetQ         float64   `bson:"target_q" json:"target_q"`                   // Target charge value
-	Current         float64   `bson:"current" json:"current"`                     // Current amperage
-	AI4             float64   `bson:"ai4" json:"ai4"`                             // Analog input 4 reading
-	VendorID        string    `bson:"vendor_id" json:"vendor_id"`                 // Vendor identifier
-	AI2             float64   `bson:"ai2" json:"ai2"`                             // Analog input 2 reading
-	SupplyCurrent   float64   `bson:"supply_current" json:"supply_current"`       // Supply current value
-	StepNumber      float64   `bson:"step_number" json:"step_number"`             // Step number in the sequence
-	VoltageDrop     float64   `bson:"voltage_drop" json:"voltage_drop"`           // Voltage drop measurement
-	LiteID          string    `bson:"lite_id" json:"lite_id"`                     // Lite identifier
-	VOCMode         float64   `bson:"voc_mode" json:"voc_mode"`                   // VOC (Voltage Open Circuit) mode
-	VOC             float64   `bson:"voc" json:"voc"`                             // Voltage Open Circuit value
-	ReadError       bool      `bson:"read_error" json:"read_error"`               // Indicates if there was an error during reading
-	TargetVOC       float64   `bson:"target_voc" json:"target_voc"`               // Target Voltage Open Circuit value
-	SupplyVolt      float64   `bson:"supply_volt" json:"supply_volt"`             // Supply voltage
-	VOCState        float64   `bson:"voc_state" json:"voc_state"`                 // State of Voltage Open Circuit
-	VOCExit         float64   `bson:"voc_exit" json:"voc_exit"`                   // VOC exit condition
+	ID              string      `bson:"_id,omitempty" json:"id"`                    // MongoDB document ID
+	Timestamp       time.Time   `bson:"timestamp" json:"timestamp"`                 // Time when the data was recorded
+	ObjectID        float64     `bson:"object_id" json:"object_id"`                 // Identifier for the monitored object
+	CreatedAt       time.Time   `bson:"created_at" json:"created_at"`               // Time when the record was created
+	State           float64     `bson:"state" json:"state"`                         // Current state of the object
+	ControllerError float64     `bson:"controller_error" json:"controller_error"`   // Error value from the controller
+	AI3             float64     `bson:"ai3" json:"ai3"`                             // Analog input 3 reading
+	AI5             float64     `bson:"ai5" json:"ai5"`                             // Analog input 5 reading
+	FWVersion       string      `bson:"fw_version" json:"fw_version"`               // Firmware version
+	PortNum         float64     `bson:"port_num" json:"port_num"`                   // Port number
+	QCharge         float64     `bson:"q_charge" json:"q_charge"`                   // Charge value
+	Voltage         float64     `bson:"voltage" json:"voltage"`                     // Current voltage
+	VoltageSetPoint float64     `bson:"voltage_set_point" json:"voltage_set_point"` // Target voltage value
+	Command         float64     `bson:"command" json:"command"`                     // Command value
+	AI1             float64     `bson:"ai1" json:"ai1"`                             // Analog input 1 reading
+	TargetQ         float64     `bson:"target_q" json:"target_q"`                   // Target charge value
+	Current         float64     `bson:"current" json:"current"`                     // Current amperage
+	AI4             float64     `bson:"ai4" json:"ai4"`                             // Analog input 4 reading
+	VendorID        string      `bson:"vendor_id" json:"vendor_id"`                 // Vendor identifier
+	AI2             float64     `bson:"ai2" json:"ai2"`                             // Analog input 2 reading
+	SupplyCurrent   float64     `bson:"supply_current" json:"supply_current"`       // Supply current value
+	StepNumber      float64     `bson:"step_number" json:"step_number"`             // Step number in the sequence
+	VoltageDrop     float64     `bson:"voltage_drop" json:"voltage_drop"`           // Voltage drop measurement
+	LiteID          string      `bson:"lite_id" json:"lite_id"`                     // Lite identifier
+	VOCMode         float64     `bson:"voc_mode" json:"voc_mode"`                   // VOC (Voltage Open Circuit) mode
+	VOC             float64     `bson:"voc" json:"voc"`                             // Voltage Open Circuit value
+	ReadError       bool        `bson:"read_error" json:"read_error"`               // Indicates if there was an error during reading
+	TargetVOC       float64     `bson:"target_voc" json:"target_voc"`               // Target Voltage Open Circuit value
+	SupplyVolt      float64     `bson:"supply_volt" json:"supply_volt"`             // Supply voltage
+	VOCState        float64     `bson:"voc_state" json:"voc_state"`                 // State of Voltage Open Circuit
+	VOCExit         float64     `bson:"voc_exit" json:"voc_exit"`                   // VOC exit condition
+	Meta            *SensorMeta `bson:"meta,omitempty" json:"-"`                    // Time-series metadata, only set in time-series collections
+
+	// Values of the virtual fields requested by a query, such as power, set by the service
+	// They are read like the stored fields but never stored
+	Derived map[string]float64 `bson:"-" json:"-"`
+}
+
+// SensorMeta is the metaField of a time-series sensor data collection
+// It repeats the object and port of the record so MongoDB can bucket by them
+type SensorMeta struct {
+	ObjectID float64 `bson:"object_id"` // Identifier for the monitored object
+	PortNum  float64 `bson:"port_num"`  // Port number
 }
 
 // SensorDataRes is the response structure for sensor data queries
@@ -45,6 +59,41 @@ type SensorData struct {
 type SensorDataRes struct {
 	SensorData []SensorData // Array of sensor data records
 	Total      int64        // Total number of records matching the query
+	NextCursor string       `json:",omitempty"` // Opaque token for the next page, empty on the last page
+	Projection []string     `json:"-"`          // Fields to include in JSON, all fields when empty
+}
+
+// MarshalJSON writes only the projected fields of every record when a projection is set
+// This keeps responses small when clients ask for a few metrics only
+func (r SensorDataRes) MarshalJSON() ([]byte, error) {
+	type plain SensorDataRes
+	if len(r.Projection) == 0 {
+		return json.Marshal(plain(r))
+	}
+
+	rows := make([]map[string]interface{}, len(r.SensorData))
+	for i := range r.SensorData {
+		rows[i] = r.SensorData[i].Project(r.Projection)
+	}
+
+	return json.Marshal(struct {
+		SensorData []map[string]interface{}
+		Total      int64
+		NextCursor string `json:",omitempty"`
+	}{rows, r.Total, r.NextCursor})
+}
+
+// SensorDataQuery describes the filters applied when reading sensor data
+// Zero values mean the corresponding filter is not applied
+type SensorDataQuery struct {
+	ObjectID   string      // Identifier of the monitored object (required)
+	PortNum    string      // Optional port number
+	From       time.Time   // Inclusive lower bound on timestamp
+	To         time.Time   // Inclusive upper bound on timestamp
+	Limit      int64       // Maximum number of records per page, 0 returns everything
+	SortDesc   bool        // Sort by timestamp descending instead of ascending
+	After      *PageCursor // Continue after this position when paging
+	Projection []string    // Fields to return in addition to KeyFields, all fields when empty
 }
 
 // PortInfo represents information about a port associated with an object
@@ -56,3 +105,145 @@ type PortInfo struct {
 type ObjectInfo struct {
 	ObjectID float64 `bson:"object_id" json:"objectId"` // Object identifier
 }
+
+// SeriesPoint is a single (time, value) sample of a chart series
+type SeriesPoint struct {
+	X time.Time `json:"x"` // Timestamp of the sample
+	Y float64   `json:"y"` // Value of the sample
+}
+
+// SeriesQuery describes a request for downsampled chart series
+type SeriesQuery struct {
+	SensorDataQuery          // Object, port and time range filters
+	Fields          []string // Numeric fields to return a series for
+	MaxPoints       int      // Upper bound on the number of points per series
+	Mode            string   // Downsampling mode: "lttb", "minmax" or "avg"
+}
+
+// SeriesRes is the response structure for downsampled series queries
+type SeriesRes struct {
+	Series map[string][]SeriesPoint `json:"series"` // Downsampled points keyed by field name
+	Mode   string                   `json:"mode"`   // Downsampling mode that was applied
+	Total  int64                    `json:"total"`  // Number of raw records the series were computed from
+}
+
+// AggregateQuery describes a time-bucketed aggregation over sensor data
+type AggregateQuery struct {
+	SensorDataQuery          // Object, port and time range filters
+	Unit            string   // Bucket unit understood by $dateTrunc: minute, hour, day, week or month
+	BinSize         int      // Number of units per bucket, e.g. 15 with unit minute
+	Fields          []string // Numeric fields to aggregate
+	Functions       []string // Aggregate functions: count, sum, avg, min, max, stddev or pNN percentiles
+}
+
+// AggregateBucket holds the aggregated values of one port for one time bucket
+type AggregateBucket struct {
+	Timestamp time.Time                     `json:"timestamp"` // Start of the bucket
+	PortNum   float64                       `json:"portNum"`   // Port the values belong to
+	Count     int64                         `json:"count"`     // Number of records in the bucket
+	Values    map[string]map[string]float64 `json:"values"`    // Aggregated values keyed by field and function
+}
+
+// RowError describes a problem with one row of an uploaded file
+type RowError struct {
+	Line   int    `bson:"line" json:"line"`     // Line number in the file, the header is line 1
+	Column string `bson:"column" json:"column"` // Column the problem was found in, empty for malformed rows
+	Value  string `bson:"value" json:"value"`   // Raw value that could not be used
+	Reason string `bson:"reason" json:"reason"` // Human readable description of the problem
+}
+
+// Error implements the error interface
+func (e *RowError) Error() string {
+	return fmt.Sprintf("Error at line %d: %s", e.Line, e.Reason)
+}
+
+// RowErrors collects every problem found in one row
+// errors.As finds each problem as a *RowError
+type RowErrors []RowError
+
+// Error implements the error interface, describing the first problem of the row
+func (e RowErrors) Error() string {
+	if len(e) == 0 {
+		return "invalid row"
+	}
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more problems)", e[0].Error(), len(e)-1)
+}
+
+// Unwrap exposes the individual problems to errors.As
+func (e RowErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i := range e {
+		errs[i] = &e[i]
+	}
+	return errs
+}
+
+// Duplicate policies deciding what happens to records that share
+// object_id, port_num and timestamp with a stored record
+const (
+	DuplicateSkip   = "skip"   // Keep the stored record and count the new one as a duplicate
+	DuplicateUpsert = "upsert" // Replace the stored record with the new one
+	DuplicateError  = "error"  // Fail the write
+)
+
+// SaveResult reports the outcome of writing a batch of sensor data records
+type SaveResult struct {
+	Inserted   int64 `json:"inserted"`   // Records stored as new documents
+	Duplicates int64 `json:"duplicates"` // Records that matched a stored record and were skipped or replaced
+}
+
+// Import modes controlling how rows with problems are handled
+const (
+	ImportStrict    = "strict"     // Stop at the first invalid row
+	ImportValidOnly = "valid-only" // Import the valid rows and report the invalid ones
+	ImportDryRun    = "dry-run"    // Validate every row and insert nothing
+)
+
+// ImportProgress reports how far a streaming import has got
+type ImportProgress struct {
+	RowsRead      int64 `json:"rowsRead"`      // Data rows read from the file so far, valid or not
+	RowsInserted  int64 `json:"rowsInserted"`  // Rows written to the database so far
+	RowsRejected  int64 `json:"rowsRejected"`  // Rows that failed validation so far
+	RowsDuplicate int64 `json:"rowsDuplicate"` // Rows matching a stored record so far, skipped or replaced
+	Batches       int64 `json:"batches"`       // Number of batches written so far
+}
+
+// ImportReport is the outcome of an import with every row problem found
+// The problem list is capped so memory stays bounded for very bad files
+type ImportReport struct {
+	ImportProgress
+	Mode            string     `json:"mode"`            // Import mode that was applied
+	Errors          []RowError `json:"errors"`          // Problems found, in file order
+	ErrorsTruncated bool       `json:"errorsTruncated"` // True when more problems were found than listed
+}
+
+// Import job states
+const (
+	ImportQueued    = "queued"    // Waiting for a worker
+	ImportRunning   = "running"   // Being processed by a worker
+	ImportSucceeded = "succeeded" // All rows were imported
+	ImportFailed    = "failed"    // Stopped because of an error
+)
+
+// ImportJob tracks an asynchronous file import
+// Job records are persisted so their status survives a server restart
+type ImportJob struct {
+	ID            string     `bson:"_id" json:"id"`                           // Job identifier returned to the client
+	State         string     `bson:"state" json:"state"`                      // One of the Import* states
+	Filename      string     `bson:"filename" json:"filename"`                // Name of the uploaded file
+	SpoolPath     string     `bson:"spool_path" json:"-"`                     // Location of the uploaded file on disk
+	RowsProcessed int64      `bson:"rows_processed" json:"rowsProcessed"`     // Rows read from the file so far
+	RowsInserted  int64      `bson:"rows_inserted" json:"rowsInserted"`       // Rows written to the database so far
+	RowsRejected  int64      `bson:"rows_rejected" json:"rowsRejected"`       // Rows that could not be imported
+	RowsDuplicate int64      `bson:"rows_duplicate" json:"rowsDuplicate"`     // Rows matching a stored record, skipped or replaced
+	Mode          string     `bson:"mode" json:"mode"`                        // Import mode applied to invalid rows
+	OnDuplicate   string     `bson:"on_duplicate" json:"onDuplicate"`         // Duplicate policy applied to the rows
+	Errors        []string   `bson:"errors" json:"errors"`                    // Problems that stopped or prevented the import
+	RowErrors     []RowError `bson:"row_errors" json:"rowErrors"`             // Problems found in individual rows
+	CreatedAt     time.Time  `bson:"created_at" json:"createdAt"`             // Time the job was submitted
+	StartedAt     time.Time  `bson:"started_at,omitempty" json:"startedAt"`   // Time a worker picked the job up
+	FinishedAt    time.Time  `bson:"finished_at,omitempty" json:"finishedAt"` // Time the job succeeded or failed
+}
@@ -0,0 +1,61 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Events notified to webhooks
+const (
+	EventAlertFiring     = "alert.firing"     // An alert started firing, data is an AlertEvent
+	EventAlertResolved   = "alert.resolved"   // A firing alert resolved, data is an AlertEvent
+	EventImportSucceeded = "import.succeeded" // An import job finished, data is an ImportJob
+	EventImportFailed    = "import.failed"    // An import job failed, data is an ImportJob
+)
+
+// Notification is the JSON body posted to webhooks
+// Every endpoint receives the same ID for an event, so receivers can discard repeats
+type Notification struct {
+	ID        string      `json:"id"`         // Notification identifier
+	Event     string      `json:"event"`      // One of the Event* names
+	CreatedAt time.Time   `json:"created_at"` // Time the event happened
+	Data      interface{} `json:"data"`       // Alert event or import job
+}
+
+// Webhook delivery states
+const (
+	DeliveryPending   = "pending"   // Waiting for its first or next attempt
+	DeliveryDelivered = "delivered" // The endpoint answered with a 2xx status
+	DeliveryDead      = "dead"      // Every attempt failed, kept for inspection and redelivery
+)
+
+// WebhookDelivery is the delivery of one notification to one endpoint
+type WebhookDelivery struct {
+	ID          string           `bson:"_id" json:"id"`                    // Delivery identifier, sent in the X-GoMongoViz-Delivery header
+	Endpoint    string           `bson:"endpoint" json:"endpoint"`         // Name of the configured endpoint
+	URL         string           `bson:"url" json:"url"`                   // URL posted to
+	Event       string           `bson:"event" json:"event"`               // One of the Event* names
+	Payload     json.RawMessage  `bson:"payload" json:"payload"`           // Body posted, the same on every attempt
+	State       string           `bson:"state" json:"state"`               // One of the Delivery* states
+	Attempts    []WebhookAttempt `bson:"attempts" json:"attempts"`         // Every attempt made, oldest first
+	QueuedAt    time.Time        `bson:"queued_at" json:"queued_at"`       // Time the delivery was created or last redelivered
+	NextAttempt time.Time        `bson:"next_attempt" json:"next_attempt"` // Time of the next attempt while pending
+	CreatedAt   time.Time        `bson:"created_at" json:"created_at"`     // Time the delivery was created
+	DeliveredAt time.Time        `bson:"delivered_at" json:"delivered_at"` // Time the endpoint accepted the notification
+}
+
+// WebhookAttempt is the outcome of one attempt to post a notification
+type WebhookAttempt struct {
+	At         time.Time `bson:"at" json:"at"`                                       // Time the attempt started
+	StatusCode int       `bson:"status_code,omitempty" json:"status_code,omitempty"` // HTTP status answered, 0 if there was no answer
+	Error      string    `bson:"error,omitempty" json:"error,omitempty"`             // Reason the attempt failed
+	DurationMs int64     `bson:"duration_ms" json:"duration_ms"`                     // Time taken by the attempt
+}
+
+// WebhookDeliveryQuery filters the delivery history
+type WebhookDeliveryQuery struct {
+	States   []string // Only deliveries in one of these states unless empty
+	Endpoint string   // Only deliveries to this endpoint unless empty
+	Event    string   // Only deliveries of this event unless empty
+	Limit    int64    // Maximum number of deliveries, newest first; 0 returns everything
+}
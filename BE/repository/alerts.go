@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"gomongoviz/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SaveAlertRule creates or replaces an alert rule
+func (r RepositoryDefault) SaveAlertRule(ctx context.Context, rule model.AlertRule) error {
+	collection := r.Client.Database(r.Database).Collection(r.RulesCollection)
+	ctx, cancel := withTimeout(ctx, r.WriteTimeout)
+	defer cancel()
+
+	_, err := collection.ReplaceOne(ctx, bson.M{"_id": rule.ID}, rule, options.Replace().SetUpsert(true))
+	return err
+}
+
+// GetAlertRule retrieves an alert rule by ID
+// It returns ErrNotFound if no rule has that ID
+func (r RepositoryDefault) GetAlertRule(ctx context.Context, id string) (*model.AlertRule, error) {
+	collection := r.Client.Database(r.Database).Collection(r.RulesCollection)
+	ctx, cancel := withTimeout(ctx, r.QueryTimeout)
+	defer cancel()
+
+	var rule model.AlertRule
+	err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&rule)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// ListAlertRules retrieves every alert rule, oldest first
+func (r RepositoryDefault) ListAlertRules(ctx context.Context) ([]model.AlertRule, error) {
+	collection := r.Client.Database(r.Database).Collection(r.RulesCollection)
+	ctx, cancel := withTimeout(ctx, r.QueryTimeout)
+	defer cancel()
+
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.WithoutCancel(ctx))
+
+	results := make([]model.AlertRule, 0)
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// DeleteAlertRule removes an alert rule
+// It returns ErrNotFound if no rule has that ID
+func (r RepositoryDefault) DeleteAlertRule(ctx context.Context, id string) error {
+	collection := r.Client.Database(r.Database).Collection(r.RulesCollection)
+	ctx, cancel := withTimeout(ctx, r.WriteTimeout)
+	defer cancel()
+
+	res, err := collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SaveAlerts creates or replaces the state of alerts
+func (r RepositoryDefault) SaveAlerts(ctx context.Context, alerts []model.Alert) error {
+	if len(alerts) == 0 {
+		return nil
+	}
+	collection := r.Client.Database(r.Database).Collection(r.AlertsCollection)
+	ctx, cancel := withTimeout(ctx, r.WriteTimeout)
+	defer cancel()
+
+	models := make([]mongo.WriteModel, 0, len(alerts))
+	for _, alert := range alerts {
+		models = append(models, mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": alert.ID}).SetReplacement(alert).SetUpsert(true))
+	}
+	_, err := collection.BulkWrite(ctx, models)
+	return err
+}
+
+// ListAlerts retrieves alert states ordered by ID
+// Only the alerts of ruleID and in one of the given states are returned unless they are empty
+func (r RepositoryDefault) ListAlerts(ctx context.Context, ruleID string, states []string) ([]model.Alert, error) {
+	collection := r.Client.Database(r.Database).Collection(r.AlertsCollection)
+	ctx, cancel := withTimeout(ctx, r.QueryTimeout)
+	defer cancel()
+
+	filter := bson.M{}
+	if ruleID != "" {
+		filter["rule_id"] = ruleID
+	}
+	if len(states) > 0 {
+		filter["state"] = bson.M{"$in": states}
+	}
+
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.WithoutCancel(ctx))
+
+	results := make([]model.Alert, 0)
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// DeleteAlerts removes the state of every alert of a rule
+func (r RepositoryDefault) DeleteAlerts(ctx context.Context, ruleID string) error {
+	collection := r.Client.Database(r.Database).Collection(r.AlertsCollection)
+	ctx, cancel := withTimeout(ctx, r.WriteTimeout)
+	defer cancel()
+
+	_, err := collection.DeleteMany(ctx, bson.M{"rule_id": ruleID})
+	return err
+}
+
+// SaveAlertEvents appends events to the alert history
+func (r RepositoryDefault) SaveAlertEvents(ctx context.Context, events []model.AlertEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	collection := r.Client.Database(r.Database).Collection(r.EventsCollection)
+	ctx, cancel := withTimeout(ctx, r.WriteTimeout)
+	defer cancel()
+
+	documents := make([]interface{}, 0, len(events))
+	for _, event := range events {
+		documents = append(documents, event)
+	}
+	_, err := collection.InsertMany(ctx, documents)
+	return err
+}
+
+// ListAlertEvents retrieves the alert history matching query, newest first
+func (r RepositoryDefault) ListAlertEvents(ctx context.Context, query model.AlertEventQuery) ([]model.AlertEvent, error) {
+	collection := r.Client.Database(r.Database).Collection(r.EventsCollection)
+	ctx, cancel := withTimeout(ctx, r.QueryTimeout)
+	defer cancel()
+
+	filter := bson.M{}
+	if query.RuleID != "" {
+		filter["rule_id"] = query.RuleID
+	}
+	if query.ObjectID != nil {
+		filter["object_id"] = *query.ObjectID
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}, {Key: "created_at", Value: -1}})
+	if query.Limit > 0 {
+		opts.SetLimit(query.Limit)
+	}
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.WithoutCancel(ctx))
+
+	results := make([]model.AlertEvent, 0)
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"context"
+
+	"gomongoviz/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SaveAnomalyBaselines creates or replaces anomaly baselines
+func (r RepositoryDefault) SaveAnomalyBaselines(ctx context.Context, baselines []model.AnomalyBaseline) error {
+	if len(baselines) == 0 {
+		return nil
+	}
+	collection := r.Client.Database(r.Database).Collection(r.BaselinesCollection)
+	ctx, cancel := withTimeout(ctx, r.WriteTimeout)
+	defer cancel()
+
+	models := make([]mongo.WriteModel, 0, len(baselines))
+	for _, baseline := range baselines {
+		models = append(models, mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": baseline.ID}).SetReplacement(baseline).SetUpsert(true))
+	}
+	_, err := collection.BulkWrite(ctx, models)
+	return err
+}
+
+// ListAnomalyBaselines retrieves every anomaly baseline ordered by ID
+func (r RepositoryDefault) ListAnomalyBaselines(ctx context.Context) ([]model.AnomalyBaseline, error) {
+	collection := r.Client.Database(r.Database).Collection(r.BaselinesCollection)
+	ctx, cancel := withTimeout(ctx, r.QueryTimeout)
+	defer cancel()
+
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.WithoutCancel(ctx))
+
+	results := make([]model.AnomalyBaseline, 0)
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// SaveAnomalies creates or replaces flagged anomalies
+// A reading flagged again replaces its previous flag
+func (r RepositoryDefault) SaveAnomalies(ctx context.Context, anomalies []model.Anomaly) error {
+	if len(anomalies) == 0 {
+		return nil
+	}
+	collection := r.Client.Database(r.Database).Collection(r.AnomaliesCollection)
+	ctx, cancel := withTimeout(ctx, r.WriteTimeout)
+	defer cancel()
+
+	models := make([]mongo.WriteModel, 0, len(anomalies))
+	for _, anomaly := range anomalies {
+		models = append(models, mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": anomaly.ID}).SetReplacement(anomaly).SetUpsert(true))
+	}
+	_, err := collection.BulkWrite(ctx, models)
+	return err
+}
+
+// ListAnomalies retrieves the anomalies matching query in timestamp order, then by port
+// and field
+func (r RepositoryDefault) ListAnomalies(ctx context.Context, query model.AnomalyQuery) ([]model.Anomaly, error) {
+	collection := r.Client.Database(r.Database).Collection(r.AnomaliesCollection)
+	ctx, cancel := withTimeout(ctx, r.QueryTimeout)
+	defer cancel()
+
+	filter := bson.M{"object_id": query.ObjectID}
+	if query.PortNum != nil {
+		filter["port_num"] = *query.PortNum
+	}
+	if len(query.Fields) > 0 {
+		filter["field"] = bson.M{"$in": query.Fields}
+	}
+	timeFilter := bson.M{}
+	if !query.From.IsZero() {
+		timeFilter["$gte"] = query.From
+	}
+	if !query.To.IsZero() {
+		timeFilter["$lte"] = query.To
+	}
+	if len(timeFilter) > 0 {
+		filter["timestamp"] = timeFilter
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}, {Key: "port_num", Value: 1}, {Key: "field", Value: 1}})
+	if query.Limit > 0 {
+		opts.SetLimit(query.Limit)
+	}
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.WithoutCancel(ctx))
+
+	results := make([]model.Anomaly, 0)
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,823 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"time"
+
+	"gomongoviz/model"
+
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Buckets of the embedded database
+var (
+	boltSensorBucket     = []byte("sensor_data")        // Records keyed by object, port and timestamp
+	boltJobsBucket       = []byte("import_jobs")        // Import job records keyed by ID
+	boltRulesBucket      = []byte("alert_rules")        // Alert rules keyed by ID
+	boltAlertsBucket     = []byte("alerts")             // Alert states keyed by ID
+	boltEventsBucket     = []byte("alert_events")       // Alert events keyed by ID
+	boltBaselinesBucket  = []byte("anomaly_baselines")  // Anomaly baselines keyed by ID
+	boltAnomaliesBucket  = []byte("anomalies")          // Anomalies keyed by object, timestamp, port and field
+	boltDeliveriesBucket = []byte("webhook_deliveries") // Webhook deliveries keyed by ID
+	boltFieldsBucket     = []byte("computed_fields")    // Computed fields keyed by name
+)
+
+// boltKeyLen is the length of a record key: object_id, port_num and timestamp, 8 bytes each
+const boltKeyLen = 24
+
+// BoltRepository is a Repository stored in a single file by the embedded bbolt database
+// Records, jobs, alerts, anomalies, deliveries and computed fields are stored as BSON documents, like in MongoDB, and records are keyed
+// by (object_id, port_num, timestamp) in an order-preserving encoding,
+// so object and port discovery and filtered queries are range scans over the keys, and
+// the key itself enforces the same uniqueness as the MongoDB index
+type BoltRepository struct {
+	db *bolt.DB
+}
+
+// NewBoltRepository opens or creates the database file at path
+// The file is locked while it is open, so only one server can use it at a time
+func NewBoltRepository(path string) (*BoltRepository, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltSensorBucket, boltJobsBucket, boltRulesBucket, boltAlertsBucket, boltEventsBucket, boltBaselinesBucket, boltAnomaliesBucket, boltDeliveriesBucket, boltFieldsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("preparing %s: %w", path, err)
+	}
+	return &BoltRepository{db: db}, nil
+}
+
+// Close releases the database file
+func (b *BoltRepository) Close() error {
+	return b.db.Close()
+}
+
+// GetUniqueObjectIDs returns every object ID in ascending order
+// It jumps from one object to the next without reading their records
+func (b *BoltRepository) GetUniqueObjectIDs(ctx context.Context) ([]model.ObjectInfo, error) {
+	results := make([]model.ObjectInfo, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltSensorBucket).Cursor()
+		for k, _ := c.First(); k != nil; k, _ = seekPast(c, k[:8]) {
+			results = append(results, model.ObjectInfo{ObjectID: decodeFloatKey(k[:8])})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, ctx.Err()
+}
+
+// GetPorts returns every port of an object in ascending order
+func (b *BoltRepository) GetPorts(ctx context.Context, objectID int) ([]model.PortInfo, error) {
+	results := make([]model.PortInfo, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		for _, port := range boltPorts(tx, float64(objectID)) {
+			results = append(results, model.PortInfo{PortNum: port})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, ctx.Err()
+}
+
+// GetDataByObjectID returns one page of the records matching the query and their total count
+func (b *BoltRepository) GetDataByObjectID(ctx context.Context, query model.SensorDataQuery) (*model.SensorDataRes, error) {
+	matches, err := b.find(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return pageRecords(matches, query), nil
+}
+
+// StreamData calls fn for every record matching the query, in timestamp order
+// A single port in ascending order is streamed straight from the keys; other queries
+// need their records merged and sorted first
+func (b *BoltRepository) StreamData(ctx context.Context, query model.SensorDataQuery, fn func(*model.SensorData) error) error {
+	filter, err := newRecordFilter(query)
+	if err != nil {
+		return err
+	}
+	if !filter.hasPort() || query.SortDesc {
+		matches, err := b.find(ctx, query)
+		if err != nil {
+			return err
+		}
+		for i := range matches {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(&matches[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return b.db.View(func(tx *bolt.Tx) error {
+		return scanPort(ctx, tx, filter, filter.portNum, fn)
+	})
+}
+
+// AggregateData computes time-bucketed aggregates of the requested fields per port
+func (b *BoltRepository) AggregateData(ctx context.Context, query model.AggregateQuery) ([]model.AggregateBucket, error) {
+	matches, err := b.find(ctx, query.SensorDataQuery)
+	if err != nil {
+		return nil, err
+	}
+	return AggregateRecords(matches, query)
+}
+
+// WatchData is not supported by the embedded storage; new records are found by polling
+func (b *BoltRepository) WatchData(ctx context.Context, query model.SensorDataQuery) (DataWatch, error) {
+	return nil, ErrWatchUnsupported
+}
+
+// SaveSensorData stores a batch of records in one transaction, applying onDuplicate to
+// records whose key is already stored
+// Like the ordered insert of RepositoryDefault, the error policy keeps the records
+// before the first duplicate
+func (b *BoltRepository) SaveSensorData(ctx context.Context, data []model.SensorData, onDuplicate string) (model.SaveResult, error) {
+	if err := ctx.Err(); err != nil {
+		return model.SaveResult{}, err
+	}
+
+	var result model.SaveResult
+	var duplicateErr error
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltSensorBucket)
+		for _, item := range data {
+			item = normalizeRecord(item)
+			key := boltKey(item.ObjectID, item.PortNum, item.Timestamp)
+			if stored := bucket.Get(key); stored != nil {
+				switch onDuplicate {
+				case model.DuplicateUpsert:
+					var previous model.SensorData
+					if err := bson.Unmarshal(stored, &previous); err != nil {
+						return err
+					}
+					item.ID = previous.ID
+				case model.DuplicateError:
+					duplicateErr = fmt.Errorf("duplicate record for object_id %v, port_num %v, timestamp %s",
+						item.ObjectID, item.PortNum, item.Timestamp.Format(time.RFC3339Nano))
+					return nil
+				default:
+					result.Duplicates++
+					continue
+				}
+				result.Duplicates++
+			} else {
+				if item.ID == "" {
+					item.ID = primitive.NewObjectID().Hex()
+				}
+				result.Inserted++
+			}
+
+			value, err := bson.Marshal(item)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return model.SaveResult{}, err
+	}
+	if duplicateErr != nil {
+		return model.SaveResult{}, duplicateErr
+	}
+	return result, nil
+}
+
+// SaveImportJob creates or replaces an import job record
+func (b *BoltRepository) SaveImportJob(ctx context.Context, job model.ImportJob) error {
+	value, err := bson.Marshal(job)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltJobsBucket).Put([]byte(job.ID), value)
+	})
+}
+
+// GetImportJob retrieves an import job record by ID
+// It returns ErrNotFound if no job has that ID
+func (b *BoltRepository) GetImportJob(ctx context.Context, id string) (*model.ImportJob, error) {
+	var job *model.ImportJob
+	err := b.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(boltJobsBucket).Get([]byte(id))
+		if value == nil {
+			return ErrNotFound
+		}
+		job = &model.ImportJob{}
+		return bson.Unmarshal(value, job)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return job, ctx.Err()
+}
+
+// ListImportJobs retrieves import job records, newest first
+// Only jobs in one of the given states are returned unless states is empty
+func (b *BoltRepository) ListImportJobs(ctx context.Context, states []string) ([]model.ImportJob, error) {
+	results := make([]model.ImportJob, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltJobsBucket).ForEach(func(_, value []byte) error {
+			var job model.ImportJob
+			if err := bson.Unmarshal(value, &job); err != nil {
+				return err
+			}
+			if len(states) == 0 || slices.Contains(states, job.State) {
+				results = append(results, job)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].CreatedAt.After(results[j].CreatedAt) })
+	return results, ctx.Err()
+}
+
+// SaveAlertRule creates or replaces an alert rule
+func (b *BoltRepository) SaveAlertRule(ctx context.Context, rule model.AlertRule) error {
+	return b.put(ctx, boltRulesBucket, rule.ID, rule)
+}
+
+// GetAlertRule retrieves an alert rule by ID
+// It returns ErrNotFound if no rule has that ID
+func (b *BoltRepository) GetAlertRule(ctx context.Context, id string) (*model.AlertRule, error) {
+	var rule *model.AlertRule
+	err := b.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(boltRulesBucket).Get([]byte(id))
+		if value == nil {
+			return ErrNotFound
+		}
+		rule = &model.AlertRule{}
+		return bson.Unmarshal(value, rule)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rule, ctx.Err()
+}
+
+// ListAlertRules retrieves every alert rule, oldest first
+func (b *BoltRepository) ListAlertRules(ctx context.Context) ([]model.AlertRule, error) {
+	results := make([]model.AlertRule, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltRulesBucket).ForEach(func(_, value []byte) error {
+			var rule model.AlertRule
+			if err := bson.Unmarshal(value, &rule); err != nil {
+				return err
+			}
+			results = append(results, rule)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortRules(results)
+	return results, ctx.Err()
+}
+
+// DeleteAlertRule removes an alert rule
+// It returns ErrNotFound if no rule has that ID
+func (b *BoltRepository) DeleteAlertRule(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltRulesBucket)
+		if bucket.Get([]byte(id)) == nil {
+			return ErrNotFound
+		}
+		return bucket.Delete([]byte(id))
+	})
+}
+
+// SaveAlerts creates or replaces the state of alerts
+func (b *BoltRepository) SaveAlerts(ctx context.Context, alerts []model.Alert) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltAlertsBucket)
+		for _, alert := range alerts {
+			value, err := bson.Marshal(alert)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(alert.ID), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ListAlerts retrieves alert states ordered by ID
+// Only the alerts of ruleID and in one of the given states are returned unless they are empty
+func (b *BoltRepository) ListAlerts(ctx context.Context, ruleID string, states []string) ([]model.Alert, error) {
+	results := make([]model.Alert, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		// Keys are the alert IDs, so the bucket is already in ID order
+		return tx.Bucket(boltAlertsBucket).ForEach(func(_, value []byte) error {
+			var alert model.Alert
+			if err := bson.Unmarshal(value, &alert); err != nil {
+				return err
+			}
+			if matchAlert(alert, ruleID, states) {
+				results = append(results, alert)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, ctx.Err()
+}
+
+// DeleteAlerts removes the state of every alert of a rule
+func (b *BoltRepository) DeleteAlerts(ctx context.Context, ruleID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		// Alert IDs start with the ID of their rule
+		// Keys are collected first as deleting under a cursor can skip the next key
+		prefix := []byte(ruleID + ":")
+		bucket := tx.Bucket(boltAlertsBucket)
+		var keys [][]byte
+		c := bucket.Cursor()
+		for key, _ := c.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = c.Next() {
+			keys = append(keys, slices.Clone(key))
+		}
+		for _, key := range keys {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SaveAlertEvents appends events to the alert history
+func (b *BoltRepository) SaveAlertEvents(ctx context.Context, events []model.AlertEvent) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltEventsBucket)
+		for _, event := range events {
+			value, err := bson.Marshal(event)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(event.ID), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ListAlertEvents retrieves the alert history matching query, newest first
+func (b *BoltRepository) ListAlertEvents(ctx context.Context, query model.AlertEventQuery) ([]model.AlertEvent, error) {
+	results := make([]model.AlertEvent, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltEventsBucket).ForEach(func(_, value []byte) error {
+			var event model.AlertEvent
+			if err := bson.Unmarshal(value, &event); err != nil {
+				return err
+			}
+			if matchAlertEvent(event, query) {
+				results = append(results, event)
+			}
+			return ctx.Err()
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return limitAlertEvents(results, query.Limit), nil
+}
+
+// SaveAnomalyBaselines creates or replaces anomaly baselines
+func (b *BoltRepository) SaveAnomalyBaselines(ctx context.Context, baselines []model.AnomalyBaseline) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBaselinesBucket)
+		for _, baseline := range baselines {
+			value, err := bson.Marshal(baseline)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(baseline.ID), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ListAnomalyBaselines retrieves every anomaly baseline ordered by ID
+func (b *BoltRepository) ListAnomalyBaselines(ctx context.Context) ([]model.AnomalyBaseline, error) {
+	results := make([]model.AnomalyBaseline, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBaselinesBucket).ForEach(func(_, value []byte) error {
+			var baseline model.AnomalyBaseline
+			if err := bson.Unmarshal(value, &baseline); err != nil {
+				return err
+			}
+			results = append(results, baseline)
+			return ctx.Err()
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// SaveAnomalies creates or replaces flagged anomalies
+// A reading flagged again replaces its previous flag
+func (b *BoltRepository) SaveAnomalies(ctx context.Context, anomalies []model.Anomaly) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltAnomaliesBucket)
+		for _, anomaly := range anomalies {
+			value, err := bson.Marshal(anomaly)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(boltAnomalyKey(anomaly), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ListAnomalies retrieves the anomalies matching query in timestamp order, then by port
+// and field
+// The keys start with the object and the timestamp, so the time range is a range scan
+func (b *BoltRepository) ListAnomalies(ctx context.Context, query model.AnomalyQuery) ([]model.Anomaly, error) {
+	results := make([]model.Anomaly, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		prefix := encodeFloatKey(query.ObjectID)
+		start := prefix
+		if !query.From.IsZero() {
+			start = append(bytes.Clone(prefix), encodeTimeKey(query.From)...)
+		}
+		var end []byte
+		if !query.To.IsZero() {
+			end = encodeTimeKey(query.To)
+		}
+
+		c := tx.Bucket(boltAnomaliesBucket).Cursor()
+		for key, value := c.Seek(start); key != nil && bytes.HasPrefix(key, prefix); key, value = c.Next() {
+			if end != nil && bytes.Compare(key[8:16], end) > 0 {
+				break
+			}
+			var anomaly model.Anomaly
+			if err := bson.Unmarshal(value, &anomaly); err != nil {
+				return err
+			}
+			if !matchAnomaly(anomaly, query) {
+				continue
+			}
+			results = append(results, anomaly)
+			if query.Limit > 0 && int64(len(results)) == query.Limit {
+				break
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// SaveWebhookDelivery creates or replaces a webhook delivery
+func (b *BoltRepository) SaveWebhookDelivery(ctx context.Context, delivery model.WebhookDelivery) error {
+	return b.put(ctx, boltDeliveriesBucket, delivery.ID, delivery)
+}
+
+// GetWebhookDelivery retrieves a webhook delivery by ID
+// It returns ErrNotFound if no delivery has that ID
+func (b *BoltRepository) GetWebhookDelivery(ctx context.Context, id string) (*model.WebhookDelivery, error) {
+	var delivery *model.WebhookDelivery
+	err := b.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(boltDeliveriesBucket).Get([]byte(id))
+		if value == nil {
+			return ErrNotFound
+		}
+		delivery = &model.WebhookDelivery{}
+		return bson.Unmarshal(value, delivery)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return delivery, ctx.Err()
+}
+
+// ListWebhookDeliveries retrieves the webhook deliveries matching query, newest first
+func (b *BoltRepository) ListWebhookDeliveries(ctx context.Context, query model.WebhookDeliveryQuery) ([]model.WebhookDelivery, error) {
+	results := make([]model.WebhookDelivery, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltDeliveriesBucket).ForEach(func(_, value []byte) error {
+			var delivery model.WebhookDelivery
+			if err := bson.Unmarshal(value, &delivery); err != nil {
+				return err
+			}
+			if matchDelivery(delivery, query) {
+				results = append(results, delivery)
+			}
+			return ctx.Err()
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return limitDeliveries(results, query.Limit), nil
+}
+
+// CreateComputedField stores a new computed field
+// It returns ErrExists if a field already has that name; the check and the write share
+// one transaction
+func (b *BoltRepository) CreateComputedField(ctx context.Context, field model.ComputedField) error {
+	value, err := bson.Marshal(field)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltFieldsBucket)
+		if bucket.Get([]byte(field.Name)) != nil {
+			return ErrExists
+		}
+		return bucket.Put([]byte(field.Name), value)
+	})
+}
+
+// SaveComputedField creates or replaces a computed field
+func (b *BoltRepository) SaveComputedField(ctx context.Context, field model.ComputedField) error {
+	return b.put(ctx, boltFieldsBucket, field.Name, field)
+}
+
+// GetComputedField retrieves a computed field by name
+// It returns ErrNotFound if no field has that name
+func (b *BoltRepository) GetComputedField(ctx context.Context, name string) (*model.ComputedField, error) {
+	var field *model.ComputedField
+	err := b.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(boltFieldsBucket).Get([]byte(name))
+		if value == nil {
+			return ErrNotFound
+		}
+		field = &model.ComputedField{}
+		return bson.Unmarshal(value, field)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return field, ctx.Err()
+}
+
+// ListComputedFields retrieves every computed field in name order
+func (b *BoltRepository) ListComputedFields(ctx context.Context) ([]model.ComputedField, error) {
+	results := make([]model.ComputedField, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltFieldsBucket).ForEach(func(_, value []byte) error {
+			var field model.ComputedField
+			if err := bson.Unmarshal(value, &field); err != nil {
+				return err
+			}
+			results = append(results, field)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortComputedFields(results)
+	return results, ctx.Err()
+}
+
+// DeleteComputedField removes a computed field
+// It returns ErrNotFound if no field has that name
+func (b *BoltRepository) DeleteComputedField(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltFieldsBucket)
+		if bucket.Get([]byte(name)) == nil {
+			return ErrNotFound
+		}
+		return bucket.Delete([]byte(name))
+	})
+}
+
+// put stores a document as BSON under key in a bucket
+func (b *BoltRepository) put(ctx context.Context, bucket []byte, key string, document interface{}) error {
+	value, err := bson.Marshal(document)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), value)
+	})
+}
+
+// EnsureIndexes has nothing to create; the record key is the only index
+func (b *BoltRepository) EnsureIndexes(ctx context.Context) ([]model.IndexStatus, error) {
+	return b.IndexStatus(ctx)
+}
+
+// IndexStatus reports the record key, which serves as the unique index of the records
+func (b *BoltRepository) IndexStatus(ctx context.Context) ([]model.IndexStatus, error) {
+	return []model.IndexStatus{{
+		Collection: string(boltSensorBucket),
+		Name:       "object_port_timestamp_key",
+		Keys:       []model.IndexKey{{Field: "object_id", Direction: 1}, {Field: "port_num", Direction: 1}, {Field: "timestamp", Direction: 1}},
+		Unique:     true,
+		Declared:   true,
+		State:      model.IndexPresent,
+		Purpose:    "record key; deduplication, object and port discovery, filtered queries",
+	}}, ctx.Err()
+}
+
+// find returns the records matching the query, sorted on (timestamp, _id) in the
+// requested direction
+// Only the key ranges of the requested ports and time range are read
+func (b *BoltRepository) find(ctx context.Context, query model.SensorDataQuery) ([]model.SensorData, error) {
+	filter, err := newRecordFilter(query)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]model.SensorData, 0)
+	err = b.db.View(func(tx *bolt.Tx) error {
+		ports := []float64{filter.portNum}
+		if !filter.hasPort() {
+			ports = boltPorts(tx, filter.objectID)
+		}
+		for _, port := range ports {
+			err := scanPort(ctx, tx, filter, port, func(data *model.SensorData) error {
+				matches = append(matches, *data)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortRecords(matches, query.SortDesc)
+	return matches, nil
+}
+
+// scanPort calls fn for the records of one port of the filtered object in the filter's
+// time range, in timestamp order
+func scanPort(ctx context.Context, tx *bolt.Tx, filter recordFilter, port float64, fn func(*model.SensorData) error) error {
+	prefix := append(encodeFloatKey(filter.objectID), encodeFloatKey(port)...)
+	start := prefix
+	if !filter.from.IsZero() {
+		start = boltKey(filter.objectID, port, filter.from)
+	}
+
+	c := tx.Bucket(boltSensorBucket).Cursor()
+	for k, v := c.Seek(start); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var data model.SensorData
+		if err := bson.Unmarshal(v, &data); err != nil {
+			return err
+		}
+		if !filter.to.IsZero() && data.Timestamp.After(filter.to) {
+			break
+		}
+		if err := fn(&data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// boltPorts returns the ports of an object in ascending order, jumping from one port
+// to the next without reading their records
+func boltPorts(tx *bolt.Tx, objectID float64) []float64 {
+	prefix := encodeFloatKey(objectID)
+	ports := make([]float64, 0)
+	c := tx.Bucket(boltSensorBucket).Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = seekPast(c, k[:16]) {
+		ports = append(ports, decodeFloatKey(k[8:16]))
+	}
+	return ports
+}
+
+// boltKey builds the key of a record
+func boltKey(objectID float64, portNum float64, timestamp time.Time) []byte {
+	key := make([]byte, 0, boltKeyLen)
+	key = append(key, encodeFloatKey(objectID)...)
+	key = append(key, encodeFloatKey(portNum)...)
+	return append(key, encodeTimeKey(timestamp)...)
+}
+
+// boltAnomalyKey returns the key of an anomaly: object_id, timestamp, port_num, then field
+func boltAnomalyKey(anomaly model.Anomaly) []byte {
+	key := make([]byte, 0, boltKeyLen+len(anomaly.Field))
+	key = append(key, encodeFloatKey(anomaly.ObjectID)...)
+	key = append(key, encodeTimeKey(anomaly.Timestamp)...)
+	key = append(key, encodeFloatKey(anomaly.PortNum)...)
+	return append(key, anomaly.Field...)
+}
+
+// encodeTimeKey encodes a timestamp to the millisecond so that byte order matches time order
+func encodeTimeKey(timestamp time.Time) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(timestamp.UnixMilli())^(1<<63))
+}
+
+// encodeFloatKey encodes a float64 so that byte order matches numeric order
+func encodeFloatKey(value float64) []byte {
+	bits := math.Float64bits(value)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	return binary.BigEndian.AppendUint64(nil, bits)
+}
+
+// decodeFloatKey reverses encodeFloatKey
+func decodeFloatKey(key []byte) float64 {
+	bits := binary.BigEndian.Uint64(key)
+	if bits&(1<<63) != 0 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits)
+}
+
+// seekPast moves the cursor to the first key that does not start with prefix and
+// comes after it, returning nil when there is none
+func seekPast(c *bolt.Cursor, prefix []byte) ([]byte, []byte) {
+	end := bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i]++
+		if end[i] != 0 {
+			return c.Seek(end[:i+1])
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,20 @@
+package repository_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"gomongoviz/repository"
+	"gomongoviz/repository/repotest"
+)
+
+func TestBoltRepository(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repository.Repository {
+		repo, err := repository.NewBoltRepository(filepath.Join(t.TempDir(), "data.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { repo.Close() })
+		return repo
+	})
+}
@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"gomongoviz/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateComputedField stores a new computed field
+// It returns ErrExists if a field already has that name, relying on the unique _id
+func (r RepositoryDefault) CreateComputedField(ctx context.Context, field model.ComputedField) error {
+	collection := r.Client.Database(r.Database).Collection(r.FieldsCollection)
+	ctx, cancel := withTimeout(ctx, r.WriteTimeout)
+	defer cancel()
+
+	_, err := collection.InsertOne(ctx, field)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrExists
+	}
+	return err
+}
+
+// SaveComputedField creates or replaces a computed field
+func (r RepositoryDefault) SaveComputedField(ctx context.Context, field model.ComputedField) error {
+	collection := r.Client.Database(r.Database).Collection(r.FieldsCollection)
+	ctx, cancel := withTimeout(ctx, r.WriteTimeout)
+	defer cancel()
+
+	_, err := collection.ReplaceOne(ctx, bson.M{"_id": field.Name}, field, options.Replace().SetUpsert(true))
+	return err
+}
+
+// GetComputedField retrieves a computed field by name
+// It returns ErrNotFound if no field has that name
+func (r RepositoryDefault) GetComputedField(ctx context.Context, name string) (*model.ComputedField, error) {
+	collection := r.Client.Database(r.Database).Collection(r.FieldsCollection)
+	ctx, cancel := withTimeout(ctx, r.QueryTimeout)
+	defer cancel()
+
+	var field model.ComputedField
+	err := collection.FindOne(ctx, bson.M{"_id": name}).Decode(&field)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &field, nil
+}
+
+// ListComputedFields retrieves every computed field in name order
+func (r RepositoryDefault) ListComputedFields(ctx context.Context) ([]model.ComputedField, error) {
+	collection := r.Client.Database(r.Database).Collection(r.FieldsCollection)
+	ctx, cancel := withTimeout(ctx, r.QueryTimeout)
+	defer cancel()
+
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.WithoutCancel(ctx))
+
+	results := make([]model.ComputedField, 0)
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// DeleteComputedField removes a computed field
+// It returns ErrNotFound if no field has that name
+func (r RepositoryDefault) DeleteComputedField(ctx context.Context, name string) error {
+	collection := r.Client.Database(r.Database).Collection(r.FieldsCollection)
+	ctx, cancel := withTimeout(ctx, r.WriteTimeout)
+	defer cancel()
+
+	res, err := collection.DeleteOne(ctx, bson.M{"_id": name})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
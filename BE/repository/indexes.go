@@ -0,0 +1,267 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+
+	"gomongoviz/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// declaredIndexes lists every index the queries of this repository rely on
+// Add new indexes here; they are created at startup by EnsureIndexes
+func (r RepositoryDefault) declaredIndexes() []model.IndexSpec {
+	jobs := model.IndexSpec{
+		Collection: r.JobsCollection,
+		Name:       "state_created_at",
+		Keys:       []model.IndexKey{{Field: "state", Direction: 1}, {Field: "created_at", Direction: -1}},
+		Purpose:    "import job listing filtered by state, newest first",
+	}
+	deliveries := model.IndexSpec{
+		Collection: r.DeliveriesCollection,
+		Name:       "state_created_at",
+		Keys:       []model.IndexKey{{Field: "state", Direction: 1}, {Field: "created_at", Direction: -1}},
+		Purpose:    "webhook delivery history filtered by state, newest first; pending deliveries at startup",
+	}
+	alerts := []model.IndexSpec{
+		{
+			Collection: r.AlertsCollection,
+			Name:       "rule_id",
+			Keys:       []model.IndexKey{{Field: "rule_id", Direction: 1}},
+			Purpose:    "alert listing and reset per rule",
+		},
+		{
+			Collection: r.EventsCollection,
+			Name:       "rule_timestamp",
+			Keys:       []model.IndexKey{{Field: "rule_id", Direction: 1}, {Field: "timestamp", Direction: -1}},
+			Purpose:    "alert history of a rule, newest first",
+		},
+		{
+			Collection: r.EventsCollection,
+			Name:       "timestamp",
+			Keys:       []model.IndexKey{{Field: "timestamp", Direction: -1}},
+			Purpose:    "alert history, newest first",
+		},
+		{
+			Collection: r.AnomaliesCollection,
+			Name:       "object_timestamp",
+			Keys:       []model.IndexKey{{Field: "object_id", Direction: 1}, {Field: "timestamp", Direction: 1}},
+			Purpose:    "anomalies of an object in a time range, oldest first",
+		},
+	}
+
+	// Time-series collections cannot have unique indexes; duplicates are checked on write
+	if r.TimeSeries {
+		return append([]model.IndexSpec{
+			{
+				Collection: r.Collection,
+				Name:       "meta_object_port_timestamp",
+				Keys:       []model.IndexKey{{Field: "meta.object_id", Direction: 1}, {Field: "meta.port_num", Direction: 1}, {Field: "timestamp", Direction: 1}},
+				Purpose:    "duplicate checks; object and port filters with time ranges, port listing, aggregates",
+			},
+			{
+				Collection: r.Collection,
+				Name:       "meta_object_timestamp",
+				Keys:       []model.IndexKey{{Field: "meta.object_id", Direction: 1}, {Field: "timestamp", Direction: 1}},
+				Purpose:    "object listing; sorted and exported data of an object across ports",
+			},
+			jobs,
+			deliveries,
+		}, alerts...)
+	}
+
+	return append([]model.IndexSpec{
+		{
+			Collection: r.Collection,
+			Name:       "object_port_timestamp_unique",
+			Keys:       []model.IndexKey{{Field: "object_id", Direction: 1}, {Field: "port_num", Direction: 1}, {Field: "timestamp", Direction: 1}},
+			Unique:     true,
+			Purpose:    "deduplicates uploads; object and port filters with time ranges, port listing, aggregates",
+		},
+		{
+			Collection: r.Collection,
+			Name:       "object_timestamp_id",
+			Keys:       []model.IndexKey{{Field: "object_id", Direction: 1}, {Field: "timestamp", Direction: 1}, {Field: "_id", Direction: 1}},
+			Purpose:    "object listing; paged, sorted and exported data of an object across ports",
+		},
+		jobs,
+		deliveries,
+	}, alerts...)
+}
+
+// EnsureIndexes creates the declared indexes that do not exist yet and logs what it did
+// A failing index does not stop the others; the returned status lists every declared index
+// and the error joins every failure
+func (r RepositoryDefault) EnsureIndexes(ctx context.Context) ([]model.IndexStatus, error) {
+	statuses, err := r.IndexStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	specs := make(map[string]model.IndexSpec)
+	for _, spec := range r.declaredIndexes() {
+		specs[spec.Collection+"."+spec.Name] = spec
+	}
+
+	var errs []error
+	for i, status := range statuses {
+		if status.State != model.IndexMissing {
+			if status.Declared {
+				log.Printf("Index %s on %s is present", status.Name, status.Collection)
+			}
+			continue
+		}
+
+		spec := specs[status.Collection+"."+status.Name]
+		indexOptions := options.Index().SetName(spec.Name)
+		if spec.Unique {
+			indexOptions.SetUnique(true)
+		}
+		createCtx, cancel := withTimeout(ctx, r.WriteTimeout)
+		_, err := r.Client.Database(r.Database).Collection(spec.Collection).Indexes().CreateOne(createCtx, mongo.IndexModel{
+			Keys:    indexKeysDocument(spec.Keys),
+			Options: indexOptions,
+		})
+		cancel()
+		if err != nil {
+			log.Printf("Error creating index %s on %s: %v", spec.Name, spec.Collection, err)
+			statuses[i].State = model.IndexFailed
+			statuses[i].Error = err.Error()
+			errs = append(errs, fmt.Errorf("creating index %s on %s: %w", spec.Name, spec.Collection, err))
+			continue
+		}
+		log.Printf("Created index %s on %s", spec.Name, spec.Collection)
+		statuses[i].State = model.IndexCreated
+	}
+	return statuses, errors.Join(errs...)
+}
+
+// IndexStatus compares the declared indexes with the indexes in the database
+// Declared indexes are listed first in declaration order, followed by any other
+// index found on the application collections
+// An existing index with the same keys and uniqueness counts as present whatever its name
+func (r RepositoryDefault) IndexStatus(ctx context.Context) ([]model.IndexStatus, error) {
+	ctx, cancel := withTimeout(ctx, r.QueryTimeout)
+	defer cancel()
+
+	existing := make(map[string][]model.IndexStatus)
+	for _, name := range r.indexedCollections() {
+		indexes, err := r.listIndexes(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		existing[name] = indexes
+	}
+
+	statuses := make([]model.IndexStatus, 0)
+	for _, spec := range r.declaredIndexes() {
+		status := model.IndexStatus{
+			Collection: spec.Collection,
+			Name:       spec.Name,
+			Keys:       spec.Keys,
+			Unique:     spec.Unique,
+			Declared:   true,
+			State:      model.IndexMissing,
+			Purpose:    spec.Purpose,
+		}
+		for i, index := range existing[spec.Collection] {
+			if index.Declared || index.Unique != spec.Unique || !sameIndexKeys(index.Keys, spec.Keys) {
+				continue
+			}
+			existing[spec.Collection][i].Declared = true
+			status.Name = index.Name
+			status.State = model.IndexPresent
+			break
+		}
+		statuses = append(statuses, status)
+	}
+
+	for _, name := range r.indexedCollections() {
+		for _, index := range existing[name] {
+			if !index.Declared {
+				statuses = append(statuses, index)
+			}
+		}
+	}
+	return statuses, nil
+}
+
+// indexedCollections returns the collections whose indexes are managed, each listed once
+func (r RepositoryDefault) indexedCollections() []string {
+	var names []string
+	for _, name := range []string{r.Collection, r.JobsCollection, r.AlertsCollection, r.EventsCollection, r.DeliveriesCollection} {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// listIndexes reads the indexes of a collection, which has none if it does not exist yet
+func (r RepositoryDefault) listIndexes(ctx context.Context, collection string) ([]model.IndexStatus, error) {
+	specs, err := r.Client.Database(r.Database).Collection(collection).Indexes().ListSpecifications(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing indexes of %s: %w", collection, err)
+	}
+
+	indexes := make([]model.IndexStatus, 0, len(specs))
+	for _, spec := range specs {
+		index := model.IndexStatus{
+			Collection: collection,
+			Name:       spec.Name,
+			Unique:     spec.Unique != nil && *spec.Unique,
+			State:      model.IndexExtra,
+		}
+		var keys bson.D
+		if err := bson.Unmarshal(spec.KeysDocument, &keys); err != nil {
+			return nil, fmt.Errorf("reading keys of index %s on %s: %w", spec.Name, collection, err)
+		}
+		for _, key := range keys {
+			index.Keys = append(index.Keys, model.IndexKey{Field: key.Key, Direction: normalizeDirection(key.Value)})
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
+}
+
+// normalizeDirection turns the numeric key values MongoDB returns, which may be
+// int32, int64 or double, into an int so they compare equal to the declared ones
+func normalizeDirection(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+	return value
+}
+
+// sameIndexKeys reports whether two indexes have the same fields in the same order and direction
+func sameIndexKeys(a []model.IndexKey, b []model.IndexKey) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Field != b[i].Field || a[i].Direction != b[i].Direction {
+			return false
+		}
+	}
+	return true
+}
+
+// indexKeysDocument converts declared index keys into the ordered document MongoDB expects
+func indexKeysDocument(keys []model.IndexKey) bson.D {
+	document := make(bson.D, 0, len(keys))
+	for _, key := range keys {
+		document = append(document, bson.E{Key: key.Field, Value: key.Direction})
+	}
+	return document
+}
@@ -0,0 +1,497 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"gomongoviz/model"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryRepository is a Repository that keeps all data in memory
+// It follows the filtering, sorting, paging and duplicate semantics of RepositoryDefault,
+// so the backend can run without a database; everything is lost when the process stops
+type MemoryRepository struct {
+	mu         sync.RWMutex
+	records    []model.SensorData               // Stored records in insertion order
+	keys       map[recordKey]int                // Position of each record by (object_id, port_num, timestamp)
+	jobs       map[string]model.ImportJob       // Import job records by ID
+	rules      map[string]model.AlertRule       // Alert rules by ID
+	alerts     map[string]model.Alert           // Alert states by ID
+	events     []model.AlertEvent               // Alert history in insertion order
+	baselines  map[string]model.AnomalyBaseline // Anomaly baselines by ID
+	anomalies  map[string]model.Anomaly         // Flagged anomalies by ID
+	deliveries map[string]model.WebhookDelivery // Webhook deliveries by ID
+	fields     map[string]model.ComputedField   // Computed fields by name
+}
+
+// NewMemoryRepository creates an empty in-memory repository
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		keys:       make(map[recordKey]int),
+		jobs:       make(map[string]model.ImportJob),
+		rules:      make(map[string]model.AlertRule),
+		alerts:     make(map[string]model.Alert),
+		baselines:  make(map[string]model.AnomalyBaseline),
+		anomalies:  make(map[string]model.Anomaly),
+		deliveries: make(map[string]model.WebhookDelivery),
+		fields:     make(map[string]model.ComputedField),
+	}
+}
+
+// GetUniqueObjectIDs returns every object ID in ascending order
+func (m *MemoryRepository) GetUniqueObjectIDs(ctx context.Context) ([]model.ObjectInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	seen := make(map[float64]bool)
+	results := make([]model.ObjectInfo, 0)
+	for _, record := range m.records {
+		if !seen[record.ObjectID] {
+			seen[record.ObjectID] = true
+			results = append(results, model.ObjectInfo{ObjectID: record.ObjectID})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].ObjectID < results[j].ObjectID })
+	return results, ctx.Err()
+}
+
+// GetPorts returns every port of an object in ascending order
+func (m *MemoryRepository) GetPorts(ctx context.Context, objectID int) ([]model.PortInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	seen := make(map[float64]bool)
+	results := make([]model.PortInfo, 0)
+	for _, record := range m.records {
+		if record.ObjectID == float64(objectID) && !seen[record.PortNum] {
+			seen[record.PortNum] = true
+			results = append(results, model.PortInfo{PortNum: record.PortNum})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].PortNum < results[j].PortNum })
+	return results, ctx.Err()
+}
+
+// GetDataByObjectID returns one page of the records matching the query and their total count
+func (m *MemoryRepository) GetDataByObjectID(ctx context.Context, query model.SensorDataQuery) (*model.SensorDataRes, error) {
+	matches, err := m.find(query)
+	if err != nil {
+		return nil, err
+	}
+	return pageRecords(matches, query), ctx.Err()
+}
+
+// StreamData calls fn for every record matching the query, in timestamp order
+// Iteration stops at the first error returned by fn or when ctx is cancelled
+func (m *MemoryRepository) StreamData(ctx context.Context, query model.SensorDataQuery, fn func(*model.SensorData) error) error {
+	matches, err := m.find(query)
+	if err != nil {
+		return err
+	}
+	for i := range matches {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(&matches[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AggregateData computes time-bucketed aggregates of the requested fields per port
+// Buckets are aligned the way $dateTrunc aligns them
+func (m *MemoryRepository) AggregateData(ctx context.Context, query model.AggregateQuery) ([]model.AggregateBucket, error) {
+	matches, err := m.find(query.SensorDataQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	buckets, err := AggregateRecords(matches, query)
+	if err != nil {
+		return nil, err
+	}
+	return buckets, ctx.Err()
+}
+
+// WatchData is not supported by the in-memory storage; new records are found by polling
+func (m *MemoryRepository) WatchData(ctx context.Context, query model.SensorDataQuery) (DataWatch, error) {
+	return nil, ErrWatchUnsupported
+}
+
+// SaveSensorData stores a batch of records, applying onDuplicate to records that share
+// object_id, port_num and timestamp with a stored record
+// Like the ordered insert of RepositoryDefault, the error policy keeps the records
+// before the first duplicate
+func (m *MemoryRepository) SaveSensorData(ctx context.Context, data []model.SensorData, onDuplicate string) (model.SaveResult, error) {
+	if err := ctx.Err(); err != nil {
+		return model.SaveResult{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var result model.SaveResult
+	for _, item := range data {
+		item = normalizeRecord(item)
+		key := keyOf(item)
+		position, exists := m.keys[key]
+		switch {
+		case !exists:
+			if item.ID == "" {
+				item.ID = primitive.NewObjectID().Hex()
+			}
+			m.keys[key] = len(m.records)
+			m.records = append(m.records, item)
+			result.Inserted++
+		case onDuplicate == model.DuplicateUpsert:
+			item.ID = m.records[position].ID
+			m.records[position] = item
+			result.Duplicates++
+		case onDuplicate == model.DuplicateError:
+			return model.SaveResult{}, fmt.Errorf("duplicate record for object_id %v, port_num %v, timestamp %s",
+				item.ObjectID, item.PortNum, item.Timestamp.Format(time.RFC3339Nano))
+		default:
+			result.Duplicates++
+		}
+	}
+	return result, nil
+}
+
+// SaveImportJob creates or replaces an import job record
+func (m *MemoryRepository) SaveImportJob(ctx context.Context, job model.ImportJob) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.jobs[job.ID] = cloneJob(job)
+	return ctx.Err()
+}
+
+// GetImportJob retrieves an import job record by ID
+// It returns ErrNotFound if no job has that ID
+func (m *MemoryRepository) GetImportJob(ctx context.Context, id string) (*model.ImportJob, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	job = cloneJob(job)
+	return &job, ctx.Err()
+}
+
+// ListImportJobs retrieves import job records, newest first
+// Only jobs in one of the given states are returned unless states is empty
+func (m *MemoryRepository) ListImportJobs(ctx context.Context, states []string) ([]model.ImportJob, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	results := make([]model.ImportJob, 0)
+	for _, job := range m.jobs {
+		if len(states) == 0 || slices.Contains(states, job.State) {
+			results = append(results, cloneJob(job))
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].CreatedAt.After(results[j].CreatedAt) })
+	return results, ctx.Err()
+}
+
+// SaveAlertRule creates or replaces an alert rule
+func (m *MemoryRepository) SaveAlertRule(ctx context.Context, rule model.AlertRule) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rules[rule.ID] = cloneRule(rule)
+	return ctx.Err()
+}
+
+// GetAlertRule retrieves an alert rule by ID
+// It returns ErrNotFound if no rule has that ID
+func (m *MemoryRepository) GetAlertRule(ctx context.Context, id string) (*model.AlertRule, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rule, ok := m.rules[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	rule = cloneRule(rule)
+	return &rule, ctx.Err()
+}
+
+// ListAlertRules retrieves every alert rule, oldest first
+func (m *MemoryRepository) ListAlertRules(ctx context.Context) ([]model.AlertRule, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	results := make([]model.AlertRule, 0, len(m.rules))
+	for _, rule := range m.rules {
+		results = append(results, cloneRule(rule))
+	}
+	sortRules(results)
+	return results, ctx.Err()
+}
+
+// DeleteAlertRule removes an alert rule
+// It returns ErrNotFound if no rule has that ID
+func (m *MemoryRepository) DeleteAlertRule(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.rules[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.rules, id)
+	return ctx.Err()
+}
+
+// SaveAlerts creates or replaces the state of alerts
+func (m *MemoryRepository) SaveAlerts(ctx context.Context, alerts []model.Alert) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, alert := range alerts {
+		m.alerts[alert.ID] = alert
+	}
+	return ctx.Err()
+}
+
+// ListAlerts retrieves alert states ordered by ID
+// Only the alerts of ruleID and in one of the given states are returned unless they are empty
+func (m *MemoryRepository) ListAlerts(ctx context.Context, ruleID string, states []string) ([]model.Alert, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	results := make([]model.Alert, 0)
+	for _, alert := range m.alerts {
+		if matchAlert(alert, ruleID, states) {
+			results = append(results, alert)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].ID < results[j].ID })
+	return results, ctx.Err()
+}
+
+// DeleteAlerts removes the state of every alert of a rule
+func (m *MemoryRepository) DeleteAlerts(ctx context.Context, ruleID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, alert := range m.alerts {
+		if alert.RuleID == ruleID {
+			delete(m.alerts, id)
+		}
+	}
+	return ctx.Err()
+}
+
+// SaveAlertEvents appends events to the alert history
+func (m *MemoryRepository) SaveAlertEvents(ctx context.Context, events []model.AlertEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.events = append(m.events, events...)
+	return ctx.Err()
+}
+
+// ListAlertEvents retrieves the alert history matching query, newest first
+func (m *MemoryRepository) ListAlertEvents(ctx context.Context, query model.AlertEventQuery) ([]model.AlertEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	results := make([]model.AlertEvent, 0)
+	for _, event := range m.events {
+		if matchAlertEvent(event, query) {
+			results = append(results, event)
+		}
+	}
+	return limitAlertEvents(results, query.Limit), ctx.Err()
+}
+
+// SaveAnomalyBaselines creates or replaces anomaly baselines
+func (m *MemoryRepository) SaveAnomalyBaselines(ctx context.Context, baselines []model.AnomalyBaseline) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, baseline := range baselines {
+		m.baselines[baseline.ID] = cloneBaseline(baseline)
+	}
+	return ctx.Err()
+}
+
+// ListAnomalyBaselines retrieves every anomaly baseline ordered by ID
+func (m *MemoryRepository) ListAnomalyBaselines(ctx context.Context) ([]model.AnomalyBaseline, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	results := make([]model.AnomalyBaseline, 0, len(m.baselines))
+	for _, baseline := range m.baselines {
+		results = append(results, cloneBaseline(baseline))
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].ID < results[j].ID })
+	return results, ctx.Err()
+}
+
+// SaveAnomalies creates or replaces flagged anomalies
+// A reading flagged again replaces its previous flag
+func (m *MemoryRepository) SaveAnomalies(ctx context.Context, anomalies []model.Anomaly) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, anomaly := range anomalies {
+		m.anomalies[anomaly.ID] = anomaly
+	}
+	return ctx.Err()
+}
+
+// ListAnomalies retrieves the anomalies matching query in timestamp order, then by port
+// and field
+func (m *MemoryRepository) ListAnomalies(ctx context.Context, query model.AnomalyQuery) ([]model.Anomaly, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	results := make([]model.Anomaly, 0)
+	for _, anomaly := range m.anomalies {
+		if matchAnomaly(anomaly, query) {
+			results = append(results, anomaly)
+		}
+	}
+	return limitAnomalies(results, query.Limit), ctx.Err()
+}
+
+// SaveWebhookDelivery creates or replaces a webhook delivery
+func (m *MemoryRepository) SaveWebhookDelivery(ctx context.Context, delivery model.WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deliveries[delivery.ID] = cloneDelivery(delivery)
+	return ctx.Err()
+}
+
+// GetWebhookDelivery retrieves a webhook delivery by ID
+// It returns ErrNotFound if no delivery has that ID
+func (m *MemoryRepository) GetWebhookDelivery(ctx context.Context, id string) (*model.WebhookDelivery, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	delivery, ok := m.deliveries[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	delivery = cloneDelivery(delivery)
+	return &delivery, ctx.Err()
+}
+
+// ListWebhookDeliveries retrieves the webhook deliveries matching query, newest first
+func (m *MemoryRepository) ListWebhookDeliveries(ctx context.Context, query model.WebhookDeliveryQuery) ([]model.WebhookDelivery, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	results := make([]model.WebhookDelivery, 0)
+	for _, delivery := range m.deliveries {
+		if matchDelivery(delivery, query) {
+			results = append(results, cloneDelivery(delivery))
+		}
+	}
+	return limitDeliveries(results, query.Limit), ctx.Err()
+}
+
+// CreateComputedField stores a new computed field
+// It returns ErrExists if a field already has that name
+func (m *MemoryRepository) CreateComputedField(ctx context.Context, field model.ComputedField) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.fields[field.Name]; ok {
+		return ErrExists
+	}
+	m.fields[field.Name] = cloneComputedField(field)
+	return ctx.Err()
+}
+
+// SaveComputedField creates or replaces a computed field
+func (m *MemoryRepository) SaveComputedField(ctx context.Context, field model.ComputedField) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.fields[field.Name] = cloneComputedField(field)
+	return ctx.Err()
+}
+
+// GetComputedField retrieves a computed field by name
+// It returns ErrNotFound if no field has that name
+func (m *MemoryRepository) GetComputedField(ctx context.Context, name string) (*model.ComputedField, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	field, ok := m.fields[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	field = cloneComputedField(field)
+	return &field, ctx.Err()
+}
+
+// ListComputedFields retrieves every computed field in name order
+func (m *MemoryRepository) ListComputedFields(ctx context.Context) ([]model.ComputedField, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	results := make([]model.ComputedField, 0, len(m.fields))
+	for _, field := range m.fields {
+		results = append(results, cloneComputedField(field))
+	}
+	sortComputedFields(results)
+	return results, ctx.Err()
+}
+
+// DeleteComputedField removes a computed field
+// It returns ErrNotFound if no field has that name
+func (m *MemoryRepository) DeleteComputedField(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.fields[name]; !ok {
+		return ErrNotFound
+	}
+	delete(m.fields, name)
+	return ctx.Err()
+}
+
+// EnsureIndexes does nothing; records are always looked up by their key in memory
+func (m *MemoryRepository) EnsureIndexes(ctx context.Context) ([]model.IndexStatus, error) {
+	return m.IndexStatus(ctx)
+}
+
+// IndexStatus reports no indexes since the in-memory storage has none to manage
+func (m *MemoryRepository) IndexStatus(ctx context.Context) ([]model.IndexStatus, error) {
+	return []model.IndexStatus{}, ctx.Err()
+}
+
+// find returns copies of the records matching the object, port and time range of the
+// query, sorted on (timestamp, _id) in the requested direction
+func (m *MemoryRepository) find(query model.SensorDataQuery) ([]model.SensorData, error) {
+	filter, err := newRecordFilter(query)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	matches := make([]model.SensorData, 0)
+	for _, record := range m.records {
+		if filter.matches(&record) {
+			matches = append(matches, record)
+		}
+	}
+	m.mu.RUnlock()
+
+	sortRecords(matches, query.SortDesc)
+	return matches, nil
+}
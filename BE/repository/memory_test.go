@@ -0,0 +1,14 @@
+package repository_test
+
+import (
+	"testing"
+
+	"gomongoviz/repository"
+	"gomongoviz/repository/repotest"
+)
+
+func TestMemoryRepository(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repository.Repository {
+		return repository.NewMemoryRepository()
+	})
+}
@@ -0,0 +1,70 @@
+package repository_test
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"gomongoviz/config"
+	"gomongoviz/repository"
+	"gomongoviz/repository/repotest"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoURIEnv names the variable holding the MongoDB server the tests may use
+// The tests are skipped when it is not set
+const mongoURIEnv = "GOMONGOVIZ_TEST_MONGO_URI"
+
+// TestMongoRepository checks the MongoDB repository in temporary collections of the
+// configured database, which are dropped after every check
+func TestMongoRepository(t *testing.T) {
+	uri := os.Getenv(mongoURIEnv)
+	if uri == "" {
+		t.Skipf("%s is not set", mongoURIEnv)
+	}
+
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+	if err := client.Ping(ctx, nil); err != nil {
+		t.Fatalf("connecting to %s: %v", mongoURIEnv, err)
+	}
+
+	runs := 0
+	repotest.Run(t, func(t *testing.T) repository.Repository {
+		runs++
+		cfg := config.Default().Mongo
+		suffix := "_test_" + strconv.FormatInt(time.Now().UnixNano(), 36) + "_" + strconv.Itoa(runs)
+		collections := []*string{
+			&cfg.Collection, &cfg.JobsCollection,
+			&cfg.RulesCollection, &cfg.AlertsCollection, &cfg.EventsCollection,
+			&cfg.DeliveriesCollection, &cfg.FieldsCollection,
+			&cfg.BaselinesCollection, &cfg.AnomaliesCollection,
+		}
+		for _, name := range collections {
+			*name += suffix
+		}
+		db := client.Database(cfg.Database)
+		t.Cleanup(func() {
+			for _, name := range collections {
+				db.Collection(*name).Drop(context.Background())
+			}
+		})
+
+		if err := repository.EnsureSensorCollection(ctx, client, cfg); err != nil {
+			t.Fatal(err)
+		}
+		repo := repository.NewRepositoryDefault(client, cfg)
+		if _, err := repo.EnsureIndexes(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return repo
+	})
+}
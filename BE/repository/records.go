@@ -0,0 +1,450 @@
+package repository
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"gomongoviz/model"
+)
+
+// The helpers below give the repositories that query records in process, rather than
+// through MongoDB, the same filtering, sorting, paging and aggregation semantics
+
+// recordFilter selects records by object, optional port and inclusive time range
+type recordFilter struct {
+	objectID float64
+	portNum  float64 // NaN when any port matches
+	from     time.Time
+	to       time.Time
+}
+
+// newRecordFilter parses the object and port of a query like RepositoryDefault does
+func newRecordFilter(query model.SensorDataQuery) (recordFilter, error) {
+	filter := recordFilter{portNum: math.NaN(), from: query.From, to: query.To}
+	var err error
+	if filter.objectID, err = strconv.ParseFloat(query.ObjectID, 64); err != nil {
+		return recordFilter{}, err
+	}
+	if query.PortNum != "" {
+		if filter.portNum, err = strconv.ParseFloat(query.PortNum, 64); err != nil {
+			return recordFilter{}, err
+		}
+	}
+	return filter, nil
+}
+
+// hasPort reports whether the filter is restricted to one port
+func (f recordFilter) hasPort() bool {
+	return !math.IsNaN(f.portNum)
+}
+
+// matches reports whether a record passes the filter
+func (f recordFilter) matches(record *model.SensorData) bool {
+	switch {
+	case record.ObjectID != f.objectID:
+	case f.hasPort() && record.PortNum != f.portNum:
+	case !f.from.IsZero() && record.Timestamp.Before(f.from):
+	case !f.to.IsZero() && record.Timestamp.After(f.to):
+	default:
+		return true
+	}
+	return false
+}
+
+// sortRecords sorts records on (timestamp, _id) in the requested direction
+func sortRecords(records []model.SensorData, desc bool) {
+	sort.Slice(records, func(i, j int) bool {
+		if desc {
+			return lessByTimeAndID(&records[j], &records[i])
+		}
+		return lessByTimeAndID(&records[i], &records[j])
+	})
+}
+
+// pageRecords returns the page of sorted matches selected by the cursor and limit of
+// the query, with the total number of matches
+func pageRecords(matches []model.SensorData, query model.SensorDataQuery) *model.SensorDataRes {
+	total := int64(len(matches))
+
+	// Continue after the cursor position when paging
+	if query.After != nil {
+		start := sort.Search(len(matches), func(i int) bool {
+			return afterCursor(&matches[i], query.After, query.SortDesc)
+		})
+		matches = matches[start:]
+	}
+
+	// Keep one extra record to find out whether another page exists
+	if query.Limit > 0 && int64(len(matches)) > query.Limit+1 {
+		matches = matches[:query.Limit+1]
+	}
+	return newPage(matches, total, query)
+}
+
+// AggregateRecords computes time-bucketed aggregates of the matches per port
+// Buckets are aligned the way $dateTrunc aligns them
+func AggregateRecords(matches []model.SensorData, query model.AggregateQuery) ([]model.AggregateBucket, error) {
+	aggregator := NewAggregator(query)
+	for i := range matches {
+		if err := aggregator.Add(&matches[i]); err != nil {
+			return nil, err
+		}
+	}
+	return aggregator.Buckets(), nil
+}
+
+// Aggregator computes time-bucketed aggregates per port of records added one at a time,
+// so the service can also aggregate fields that are computed rather than stored while it
+// streams them
+// Each bucket keeps running sums, so memory grows with the number of buckets rather than
+// records; the values themselves are only kept when a percentile is requested
+type Aggregator struct {
+	query       model.AggregateQuery
+	percentiles bool // Whether a pNN function is requested
+	buckets     map[bucketKey]*bucketAccumulator
+}
+
+// bucketKey identifies the bucket of one port
+type bucketKey struct {
+	start   int64 // Start of the bucket in Unix milliseconds
+	portNum float64
+}
+
+// bucketAccumulator holds the running aggregates of one bucket, a field accumulator for
+// each field of the query in order
+type bucketAccumulator struct {
+	count  int64
+	fields []fieldAccumulator
+}
+
+// fieldAccumulator holds the running aggregates of one field of a bucket
+// The mean and squared deviations are updated with Welford's method, which keeps the
+// standard deviation accurate for values with a large mean and a small spread
+type fieldAccumulator struct {
+	sum    float64
+	min    float64
+	max    float64
+	mean   float64
+	m2     float64   // Sum of the squared deviations from the mean
+	values []float64 // Every value, only when a percentile is requested
+}
+
+// NewAggregator returns an empty aggregator of the fields and functions of query
+func NewAggregator(query model.AggregateQuery) *Aggregator {
+	a := &Aggregator{query: query, buckets: make(map[bucketKey]*bucketAccumulator)}
+	for _, function := range query.Functions {
+		if _, ok := model.PercentileOf(function); ok {
+			a.percentiles = true
+		}
+	}
+	return a
+}
+
+// Add adds a record to the bucket of its port and time
+func (a *Aggregator) Add(record *model.SensorData) error {
+	start, err := truncateTime(record.Timestamp, a.query.Unit, a.query.BinSize)
+	if err != nil {
+		return err
+	}
+	key := bucketKey{start: start.UnixMilli(), portNum: record.PortNum}
+	bucket, ok := a.buckets[key]
+	if !ok {
+		bucket = &bucketAccumulator{fields: make([]fieldAccumulator, len(a.query.Fields))}
+		a.buckets[key] = bucket
+	}
+
+	bucket.count++
+	for i, field := range a.query.Fields {
+		value, _ := record.NumericValue(field)
+		f := &bucket.fields[i]
+		if bucket.count == 1 {
+			f.min, f.max = value, value
+		} else {
+			f.min, f.max = min(f.min, value), max(f.max, value)
+		}
+		f.sum += value
+		delta := value - f.mean
+		f.mean += delta / float64(bucket.count)
+		f.m2 += delta * (value - f.mean)
+		if a.percentiles {
+			f.values = append(f.values, value)
+		}
+	}
+	return nil
+}
+
+// Buckets returns the aggregates of every bucket in time order, then by port
+func (a *Aggregator) Buckets() []model.AggregateBucket {
+	results := make([]model.AggregateBucket, 0, len(a.buckets))
+	for key, accumulator := range a.buckets {
+		bucket := model.AggregateBucket{
+			Timestamp: time.UnixMilli(key.start).UTC(),
+			PortNum:   key.portNum,
+			Count:     accumulator.count,
+			Values:    make(map[string]map[string]float64, len(a.query.Fields)),
+		}
+		for i, field := range a.query.Fields {
+			bucket.Values[field] = make(map[string]float64, len(a.query.Functions))
+			for _, function := range a.query.Functions {
+				bucket.Values[field][function] = accumulator.fields[i].value(function, accumulator.count)
+			}
+		}
+		results = append(results, bucket)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if !results[i].Timestamp.Equal(results[j].Timestamp) {
+			return results[i].Timestamp.Before(results[j].Timestamp)
+		}
+		return results[i].PortNum < results[j].PortNum
+	})
+	return results
+}
+
+// cloneJob copies the error lists of a job so stored records never share memory with callers
+func cloneJob(job model.ImportJob) model.ImportJob {
+	job.Errors = slices.Clone(job.Errors)
+	job.RowErrors = slices.Clone(job.RowErrors)
+	return job
+}
+
+// cloneRule copies a rule so stored rules do not share their optional filters with callers
+func cloneRule(rule model.AlertRule) model.AlertRule {
+	if rule.ObjectID != nil {
+		objectID := *rule.ObjectID
+		rule.ObjectID = &objectID
+	}
+	if rule.PortNum != nil {
+		portNum := *rule.PortNum
+		rule.PortNum = &portNum
+	}
+	return rule
+}
+
+// sortRules orders alert rules oldest first like the MongoDB listing
+func sortRules(rules []model.AlertRule) {
+	sort.Slice(rules, func(i, j int) bool {
+		if !rules[i].CreatedAt.Equal(rules[j].CreatedAt) {
+			return rules[i].CreatedAt.Before(rules[j].CreatedAt)
+		}
+		return rules[i].ID < rules[j].ID
+	})
+}
+
+// matchAlert reports whether an alert belongs to ruleID and is in one of states,
+// either filter being skipped when empty
+func matchAlert(alert model.Alert, ruleID string, states []string) bool {
+	return (ruleID == "" || alert.RuleID == ruleID) && (len(states) == 0 || slices.Contains(states, alert.State))
+}
+
+// matchAlertEvent reports whether an event passes the filters of query
+func matchAlertEvent(event model.AlertEvent, query model.AlertEventQuery) bool {
+	return (query.RuleID == "" || event.RuleID == query.RuleID) && (query.ObjectID == nil || event.ObjectID == *query.ObjectID)
+}
+
+// limitAlertEvents sorts events newest first like the MongoDB listing and keeps the
+// first limit of them, all of them if limit is zero
+func limitAlertEvents(events []model.AlertEvent, limit int64) []model.AlertEvent {
+	sort.SliceStable(events, func(i, j int) bool {
+		if !events[i].Timestamp.Equal(events[j].Timestamp) {
+			return events[i].Timestamp.After(events[j].Timestamp)
+		}
+		return events[i].CreatedAt.After(events[j].CreatedAt)
+	})
+	if limit > 0 && int64(len(events)) > limit {
+		events = events[:limit]
+	}
+	return events
+}
+
+// cloneBaseline copies an anomaly baseline so stored baselines do not share their window
+// with callers
+func cloneBaseline(baseline model.AnomalyBaseline) model.AnomalyBaseline {
+	baseline.Values = slices.Clone(baseline.Values)
+	return baseline
+}
+
+// matchAnomaly reports whether an anomaly passes the filters of query
+func matchAnomaly(anomaly model.Anomaly, query model.AnomalyQuery) bool {
+	return anomaly.ObjectID == query.ObjectID &&
+		(query.PortNum == nil || anomaly.PortNum == *query.PortNum) &&
+		(len(query.Fields) == 0 || slices.Contains(query.Fields, anomaly.Field)) &&
+		(query.From.IsZero() || !anomaly.Timestamp.Before(query.From)) &&
+		(query.To.IsZero() || !anomaly.Timestamp.After(query.To))
+}
+
+// limitAnomalies sorts anomalies by timestamp, port and field like the MongoDB listing
+// and keeps the first limit of them, all of them if limit is zero
+func limitAnomalies(anomalies []model.Anomaly, limit int64) []model.Anomaly {
+	sort.Slice(anomalies, func(i, j int) bool {
+		a, b := anomalies[i], anomalies[j]
+		if !a.Timestamp.Equal(b.Timestamp) {
+			return a.Timestamp.Before(b.Timestamp)
+		}
+		if a.PortNum != b.PortNum {
+			return a.PortNum < b.PortNum
+		}
+		return a.Field < b.Field
+	})
+	if limit > 0 && int64(len(anomalies)) > limit {
+		anomalies = anomalies[:limit]
+	}
+	return anomalies
+}
+
+// cloneDelivery copies a delivery so stored deliveries do not share their payload and
+// attempts with callers
+func cloneDelivery(delivery model.WebhookDelivery) model.WebhookDelivery {
+	delivery.Payload = slices.Clone(delivery.Payload)
+	delivery.Attempts = slices.Clone(delivery.Attempts)
+	return delivery
+}
+
+// matchDelivery reports whether a webhook delivery passes the filters of query
+func matchDelivery(delivery model.WebhookDelivery, query model.WebhookDeliveryQuery) bool {
+	return (len(query.States) == 0 || slices.Contains(query.States, delivery.State)) &&
+		(query.Endpoint == "" || delivery.Endpoint == query.Endpoint) &&
+		(query.Event == "" || delivery.Event == query.Event)
+}
+
+// limitDeliveries sorts deliveries newest first like the MongoDB listing and keeps the
+// first limit of them, all of them if limit is zero
+func limitDeliveries(deliveries []model.WebhookDelivery, limit int64) []model.WebhookDelivery {
+	sort.Slice(deliveries, func(i, j int) bool {
+		if !deliveries[i].CreatedAt.Equal(deliveries[j].CreatedAt) {
+			return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt)
+		}
+		return deliveries[i].ID > deliveries[j].ID
+	})
+	if limit > 0 && int64(len(deliveries)) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries
+}
+
+// cloneComputedField copies a computed field so stored fields do not share their list of
+// inputs with callers
+func cloneComputedField(field model.ComputedField) model.ComputedField {
+	field.Fields = slices.Clone(field.Fields)
+	return field
+}
+
+// sortComputedFields orders computed fields by name like the MongoDB listing
+func sortComputedFields(fields []model.ComputedField) {
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+}
+
+// lessByTimeAndID orders records on (timestamp, _id) like the MongoDB sort
+// Hex ObjectIDs of equal length sort the same way as the IDs themselves
+func lessByTimeAndID(a *model.SensorData, b *model.SensorData) bool {
+	if !a.Timestamp.Equal(b.Timestamp) {
+		return a.Timestamp.Before(b.Timestamp)
+	}
+	return a.ID < b.ID
+}
+
+// afterCursor reports whether a record comes after the cursor position in the sort order
+func afterCursor(record *model.SensorData, after *model.PageCursor, desc bool) bool {
+	position := model.SensorData{Timestamp: after.Timestamp, ID: after.ID}
+	if desc {
+		return lessByTimeAndID(record, &position)
+	}
+	return lessByTimeAndID(&position, record)
+}
+
+// normalizeRecord stores times the way MongoDB does, in UTC with millisecond precision,
+// so duplicate detection and range filters behave the same outside MongoDB
+func normalizeRecord(data model.SensorData) model.SensorData {
+	data.Timestamp = data.Timestamp.UTC().Truncate(time.Millisecond)
+	data.CreatedAt = data.CreatedAt.UTC().Truncate(time.Millisecond)
+	data.Meta = nil
+	return data
+}
+
+// dateTruncReference is the origin $dateTrunc aligns bins of more than one unit to
+var dateTruncReference = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// truncateTime returns the start of the bucket holding t, with the same alignment as
+// $dateTrunc: bins count from 2000-01-01, weeks start on Sunday
+func truncateTime(t time.Time, unit string, binSize int) (time.Time, error) {
+	if binSize <= 0 {
+		binSize = 1
+	}
+	t = t.UTC()
+
+	var step time.Duration
+	reference := dateTruncReference
+	switch unit {
+	case "second":
+		step = time.Second
+	case "minute":
+		step = time.Minute
+	case "hour":
+		step = time.Hour
+	case "day":
+		step = 24 * time.Hour
+	case "week":
+		step = 7 * 24 * time.Hour
+		reference = reference.AddDate(0, 0, 1) // 2000-01-02 is the first Sunday
+	case "month", "quarter", "year":
+		months := map[string]int{"month": 1, "quarter": 3, "year": 12}[unit] * binSize
+		elapsed := (t.Year()-reference.Year())*12 + int(t.Month()) - 1
+		return reference.AddDate(0, floorDiv(elapsed, months)*months, 0), nil
+	default:
+		return time.Time{}, fmt.Errorf("unsupported bucket unit %q", unit)
+	}
+
+	bin := int64(step) * int64(binSize)
+	elapsed := int64(t.Sub(reference))
+	return reference.Add(time.Duration(floorDiv64(elapsed, bin) * bin)), nil
+}
+
+// floorDiv divides rounding towards negative infinity, so times before the reference
+// fall into the bin that starts before them
+func floorDiv(a int, b int) int {
+	return int(floorDiv64(int64(a), int64(b)))
+}
+
+// floorDiv64 is floorDiv for int64 values
+func floorDiv64(a int64, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// value applies one aggregate function to the count values of a field
+// Standard deviations are population deviations like $stdDevPop, and percentiles use
+// the nearest-rank method
+func (f *fieldAccumulator) value(function string, count int64) float64 {
+	if count == 0 {
+		return 0
+	}
+	switch function {
+	case "count":
+		return float64(count)
+	case "sum":
+		return f.sum
+	case "avg":
+		return f.sum / float64(count)
+	case "stddev":
+		return math.Sqrt(max(f.m2, 0) / float64(count))
+	case "min":
+		return f.min
+	case "max":
+		return f.max
+	}
+
+	p, ok := model.PercentileOf(function)
+	if !ok || len(f.values) == 0 {
+		return 0
+	}
+	slices.Sort(f.values)
+	rank := int(math.Ceil(p*float64(len(f.values)))) - 1
+	return f.values[max(0, min(rank, len(f.values)-1))]
+}
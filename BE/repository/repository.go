@@ -3,12 +3,17 @@ package repository
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"gomongoviz/config"
 	"gomongoviz/model"
 	"log"
 	"strconv"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // ConnectedMongoClient is a global variable to hold the MongoDB client connection
@@ -17,28 +22,97 @@ var ConnectedMongoClient *mongo.Client
 // RepositoryDefault is the concrete implementation of the Repository interface
 // It handles database operations for the application
 type RepositoryDefault struct {
-	Client *mongo.Client // MongoDB client connection
+	Client               *mongo.Client // MongoDB client connection
+	Database             string        // Name of the database holding the sensor data
+	Collection           string        // Name of the collection holding the sensor data
+	JobsCollection       string        // Name of the collection holding import job records
+	RulesCollection      string        // Name of the collection holding alert rules
+	AlertsCollection     string        // Name of the collection holding alert states
+	EventsCollection     string        // Name of the collection holding alert events
+	DeliveriesCollection string        // Name of the collection holding webhook deliveries
+	FieldsCollection     string        // Name of the collection holding computed fields
+	BaselinesCollection  string        // Name of the collection holding anomaly baselines
+	AnomaliesCollection  string        // Name of the collection holding flagged anomalies
+	TimeSeries           bool          // Whether the sensor data lives in a time-series collection
+
+	QueryTimeout     time.Duration // Deadline of finds, listings and lookups, none if zero
+	AggregateTimeout time.Duration // Deadline of aggregation pipelines, none if zero
+	WriteTimeout     time.Duration // Deadline of each write, none if zero
+	ExportTimeout    time.Duration // Deadline of a whole streaming export, none if zero
 }
 
+// ErrNotFound is returned when a requested record does not exist
+var ErrNotFound = errors.New("not found")
+
+// ErrExists is returned when creating a record under a key that is already in use
+var ErrExists = errors.New("already exists")
+
 // Repository defines the interface for data access operations
 // It abstracts the database layer, making it easier to test and replace implementations
+// Every method stops when ctx is cancelled or its deadline passes
 type Repository interface {
-	GetUniqueObjectIDs() ([]model.ObjectInfo, error)
-	GetPorts(objectID int) ([]model.PortInfo, error)
-	GetDataByObjectID(objectID string, portNum string) (*model.SensorDataRes, error)
-	SaveSensorData(data []model.SensorData) error
+	GetUniqueObjectIDs(ctx context.Context) ([]model.ObjectInfo, error)
+	GetPorts(ctx context.Context, objectID int) ([]model.PortInfo, error)
+	GetDataByObjectID(ctx context.Context, query model.SensorDataQuery) (*model.SensorDataRes, error)
+	SaveSensorData(ctx context.Context, data []model.SensorData, onDuplicate string) (model.SaveResult, error)
+	AggregateData(ctx context.Context, query model.AggregateQuery) ([]model.AggregateBucket, error)
+	StreamData(ctx context.Context, query model.SensorDataQuery, fn func(*model.SensorData) error) error
+	WatchData(ctx context.Context, query model.SensorDataQuery) (DataWatch, error)
+
+	// Import job records
+	SaveImportJob(ctx context.Context, job model.ImportJob) error
+	GetImportJob(ctx context.Context, id string) (*model.ImportJob, error)
+	ListImportJobs(ctx context.Context, states []string) ([]model.ImportJob, error)
+
+	// Alert rules, the state of their alerts and the history of alert transitions
+	SaveAlertRule(ctx context.Context, rule model.AlertRule) error
+	GetAlertRule(ctx context.Context, id string) (*model.AlertRule, error)
+	ListAlertRules(ctx context.Context) ([]model.AlertRule, error)
+	DeleteAlertRule(ctx context.Context, id string) error
+	SaveAlerts(ctx context.Context, alerts []model.Alert) error
+	ListAlerts(ctx context.Context, ruleID string, states []string) ([]model.Alert, error)
+	DeleteAlerts(ctx context.Context, ruleID string) error
+	SaveAlertEvents(ctx context.Context, events []model.AlertEvent) error
+	ListAlertEvents(ctx context.Context, query model.AlertEventQuery) ([]model.AlertEvent, error)
+
+	// Anomaly baselines of every port and field, and the readings they flagged
+	SaveAnomalyBaselines(ctx context.Context, baselines []model.AnomalyBaseline) error
+	ListAnomalyBaselines(ctx context.Context) ([]model.AnomalyBaseline, error)
+	SaveAnomalies(ctx context.Context, anomalies []model.Anomaly) error
+	ListAnomalies(ctx context.Context, query model.AnomalyQuery) ([]model.Anomaly, error)
+
+	// Webhook deliveries, pending, delivered and dead
+	SaveWebhookDelivery(ctx context.Context, delivery model.WebhookDelivery) error
+	GetWebhookDelivery(ctx context.Context, id string) (*model.WebhookDelivery, error)
+	ListWebhookDeliveries(ctx context.Context, query model.WebhookDeliveryQuery) ([]model.WebhookDelivery, error)
+
+	// User-defined computed fields
+	CreateComputedField(ctx context.Context, field model.ComputedField) error
+	SaveComputedField(ctx context.Context, field model.ComputedField) error
+	GetComputedField(ctx context.Context, name string) (*model.ComputedField, error)
+	ListComputedFields(ctx context.Context) ([]model.ComputedField, error)
+	DeleteComputedField(ctx context.Context, name string) error
+
+	// Index management
+	EnsureIndexes(ctx context.Context) ([]model.IndexStatus, error)
+	IndexStatus(ctx context.Context) ([]model.IndexStatus, error)
 }
 
 // GetUniqueObjectIDs retrieves a list of all unique object IDs in the database
 // It uses MongoDB aggregation to group and sort the results
-func (r RepositoryDefault) GetUniqueObjectIDs() ([]model.ObjectInfo, error) {
-	collection := r.Client.Database("gomongoviz").Collection("sensor_data")
+func (r RepositoryDefault) GetUniqueObjectIDs(ctx context.Context) ([]model.ObjectInfo, error) {
+	collection := r.collection()
+	ctx, cancel := withTimeout(ctx, r.QueryTimeout)
+	defer cancel()
 
 	// Create an aggregation pipeline to get distinct object_ids
+	// Sorting first lets MongoDB read the distinct values from the object_id index
+	objectField := r.metaField("object_id")
 	pipeline := []bson.M{
+		{"$sort": bson.M{objectField: 1}},
 		{"$group": bson.M{
-			"_id":       "$object_id",
-			"object_id": bson.M{"$first": "$object_id"},
+			"_id":       "$" + objectField,
+			"object_id": bson.M{"$first": "$" + objectField},
 		}},
 		{"$project": bson.M{
 			"_id": 0,
@@ -57,15 +131,15 @@ func (r RepositoryDefault) GetUniqueObjectIDs() ([]model.ObjectInfo, error) {
 	}
 
 	// Execute the aggregation pipeline
-	cursor, err := collection.Aggregate(context.TODO(), pipeline)
+	cursor, err := collection.Aggregate(ctx, pipeline)
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(context.TODO())
+	defer cursor.Close(context.WithoutCancel(ctx))
 
 	// Parse results into model objects
 	var results []model.ObjectInfo
-	if err = cursor.All(context.TODO(), &results); err != nil {
+	if err = cursor.All(ctx, &results); err != nil {
 		return nil, err
 	}
 
@@ -80,15 +154,20 @@ func (r RepositoryDefault) GetUniqueObjectIDs() ([]model.ObjectInfo, error) {
 
 // GetPorts retrieves a list of all ports for a specific object ID
 // It uses MongoDB aggregation to find distinct port numbers for the given object
-func (r RepositoryDefault) GetPorts(objectID int) ([]model.PortInfo, error) {
-	collection := r.Client.Database("gomongoviz").Collection("sensor_data")
+func (r RepositoryDefault) GetPorts(ctx context.Context, objectID int) ([]model.PortInfo, error) {
+	collection := r.collection()
+	ctx, cancel := withTimeout(ctx, r.QueryTimeout)
+	defer cancel()
 
 	// Create an aggregation pipeline to get distinct port numbers for the given object ID
+	// Sorting on the index prefix lets MongoDB read the distinct ports from the index
+	objectField, portField := r.metaField("object_id"), r.metaField("port_num")
 	pipeline := []bson.M{
-		{"$match": bson.M{"object_id": float64(objectID)}},
+		{"$match": bson.M{objectField: float64(objectID)}},
+		{"$sort": bson.D{{Key: objectField, Value: 1}, {Key: portField, Value: 1}}},
 		{"$group": bson.M{
-			"_id":      "$port_num",
-			"port_num": bson.M{"$first": "$port_num"},
+			"_id":      "$" + portField,
+			"port_num": bson.M{"$first": "$" + portField},
 		}},
 		{"$project": bson.M{
 			"_id": 0,
@@ -104,15 +183,15 @@ func (r RepositoryDefault) GetPorts(objectID int) ([]model.PortInfo, error) {
 	}
 
 	// Execute the aggregation pipeline
-	cursor, err := collection.Aggregate(context.TODO(), pipeline)
+	cursor, err := collection.Aggregate(ctx, pipeline)
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(context.TODO())
+	defer cursor.Close(context.WithoutCancel(ctx))
 
 	// Parse results into model objects
 	var results []model.PortInfo
-	if err = cursor.All(context.TODO(), &results); err != nil {
+	if err = cursor.All(ctx, &results); err != nil {
 		return nil, err
 	}
 
@@ -125,91 +204,511 @@ func (r RepositoryDefault) GetPorts(objectID int) ([]model.PortInfo, error) {
 	return results, nil
 }
 
-// GetDataByObjectID retrieves sensor data for a specific object ID and optionally filtered by port number
+// GetDataByObjectID retrieves sensor data for a specific object ID, optionally filtered
+// by port number and time range
 // It returns both the matching data and the total count of matching documents
-func (r RepositoryDefault) GetDataByObjectID(objectID string, portNum string) (*model.SensorDataRes, error) {
-	collection := r.Client.Database("gomongoviz").Collection("sensor_data")
+func (r RepositoryDefault) GetDataByObjectID(ctx context.Context, query model.SensorDataQuery) (*model.SensorDataRes, error) {
+	collection := r.collection()
+	ctx, cancel := withTimeout(ctx, r.QueryTimeout)
+	defer cancel()
 
-	// Convert objectID string to float64
-	objectIDFloat, err := strconv.ParseFloat(objectID, 64)
+	filter, err := r.buildDataFilter(query)
 	if err != nil {
 		return nil, err
 	}
 
-	// Build the filter based on objectID and optional portNum
-	filter := bson.M{"object_id": objectIDFloat}
-
-	if portNum != "" {
-		portNumFloat, err := strconv.ParseFloat(portNum, 64)
-		if err != nil {
-			return nil, err
-		}
-		filter["port_num"] = portNumFloat
-	}
-
 	// Log the filter being used
 	log.Printf("MongoDB filter: %+v", filter)
 
 	// First, get count of matching documents
-	count, err := collection.CountDocuments(context.TODO(), filter)
+	count, err := collection.CountDocuments(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
 	log.Printf("Found %d matching documents", count)
 
+	// Continue after the cursor position when paging
+	if query.After != nil {
+		filter["$or"] = cursorCondition(query.After, query.SortDesc)
+	}
+
+	findOptions := buildFindOptions(query)
+
+	// Fetch one extra document to find out whether another page exists
+	if query.Limit > 0 {
+		findOptions.SetLimit(query.Limit + 1)
+	}
+
 	// Execute the query
-	cursor, err := collection.Find(context.TODO(), filter)
+	cursor, err := collection.Find(ctx, filter, findOptions)
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(context.TODO())
+	defer cursor.Close(context.WithoutCancel(ctx))
 
 	// Parse results into model objects
 	results := make([]model.SensorData, 0)
-	if err = cursor.All(context.TODO(), &results); err != nil {
+	if err = cursor.All(ctx, &results); err != nil {
 		return nil, err
 	}
 
 	// Log the number of results
 	log.Printf("Retrieved %d documents", len(results))
 
-	return &model.SensorDataRes{
+	return newPage(results, count, query), nil
+}
+
+// StreamData calls fn for every document matching the query, in timestamp order
+// Documents are decoded one at a time from the cursor so memory use stays flat
+// Iteration stops at the first error returned by fn
+func (r RepositoryDefault) StreamData(ctx context.Context, query model.SensorDataQuery, fn func(*model.SensorData) error) error {
+	collection := r.collection()
+	ctx, cancel := withTimeout(ctx, r.ExportTimeout)
+	defer cancel()
+
+	filter, err := r.buildDataFilter(query)
+	if err != nil {
+		return err
+	}
+
+	// Log the filter being used
+	log.Printf("MongoDB stream filter: %+v", filter)
+
+	cursor, err := collection.Find(ctx, filter, buildFindOptions(query))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.WithoutCancel(ctx))
+
+	for cursor.Next(ctx) {
+		var data model.SensorData
+		if err := cursor.Decode(&data); err != nil {
+			return err
+		}
+		if err := fn(&data); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// buildFindOptions sets the sort order and projection shared by all find queries
+func buildFindOptions(query model.SensorDataQuery) *options.FindOptions {
+	// Sort on (timestamp, _id) so pages are stable even when timestamps repeat
+	direction := 1
+	if query.SortDesc {
+		direction = -1
+	}
+	findOptions := options.Find().SetSort(bson.D{{Key: "timestamp", Value: direction}, {Key: "_id", Value: direction}})
+
+	// Only load the requested fields from the database when a projection is set
+	if projection := buildProjection(query.Projection); projection != nil {
+		findOptions.SetProjection(projection)
+	}
+	return findOptions
+}
+
+// buildProjection converts the requested fields into a MongoDB projection document
+// The key fields are always included, nil means all fields are returned
+func buildProjection(fields []string) bson.M {
+	if len(fields) == 0 {
+		return nil
+	}
+	projection := bson.M{}
+	for _, field := range append(append([]string{}, model.KeyFields...), fields...) {
+		// The model calls the document ID "id" but MongoDB stores it as "_id"
+		if field == "id" {
+			field = "_id"
+		}
+		projection[field] = 1
+	}
+	return projection
+}
+
+// newPage trims results to the requested limit and sets the cursor for the next page
+func newPage(results []model.SensorData, total int64, query model.SensorDataQuery) *model.SensorDataRes {
+	res := &model.SensorDataRes{
 		SensorData: results,
-		Total:      count,
-	}, nil
+		Total:      total,
+		Projection: query.Projection,
+	}
+	if query.Limit > 0 && int64(len(results)) > query.Limit {
+		res.SensorData = results[:query.Limit]
+		last := res.SensorData[len(res.SensorData)-1]
+		res.NextCursor = model.EncodePageCursor(model.PageCursor{
+			Timestamp: last.Timestamp,
+			ID:        last.ID,
+			Desc:      query.SortDesc,
+		})
+	}
+	return res
+}
+
+// cursorCondition builds the $or clause selecting documents after the cursor position
+// in (timestamp, _id) order
+func cursorCondition(after *model.PageCursor, desc bool) bson.A {
+	op := "$gt"
+	if desc {
+		op = "$lt"
+	}
+
+	// IDs generated by MongoDB are ObjectIDs, encoded as hex in the model
+	var id interface{} = after.ID
+	if oid, err := primitive.ObjectIDFromHex(after.ID); err == nil {
+		id = oid
+	}
+
+	return bson.A{
+		bson.M{"timestamp": bson.M{op: after.Timestamp}},
+		bson.M{"timestamp": after.Timestamp, "_id": bson.M{op: id}},
+	}
+}
+
+// buildDataFilter converts a SensorDataQuery into a MongoDB filter document
+// The time range is pushed down as a $gte/$lte condition on timestamp
+func (r RepositoryDefault) buildDataFilter(query model.SensorDataQuery) (bson.M, error) {
+	// Convert objectID string to float64
+	objectIDFloat, err := strconv.ParseFloat(query.ObjectID, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	// Build the filter based on objectID and optional portNum
+	filter := bson.M{r.metaField("object_id"): objectIDFloat}
+
+	if query.PortNum != "" {
+		portNumFloat, err := strconv.ParseFloat(query.PortNum, 64)
+		if err != nil {
+			return nil, err
+		}
+		filter[r.metaField("port_num")] = portNumFloat
+	}
+
+	// Restrict the timestamp range when either bound is set
+	timeRange := bson.M{}
+	if !query.From.IsZero() {
+		timeRange["$gte"] = query.From
+	}
+	if !query.To.IsZero() {
+		timeRange["$lte"] = query.To
+	}
+	if len(timeRange) > 0 {
+		filter["timestamp"] = timeRange
+	}
+
+	return filter, nil
+}
+
+// AggregateData computes time-bucketed aggregates of the requested fields per port
+// It uses a MongoDB aggregation pipeline with $dateTrunc to bucket the timestamps
+// Percentile functions rely on the $percentile accumulator available since MongoDB 7.0
+func (r RepositoryDefault) AggregateData(ctx context.Context, query model.AggregateQuery) ([]model.AggregateBucket, error) {
+	collection := r.collection()
+	ctx, cancel := withTimeout(ctx, r.AggregateTimeout)
+	defer cancel()
+
+	filter, err := r.buildDataFilter(query.SensorDataQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	// Group by port and truncated timestamp, one accumulator per field and function
+	group := bson.M{
+		"_id": bson.M{
+			"port_num": "$port_num",
+			"bucket": bson.M{"$dateTrunc": bson.M{
+				"date":    "$timestamp",
+				"unit":    query.Unit,
+				"binSize": query.BinSize,
+			}},
+		},
+		"count": bson.M{"$sum": 1},
+	}
+	for _, field := range query.Fields {
+		for _, function := range query.Functions {
+			group[aggregateKey(field, function)] = accumulator(field, function)
+		}
+	}
+
+	// Create an aggregation pipeline to bucket and aggregate the matching documents
+	pipeline := []bson.M{
+		{"$match": filter},
+		{"$group": group},
+		{"$sort": bson.D{{Key: "_id.bucket", Value: 1}, {Key: "_id.port_num", Value: 1}}},
+	}
+
+	// Print the pipeline for debugging
+	pipelineJSON, err := json.MarshalIndent(pipeline, "", "  ")
+	if err != nil {
+		log.Printf("Error marshaling pipeline: %v", err)
+	} else {
+		log.Printf("MongoDB Aggregation Pipeline:\n%s", string(pipelineJSON))
+	}
+
+	// Execute the aggregation pipeline
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.WithoutCancel(ctx))
+
+	// Decode the raw group documents before mapping them to buckets
+	var rows []bson.M
+	if err = cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	results := make([]model.AggregateBucket, 0, len(rows))
+	for _, row := range rows {
+		id, _ := row["_id"].(bson.M)
+		bucket := model.AggregateBucket{
+			PortNum: toFloat(id["port_num"]),
+			Count:   int64(toFloat(row["count"])),
+			Values:  make(map[string]map[string]float64, len(query.Fields)),
+		}
+		if ts, ok := id["bucket"].(primitive.DateTime); ok {
+			bucket.Timestamp = ts.Time().UTC()
+		}
+		for _, field := range query.Fields {
+			bucket.Values[field] = make(map[string]float64, len(query.Functions))
+			for _, function := range query.Functions {
+				value := row[aggregateKey(field, function)]
+				// $percentile returns an array with one value per requested percentile
+				if values, ok := value.(bson.A); ok && len(values) > 0 {
+					value = values[0]
+				}
+				bucket.Values[field][function] = toFloat(value)
+			}
+		}
+		results = append(results, bucket)
+	}
+
+	// Log the number of results
+	log.Printf("Computed %d aggregate buckets", len(results))
+
+	return results, nil
+}
+
+// aggregateKey names the $group output holding one field/function combination
+func aggregateKey(field string, function string) string {
+	return field + "__" + function
+}
+
+// accumulator builds the $group accumulator expression for a field and function
+func accumulator(field string, function string) bson.M {
+	path := "$" + field
+	switch function {
+	case "count":
+		// Count only documents where the field is present
+		return bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{bson.M{"$ifNull": bson.A{path, nil}}, nil}}, 0, 1}}}
+	case "sum":
+		return bson.M{"$sum": path}
+	case "avg":
+		return bson.M{"$avg": path}
+	case "min":
+		return bson.M{"$min": path}
+	case "max":
+		return bson.M{"$max": path}
+	case "stddev":
+		return bson.M{"$stdDevPop": path}
+	}
+	p, _ := model.PercentileOf(function)
+	return bson.M{"$percentile": bson.M{"input": path, "p": bson.A{p}, "method": "approximate"}}
+}
+
+// toFloat converts a numeric BSON value to float64, returning 0 for anything else
+func toFloat(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	}
+	return 0
 }
 
 // SaveSensorData saves a batch of sensor data records to the database
 // Used for CSV file uploads to add new sensor data to the collection
-func (r RepositoryDefault) SaveSensorData(data []model.SensorData) error {
+// Records are identified by (object_id, port_num, timestamp); onDuplicate decides
+// whether records already stored are skipped, replaced or reported as an error
+func (r RepositoryDefault) SaveSensorData(ctx context.Context, data []model.SensorData, onDuplicate string) (model.SaveResult, error) {
 	if len(data) == 0 {
-		return nil // No data to save
+		return model.SaveResult{}, nil // No data to save
+	}
+	ctx, cancel := withTimeout(ctx, r.WriteTimeout)
+	defer cancel()
+
+	// Time-series collections cannot have the unique index the code below relies on
+	if r.TimeSeries {
+		return r.saveTimeSeries(ctx, data, onDuplicate)
+	}
+
+	collection := r.collection()
+
+	// Replace matching records, inserting the ones that do not exist yet
+	if onDuplicate == model.DuplicateUpsert {
+		models := make([]mongo.WriteModel, 0, len(data))
+		for _, item := range data {
+			models = append(models, mongo.NewReplaceOneModel().
+				SetFilter(bson.M{"object_id": item.ObjectID, "port_num": item.PortNum, "timestamp": item.Timestamp}).
+				SetReplacement(item).
+				SetUpsert(true))
+		}
+
+		log.Printf("Upserting %d documents to MongoDB", len(models))
+		result, err := collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		if err != nil {
+			log.Printf("Error upserting documents: %v", err)
+			return model.SaveResult{}, err
+		}
+		log.Printf("Upserted %d new and replaced %d existing documents", result.UpsertedCount, result.MatchedCount)
+		return model.SaveResult{Inserted: result.UpsertedCount, Duplicates: result.MatchedCount}, nil
 	}
 
-	collection := r.Client.Database("gomongoviz").Collection("sensor_data")
-	
 	// Convert data to interface slice for bulk insert
 	var documents []interface{}
 	for _, item := range data {
 		documents = append(documents, item)
 	}
-	
+
 	// Insert all documents in a single operation
+	// When skipping duplicates the insert is unordered, so every non-duplicate is written
+	// and the unique index rejects the rest
 	log.Printf("Inserting %d documents to MongoDB", len(documents))
-	_, err := collection.InsertMany(context.TODO(), documents)
+	insertOptions := options.InsertMany().SetOrdered(onDuplicate != model.DuplicateSkip)
+	_, err := collection.InsertMany(ctx, documents, insertOptions)
+	if err != nil && onDuplicate == model.DuplicateSkip {
+		duplicates, onlyDuplicates := countDuplicateKeyErrors(err)
+		if onlyDuplicates {
+			inserted := int64(len(documents)) - duplicates
+			log.Printf("Successfully inserted %d documents, skipped %d duplicates", inserted, duplicates)
+			return model.SaveResult{Inserted: inserted, Duplicates: duplicates}, nil
+		}
+	}
 	if err != nil {
 		log.Printf("Error inserting documents: %v", err)
-		return err
+		return model.SaveResult{}, err
 	}
-	
+
 	log.Printf("Successfully inserted %d documents", len(documents))
-	return nil
+	return model.SaveResult{Inserted: int64(len(documents))}, nil
+}
+
+// countDuplicateKeyErrors counts the duplicate key errors of a bulk insert
+// The second result is false if the insert also failed for any other reason
+func countDuplicateKeyErrors(err error) (int64, bool) {
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+		return 0, false
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		if !mongo.IsDuplicateKeyError(writeErr) {
+			return 0, false
+		}
+	}
+	return int64(len(bulkErr.WriteErrors)), true
+}
+
+// SaveImportJob creates or replaces an import job record
+func (r RepositoryDefault) SaveImportJob(ctx context.Context, job model.ImportJob) error {
+	collection := r.Client.Database(r.Database).Collection(r.JobsCollection)
+	ctx, cancel := withTimeout(ctx, r.WriteTimeout)
+	defer cancel()
+
+	_, err := collection.ReplaceOne(ctx, bson.M{"_id": job.ID}, job, options.Replace().SetUpsert(true))
+	return err
+}
+
+// GetImportJob retrieves an import job record by ID
+// It returns ErrNotFound if no job has that ID
+func (r RepositoryDefault) GetImportJob(ctx context.Context, id string) (*model.ImportJob, error) {
+	collection := r.Client.Database(r.Database).Collection(r.JobsCollection)
+	ctx, cancel := withTimeout(ctx, r.QueryTimeout)
+	defer cancel()
+
+	var job model.ImportJob
+	err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ListImportJobs retrieves import job records, newest first
+// Only jobs in one of the given states are returned unless states is empty
+func (r RepositoryDefault) ListImportJobs(ctx context.Context, states []string) ([]model.ImportJob, error) {
+	collection := r.Client.Database(r.Database).Collection(r.JobsCollection)
+	ctx, cancel := withTimeout(ctx, r.QueryTimeout)
+	defer cancel()
+
+	filter := bson.M{}
+	if len(states) > 0 {
+		filter["state"] = bson.M{"$in": states}
+	}
+
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.WithoutCancel(ctx))
+
+	results := make([]model.ImportJob, 0)
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// collection returns the configured sensor data collection
+func (r RepositoryDefault) collection() *mongo.Collection {
+	return r.Client.Database(r.Database).Collection(r.Collection)
+}
+
+// withTimeout bounds ctx by the given operation deadline, leaving it unbounded if timeout is zero
+// Cancelling ctx, for example because the HTTP client went away, still stops the operation
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// metaField returns the path of object_id or port_num used in filters, sorts and groups
+// Time-series collections are bucketed by their metaField, so filtering on it is what
+// lets MongoDB skip whole buckets
+func (r RepositoryDefault) metaField(field string) string {
+	if r.TimeSeries {
+		return "meta." + field
+	}
+	return field
 }
 
 // NewRepositoryDefault creates a new instance of the default repository implementation
-// It takes a MongoDB client as input and returns a Repository interface
-func NewRepositoryDefault(client *mongo.Client) Repository {
+// It takes a MongoDB client and the configured database and collection names
+// and returns a Repository interface
+func NewRepositoryDefault(client *mongo.Client, cfg config.MongoConfig) Repository {
 	return RepositoryDefault{
-		Client: client,
+		Client:               client,
+		Database:             cfg.Database,
+		Collection:           cfg.Collection,
+		JobsCollection:       cfg.JobsCollection,
+		RulesCollection:      cfg.RulesCollection,
+		AlertsCollection:     cfg.AlertsCollection,
+		EventsCollection:     cfg.EventsCollection,
+		DeliveriesCollection: cfg.DeliveriesCollection,
+		FieldsCollection:     cfg.FieldsCollection,
+		BaselinesCollection:  cfg.BaselinesCollection,
+		AnomaliesCollection:  cfg.AnomaliesCollection,
+		TimeSeries:           cfg.TimeSeries,
+
+		QueryTimeout:     cfg.QueryTimeout,
+		AggregateTimeout: cfg.AggregateTimeout,
+		WriteTimeout:     cfg.WriteTimeout,
+		ExportTimeout:    cfg.ExportTimeout,
 	}
 }
@@ -0,0 +1,876 @@
+// Package repotest checks that a repository.Repository implementation behaves the way
+// the MongoDB repository does, so every storage backend can be used interchangeably
+// The tests of each backend call Run with a function creating an empty repository
+package repotest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"gomongoviz/model"
+	"gomongoviz/repository"
+)
+
+// Factory creates an empty repository for one check
+// Repositories that hold resources release them with t.Cleanup
+type Factory func(t *testing.T) repository.Repository
+
+// check is one named behaviour every repository must have
+type check struct {
+	name string
+	run  func(ctx context.Context, repo repository.Repository) error
+}
+
+// checks lists the behaviours verified by Run, in the order they run
+var checks = []check{
+	{"empty storage", checkEmpty},
+	{"object and port discovery", checkDiscovery},
+	{"filtered queries", checkQueries},
+	{"cursor paging", checkPaging},
+	{"duplicate policies", checkDuplicates},
+	{"streaming", checkStreaming},
+	{"watching", checkWatching},
+	{"aggregates", checkAggregates},
+	{"import jobs", checkJobs},
+	{"alerts", checkAlerts},
+	{"anomalies", checkAnomalies},
+	{"webhook deliveries", checkDeliveries},
+	{"computed fields", checkComputedFields},
+	{"indexes", checkIndexes},
+	{"cancelled context", checkCancelled},
+}
+
+// Run checks the repositories created by newRepo against the shared behaviour of all
+// storage backends, running every check as a subtest on a fresh repository
+func Run(t *testing.T, newRepo Factory) {
+	for _, c := range checks {
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.run(context.Background(), newRepo(t)); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// base is the time of the first sample record; whole seconds survive every backend
+var base = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// sample builds a record of an object and port taken minute minutes after base
+func sample(objectID float64, portNum float64, minute int, voltage float64) model.SensorData {
+	return model.SensorData{
+		Timestamp: base.Add(time.Duration(minute) * time.Minute),
+		CreatedAt: base,
+		ObjectID:  objectID,
+		PortNum:   portNum,
+		Voltage:   voltage,
+		FWVersion: "1.0.0",
+	}
+}
+
+// save stores records and fails unless all of them were inserted
+func save(ctx context.Context, repo repository.Repository, records ...model.SensorData) error {
+	result, err := repo.SaveSensorData(ctx, records, model.DuplicateSkip)
+	if err != nil {
+		return fmt.Errorf("saving records: %w", err)
+	}
+	if result.Inserted != int64(len(records)) || result.Duplicates != 0 {
+		return fmt.Errorf("saving %d new records: got %+v", len(records), result)
+	}
+	return nil
+}
+
+// minutes returns how many minutes after base each record was taken
+func minutes(records []model.SensorData) []int {
+	result := make([]int, len(records))
+	for i, record := range records {
+		result[i] = int(record.Timestamp.Sub(base) / time.Minute)
+	}
+	return result
+}
+
+// query returns every record of the query, failing if the total does not match
+func query(ctx context.Context, repo repository.Repository, q model.SensorDataQuery) ([]model.SensorData, error) {
+	res, err := repo.GetDataByObjectID(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	if res.Total != int64(len(res.SensorData)) {
+		return nil, fmt.Errorf("total is %d for %d records", res.Total, len(res.SensorData))
+	}
+	return res.SensorData, nil
+}
+
+// expectMinutes compares the timestamps of records with the expected minutes after base
+func expectMinutes(what string, records []model.SensorData, want ...int) error {
+	if got := minutes(records); !slices.Equal(got, want) {
+		return fmt.Errorf("%s: got records at minutes %v, want %v", what, got, want)
+	}
+	return nil
+}
+
+func checkEmpty(ctx context.Context, repo repository.Repository) error {
+	objects, err := repo.GetUniqueObjectIDs(ctx)
+	if err != nil {
+		return err
+	}
+	if objects == nil || len(objects) != 0 {
+		return fmt.Errorf("objects: got %v, want an empty list", objects)
+	}
+	ports, err := repo.GetPorts(ctx, 1)
+	if err != nil {
+		return err
+	}
+	if ports == nil || len(ports) != 0 {
+		return fmt.Errorf("ports: got %v, want an empty list", ports)
+	}
+	records, err := query(ctx, repo, model.SensorDataQuery{ObjectID: "1"})
+	if err != nil {
+		return err
+	}
+	if len(records) != 0 {
+		return fmt.Errorf("data: got %d records, want none", len(records))
+	}
+	jobs, err := repo.ListImportJobs(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if jobs == nil || len(jobs) != 0 {
+		return fmt.Errorf("jobs: got %v, want an empty list", jobs)
+	}
+	if _, err := repo.GetImportJob(ctx, "missing"); !errors.Is(err, repository.ErrNotFound) {
+		return fmt.Errorf("missing job: got %v, want ErrNotFound", err)
+	}
+	return nil
+}
+
+func checkDiscovery(ctx context.Context, repo repository.Repository) error {
+	err := save(ctx, repo,
+		sample(20, 3, 0, 1), sample(3, 2, 0, 1), sample(20, 1, 0, 1),
+		sample(20, 3, 1, 1), sample(-1, 1, 0, 1), sample(20, 10, 0, 1))
+	if err != nil {
+		return err
+	}
+
+	objects, err := repo.GetUniqueObjectIDs(ctx)
+	if err != nil {
+		return err
+	}
+	var gotObjects []float64
+	for _, object := range objects {
+		gotObjects = append(gotObjects, object.ObjectID)
+	}
+	if want := []float64{-1, 3, 20}; !slices.Equal(gotObjects, want) {
+		return fmt.Errorf("objects: got %v, want %v", gotObjects, want)
+	}
+
+	ports, err := repo.GetPorts(ctx, 20)
+	if err != nil {
+		return err
+	}
+	var gotPorts []float64
+	for _, port := range ports {
+		gotPorts = append(gotPorts, port.PortNum)
+	}
+	if want := []float64{1, 3, 10}; !slices.Equal(gotPorts, want) {
+		return fmt.Errorf("ports: got %v, want %v", gotPorts, want)
+	}
+	return nil
+}
+
+func checkQueries(ctx context.Context, repo repository.Repository) error {
+	err := save(ctx, repo,
+		sample(1, 1, 3, 1), sample(1, 1, 1, 1), sample(1, 2, 2, 1),
+		sample(1, 1, 0, 1), sample(1, 1, 2, 1), sample(2, 1, 1, 1))
+	if err != nil {
+		return err
+	}
+
+	records, err := query(ctx, repo, model.SensorDataQuery{ObjectID: "1"})
+	if err != nil {
+		return err
+	}
+	if err := expectMinutes("all ports", records, 0, 1, 2, 2, 3); err != nil {
+		return err
+	}
+
+	records, err = query(ctx, repo, model.SensorDataQuery{ObjectID: "1", PortNum: "1"})
+	if err != nil {
+		return err
+	}
+	if err := expectMinutes("one port", records, 0, 1, 2, 3); err != nil {
+		return err
+	}
+
+	// Both bounds are inclusive
+	records, err = query(ctx, repo, model.SensorDataQuery{
+		ObjectID: "1",
+		PortNum:  "1",
+		From:     base.Add(time.Minute),
+		To:       base.Add(2 * time.Minute),
+	})
+	if err != nil {
+		return err
+	}
+	if err := expectMinutes("time range", records, 1, 2); err != nil {
+		return err
+	}
+
+	records, err = query(ctx, repo, model.SensorDataQuery{ObjectID: "1", PortNum: "1", SortDesc: true})
+	if err != nil {
+		return err
+	}
+	if err := expectMinutes("descending", records, 3, 2, 1, 0); err != nil {
+		return err
+	}
+
+	records, err = query(ctx, repo, model.SensorDataQuery{ObjectID: "1", PortNum: "9"})
+	if err != nil {
+		return err
+	}
+	return expectMinutes("unknown port", records)
+}
+
+func checkPaging(ctx context.Context, repo repository.Repository) error {
+	// Two records share every timestamp, so pages must also be ordered by _id
+	var records []model.SensorData
+	for minute := 0; minute < 5; minute++ {
+		records = append(records, sample(1, 1, minute, 1), sample(1, 2, minute, 1))
+	}
+	if err := save(ctx, repo, records...); err != nil {
+		return err
+	}
+
+	for _, desc := range []bool{false, true} {
+		q := model.SensorDataQuery{ObjectID: "1", Limit: 3, SortDesc: desc}
+		seen := make(map[string]bool)
+		var paged []model.SensorData
+		for page := 0; ; page++ {
+			if page > len(records) {
+				return fmt.Errorf("descending %v: paging does not end", desc)
+			}
+			res, err := repo.GetDataByObjectID(ctx, q)
+			if err != nil {
+				return err
+			}
+			if res.Total != int64(len(records)) {
+				return fmt.Errorf("descending %v: total is %d, want %d", desc, res.Total, len(records))
+			}
+			if len(res.SensorData) > 3 {
+				return fmt.Errorf("descending %v: page of %d records exceeds the limit", desc, len(res.SensorData))
+			}
+			for _, record := range res.SensorData {
+				if seen[record.ID] {
+					return fmt.Errorf("descending %v: record %s returned twice", desc, record.ID)
+				}
+				seen[record.ID] = true
+			}
+			paged = append(paged, res.SensorData...)
+			if res.NextCursor == "" {
+				break
+			}
+			if q.After, err = model.DecodePageCursor(res.NextCursor); err != nil {
+				return err
+			}
+		}
+
+		want := []int{0, 0, 1, 1, 2, 2, 3, 3, 4, 4}
+		if desc {
+			slices.Reverse(want)
+		}
+		if err := expectMinutes(fmt.Sprintf("descending %v", desc), paged, want...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkDuplicates(ctx context.Context, repo repository.Repository) error {
+	if err := save(ctx, repo, sample(1, 1, 0, 1), sample(1, 1, 1, 1)); err != nil {
+		return err
+	}
+
+	result, err := repo.SaveSensorData(ctx, []model.SensorData{sample(1, 1, 0, 2), sample(1, 1, 2, 2)}, model.DuplicateSkip)
+	if err != nil {
+		return fmt.Errorf("skip: %w", err)
+	}
+	if result != (model.SaveResult{Inserted: 1, Duplicates: 1}) {
+		return fmt.Errorf("skip: got %+v, want 1 inserted and 1 duplicate", result)
+	}
+	records, err := query(ctx, repo, model.SensorDataQuery{ObjectID: "1", PortNum: "1"})
+	if err != nil {
+		return err
+	}
+	if err := expectMinutes("skip", records, 0, 1, 2); err != nil {
+		return err
+	}
+	if records[0].Voltage != 1 {
+		return fmt.Errorf("skip: stored record was changed to voltage %v", records[0].Voltage)
+	}
+
+	result, err = repo.SaveSensorData(ctx, []model.SensorData{sample(1, 1, 0, 3), sample(1, 1, 3, 3)}, model.DuplicateUpsert)
+	if err != nil {
+		return fmt.Errorf("upsert: %w", err)
+	}
+	if result != (model.SaveResult{Inserted: 1, Duplicates: 1}) {
+		return fmt.Errorf("upsert: got %+v, want 1 inserted and 1 duplicate", result)
+	}
+	records, err = query(ctx, repo, model.SensorDataQuery{ObjectID: "1", PortNum: "1"})
+	if err != nil {
+		return err
+	}
+	if err := expectMinutes("upsert", records, 0, 1, 2, 3); err != nil {
+		return err
+	}
+	if records[0].Voltage != 3 {
+		return fmt.Errorf("upsert: stored record has voltage %v, want 3", records[0].Voltage)
+	}
+
+	if _, err := repo.SaveSensorData(ctx, []model.SensorData{sample(1, 1, 1, 4)}, model.DuplicateError); err == nil {
+		return errors.New("error policy: saving a duplicate succeeded")
+	}
+	records, err = query(ctx, repo, model.SensorDataQuery{ObjectID: "1", PortNum: "1"})
+	if err != nil {
+		return err
+	}
+	if records[1].Voltage != 1 {
+		return fmt.Errorf("error policy: stored record was changed to voltage %v", records[1].Voltage)
+	}
+	return expectMinutes("error policy", records, 0, 1, 2, 3)
+}
+
+func checkStreaming(ctx context.Context, repo repository.Repository) error {
+	if err := save(ctx, repo, sample(1, 1, 2, 1), sample(1, 1, 0, 1), sample(1, 2, 1, 1), sample(1, 1, 1, 1)); err != nil {
+		return err
+	}
+
+	for _, q := range []model.SensorDataQuery{
+		{ObjectID: "1", PortNum: "1"},
+		{ObjectID: "1", PortNum: "1", SortDesc: true},
+		{ObjectID: "1"},
+	} {
+		var streamed []model.SensorData
+		err := repo.StreamData(ctx, q, func(data *model.SensorData) error {
+			streamed = append(streamed, *data)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		want, err := query(ctx, repo, q)
+		if err != nil {
+			return err
+		}
+		if err := expectMinutes(fmt.Sprintf("port %q descending %v", q.PortNum, q.SortDesc), streamed, minutes(want)...); err != nil {
+			return err
+		}
+	}
+
+	// An error returned by fn stops the stream and is passed on unchanged
+	stop := errors.New("stop")
+	calls := 0
+	err := repo.StreamData(ctx, model.SensorDataQuery{ObjectID: "1"}, func(*model.SensorData) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) {
+		return fmt.Errorf("callback error: got %v, want it returned", err)
+	}
+	if calls != 1 {
+		return fmt.Errorf("callback error: fn was called %d times after failing", calls)
+	}
+	return nil
+}
+
+func checkWatching(ctx context.Context, repo repository.Repository) error {
+	// Storage without push support is polled instead, which the other checks cover
+	watch, err := repo.WatchData(ctx, model.SensorDataQuery{ObjectID: "1", PortNum: "1"})
+	if errors.Is(err, repository.ErrWatchUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer watch.Close(context.WithoutCancel(ctx))
+
+	if err := save(ctx, repo, sample(2, 1, 0, 1), sample(1, 2, 0, 1), sample(1, 1, 5, 7)); err != nil {
+		return err
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	data, err := watch.Next(waitCtx)
+	if err != nil {
+		return fmt.Errorf("waiting for the inserted record: %w", err)
+	}
+	if data.ObjectID != 1 || data.PortNum != 1 || data.Voltage != 7 || data.ID == "" {
+		return fmt.Errorf("got %+v, want the record of object 1 and port 1", *data)
+	}
+	return nil
+}
+
+func checkAggregates(ctx context.Context, repo repository.Repository) error {
+	// Port 1 has three records in the first hour and one in the second, port 2 one
+	err := save(ctx, repo,
+		sample(1, 1, 0, 1), sample(1, 1, 20, 2), sample(1, 1, 59, 6),
+		sample(1, 1, 61, 10), sample(1, 2, 30, 5))
+	if err != nil {
+		return err
+	}
+
+	buckets, err := repo.AggregateData(ctx, model.AggregateQuery{
+		SensorDataQuery: model.SensorDataQuery{ObjectID: "1"},
+		Unit:            "hour",
+		BinSize:         1,
+		Fields:          []string{"voltage"},
+		Functions:       []string{"count", "avg", "min", "max"},
+	})
+	if err != nil {
+		return err
+	}
+
+	type expected struct {
+		hour     int
+		port     float64
+		count    int64
+		avg, max float64
+	}
+	want := []expected{{0, 1, 3, 3, 6}, {0, 2, 1, 5, 5}, {1, 1, 1, 10, 10}}
+	if len(buckets) != len(want) {
+		return fmt.Errorf("got %d buckets, want %d", len(buckets), len(want))
+	}
+	for i, w := range want {
+		bucket := buckets[i]
+		if !bucket.Timestamp.Equal(base.Add(time.Duration(w.hour)*time.Hour)) || bucket.PortNum != w.port {
+			return fmt.Errorf("bucket %d: got port %v at %s, want port %v at hour %d", i, bucket.PortNum, bucket.Timestamp, w.port, w.hour)
+		}
+		values := bucket.Values["voltage"]
+		if bucket.Count != w.count || values["avg"] != w.avg || values["max"] != w.max {
+			return fmt.Errorf("bucket %d: got count %d and values %v, want count %d, avg %v and max %v",
+				i, bucket.Count, values, w.count, w.avg, w.max)
+		}
+	}
+	return nil
+}
+
+func checkJobs(ctx context.Context, repo repository.Repository) error {
+	jobs := []model.ImportJob{
+		{ID: "a", State: model.ImportSucceeded, Filename: "a.csv", CreatedAt: base, Errors: []string{}},
+		{ID: "b", State: model.ImportQueued, Filename: "b.csv", SpoolPath: "/spool/b.csv", CreatedAt: base.Add(time.Hour), Errors: []string{}},
+		{ID: "c", State: model.ImportRunning, Filename: "c.csv", CreatedAt: base.Add(time.Minute), Errors: []string{}},
+	}
+	for _, job := range jobs {
+		if err := repo.SaveImportJob(ctx, job); err != nil {
+			return err
+		}
+	}
+
+	// The spool path is never sent to clients but queued jobs need it to resume
+	job, err := repo.GetImportJob(ctx, "b")
+	if err != nil {
+		return err
+	}
+	if job.Filename != "b.csv" || job.SpoolPath != "/spool/b.csv" || !job.CreatedAt.Equal(jobs[1].CreatedAt) {
+		return fmt.Errorf("got job %+v, want %+v", *job, jobs[1])
+	}
+
+	listed, err := repo.ListImportJobs(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if ids := jobIDs(listed); !slices.Equal(ids, []string{"b", "c", "a"}) {
+		return fmt.Errorf("all jobs: got %v, want newest first [b c a]", ids)
+	}
+	listed, err = repo.ListImportJobs(ctx, []string{model.ImportQueued, model.ImportRunning})
+	if err != nil {
+		return err
+	}
+	if ids := jobIDs(listed); !slices.Equal(ids, []string{"b", "c"}) {
+		return fmt.Errorf("unfinished jobs: got %v, want [b c]", ids)
+	}
+
+	// Saving a job again replaces it
+	jobs[1].State = model.ImportFailed
+	jobs[1].Errors = []string{"failed"}
+	if err := repo.SaveImportJob(ctx, jobs[1]); err != nil {
+		return err
+	}
+	if job, err = repo.GetImportJob(ctx, "b"); err != nil {
+		return err
+	}
+	if job.State != model.ImportFailed || !slices.Equal(job.Errors, jobs[1].Errors) {
+		return fmt.Errorf("replaced job: got state %s and errors %v", job.State, job.Errors)
+	}
+	listed, err = repo.ListImportJobs(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if len(listed) != len(jobs) {
+		return fmt.Errorf("replaced job: got %d jobs, want %d", len(listed), len(jobs))
+	}
+	return nil
+}
+
+// jobIDs returns the IDs of jobs in order
+func jobIDs(jobs []model.ImportJob) []string {
+	ids := make([]string, len(jobs))
+	for i, job := range jobs {
+		ids[i] = job.ID
+	}
+	return ids
+}
+
+func checkAlerts(ctx context.Context, repo repository.Repository) error {
+	object := 7.0
+	rules := []model.AlertRule{
+		{ID: "r2", Name: "low", Field: "voltage", Operator: "<", Threshold: 1, CreatedAt: base.Add(time.Hour)},
+		{ID: "r1", Name: "high", ObjectID: &object, Field: "voltage", Operator: ">", Threshold: 14.5, For: model.Duration(2 * time.Minute), Hysteresis: 0.5, CreatedAt: base},
+	}
+	for _, rule := range rules {
+		if err := repo.SaveAlertRule(ctx, rule); err != nil {
+			return err
+		}
+	}
+	rule, err := repo.GetAlertRule(ctx, "r1")
+	if err != nil {
+		return err
+	}
+	if rule.ObjectID == nil || *rule.ObjectID != object || rule.PortNum != nil || rule.For != rules[1].For || !rule.CreatedAt.Equal(base) {
+		return fmt.Errorf("got rule %+v, want %+v", *rule, rules[1])
+	}
+	listed, err := repo.ListAlertRules(ctx)
+	if err != nil {
+		return err
+	}
+	if len(listed) != 2 || listed[0].ID != "r1" || listed[1].ID != "r2" {
+		return fmt.Errorf("rules: got %d, want oldest first [r1 r2]", len(listed))
+	}
+	if err := repo.DeleteAlertRule(ctx, "r2"); err != nil {
+		return err
+	}
+	if _, err := repo.GetAlertRule(ctx, "r2"); !errors.Is(err, repository.ErrNotFound) {
+		return fmt.Errorf("deleted rule: got %v, want ErrNotFound", err)
+	}
+	if err := repo.DeleteAlertRule(ctx, "r2"); !errors.Is(err, repository.ErrNotFound) {
+		return fmt.Errorf("deleting a missing rule: got %v, want ErrNotFound", err)
+	}
+
+	alerts := []model.Alert{
+		{ID: model.AlertID("r1", 7, 2), RuleID: "r1", ObjectID: 7, PortNum: 2, State: model.AlertPending, Since: base},
+		{ID: model.AlertID("r1", 7, 1), RuleID: "r1", ObjectID: 7, PortNum: 1, State: model.AlertFiring, Since: base, FiredAt: base.Add(2 * time.Minute)},
+		{ID: model.AlertID("r2", 8, 1), RuleID: "r2", ObjectID: 8, PortNum: 1, State: model.AlertResolved},
+	}
+	if err := repo.SaveAlerts(ctx, alerts); err != nil {
+		return err
+	}
+	alerts[0].State = model.AlertFiring
+	if err := repo.SaveAlerts(ctx, alerts[:1]); err != nil {
+		return err
+	}
+	states, err := repo.ListAlerts(ctx, "r1", []string{model.AlertFiring})
+	if err != nil {
+		return err
+	}
+	if len(states) != 2 || states[0].PortNum != 1 || states[1].PortNum != 2 || !states[0].FiredAt.Equal(alerts[1].FiredAt) {
+		return fmt.Errorf("firing alerts of r1: got %+v, want ports [1 2]", states)
+	}
+	if err := repo.DeleteAlerts(ctx, "r1"); err != nil {
+		return err
+	}
+	if states, err = repo.ListAlerts(ctx, "", nil); err != nil {
+		return err
+	}
+	if len(states) != 1 || states[0].RuleID != "r2" {
+		return fmt.Errorf("alerts after deleting those of r1: got %+v", states)
+	}
+
+	events := []model.AlertEvent{
+		{ID: "e1", RuleID: "r1", ObjectID: 7, State: model.AlertFiring, Timestamp: base},
+		{ID: "e2", RuleID: "r1", ObjectID: 7, State: model.AlertResolved, Timestamp: base.Add(time.Minute)},
+		{ID: "e3", RuleID: "r2", ObjectID: 8, State: model.AlertFiring, Timestamp: base.Add(2 * time.Minute)},
+	}
+	if err := repo.SaveAlertEvents(ctx, events); err != nil {
+		return err
+	}
+	history, err := repo.ListAlertEvents(ctx, model.AlertEventQuery{})
+	if err != nil {
+		return err
+	}
+	if ids := eventIDs(history); !slices.Equal(ids, []string{"e3", "e2", "e1"}) {
+		return fmt.Errorf("alert history: got %v, want newest first [e3 e2 e1]", ids)
+	}
+	if history, err = repo.ListAlertEvents(ctx, model.AlertEventQuery{RuleID: "r1", ObjectID: &object, Limit: 1}); err != nil {
+		return err
+	}
+	if ids := eventIDs(history); !slices.Equal(ids, []string{"e2"}) {
+		return fmt.Errorf("latest event of r1 on object 7: got %v, want [e2]", ids)
+	}
+	return nil
+}
+
+// eventIDs returns the IDs of alert events in order
+func eventIDs(events []model.AlertEvent) []string {
+	ids := make([]string, len(events))
+	for i, event := range events {
+		ids[i] = event.ID
+	}
+	return ids
+}
+
+func checkAnomalies(ctx context.Context, repo repository.Repository) error {
+	baselines := []model.AnomalyBaseline{
+		{ID: model.AnomalyBaselineID(7, 2, "voc"), ObjectID: 7, PortNum: 2, Field: "voc", Method: model.AnomalyEWMA, Window: 60, Count: 12, Mean: 40.5, Variance: 0.25, LastTimestamp: base},
+		{ID: model.AnomalyBaselineID(7, 1, "voc"), ObjectID: 7, PortNum: 1, Field: "voc", Method: model.AnomalyZScore, Window: 3, Values: []float64{1, 2, 3}, LastTimestamp: base},
+	}
+	if err := repo.SaveAnomalyBaselines(ctx, baselines); err != nil {
+		return err
+	}
+	baselines[1].Values = []float64{2, 3, 4}
+	baselines[1].LastTimestamp = base.Add(time.Minute)
+	if err := repo.SaveAnomalyBaselines(ctx, baselines[1:]); err != nil {
+		return err
+	}
+	stored, err := repo.ListAnomalyBaselines(ctx)
+	if err != nil {
+		return err
+	}
+	if len(stored) != 2 || stored[0].PortNum != 1 || stored[1].PortNum != 2 {
+		return fmt.Errorf("baselines: got %+v, want ports [1 2]", stored)
+	}
+	if !slices.Equal(stored[0].Values, []float64{2, 3, 4}) || !stored[0].LastTimestamp.Equal(base.Add(time.Minute)) {
+		return fmt.Errorf("replaced baseline: got %+v, want the values 2 3 4 at minute 1", stored[0])
+	}
+	if stored[1].Method != model.AnomalyEWMA || stored[1].Count != 12 || stored[1].Mean != 40.5 || stored[1].Variance != 0.25 {
+		return fmt.Errorf("EWMA baseline: got %+v, want %+v", stored[1], baselines[0])
+	}
+
+	anomaly := func(objectID float64, portNum float64, field string, minute int, score float64) model.Anomaly {
+		timestamp := base.Add(time.Duration(minute) * time.Minute)
+		return model.Anomaly{ID: model.AnomalyID(objectID, portNum, field, timestamp), ObjectID: objectID, PortNum: portNum, Field: field, Timestamp: timestamp, Score: score}
+	}
+	if err := repo.SaveAnomalies(ctx, []model.Anomaly{
+		anomaly(7, 2, "voc", 3, 4),
+		anomaly(7, 1, "voc", 3, 5),
+		anomaly(7, 1, "supply_volt", 3, 6),
+		anomaly(7, 1, "voc", 1, 7),
+		anomaly(7, 2, "voc", 5, 8),
+		anomaly(8, 1, "voc", 2, 9),
+	}); err != nil {
+		return err
+	}
+	// Flagging a reading again replaces its anomaly
+	if err := repo.SaveAnomalies(ctx, []model.Anomaly{anomaly(7, 1, "voc", 1, -3)}); err != nil {
+		return err
+	}
+
+	port := 1.0
+	tests := []struct {
+		name  string
+		query model.AnomalyQuery
+		want  []float64 // Scores in order
+	}{
+		{"object", model.AnomalyQuery{ObjectID: 7}, []float64{-3, 6, 5, 4, 8}},
+		{"port", model.AnomalyQuery{ObjectID: 7, PortNum: &port}, []float64{-3, 6, 5}},
+		{"fields", model.AnomalyQuery{ObjectID: 7, Fields: []string{"voc"}}, []float64{-3, 5, 4, 8}},
+		{"inclusive range", model.AnomalyQuery{ObjectID: 7, From: base.Add(3 * time.Minute), To: base.Add(5 * time.Minute)}, []float64{6, 5, 4, 8}},
+		{"limit", model.AnomalyQuery{ObjectID: 7, From: base.Add(2 * time.Minute), Limit: 2}, []float64{6, 5}},
+		{"other object", model.AnomalyQuery{ObjectID: 8, To: base.Add(2 * time.Minute)}, []float64{9}},
+		{"no match", model.AnomalyQuery{ObjectID: 9}, []float64{}},
+	}
+	for _, tt := range tests {
+		anomalies, err := repo.ListAnomalies(ctx, tt.query)
+		if err != nil {
+			return err
+		}
+		scores := make([]float64, len(anomalies))
+		for i, anomaly := range anomalies {
+			scores[i] = anomaly.Score
+		}
+		if !slices.Equal(scores, tt.want) {
+			return fmt.Errorf("anomalies by %s: got scores %v, want %v", tt.name, scores, tt.want)
+		}
+	}
+	return nil
+}
+
+func checkDeliveries(ctx context.Context, repo repository.Repository) error {
+	deliveries := []model.WebhookDelivery{
+		{ID: "d1", Endpoint: "tickets", Event: model.EventAlertFiring, Payload: []byte(`{"event":"alert.firing"}`), State: model.DeliveryDelivered, CreatedAt: base},
+		{ID: "d2", Endpoint: "chat", Event: model.EventAlertFiring, State: model.DeliveryPending, CreatedAt: base.Add(time.Minute)},
+		{ID: "d3", Endpoint: "tickets", Event: model.EventImportFailed, State: model.DeliveryPending, CreatedAt: base.Add(2 * time.Minute)},
+	}
+	for _, delivery := range deliveries {
+		if err := repo.SaveWebhookDelivery(ctx, delivery); err != nil {
+			return err
+		}
+	}
+	deliveries[2].State = model.DeliveryDead
+	deliveries[2].Attempts = []model.WebhookAttempt{{At: base, StatusCode: 500, Error: "unexpected status 500"}}
+	if err := repo.SaveWebhookDelivery(ctx, deliveries[2]); err != nil {
+		return err
+	}
+
+	delivery, err := repo.GetWebhookDelivery(ctx, "d3")
+	if err != nil {
+		return err
+	}
+	if delivery.State != model.DeliveryDead || len(delivery.Attempts) != 1 || delivery.Attempts[0].StatusCode != 500 {
+		return fmt.Errorf("got delivery %+v, want dead after one attempt", *delivery)
+	}
+	if delivery, err = repo.GetWebhookDelivery(ctx, "d1"); err != nil {
+		return err
+	}
+	if string(delivery.Payload) != string(deliveries[0].Payload) {
+		return fmt.Errorf("payload: got %s, want %s", delivery.Payload, deliveries[0].Payload)
+	}
+	if _, err := repo.GetWebhookDelivery(ctx, "missing"); !errors.Is(err, repository.ErrNotFound) {
+		return fmt.Errorf("missing delivery: got %v, want ErrNotFound", err)
+	}
+
+	listed, err := repo.ListWebhookDeliveries(ctx, model.WebhookDeliveryQuery{})
+	if err != nil {
+		return err
+	}
+	if ids := deliveryIDs(listed); !slices.Equal(ids, []string{"d3", "d2", "d1"}) {
+		return fmt.Errorf("deliveries: got %v, want newest first [d3 d2 d1]", ids)
+	}
+	if listed, err = repo.ListWebhookDeliveries(ctx, model.WebhookDeliveryQuery{States: []string{model.DeliveryPending, model.DeliveryDead}, Endpoint: "tickets"}); err != nil {
+		return err
+	}
+	if ids := deliveryIDs(listed); !slices.Equal(ids, []string{"d3"}) {
+		return fmt.Errorf("pending and dead deliveries to tickets: got %v, want [d3]", ids)
+	}
+	if listed, err = repo.ListWebhookDeliveries(ctx, model.WebhookDeliveryQuery{Event: model.EventAlertFiring, Limit: 1}); err != nil {
+		return err
+	}
+	if ids := deliveryIDs(listed); !slices.Equal(ids, []string{"d2"}) {
+		return fmt.Errorf("latest alert.firing delivery: got %v, want [d2]", ids)
+	}
+	return nil
+}
+
+// deliveryIDs returns the IDs of webhook deliveries in order
+func deliveryIDs(deliveries []model.WebhookDelivery) []string {
+	ids := make([]string, len(deliveries))
+	for i, delivery := range deliveries {
+		ids[i] = delivery.ID
+	}
+	return ids
+}
+
+func checkComputedFields(ctx context.Context, repo repository.Repository) error {
+	fields := []model.ComputedField{
+		{Name: "scaled_ai3", Expression: "ai3 * 0.0125 + 0.4", Fields: []string{"ai3"}, CreatedAt: base, UpdatedAt: base},
+		{Name: "drop_ohms", Expression: "(supply_volt - voltage) / supply_current", Fields: []string{"supply_volt", "voltage", "supply_current"}, CreatedAt: base, UpdatedAt: base},
+	}
+	for _, field := range fields {
+		if err := repo.CreateComputedField(ctx, field); err != nil {
+			return err
+		}
+	}
+	taken := fields[0]
+	taken.Expression = "ai3"
+	if err := repo.CreateComputedField(ctx, taken); !errors.Is(err, repository.ErrExists) {
+		return fmt.Errorf("creating a taken name: got %v, want ErrExists", err)
+	}
+
+	// Only one of concurrent creations of the same name succeeds
+	var wg sync.WaitGroup
+	created := make(chan int, 8)
+	for i := 0; i < cap(created); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			field := model.ComputedField{Name: "racing", Expression: strconv.Itoa(i), CreatedAt: base, UpdatedAt: base}
+			if err := repo.CreateComputedField(ctx, field); err == nil {
+				created <- i
+			} else if !errors.Is(err, repository.ErrExists) {
+				created <- -1
+			}
+		}()
+	}
+	wg.Wait()
+	close(created)
+	var winners []int
+	for i := range created {
+		winners = append(winners, i)
+	}
+	if len(winners) != 1 || winners[0] < 0 {
+		return fmt.Errorf("concurrent creations: got winners %v, want exactly one", winners)
+	}
+	if racing, err := repo.GetComputedField(ctx, "racing"); err != nil || racing.Expression != strconv.Itoa(winners[0]) {
+		return fmt.Errorf("concurrent creations: stored %+v, %v, want the expression of the winner %d", racing, err, winners[0])
+	}
+	if err := repo.DeleteComputedField(ctx, "racing"); err != nil {
+		return err
+	}
+
+	fields[0].Expression = "ai3 * 0.025"
+	fields[0].UpdatedAt = base.Add(time.Hour)
+	if err := repo.SaveComputedField(ctx, fields[0]); err != nil {
+		return err
+	}
+
+	field, err := repo.GetComputedField(ctx, "scaled_ai3")
+	if err != nil {
+		return err
+	}
+	if field.Expression != "ai3 * 0.025" || len(field.Fields) != 1 || !field.CreatedAt.Equal(base) || !field.UpdatedAt.Equal(fields[0].UpdatedAt) {
+		return fmt.Errorf("got field %+v, want %+v", *field, fields[0])
+	}
+	listed, err := repo.ListComputedFields(ctx)
+	if err != nil {
+		return err
+	}
+	if len(listed) != 2 || listed[0].Name != "drop_ohms" || listed[1].Name != "scaled_ai3" || len(listed[0].Fields) != 3 {
+		return fmt.Errorf("fields: got %+v, want [drop_ohms scaled_ai3] in name order", listed)
+	}
+	if err := repo.DeleteComputedField(ctx, "drop_ohms"); err != nil {
+		return err
+	}
+	if _, err := repo.GetComputedField(ctx, "drop_ohms"); !errors.Is(err, repository.ErrNotFound) {
+		return fmt.Errorf("deleted field: got %v, want ErrNotFound", err)
+	}
+	if err := repo.DeleteComputedField(ctx, "drop_ohms"); !errors.Is(err, repository.ErrNotFound) {
+		return fmt.Errorf("deleting a missing field: got %v, want ErrNotFound", err)
+	}
+	return nil
+}
+
+func checkIndexes(ctx context.Context, repo repository.Repository) error {
+	statuses, err := repo.EnsureIndexes(ctx)
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		if status.State == model.IndexMissing || status.State == model.IndexFailed {
+			return fmt.Errorf("index %s is %s after EnsureIndexes", status.Name, status.State)
+		}
+	}
+	_, err = repo.IndexStatus(ctx)
+	return err
+}
+
+func checkCancelled(ctx context.Context, repo repository.Repository) error {
+	if err := save(ctx, repo, sample(1, 1, 0, 1)); err != nil {
+		return err
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := repo.GetDataByObjectID(cancelled, model.SensorDataQuery{ObjectID: "1"}); !errors.Is(err, context.Canceled) {
+		return fmt.Errorf("query: got %v, want context.Canceled", err)
+	}
+	err := repo.StreamData(cancelled, model.SensorDataQuery{ObjectID: "1"}, func(*model.SensorData) error { return nil })
+	if !errors.Is(err, context.Canceled) {
+		return fmt.Errorf("stream: got %v, want context.Canceled", err)
+	}
+	if _, err := repo.SaveSensorData(cancelled, []model.SensorData{sample(1, 1, 1, 1)}, model.DuplicateSkip); !errors.Is(err, context.Canceled) {
+		return fmt.Errorf("save: got %v, want context.Canceled", err)
+	}
+	return nil
+}
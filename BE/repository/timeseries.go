@@ -0,0 +1,288 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gomongoviz/config"
+	"gomongoviz/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrNotTimeSeries is returned when time-series storage is enabled but the sensor data
+// collection already exists as a plain collection
+var ErrNotTimeSeries = errors.New("collection is not a time-series collection")
+
+// recordKey identifies a sensor data record the way the unique index of a plain collection does
+// Timestamps are compared in milliseconds, the precision MongoDB stores
+type recordKey struct {
+	objectID  float64
+	portNum   float64
+	timestamp int64
+}
+
+// keyOf returns the identifying key of a record
+func keyOf(data model.SensorData) recordKey {
+	return recordKey{objectID: data.ObjectID, portNum: data.PortNum, timestamp: data.Timestamp.UnixMilli()}
+}
+
+// timeSeriesOptions describes the time-series collection created for the sensor data
+// The metaField holds object and port, so each bucket belongs to a single port
+func timeSeriesOptions(granularity string) *options.CreateCollectionOptions {
+	return options.CreateCollection().SetTimeSeriesOptions(options.TimeSeries().
+		SetTimeField("timestamp").
+		SetMetaField("meta").
+		SetGranularity(granularity))
+}
+
+// collectionType returns the type of a collection, "collection" or "timeseries",
+// or an empty string if it does not exist
+func collectionType(ctx context.Context, db *mongo.Database, name string) (string, error) {
+	specs, err := db.ListCollectionSpecifications(ctx, bson.M{"name": name})
+	if err != nil {
+		return "", err
+	}
+	if len(specs) == 0 {
+		return "", nil
+	}
+	return specs[0].Type, nil
+}
+
+// EnsureSensorCollection makes sure the sensor data collection matches the configured storage
+// With time-series storage enabled a missing collection is created as a time-series
+// collection, and a plain collection is refused with ErrNotTimeSeries since its documents
+// lack the metaField; they can be moved across with MigrateToTimeSeries
+func EnsureSensorCollection(ctx context.Context, client *mongo.Client, cfg config.MongoConfig) error {
+	db := client.Database(cfg.Database)
+	kind, err := collectionType(ctx, db, cfg.Collection)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case cfg.TimeSeries && kind == "":
+		if err := db.CreateCollection(ctx, cfg.Collection, timeSeriesOptions(cfg.Granularity)); err != nil {
+			return fmt.Errorf("creating time-series collection %s: %w", cfg.Collection, err)
+		}
+		log.Printf("Created time-series collection %s with %s granularity", cfg.Collection, cfg.Granularity)
+	case cfg.TimeSeries && kind != "timeseries":
+		return fmt.Errorf("%s: %w, run the migrate-timeseries command first", cfg.Collection, ErrNotTimeSeries)
+	case !cfg.TimeSeries && kind == "timeseries":
+		return fmt.Errorf("%s is a time-series collection, set mongo.time_series to use it", cfg.Collection)
+	}
+	return nil
+}
+
+// MigrateToTimeSeries moves the documents of a plain sensor data collection into a new
+// time-series collection of the same name, batchSize documents at a time
+// The plain collection is renamed first and kept as a backup, so the data is never
+// lost; its name is returned together with the number of documents copied
+// Cancelling ctx stops the copy between batches
+func MigrateToTimeSeries(ctx context.Context, client *mongo.Client, cfg config.MongoConfig, batchSize int) (string, int64, error) {
+	db := client.Database(cfg.Database)
+	kind, err := collectionType(ctx, db, cfg.Collection)
+	if err != nil {
+		return "", 0, err
+	}
+	switch kind {
+	case "timeseries":
+		return "", 0, fmt.Errorf("%s is already a time-series collection", cfg.Collection)
+	case "":
+		err := db.CreateCollection(ctx, cfg.Collection, timeSeriesOptions(cfg.Granularity))
+		return "", 0, err
+	}
+
+	// Time-series collections cannot be renamed, so the plain one makes way instead
+	backup := cfg.Collection + "_backup_" + time.Now().UTC().Format("20060102150405")
+	err = client.Database("admin").RunCommand(ctx, bson.D{
+		{Key: "renameCollection", Value: cfg.Database + "." + cfg.Collection},
+		{Key: "to", Value: cfg.Database + "." + backup},
+	}).Err()
+	if err != nil {
+		return "", 0, fmt.Errorf("renaming %s to %s: %w", cfg.Collection, backup, err)
+	}
+	log.Printf("Renamed %s to %s", cfg.Collection, backup)
+
+	if err := db.CreateCollection(ctx, cfg.Collection, timeSeriesOptions(cfg.Granularity)); err != nil {
+		return backup, 0, fmt.Errorf("creating time-series collection %s: %w", cfg.Collection, err)
+	}
+
+	cursor, err := db.Collection(backup).Find(ctx, bson.M{}, options.Find().SetBatchSize(int32(batchSize)))
+	if err != nil {
+		return backup, 0, err
+	}
+	defer cursor.Close(context.WithoutCancel(ctx))
+
+	target := db.Collection(cfg.Collection)
+	var copied int64
+	batch := make([]interface{}, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if _, err := target.InsertMany(ctx, batch, options.InsertMany().SetOrdered(false)); err != nil {
+			return fmt.Errorf("copying documents into %s: %w", cfg.Collection, err)
+		}
+		copied += int64(len(batch))
+		log.Printf("Copied %d documents into %s", copied, cfg.Collection)
+		batch = batch[:0]
+		return nil
+	}
+
+	for cursor.Next(ctx) {
+		// Documents are copied as they are, keeping their _id, with the metaField added
+		var document bson.M
+		if err := cursor.Decode(&document); err != nil {
+			return backup, copied, err
+		}
+		document["meta"] = bson.M{"object_id": document["object_id"], "port_num": document["port_num"]}
+		batch = append(batch, document)
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return backup, copied, err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return backup, copied, err
+	}
+	return backup, copied, flush()
+}
+
+// saveTimeSeries writes a batch of records to a time-series collection
+// Time-series collections do not support unique indexes, so records that are already
+// stored are looked up first and onDuplicate is applied to them here; records repeated
+// within the batch count as duplicates of their first occurrence
+// This is best-effort: writers saving the same records at the same time can both find
+// them missing and store them twice
+// Upsert inserts the new records before deleting the ones they replace, so a failure
+// leaves the old and maybe the new records stored but never loses one; saving the
+// batch again removes the extra copies
+func (r RepositoryDefault) saveTimeSeries(ctx context.Context, data []model.SensorData, onDuplicate string) (model.SaveResult, error) {
+	collection := r.collection()
+
+	stored, err := r.storedKeys(ctx, data)
+	if err != nil {
+		return model.SaveResult{}, err
+	}
+
+	// Keep one record per key; on upsert the last one wins, like consecutive replaces
+	records := make(map[recordKey]model.SensorData, len(data))
+	order := make([]recordKey, 0, len(data))
+	var existing []recordKey
+	var replaced bson.A // IDs of the stored records that upsert replaces
+	for _, item := range data {
+		key := keyOf(item)
+		if _, seen := records[key]; !seen {
+			order = append(order, key)
+			if ids, ok := stored[key]; ok {
+				existing = append(existing, key)
+				replaced = append(replaced, ids...)
+			}
+		} else if onDuplicate != model.DuplicateUpsert {
+			continue
+		}
+		item.Meta = &model.SensorMeta{ObjectID: item.ObjectID, PortNum: item.PortNum}
+		records[key] = item
+	}
+	duplicates := int64(len(data) - len(order) + len(existing))
+
+	switch onDuplicate {
+	case model.DuplicateError:
+		if duplicates > 0 {
+			return model.SaveResult{}, fmt.Errorf("%d of %d records are already stored or repeated", duplicates, len(data))
+		}
+	case model.DuplicateUpsert:
+		// The replaced records are deleted once the new ones are stored
+	default:
+		// Skip: stored records are left alone and not written again
+		kept := order[:0]
+		for _, key := range order {
+			if _, ok := stored[key]; !ok {
+				kept = append(kept, key)
+			}
+		}
+		order = kept
+	}
+
+	documents := make([]interface{}, 0, len(order))
+	for _, key := range order {
+		documents = append(documents, records[key])
+	}
+	inserted := int64(len(documents))
+	if onDuplicate == model.DuplicateUpsert {
+		inserted -= int64(len(existing))
+	}
+	if len(documents) == 0 {
+		return model.SaveResult{Inserted: inserted, Duplicates: duplicates}, nil
+	}
+
+	log.Printf("Inserting %d documents to time-series collection", len(documents))
+	if _, err := collection.InsertMany(ctx, documents); err != nil {
+		log.Printf("Error inserting documents: %v", err)
+		return model.SaveResult{}, err
+	}
+	if len(replaced) > 0 {
+		// Deleting measurements by _id needs MongoDB 7.0 or later
+		if _, err := collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": replaced}}); err != nil {
+			log.Printf("Error removing %d replaced documents: %v", len(replaced), err)
+			return model.SaveResult{}, err
+		}
+	}
+	log.Printf("Successfully inserted %d documents, %d duplicates", inserted, duplicates)
+	return model.SaveResult{Inserted: inserted, Duplicates: duplicates}, nil
+}
+
+// storedKeys returns the keys of the records in data that are already stored, with the
+// IDs of the documents holding them
+// One query covers the objects and the time span of the whole batch
+func (r RepositoryDefault) storedKeys(ctx context.Context, data []model.SensorData) (map[recordKey][]interface{}, error) {
+	objects := make(map[float64]bool)
+	from, to := data[0].Timestamp, data[0].Timestamp
+	for _, item := range data {
+		objects[item.ObjectID] = true
+		if item.Timestamp.Before(from) {
+			from = item.Timestamp
+		}
+		if item.Timestamp.After(to) {
+			to = item.Timestamp
+		}
+	}
+	objectIDs := make(bson.A, 0, len(objects))
+	for id := range objects {
+		objectIDs = append(objectIDs, id)
+	}
+
+	filter := bson.M{
+		"meta.object_id": bson.M{"$in": objectIDs},
+		"timestamp":      bson.M{"$gte": from.Truncate(time.Millisecond), "$lte": to},
+	}
+	projection := bson.M{"_id": 1, "object_id": 1, "port_num": 1, "timestamp": 1}
+	cursor, err := r.collection().Find(ctx, filter, options.Find().SetProjection(projection))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.WithoutCancel(ctx))
+
+	stored := make(map[recordKey][]interface{})
+	for cursor.Next(ctx) {
+		var item struct {
+			ID        interface{} `bson:"_id"`
+			ObjectID  float64     `bson:"object_id"`
+			PortNum   float64     `bson:"port_num"`
+			Timestamp time.Time   `bson:"timestamp"`
+		}
+		if err := cursor.Decode(&item); err != nil {
+			return nil, err
+		}
+		key := keyOf(model.SensorData{ObjectID: item.ObjectID, PortNum: item.PortNum, Timestamp: item.Timestamp})
+		stored[key] = append(stored[key], item.ID)
+	}
+	return stored, cursor.Err()
+}
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gomongoviz/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrWatchUnsupported is returned by WatchData when the storage cannot push new records,
+// in which case callers poll for them instead
+var ErrWatchUnsupported = errors.New("watching for new records is not supported by this storage")
+
+// DataWatch delivers the sensor data records inserted after it was opened, in insertion order
+type DataWatch interface {
+	// Next waits for the next inserted record until ctx is cancelled
+	Next(ctx context.Context) (*model.SensorData, error)
+	// Close stops the watch and releases its resources
+	Close(ctx context.Context) error
+}
+
+// WatchData opens a change stream on the sensor data collection delivering every record
+// inserted for the object and port of the query; an empty ObjectID watches every object
+// Change streams need a replica set or a sharded cluster and are not available on
+// time-series collections, in both cases ErrWatchUnsupported is returned
+func (r RepositoryDefault) WatchData(ctx context.Context, query model.SensorDataQuery) (DataWatch, error) {
+	if r.TimeSeries {
+		return nil, fmt.Errorf("%w: time-series collections have no change streams", ErrWatchUnsupported)
+	}
+
+	match := bson.M{"operationType": "insert"}
+	if query.ObjectID != "" {
+		objectID, err := strconv.ParseFloat(query.ObjectID, 64)
+		if err != nil {
+			return nil, err
+		}
+		match["fullDocument.object_id"] = objectID
+	}
+	if query.PortNum != "" {
+		portNum, err := strconv.ParseFloat(query.PortNum, 64)
+		if err != nil {
+			return nil, err
+		}
+		match["fullDocument.port_num"] = portNum
+	}
+
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: match}}}
+	stream, err := r.collection().Watch(ctx, pipeline, options.ChangeStream())
+	if err != nil {
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) && (cmdErr.Code == 40573 || strings.Contains(cmdErr.Message, "replica sets")) {
+			return nil, fmt.Errorf("%w: %s", ErrWatchUnsupported, cmdErr.Message)
+		}
+		return nil, err
+	}
+	return &changeStreamWatch{stream: stream}, nil
+}
+
+// changeStreamWatch is a DataWatch reading a MongoDB change stream
+// The driver resumes the stream by itself after transient errors
+type changeStreamWatch struct {
+	stream *mongo.ChangeStream
+}
+
+// Next waits for the next insert event and returns the inserted document
+func (w *changeStreamWatch) Next(ctx context.Context) (*model.SensorData, error) {
+	if !w.stream.Next(ctx) {
+		if err := w.stream.Err(); err != nil {
+			return nil, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("change stream closed")
+	}
+
+	var event struct {
+		FullDocument model.SensorData `bson:"fullDocument"`
+	}
+	if err := w.stream.Decode(&event); err != nil {
+		return nil, err
+	}
+	return &event.FullDocument, nil
+}
+
+// Close closes the change stream on the server
+func (w *changeStreamWatch) Close(ctx context.Context) error {
+	return w.stream.Close(ctx)
+}
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"gomongoviz/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SaveWebhookDelivery creates or replaces a webhook delivery
+func (r RepositoryDefault) SaveWebhookDelivery(ctx context.Context, delivery model.WebhookDelivery) error {
+	collection := r.Client.Database(r.Database).Collection(r.DeliveriesCollection)
+	ctx, cancel := withTimeout(ctx, r.WriteTimeout)
+	defer cancel()
+
+	_, err := collection.ReplaceOne(ctx, bson.M{"_id": delivery.ID}, delivery, options.Replace().SetUpsert(true))
+	return err
+}
+
+// GetWebhookDelivery retrieves a webhook delivery by ID
+// It returns ErrNotFound if no delivery has that ID
+func (r RepositoryDefault) GetWebhookDelivery(ctx context.Context, id string) (*model.WebhookDelivery, error) {
+	collection := r.Client.Database(r.Database).Collection(r.DeliveriesCollection)
+	ctx, cancel := withTimeout(ctx, r.QueryTimeout)
+	defer cancel()
+
+	var delivery model.WebhookDelivery
+	err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&delivery)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// ListWebhookDeliveries retrieves the webhook deliveries matching query, newest first
+func (r RepositoryDefault) ListWebhookDeliveries(ctx context.Context, query model.WebhookDeliveryQuery) ([]model.WebhookDelivery, error) {
+	collection := r.Client.Database(r.Database).Collection(r.DeliveriesCollection)
+	ctx, cancel := withTimeout(ctx, r.QueryTimeout)
+	defer cancel()
+
+	filter := bson.M{}
+	if len(query.States) > 0 {
+		filter["state"] = bson.M{"$in": query.States}
+	}
+	if query.Endpoint != "" {
+		filter["endpoint"] = query.Endpoint
+	}
+	if query.Event != "" {
+		filter["event"] = query.Event
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+	if query.Limit > 0 {
+		opts.SetLimit(query.Limit)
+	}
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.WithoutCancel(ctx))
+
+	results := make([]model.WebhookDelivery, 0)
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gomongoviz/model"
+)
+
+// intervalUnits maps the unit suffix of an interval to the $dateTrunc unit
+var intervalUnits = map[string]string{
+	"m": "minute",
+	"h": "hour",
+	"d": "day",
+	"w": "week",
+}
+
+// intervalPattern matches intervals such as "15m", "1h" or "7d"
+var intervalPattern = regexp.MustCompile(`^(\d+)([mhdw])$`)
+
+// ParseAggregateOptions parses the interval, fields and functions query parameters into the query
+// interval accepts "minute", "hour", "day", "week", "month" or a count with a unit such as "15m" or "6h"
+// functions defaults to "avg,min,max,count" when empty
+func ParseAggregateOptions(interval string, fields string, functions string, query *model.AggregateQuery) error {
+	if err := parseInterval(interval, query); err != nil {
+		return err
+	}
+
+	parsed, err := parseFieldList(fields)
+	if err != nil {
+		return err
+	}
+	query.Fields = parsed
+
+	if strings.TrimSpace(functions) == "" {
+		functions = "avg,min,max,count"
+	}
+	for _, function := range strings.Split(functions, ",") {
+		function = strings.ToLower(strings.TrimSpace(function))
+		if function == "median" {
+			function = "p50"
+		}
+		switch {
+		case function == "":
+			continue
+		case function == "count", function == "sum", function == "avg", function == "min", function == "max", function == "stddev":
+		default:
+			if _, ok := model.PercentileOf(function); !ok {
+				return fmt.Errorf("unknown function %q: use count, sum, avg, min, max, stddev, median or pNN", function)
+			}
+		}
+		query.Functions = append(query.Functions, function)
+	}
+
+	return nil
+}
+
+// parseInterval sets the bucket unit and bin size from the interval parameter
+func parseInterval(interval string, query *model.AggregateQuery) error {
+	interval = strings.ToLower(strings.TrimSpace(interval))
+	switch interval {
+	case "":
+		query.Unit, query.BinSize = "hour", 1
+		return nil
+	case "minute", "hour", "day", "week", "month":
+		query.Unit, query.BinSize = interval, 1
+		return nil
+	}
+
+	match := intervalPattern.FindStringSubmatch(interval)
+	if match == nil {
+		return fmt.Errorf("invalid interval %q: use minute, hour, day, week, month or a form like 15m, 6h, 1d", interval)
+	}
+	n, err := strconv.Atoi(match[1])
+	if err != nil || n <= 0 {
+		return fmt.Errorf("invalid interval %q: count must be positive", interval)
+	}
+	query.Unit, query.BinSize = intervalUnits[match[2]], n
+	return nil
+}
@@ -0,0 +1,499 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"gomongoviz/model"
+)
+
+// ErrInvalidRule wraps the problems found in an alert rule sent by a client
+var ErrInvalidRule = errors.New("invalid alert rule")
+
+// alertEngine holds the enabled alert rules and the state of their alerts
+// Alert states are read from the repository once and then kept in memory, so readings
+// can be evaluated as they are saved without a query per reading
+type alertEngine struct {
+	mu      sync.Mutex
+	idle    *sync.Cond              // Signalled on mu when a save or reset ends
+	loaded  bool                    // Rules and alerts have been read from the repository
+	saving  bool                    // A save or reset is writing to the repository
+	rules   []model.AlertRule       // Enabled rules
+	alerts  map[string]*model.Alert // Alert states by ID
+	dirty   map[string]bool         // Alerts changed since they were last saved
+	pending []model.AlertEvent      // Events not saved yet
+}
+
+// newAlertEngine returns an engine with no rules, to be loaded by StartAlerts
+func newAlertEngine() *alertEngine {
+	e := &alertEngine{alerts: make(map[string]*model.Alert), dirty: make(map[string]bool)}
+	e.idle = sync.NewCond(&e.mu)
+	return e
+}
+
+// CreateAlertRule validates and stores a new alert rule
+func (s *Service) CreateAlertRule(ctx context.Context, rule model.AlertRule) (*model.AlertRule, error) {
+	if err := rule.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidRule, err)
+	}
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	rule.ID = id
+	rule.CreatedAt = time.Now()
+	rule.UpdatedAt = rule.CreatedAt
+	if err := s.repo.SaveAlertRule(ctx, rule); err != nil {
+		return nil, err
+	}
+	s.alerts.setRule(rule)
+	log.Printf("Created alert rule %s %q: %s %s %g", rule.ID, rule.Name, rule.Field, rule.Operator, rule.Threshold)
+	return &rule, nil
+}
+
+// UpdateAlertRule replaces an alert rule, keeping its ID and creation time
+// The alerts of the rule start again from inactive, as their state was computed with
+// the previous condition
+// It returns repository.ErrNotFound if no rule has that ID
+func (s *Service) UpdateAlertRule(ctx context.Context, id string, rule model.AlertRule) (*model.AlertRule, error) {
+	if err := rule.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidRule, err)
+	}
+	existing, err := s.repo.GetAlertRule(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	rule.ID = existing.ID
+	rule.CreatedAt = existing.CreatedAt
+	rule.UpdatedAt = time.Now()
+	if err := s.repo.SaveAlertRule(ctx, rule); err != nil {
+		return nil, err
+	}
+	if err := s.resetAlerts(ctx, id); err != nil {
+		return nil, err
+	}
+	s.alerts.setRule(rule)
+	return &rule, nil
+}
+
+// DeleteAlertRule removes an alert rule and the state of its alerts
+// Its events are kept in the alert history
+// It returns repository.ErrNotFound if no rule has that ID
+func (s *Service) DeleteAlertRule(ctx context.Context, id string) error {
+	if err := s.repo.DeleteAlertRule(ctx, id); err != nil {
+		return err
+	}
+	return s.resetAlerts(ctx, id)
+}
+
+// GetAlertRule retrieves an alert rule by ID
+func (s *Service) GetAlertRule(ctx context.Context, id string) (*model.AlertRule, error) {
+	return s.repo.GetAlertRule(ctx, id)
+}
+
+// ListAlertRules retrieves every alert rule, oldest first
+func (s *Service) ListAlertRules(ctx context.Context) ([]model.AlertRule, error) {
+	return s.repo.ListAlertRules(ctx)
+}
+
+// ListAlerts retrieves the state of alerts, optionally of one rule and in some states
+func (s *Service) ListAlerts(ctx context.Context, ruleID string, states []string) ([]model.Alert, error) {
+	return s.repo.ListAlerts(ctx, ruleID, states)
+}
+
+// ListAlertEvents retrieves the history of alerts firing and resolving, newest first
+func (s *Service) ListAlertEvents(ctx context.Context, query model.AlertEventQuery) ([]model.AlertEvent, error) {
+	return s.repo.ListAlertEvents(ctx, query)
+}
+
+// resetAlerts forgets the state of the alerts of a rule, stored and in memory
+// The states in memory are dropped under s.alerts.mu and the stored ones deleted without
+// it, so readings are evaluated meanwhile; the reset takes the place of a save, so no
+// save can store the old states again while it deletes them, and it saves the changes
+// made meanwhile once done
+func (s *Service) resetAlerts(ctx context.Context, ruleID string) error {
+	e := s.alerts
+	e.mu.Lock()
+	for e.saving {
+		e.idle.Wait()
+	}
+	e.saving = true
+	e.rules = deleteRule(e.rules, ruleID)
+	for id, alert := range e.alerts {
+		if alert.RuleID == ruleID {
+			delete(e.alerts, id)
+			delete(e.dirty, id)
+		}
+	}
+	e.mu.Unlock()
+
+	err := s.repo.DeleteAlerts(ctx, ruleID)
+
+	e.mu.Lock()
+	e.saving = false
+	e.idle.Broadcast()
+	e.mu.Unlock()
+	s.saveAlerts(ctx)
+	return err
+}
+
+// StartAlerts loads the alert rules and states, then evaluates the readings stored since
+// the last evaluation every alerts.interval
+// Readings saved through the service, imports included, are evaluated as they are saved;
+// the periodic evaluation catches those written by other processes
+func (s *Service) StartAlerts(ctx context.Context) error {
+	rules, err := s.repo.ListAlertRules(ctx)
+	if err != nil {
+		return err
+	}
+	alerts, err := s.repo.ListAlerts(ctx, "", nil)
+	if err != nil {
+		return err
+	}
+
+	e := s.alerts
+	e.mu.Lock()
+	e.rules = enabledRules(rules)
+	for i := range alerts {
+		e.alerts[alerts[i].ID] = &alerts[i]
+	}
+	e.loaded = true
+	e.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(s.alertsCfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := s.evaluateStoredData(ctx); err != nil {
+				log.Printf("Error evaluating alert rules: %v", err)
+			}
+		}
+	}()
+	log.Printf("Evaluating %d alert rules, stored data every %s", len(e.rules), s.alertsCfg.Interval)
+	return nil
+}
+
+// evaluateAlerts advances the alerts of every enabled rule with readings that were just
+// saved
+// Failures are logged; the readings are stored either way
+func (s *Service) evaluateAlerts(ctx context.Context, data []model.SensorData) {
+	e := s.alerts
+	e.mu.Lock()
+	if !e.loaded || len(e.rules) == 0 {
+		e.mu.Unlock()
+		return
+	}
+
+	// For-durations are measured between reading timestamps, so readings are evaluated
+	// in time order whatever order they were sent in
+	for _, record := range byTimestamp(data) {
+		for _, rule := range e.rules {
+			e.step(rule, record)
+		}
+	}
+	e.mu.Unlock()
+	s.saveAlerts(ctx)
+}
+
+// byTimestamp returns pointers to the readings in timestamp order, keeping the order of
+// readings with the same timestamp
+func byTimestamp(data []model.SensorData) []*model.SensorData {
+	records := make([]*model.SensorData, len(data))
+	for i := range data {
+		records[i] = &data[i]
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Timestamp.Before(records[j].Timestamp) })
+	return records
+}
+
+// evaluateStoredData advances the alerts of every enabled rule with the readings stored
+// after the last one each alert has evaluated
+// A series the rule has not seen yet starts from the time the rule was last changed,
+// less its for-duration
+func (s *Service) evaluateStoredData(ctx context.Context) error {
+	rules, err := s.repo.ListAlertRules(ctx)
+	if err != nil {
+		return err
+	}
+	e := s.alerts
+	e.mu.Lock()
+	e.rules = enabledRules(rules)
+	rules = e.rules
+	e.mu.Unlock()
+
+	var allObjects []model.ObjectInfo
+	for _, rule := range rules {
+		objects := []float64{}
+		if rule.ObjectID != nil {
+			objects = append(objects, *rule.ObjectID)
+		} else {
+			if allObjects == nil {
+				if allObjects, err = s.repo.GetUniqueObjectIDs(ctx); err != nil {
+					return err
+				}
+			}
+			for _, object := range allObjects {
+				objects = append(objects, object.ObjectID)
+			}
+		}
+
+		for _, objectID := range objects {
+			ports := []float64{}
+			if rule.PortNum != nil {
+				ports = append(ports, *rule.PortNum)
+			} else {
+				infos, err := s.repo.GetPorts(ctx, int(objectID))
+				if err != nil {
+					return err
+				}
+				for _, info := range infos {
+					ports = append(ports, info.PortNum)
+				}
+			}
+			for _, portNum := range ports {
+				if err := s.evaluateSeries(ctx, rule, objectID, portNum); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	s.saveAlerts(ctx)
+	return nil
+}
+
+// evaluateSeries advances the alert of a rule for one object and port with the readings
+// stored after the last one it has evaluated
+func (s *Service) evaluateSeries(ctx context.Context, rule model.AlertRule, objectID float64, portNum float64) error {
+	e := s.alerts
+	e.mu.Lock()
+	from := rule.UpdatedAt.Add(-time.Duration(rule.For))
+	if alert, ok := e.alerts[model.AlertID(rule.ID, objectID, portNum)]; ok {
+		from = alert.LastTimestamp
+	}
+	e.mu.Unlock()
+
+	query := model.SensorDataQuery{
+		ObjectID:   strconv.FormatFloat(objectID, 'f', -1, 64),
+		PortNum:    strconv.FormatFloat(portNum, 'f', -1, 64),
+		From:       from,
+		Limit:      liveBatchSize,
+		Projection: []string{rule.Field},
+	}
+	_, err := s.sendAfter(ctx, query, nil, func(event LiveEvent) error {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		// The rule may have been changed or deleted meanwhile
+		if i := ruleIndex(e.rules, rule.ID); i >= 0 && e.rules[i].UpdatedAt.Equal(rule.UpdatedAt) {
+			e.step(rule, event.Data)
+		}
+		return nil
+	})
+	return err
+}
+
+// step advances the alert of a rule for the object and port of a reading
+// Readings not newer than the last one evaluated for the alert are ignored, so a
+// reading saved again or read again by the periodic evaluation counts once
+// The caller must hold e.mu
+func (e *alertEngine) step(rule model.AlertRule, record *model.SensorData) {
+	if !rule.Matches(record) {
+		return
+	}
+	value, ok := record.NumericValue(rule.Field)
+	if !ok {
+		return
+	}
+
+	id := model.AlertID(rule.ID, record.ObjectID, record.PortNum)
+	alert, ok := e.alerts[id]
+	if !ok {
+		alert = &model.Alert{ID: id, RuleID: rule.ID, ObjectID: record.ObjectID, PortNum: record.PortNum, State: model.AlertInactive}
+		e.alerts[id] = alert
+	}
+	if !record.Timestamp.After(alert.LastTimestamp) {
+		return
+	}
+	alert.Value = value
+	alert.LastTimestamp = record.Timestamp
+	e.dirty[id] = true
+
+	switch alert.State {
+	case model.AlertFiring:
+		// Values between the threshold and the hysteresis margin keep the alert firing
+		if rule.Recovered(value) {
+			alert.State = model.AlertResolved
+			alert.ResolvedAt = record.Timestamp
+			alert.Since = time.Time{}
+			e.record(rule, alert)
+		}
+		return
+	case model.AlertPending:
+		if !rule.Breached(value) {
+			alert.State = model.AlertInactive
+			alert.Since = time.Time{}
+			return
+		}
+	default:
+		if !rule.Breached(value) {
+			return
+		}
+		alert.State = model.AlertPending
+		alert.Since = record.Timestamp
+	}
+
+	if record.Timestamp.Sub(alert.Since) >= time.Duration(rule.For) {
+		alert.State = model.AlertFiring
+		alert.FiredAt = record.Timestamp
+		e.record(rule, alert)
+	}
+}
+
+// record queues the event of an alert that has just fired or resolved
+// The caller must hold e.mu
+func (e *alertEngine) record(rule model.AlertRule, alert *model.Alert) {
+	id, err := newID()
+	if err != nil {
+		log.Printf("Error recording alert event of %s: %v", alert.ID, err)
+		return
+	}
+	e.pending = append(e.pending, model.AlertEvent{
+		ID:        id,
+		AlertID:   alert.ID,
+		RuleID:    rule.ID,
+		RuleName:  rule.Name,
+		ObjectID:  alert.ObjectID,
+		PortNum:   alert.PortNum,
+		Field:     rule.Field,
+		Operator:  rule.Operator,
+		Threshold: rule.Threshold,
+		State:     alert.State,
+		Value:     alert.Value,
+		Timestamp: alert.LastTimestamp,
+		CreatedAt: time.Now(),
+	})
+	log.Printf("Alert %q %s on object %g port %g: %s = %g", rule.Name, alert.State, alert.ObjectID, alert.PortNum, rule.Field, alert.Value)
+}
+
+// saveAlerts stores the alerts changed and the events recorded since the last save, and
+// notifies the events stored
+// The changes are taken under s.alerts.mu and written without it, so evaluation goes on
+// during the writes; one save runs at a time so older states never overwrite newer ones,
+// and a save started meanwhile leaves its changes to the running one
+// What cannot be stored is kept and tried again on the next save
+func (s *Service) saveAlerts(ctx context.Context) {
+	e := s.alerts
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.saving {
+		return
+	}
+	e.saving = true
+	defer e.idle.Broadcast()
+
+	for len(e.dirty) > 0 || len(e.pending) > 0 {
+		alerts := make([]model.Alert, 0, len(e.dirty))
+		for id := range e.dirty {
+			if alert, ok := e.alerts[id]; ok {
+				alerts = append(alerts, *alert)
+			}
+		}
+		clear(e.dirty)
+		events := e.pending
+		e.pending = nil
+		e.mu.Unlock()
+
+		var alertsErr, eventsErr error
+		if len(alerts) > 0 {
+			if alertsErr = s.repo.SaveAlerts(ctx, alerts); alertsErr != nil {
+				log.Printf("Error saving %d alert states: %v", len(alerts), alertsErr)
+			}
+		}
+		if len(events) > 0 {
+			if eventsErr = s.repo.SaveAlertEvents(ctx, events); eventsErr != nil {
+				log.Printf("Error saving %d alert events: %v", len(events), eventsErr)
+			} else {
+				s.notifyAlertEvents(events)
+			}
+		}
+
+		e.mu.Lock()
+		if alertsErr != nil {
+			for _, alert := range alerts {
+				if _, ok := e.alerts[alert.ID]; ok {
+					e.dirty[alert.ID] = true
+				}
+			}
+		}
+		if eventsErr != nil {
+			e.pending = append(events, e.pending...)
+		}
+		if alertsErr != nil || eventsErr != nil {
+			break
+		}
+	}
+	e.saving = false
+}
+
+// notifyAlertEvents queues the webhook notifications of stored alert events
+// Queueing writes to the repository, so it must not hold s.alerts.mu
+func (s *Service) notifyAlertEvents(events []model.AlertEvent) {
+	for _, event := range events {
+		name := model.EventAlertFiring
+		if event.State == model.AlertResolved {
+			name = model.EventAlertResolved
+		}
+		s.notify(name, event)
+	}
+}
+
+// setRule adds or replaces a rule in the evaluated rules, or removes it if disabled
+func (e *alertEngine) setRule(rule model.AlertRule) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rules = deleteRule(e.rules, rule.ID)
+	if !rule.Disabled {
+		e.rules = append(e.rules, rule)
+	}
+}
+
+// enabledRules returns the rules that are not disabled
+func enabledRules(rules []model.AlertRule) []model.AlertRule {
+	enabled := make([]model.AlertRule, 0, len(rules))
+	for _, rule := range rules {
+		if !rule.Disabled {
+			enabled = append(enabled, rule)
+		}
+	}
+	return enabled
+}
+
+// ruleIndex returns the position of the rule with an ID, or -1
+func ruleIndex(rules []model.AlertRule, id string) int {
+	for i, rule := range rules {
+		if rule.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// deleteRule returns rules without the one with an ID, leaving the input unchanged
+func deleteRule(rules []model.AlertRule, id string) []model.AlertRule {
+	i := ruleIndex(rules, id)
+	if i < 0 {
+		return rules
+	}
+	return append(rules[:i:i], rules[i+1:]...)
+}
@@ -0,0 +1,204 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gomongoviz/config"
+	"gomongoviz/model"
+	"gomongoviz/repository"
+)
+
+// alertStart is the time of the first reading of the alert tests
+var alertStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newAlertService returns a service on repo evaluating a rule firing when the voltage of
+// object 1 stays above 20 V for two minutes, and resolving once it is 17 V or less
+func newAlertService(t *testing.T, repo repository.Repository) (*Service, *model.AlertRule) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	s := NewService(repo, config.Default())
+	rules, err := s.ListAlertRules(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rule *model.AlertRule
+	if len(rules) > 0 {
+		rule = &rules[0]
+	} else {
+		objectID := 1.0
+		rule, err = s.CreateAlertRule(ctx, model.AlertRule{
+			Name:       "overvoltage",
+			ObjectID:   &objectID,
+			Field:      "voltage",
+			Operator:   ">",
+			Threshold:  20,
+			For:        model.Duration(2 * time.Minute),
+			Hysteresis: 3,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.StartAlerts(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return s, rule
+}
+
+// saveVoltages saves readings of object 1 port 1, the voltages given by minute
+func saveVoltages(t *testing.T, s *Service, onDuplicate string, voltages map[int]float64) {
+	t.Helper()
+	var data []model.SensorData
+	for minute, voltage := range voltages {
+		data = append(data, model.SensorData{Timestamp: alertStart.Add(time.Duration(minute) * time.Minute), ObjectID: 1, PortNum: 1, Voltage: voltage})
+	}
+	if _, err := s.SaveSensorData(context.Background(), data, onDuplicate); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// storedAlert returns the only alert stored for a rule
+func storedAlert(t *testing.T, s *Service, ruleID string) model.Alert {
+	t.Helper()
+	alerts, err := s.ListAlerts(context.Background(), ruleID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != 1 {
+		t.Fatalf("got %d alerts, want 1", len(alerts))
+	}
+	return alerts[0]
+}
+
+func TestAlertTransitions(t *testing.T) {
+	s, rule := newAlertService(t, repository.NewMemoryRepository())
+
+	steps := []struct {
+		name        string
+		onDuplicate string
+		voltages    map[int]float64
+		state       string
+		since       int // Minute the condition has held from, or -1
+	}{
+		{name: "below the threshold", voltages: map[int]float64{0: 10}, state: model.AlertInactive, since: -1},
+		{name: "breached", voltages: map[int]float64{1: 21}, state: model.AlertPending, since: 1},
+		{name: "breached for less than the duration", voltages: map[int]float64{2: 22}, state: model.AlertPending, since: 1},
+		{name: "breached for the duration", voltages: map[int]float64{3: 23}, state: model.AlertFiring, since: 1},
+		// 19 V is below the threshold but within the hysteresis margin
+		{name: "within the hysteresis", voltages: map[int]float64{4: 19}, state: model.AlertFiring, since: 1},
+		{name: "older reading", voltages: map[int]float64{2: 5}, state: model.AlertFiring, since: 1},
+		{name: "recovered", voltages: map[int]float64{5: 17}, state: model.AlertResolved, since: -1},
+		{name: "reading saved again", onDuplicate: model.DuplicateUpsert, voltages: map[int]float64{5: 30}, state: model.AlertResolved, since: -1},
+		// A batch is evaluated in time order, so the breach at minute 8 has held since 6
+		{name: "batch", voltages: map[int]float64{8: 26, 6: 24, 7: 25}, state: model.AlertFiring, since: 6},
+	}
+	for _, step := range steps {
+		onDuplicate := step.onDuplicate
+		if onDuplicate == "" {
+			onDuplicate = model.DuplicateSkip
+		}
+		saveVoltages(t, s, onDuplicate, step.voltages)
+		alert := storedAlert(t, s, rule.ID)
+		since := time.Time{}
+		if step.since >= 0 {
+			since = alertStart.Add(time.Duration(step.since) * time.Minute)
+		}
+		if alert.State != step.state || !alert.Since.Equal(since) {
+			t.Fatalf("%s: got %s since %s, want %s since %s", step.name, alert.State, alert.Since, step.state, since)
+		}
+	}
+
+	alert := storedAlert(t, s, rule.ID)
+	if !alert.FiredAt.Equal(alertStart.Add(8*time.Minute)) || !alert.ResolvedAt.Equal(alertStart.Add(5*time.Minute)) {
+		t.Errorf("got fired at %s and resolved at %s, want minutes 8 and 5", alert.FiredAt, alert.ResolvedAt)
+	}
+	if alert.Value != 26 || !alert.LastTimestamp.Equal(alertStart.Add(8*time.Minute)) {
+		t.Errorf("got %g V at %s, want the reading of minute 8", alert.Value, alert.LastTimestamp)
+	}
+}
+
+func TestAlertPersistence(t *testing.T) {
+	repo := repository.NewMemoryRepository()
+	s, rule := newAlertService(t, repo)
+	saveVoltages(t, s, model.DuplicateSkip, map[int]float64{0: 21, 1: 22, 2: 23, 3: 10})
+
+	// The firing and the resolution are stored, newest first
+	events, err := s.ListAlertEvents(context.Background(), model.AlertEventQuery{RuleID: rule.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+	if events[0].State != model.AlertResolved || events[0].Value != 10 || !events[0].Timestamp.Equal(alertStart.Add(3*time.Minute)) {
+		t.Errorf("got event %+v, want the resolution at minute 3", events[0])
+	}
+	if events[1].State != model.AlertFiring || events[1].Value != 23 || events[1].RuleName != rule.Name {
+		t.Errorf("got event %+v, want the firing of %q at 23 V", events[1], rule.Name)
+	}
+
+	// Another service on the same storage goes on from the stored state: the breach at
+	// minute 4 is pending, not firing, and the older readings are ignored
+	saveVoltages(t, s, model.DuplicateSkip, map[int]float64{4: 21})
+	restarted, _ := newAlertService(t, repo)
+	saveVoltages(t, restarted, model.DuplicateSkip, map[int]float64{1: 30, 5: 22})
+	if alert := storedAlert(t, restarted, rule.ID); alert.State != model.AlertPending || !alert.Since.Equal(alertStart.Add(4*time.Minute)) {
+		t.Errorf("got %s since %s, want pending since minute 4", alert.State, alert.Since)
+	}
+	saveVoltages(t, restarted, model.DuplicateSkip, map[int]float64{6: 23})
+	if alert := storedAlert(t, restarted, rule.ID); alert.State != model.AlertFiring {
+		t.Errorf("got %s, want firing", alert.State)
+	}
+}
+
+func TestAlertRuleReset(t *testing.T) {
+	s, rule := newAlertService(t, repository.NewMemoryRepository())
+	ctx := context.Background()
+	saveVoltages(t, s, model.DuplicateSkip, map[int]float64{0: 21, 1: 22, 2: 23})
+	if alert := storedAlert(t, s, rule.ID); alert.State != model.AlertFiring {
+		t.Fatalf("got %s, want firing", alert.State)
+	}
+
+	// Updating the rule forgets the alert, which starts again from the next reading
+	update := *rule
+	update.Threshold = 25
+	if _, err := s.UpdateAlertRule(ctx, rule.ID, update); err != nil {
+		t.Fatal(err)
+	}
+	alerts, err := s.ListAlerts(ctx, rule.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != 0 {
+		t.Fatalf("got %d alerts after the update, want none", len(alerts))
+	}
+	// 24 V no longer breaches the rule
+	saveVoltages(t, s, model.DuplicateSkip, map[int]float64{3: 24})
+	if alert := storedAlert(t, s, rule.ID); alert.State != model.AlertInactive {
+		t.Errorf("got %s, want inactive", alert.State)
+	}
+	saveVoltages(t, s, model.DuplicateUpsert, map[int]float64{1: 30})
+	if alert := storedAlert(t, s, rule.ID); alert.State != model.AlertInactive {
+		t.Errorf("got %s after an older reading, want inactive", alert.State)
+	}
+	saveVoltages(t, s, model.DuplicateSkip, map[int]float64{4: 30})
+	if alert := storedAlert(t, s, rule.ID); alert.State != model.AlertPending {
+		t.Errorf("got %s, want pending", alert.State)
+	}
+
+	// Deleting the rule forgets its alert and stops the evaluation
+	if err := s.DeleteAlertRule(ctx, rule.ID); err != nil {
+		t.Fatal(err)
+	}
+	saveVoltages(t, s, model.DuplicateSkip, map[int]float64{5: 30, 6: 30, 7: 30})
+	alerts, err = s.ListAlerts(ctx, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != 0 {
+		t.Errorf("got %d alerts after the deletion, want none", len(alerts))
+	}
+}
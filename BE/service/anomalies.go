@@ -0,0 +1,497 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gomongoviz/config"
+	"gomongoviz/model"
+)
+
+// Limits applied to the limit parameter of anomaly queries
+const (
+	DefaultAnomalyLimit = 1000
+	MaxAnomalyLimit     = 10000
+)
+
+// Scale factors turning absolute deviations into estimates of the standard deviation of
+// normally distributed values, so every method is read on the same scale
+const (
+	madScale    = 1.4826 // Median absolute deviation
+	meanADScale = 1.2533 // Mean absolute deviation, used when the MAD is zero
+)
+
+// defaultAnomalyThresholds are the usual cut-offs of each method
+// The MAD cut-off follows Iglewicz and Hoaglin's modified z-score
+var defaultAnomalyThresholds = map[string]float64{
+	model.AnomalyZScore: 3,
+	model.AnomalyEWMA:   3,
+	model.AnomalyMAD:    3.5,
+}
+
+// anomalyDetector holds the baselines of the configured fields of every port and the
+// anomalies flagged since the last save
+// Baselines are read from the repository once and then kept in memory, so readings can
+// be scored as they are saved without a query per reading
+type anomalyDetector struct {
+	mu        sync.Mutex
+	cfg       config.AnomaliesConfig       // Fields, method and window of the baselines
+	threshold float64                      // Absolute score above which a reading is flagged
+	loaded    bool                         // Baselines have been read from the repository
+	saving    bool                         // A save is writing to the repository
+	baselines map[anomalyKey]*portBaseline // Baselines by object, port and field
+	dirty     map[anomalyKey]bool          // Baselines changed since they were last saved
+	pending   []model.Anomaly              // Anomalies not saved yet
+}
+
+// newAnomalyDetector returns a detector with no baselines, to be loaded by StartAnomalies
+// A zero threshold is replaced by the usual cut-off of the method
+func newAnomalyDetector(cfg config.AnomaliesConfig) *anomalyDetector {
+	threshold := cfg.Threshold
+	if threshold == 0 {
+		threshold = defaultAnomalyThresholds[cfg.Method]
+	}
+	return &anomalyDetector{
+		cfg:       cfg,
+		threshold: threshold,
+		baselines: make(map[anomalyKey]*portBaseline),
+		dirty:     make(map[anomalyKey]bool),
+	}
+}
+
+// ParseAnomalyOptions parses the fields and limit query parameters into the query
+// fields is optional and limited to stored numeric fields, as only those are scored;
+// limit defaults to DefaultAnomalyLimit
+func ParseAnomalyOptions(fields string, limit string, query *model.AnomalyQuery) error {
+	query.Fields = nil
+	for _, field := range strings.Split(fields, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !model.IsNumericField(field) {
+			return fmt.Errorf("unknown field %q: valid fields are %s", field, strings.Join(model.NumericFieldNames(), ", "))
+		}
+		query.Fields = append(query.Fields, field)
+	}
+
+	query.Limit = DefaultAnomalyLimit
+	if limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > MaxAnomalyLimit {
+			return fmt.Errorf("invalid limit %q: must be between 1 and %d", limit, MaxAnomalyLimit)
+		}
+		query.Limit = int64(n)
+	}
+	return nil
+}
+
+// ListAnomalies returns the anomalies flagged in the readings of an object, oldest first
+// query.Limit must be positive; Truncated reports whether more anomalies follow
+func (s *Service) ListAnomalies(ctx context.Context, query model.AnomalyQuery) (*model.AnomalyRes, error) {
+	if query.Limit <= 0 {
+		return nil, fmt.Errorf("invalid anomaly limit %d: must be positive", query.Limit)
+	}
+	limit := query.Limit
+	query.Limit++
+	anomalies, err := s.repo.ListAnomalies(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	res := &model.AnomalyRes{Anomalies: anomalies}
+	if int64(len(anomalies)) > limit {
+		res.Anomalies = anomalies[:limit]
+		res.Truncated = true
+	}
+	return res, nil
+}
+
+// StartAnomalies loads the anomaly baselines, after which the readings saved through the
+// service are scored as they are saved
+// Stored baselines built with another method or window than configured start again empty
+func (s *Service) StartAnomalies(ctx context.Context) error {
+	d := s.anomalies
+	for _, field := range d.cfg.Fields {
+		if !model.IsNumericField(field) {
+			return fmt.Errorf("anomalies.fields: unknown numeric field %q", field)
+		}
+	}
+	if len(d.cfg.Fields) == 0 {
+		log.Printf("Anomaly detection disabled: anomalies.fields is empty")
+		return nil
+	}
+	stored, err := s.repo.ListAnomalyBaselines(ctx)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	for _, sb := range stored {
+		if sb.Method != d.cfg.Method || sb.Window != d.cfg.Window || !slices.Contains(d.cfg.Fields, sb.Field) {
+			continue
+		}
+		b := newBaseline(d.cfg.Method, d.cfg.Window)
+		b.load(sb)
+		d.baselines[anomalyKey{objectID: sb.ObjectID, portNum: sb.PortNum, field: sb.Field}] = &portBaseline{baseline: b, last: sb.LastTimestamp}
+	}
+	restored := len(d.baselines)
+	d.loaded = true
+	d.mu.Unlock()
+
+	log.Printf("Scoring %s for anomalies with %s baselines of %d readings, %d restored", strings.Join(d.cfg.Fields, ", "), d.cfg.Method, d.cfg.Window, restored)
+	return nil
+}
+
+// evaluateAnomalies scores readings that were just saved against the baselines of their
+// port and field, flags those out of line, then adds them to the baselines
+// Failures are logged; the readings are stored either way
+func (s *Service) evaluateAnomalies(ctx context.Context, data []model.SensorData) {
+	d := s.anomalies
+	d.mu.Lock()
+	if !d.loaded {
+		d.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	for _, record := range byTimestamp(data) {
+		for _, field := range d.cfg.Fields {
+			d.score(record, field, now)
+		}
+	}
+	d.mu.Unlock()
+	s.saveAnomalies(ctx)
+}
+
+// score scores a field of a reading against the baseline of its port, then adds it
+// Readings not newer than the last one added are ignored, so a reading saved again
+// counts once and a late reading does not disturb the baseline
+// The caller must hold d.mu
+func (d *anomalyDetector) score(record *model.SensorData, field string, now time.Time) {
+	value, ok := record.NumericValue(field)
+	if !ok {
+		return
+	}
+	key := anomalyKey{objectID: record.ObjectID, portNum: record.PortNum, field: field}
+	b, ok := d.baselines[key]
+	if !ok {
+		b = &portBaseline{baseline: newBaseline(d.cfg.Method, d.cfg.Window)}
+		d.baselines[key] = b
+	}
+	if !record.Timestamp.After(b.last) {
+		return
+	}
+
+	if center, spread, ok := b.expect(); ok {
+		if score := (value - center) / spread; math.Abs(score) > d.threshold {
+			d.pending = append(d.pending, model.Anomaly{
+				ID:        model.AnomalyID(record.ObjectID, record.PortNum, field, record.Timestamp),
+				ObjectID:  record.ObjectID,
+				PortNum:   record.PortNum,
+				Field:     field,
+				Timestamp: record.Timestamp,
+				Value:     value,
+				Method:    d.cfg.Method,
+				Baseline:  center,
+				Spread:    spread,
+				Score:     score,
+				CreatedAt: now,
+			})
+		}
+	}
+	b.add(value)
+	b.last = record.Timestamp
+	d.dirty[key] = true
+}
+
+// saveAnomalies stores the baselines changed and the anomalies flagged since the last save
+// Like saveAlerts, the changes are taken under s.anomalies.mu and written without it, one
+// save at a time, and what cannot be stored is tried again on the next save
+func (s *Service) saveAnomalies(ctx context.Context) {
+	d := s.anomalies
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.saving {
+		return
+	}
+	d.saving = true
+
+	for len(d.dirty) > 0 || len(d.pending) > 0 {
+		baselines := make([]model.AnomalyBaseline, 0, len(d.dirty))
+		for key := range d.dirty {
+			b := d.baselines[key]
+			stored := model.AnomalyBaseline{
+				ID:            model.AnomalyBaselineID(key.objectID, key.portNum, key.field),
+				ObjectID:      key.objectID,
+				PortNum:       key.portNum,
+				Field:         key.field,
+				Method:        d.cfg.Method,
+				Window:        d.cfg.Window,
+				LastTimestamp: b.last,
+			}
+			b.save(&stored)
+			baselines = append(baselines, stored)
+		}
+		clear(d.dirty)
+		anomalies := d.pending
+		d.pending = nil
+		d.mu.Unlock()
+
+		var baselinesErr, anomaliesErr error
+		if len(baselines) > 0 {
+			if baselinesErr = s.repo.SaveAnomalyBaselines(ctx, baselines); baselinesErr != nil {
+				log.Printf("Error saving %d anomaly baselines: %v", len(baselines), baselinesErr)
+			}
+		}
+		if len(anomalies) > 0 {
+			if anomaliesErr = s.repo.SaveAnomalies(ctx, anomalies); anomaliesErr != nil {
+				log.Printf("Error saving %d anomalies: %v", len(anomalies), anomaliesErr)
+			}
+		}
+
+		d.mu.Lock()
+		if baselinesErr != nil {
+			for _, stored := range baselines {
+				d.dirty[anomalyKey{objectID: stored.ObjectID, portNum: stored.PortNum, field: stored.Field}] = true
+			}
+		}
+		if anomaliesErr != nil {
+			d.pending = append(anomalies, d.pending...)
+		}
+		if baselinesErr != nil || anomaliesErr != nil {
+			break
+		}
+	}
+	d.saving = false
+}
+
+// anomalyKey identifies the baseline of one field of one port
+type anomalyKey struct {
+	objectID float64
+	portNum  float64
+	field    string
+}
+
+// baseline is the running reference that readings of one port and field are scored against
+type baseline interface {
+	// expect returns the expected value and spread of the next reading
+	// ok is false until the baseline has seen a full window, and while it has no spread
+	expect() (center float64, spread float64, ok bool)
+	add(value float64)
+	// save writes what the baseline holds into stored, and load restores it
+	save(stored *model.AnomalyBaseline)
+	load(stored model.AnomalyBaseline)
+}
+
+// portBaseline is the baseline of one field of one port and the last reading added to it
+type portBaseline struct {
+	baseline
+	last time.Time // Timestamp of the last reading added
+}
+
+// newBaseline returns an empty baseline of a validated method
+func newBaseline(method string, window int) baseline {
+	switch method {
+	case model.AnomalyEWMA:
+		return &ewmaBaseline{alpha: 2 / float64(window+1), warmup: window}
+	case model.AnomalyMAD:
+		return &medianBaseline{ring: make([]float64, 0, window), sorted: make([]float64, 0, window)}
+	default:
+		return &rollingBaseline{ring: make([]float64, 0, window)}
+	}
+}
+
+// rollingBaseline is the mean and standard deviation of the last readings
+// The sums are kept relative to a recent reading, which keeps the variance accurate for
+// fields with a large mean and a small spread, and are recomputed every window so
+// rounding errors do not build up
+type rollingBaseline struct {
+	ring  []float64 // Last readings, overwritten oldest first once full
+	next  int       // Position of the oldest reading once the ring is full
+	shift float64   // Reading the sums are relative to
+	sum   float64   // Sum of the shifted readings
+	sumSq float64   // Sum of the squared shifted readings
+}
+
+func (b *rollingBaseline) expect() (float64, float64, bool) {
+	n := float64(len(b.ring))
+	if len(b.ring) < cap(b.ring) {
+		return 0, 0, false
+	}
+	variance := (b.sumSq - b.sum*b.sum/n) / (n - 1)
+	if variance <= 0 {
+		return 0, 0, false
+	}
+	return b.shift + b.sum/n, math.Sqrt(variance), true
+}
+
+func (b *rollingBaseline) save(stored *model.AnomalyBaseline) {
+	stored.Values = ringValues(b.ring, b.next)
+}
+
+func (b *rollingBaseline) load(stored model.AnomalyBaseline) {
+	for _, value := range stored.Values {
+		b.add(value)
+	}
+}
+
+func (b *rollingBaseline) add(value float64) {
+	if len(b.ring) < cap(b.ring) {
+		if len(b.ring) == 0 {
+			b.shift = value
+		}
+		b.ring = append(b.ring, value)
+		b.sum += value - b.shift
+		b.sumSq += (value - b.shift) * (value - b.shift)
+		return
+	}
+
+	old := b.ring[b.next] - b.shift
+	b.sum += value - b.shift - old
+	b.sumSq += (value-b.shift)*(value-b.shift) - old*old
+	b.ring[b.next] = value
+	b.next = (b.next + 1) % len(b.ring)
+	if b.next == 0 {
+		b.shift, b.sum, b.sumSq = value, 0, 0
+		for _, v := range b.ring {
+			b.sum += v - b.shift
+			b.sumSq += (v - b.shift) * (v - b.shift)
+		}
+	}
+}
+
+// ewmaBaseline is the exponentially weighted mean and standard deviation of the readings
+// With a span of N readings, alpha is 2/(N+1)
+type ewmaBaseline struct {
+	alpha    float64 // Weight of the newest reading
+	warmup   int     // Readings needed before scoring
+	n        int     // Readings seen
+	mean     float64
+	variance float64
+}
+
+func (b *ewmaBaseline) expect() (float64, float64, bool) {
+	if b.n < b.warmup || b.variance <= 0 {
+		return 0, 0, false
+	}
+	return b.mean, math.Sqrt(b.variance), true
+}
+
+func (b *ewmaBaseline) save(stored *model.AnomalyBaseline) {
+	stored.Count, stored.Mean, stored.Variance = b.n, b.mean, b.variance
+}
+
+func (b *ewmaBaseline) load(stored model.AnomalyBaseline) {
+	b.n, b.mean, b.variance = stored.Count, stored.Mean, stored.Variance
+}
+
+func (b *ewmaBaseline) add(value float64) {
+	b.n++
+	if b.n == 1 {
+		b.mean = value
+		return
+	}
+	diff := value - b.mean
+	increment := b.alpha * diff
+	b.mean += increment
+	b.variance = (1 - b.alpha) * (b.variance + diff*increment)
+}
+
+// medianBaseline is the median and the scaled median absolute deviation of the last
+// readings, which a few outliers in the window do not move
+type medianBaseline struct {
+	ring   []float64 // Last readings, overwritten oldest first once full
+	next   int       // Position of the oldest reading once the ring is full
+	sorted []float64 // The same readings in ascending order
+}
+
+func (b *medianBaseline) expect() (float64, float64, bool) {
+	if len(b.ring) < cap(b.ring) {
+		return 0, 0, false
+	}
+	median := medianOfSorted(b.sorted)
+	spread := madScale * medianAbsDeviation(b.sorted, median)
+	if spread == 0 {
+		// More than half of the window holds the same value; fall back on the mean
+		// absolute deviation so a change from a flat baseline is still scored
+		var total float64
+		for _, value := range b.sorted {
+			total += math.Abs(value - median)
+		}
+		spread = meanADScale * total / float64(len(b.sorted))
+	}
+	if spread == 0 {
+		return 0, 0, false
+	}
+	return median, spread, true
+}
+
+func (b *medianBaseline) save(stored *model.AnomalyBaseline) {
+	stored.Values = ringValues(b.ring, b.next)
+}
+
+func (b *medianBaseline) load(stored model.AnomalyBaseline) {
+	for _, value := range stored.Values {
+		b.add(value)
+	}
+}
+
+func (b *medianBaseline) add(value float64) {
+	if len(b.ring) < cap(b.ring) {
+		b.ring = append(b.ring, value)
+	} else {
+		old := b.ring[b.next]
+		i := sort.SearchFloat64s(b.sorted, old)
+		b.sorted = slices.Delete(b.sorted, i, i+1)
+		b.ring[b.next] = value
+		b.next = (b.next + 1) % len(b.ring)
+	}
+	i := sort.SearchFloat64s(b.sorted, value)
+	b.sorted = slices.Insert(b.sorted, i, value)
+}
+
+// ringValues returns the readings of a rolling window oldest first, next being the
+// position of the oldest one
+func ringValues(ring []float64, next int) []float64 {
+	return append(slices.Clone(ring[next:]), ring[:next]...)
+}
+
+// medianOfSorted returns the median of ascending values
+func medianOfSorted(sorted []float64) float64 {
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// medianAbsDeviation returns the median of the distances of ascending values to their
+// median without sorting the distances
+// Walking outwards from the median visits the distances in ascending order
+func medianAbsDeviation(sorted []float64, median float64) float64 {
+	n := len(sorted)
+	hi := sort.SearchFloat64s(sorted, median)
+	lo := hi - 1
+	var previous, current float64
+	for k := 0; k <= n/2; k++ {
+		previous = current
+		if lo >= 0 && (hi >= n || median-sorted[lo] <= sorted[hi]-median) {
+			current = median - sorted[lo]
+			lo--
+		} else {
+			current = sorted[hi] - median
+			hi++
+		}
+	}
+	if n%2 == 1 {
+		return current
+	}
+	return (previous + current) / 2
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gomongoviz/config"
+	"gomongoviz/model"
+	"gomongoviz/repository"
+)
+
+// anomalyStart is the time of the first reading of the anomaly tests
+var anomalyStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newAnomalyService returns a service on repo scoring the voltage of every port with
+// method over windows of an hour of readings
+func newAnomalyService(t *testing.T, repo repository.Repository, method string) *Service {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Anomalies.Fields = []string{"voltage"}
+	cfg.Anomalies.Method = method
+	cfg.Anomalies.Window = 60
+	s := NewService(repo, cfg)
+	if err := s.StartAnomalies(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// anomalyReading returns a reading of object 1 port 1 at a minute of the tests
+func anomalyReading(minute int, voltage float64) model.SensorData {
+	return model.SensorData{Timestamp: anomalyStart.Add(time.Duration(minute) * time.Minute), ObjectID: 1, PortNum: 1, Voltage: voltage}
+}
+
+// saveSpikes saves a reading a minute over two days, alternating between 10 and 11 V
+// with a 50 V spike every hour on the hour, an hour at a time
+func saveSpikes(t *testing.T, s *Service, onDuplicate string) {
+	t.Helper()
+	for hour := 0; hour < 48; hour++ {
+		var data []model.SensorData
+		// Each hour is sent newest first, and scored in time order all the same
+		for minute := hour*60 + 59; minute >= hour*60; minute-- {
+			voltage := float64(10 + minute%2)
+			if minute%60 == 0 {
+				voltage = 50
+			}
+			data = append(data, anomalyReading(minute, voltage))
+		}
+		if _, err := s.SaveSensorData(context.Background(), data, onDuplicate); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// listAnomalies returns the anomalies stored for object 1 matching query
+func listAnomalies(t *testing.T, s *Service, query model.AnomalyQuery) *model.AnomalyRes {
+	t.Helper()
+	query.ObjectID = 1
+	if query.Limit == 0 {
+		query.Limit = DefaultAnomalyLimit
+	}
+	res, err := s.ListAnomalies(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestListAnomalies(t *testing.T) {
+	s := newAnomalyService(t, repository.NewMemoryRepository(), model.AnomalyMAD)
+	saveSpikes(t, s, model.DuplicateSkip)
+
+	port := 1.0
+	otherPort := 2.0
+	tests := []struct {
+		name      string
+		query     model.AnomalyQuery
+		anomalies int
+		truncated bool
+	}{
+		// The first spike comes before the baseline holds a full window
+		{name: "every spike", anomalies: 47},
+		{name: "port", query: model.AnomalyQuery{PortNum: &port}, anomalies: 47},
+		{name: "other port", query: model.AnomalyQuery{PortNum: &otherPort}, anomalies: 0},
+		{name: "other field", query: model.AnomalyQuery{Fields: []string{"current"}}, anomalies: 0},
+		{name: "inclusive range", query: model.AnomalyQuery{From: anomalyStart.Add(10 * time.Hour), To: anomalyStart.Add(20 * time.Hour)}, anomalies: 11},
+		{name: "limit", query: model.AnomalyQuery{Limit: 5}, anomalies: 5, truncated: true},
+		{name: "limit reached exactly", query: model.AnomalyQuery{Limit: 47}, anomalies: 47},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := listAnomalies(t, s, tt.query)
+			if len(res.Anomalies) != tt.anomalies || res.Truncated != tt.truncated {
+				t.Errorf("got %d anomalies, truncated %v, want %d, truncated %v", len(res.Anomalies), res.Truncated, tt.anomalies, tt.truncated)
+			}
+			for _, anomaly := range res.Anomalies {
+				if anomaly.Value != 50 || anomaly.Timestamp.Minute() != 0 || anomaly.Method != model.AnomalyMAD || anomaly.Baseline != 11 {
+					t.Errorf("got anomaly %+v, want a spike on an 11 V median, the previous spike being in the window", anomaly)
+				}
+			}
+		})
+	}
+}
+
+func TestAnomalyReadingsSavedAgain(t *testing.T) {
+	s := newAnomalyService(t, repository.NewMemoryRepository(), model.AnomalyZScore)
+	saveSpikes(t, s, model.DuplicateSkip)
+	before := listAnomalies(t, s, model.AnomalyQuery{})
+
+	// Readings saved again, or older than the last one of their port, are not scored and
+	// leave the baselines as they were
+	saveSpikes(t, s, model.DuplicateUpsert)
+	if _, err := s.SaveSensorData(context.Background(), []model.SensorData{anomalyReading(30, 100)}, model.DuplicateUpsert); err != nil {
+		t.Fatal(err)
+	}
+	after := listAnomalies(t, s, model.AnomalyQuery{})
+	if len(after.Anomalies) != len(before.Anomalies) {
+		t.Fatalf("got %d anomalies after saving the readings again, want %d", len(after.Anomalies), len(before.Anomalies))
+	}
+	for i := range after.Anomalies {
+		if after.Anomalies[i] != before.Anomalies[i] {
+			t.Errorf("got anomaly %+v, want %+v", after.Anomalies[i], before.Anomalies[i])
+		}
+	}
+}
+
+func TestAnomalyBaselinesPersisted(t *testing.T) {
+	for _, method := range model.AnomalyMethods {
+		t.Run(method, func(t *testing.T) {
+			repo := repository.NewMemoryRepository()
+			saveSpikes(t, newAnomalyService(t, repo, method), model.DuplicateSkip)
+
+			// Another service on the same storage scores the next spike at once, from the
+			// stored baseline
+			restarted := newAnomalyService(t, repo, method)
+			if _, err := restarted.SaveSensorData(context.Background(), []model.SensorData{anomalyReading(48*60, 50)}, model.DuplicateSkip); err != nil {
+				t.Fatal(err)
+			}
+			res := listAnomalies(t, restarted, model.AnomalyQuery{From: anomalyStart.Add(48 * time.Hour)})
+			if len(res.Anomalies) != 1 {
+				t.Fatalf("got %d anomalies after the restart, want the spike", len(res.Anomalies))
+			}
+		})
+	}
+
+	// A baseline built with another method starts again, so it scores nothing until its
+	// window is full
+	repo := repository.NewMemoryRepository()
+	saveSpikes(t, newAnomalyService(t, repo, model.AnomalyMAD), model.DuplicateSkip)
+	changed := newAnomalyService(t, repo, model.AnomalyZScore)
+	if _, err := changed.SaveSensorData(context.Background(), []model.SensorData{anomalyReading(48*60, 50)}, model.DuplicateSkip); err != nil {
+		t.Fatal(err)
+	}
+	if res := listAnomalies(t, changed, model.AnomalyQuery{From: anomalyStart.Add(48 * time.Hour)}); len(res.Anomalies) != 0 {
+		t.Errorf("got %d anomalies from a baseline of another method, want none", len(res.Anomalies))
+	}
+}
+
+func TestParseAnomalyOptions(t *testing.T) {
+	var query model.AnomalyQuery
+	if err := ParseAnomalyOptions(" voc, supply_volt ", "", &query); err != nil {
+		t.Fatal(err)
+	}
+	if len(query.Fields) != 2 || query.Fields[0] != "voc" || query.Fields[1] != "supply_volt" || query.Limit != DefaultAnomalyLimit {
+		t.Errorf("got fields %v and limit %d, want [voc supply_volt] and %d", query.Fields, query.Limit, DefaultAnomalyLimit)
+	}
+	for _, fields := range []string{"power", "bogus"} {
+		if err := ParseAnomalyOptions(fields, "", &query); err == nil {
+			t.Errorf("fields %q accepted", fields)
+		}
+	}
+	for _, limit := range []string{"0", "-1", "10001", "many"} {
+		if err := ParseAnomalyOptions("", limit, &query); err == nil {
+			t.Errorf("limit %q accepted", limit)
+		}
+	}
+	if _, err := newAnomalyService(t, repository.NewMemoryRepository(), model.AnomalyMAD).ListAnomalies(context.Background(), model.AnomalyQuery{ObjectID: 1}); err == nil {
+		t.Error("query without a limit accepted")
+	}
+}
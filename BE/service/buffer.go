@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"gomongoviz/model"
+)
+
+// ErrIngestBufferFull is returned when the ingest buffer holds ingest.buffer_size records
+// that have not been written yet
+var ErrIngestBufferFull = errors.New("ingest buffer is full, try again later")
+
+// ingestRetries is how many times a failed ingest batch is written again before its
+// records are given up
+const ingestRetries = 3
+
+// ingestBuffer collects records pushed by devices until the flusher writes them
+type ingestBuffer struct {
+	mu      sync.Mutex
+	records []model.SensorData
+	waiters []*ingestWaiter
+	full    chan struct{} // Signalled when a whole batch is waiting
+}
+
+// ingestWaiter reports to the sender of a group of records once all of them are written
+type ingestWaiter struct {
+	start, end int        // Positions of the records in the buffer
+	err        error      // First error writing any of the records
+	done       chan error // Receives err once every record has been written or given up
+}
+
+// Ingest queues validated records for the next bulk insert and returns without waiting
+// for it; records already stored are skipped, so devices can safely send again
+// The returned channel receives nil once all the records are written, or the error
+// that made the server give up on some of them
+// It fails with ErrIngestBufferFull instead of queueing only part of the records
+func (s *Service) Ingest(records []model.SensorData) (<-chan error, error) {
+	waiter := &ingestWaiter{done: make(chan error, 1)}
+	if len(records) == 0 {
+		waiter.done <- nil
+		return waiter.done, nil
+	}
+
+	b := s.ingest
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.records)+len(records) > s.ingestCfg.BufferSize {
+		return nil, ErrIngestBufferFull
+	}
+
+	now := time.Now()
+	waiter.start = len(b.records)
+	for _, record := range records {
+		if record.CreatedAt.IsZero() {
+			record.CreatedAt = now
+		}
+		b.records = append(b.records, record)
+	}
+	waiter.end = len(b.records)
+	b.waiters = append(b.waiters, waiter)
+
+	if len(b.records) >= s.ingestCfg.BatchSize {
+		select {
+		case b.full <- struct{}{}:
+		default:
+		}
+	}
+	return waiter.done, nil
+}
+
+// IngestBatchSize returns how many records are written per insert
+// A group of records that large always fits in an empty ingest buffer
+func (s *Service) IngestBatchSize() int {
+	return s.ingestCfg.BatchSize
+}
+
+// StartIngestFlusher starts writing buffered records every ingest.flush_interval, or
+// as soon as a whole batch is waiting
+// When ctx is cancelled the remaining records are written before the flusher stops, then
+// the returned channel is closed
+func (s *Service) StartIngestFlusher(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(s.ingestCfg.FlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				s.flushIngest(context.WithoutCancel(ctx))
+				return
+			case <-ticker.C:
+			case <-s.ingest.full:
+			}
+			s.flushIngest(ctx)
+		}
+	}()
+	log.Printf("Flushing ingested records every %s in batches of %d", s.ingestCfg.FlushInterval, s.ingestCfg.BatchSize)
+	return done
+}
+
+// flushIngest writes every buffered record in batches of ingest.batch_size
+// New records can be queued while the batches are written
+func (s *Service) flushIngest(ctx context.Context) {
+	b := s.ingest
+	b.mu.Lock()
+	records, waiters := b.records, b.waiters
+	b.records, b.waiters = nil, nil
+	b.mu.Unlock()
+
+	for start := 0; start < len(records); start += s.ingestCfg.BatchSize {
+		end := min(start+s.ingestCfg.BatchSize, len(records))
+		err := s.saveIngestBatch(ctx, records[start:end])
+
+		// Tell every sender whose records are now all written or given up
+		for len(waiters) > 0 && waiters[0].start < end {
+			waiter := waiters[0]
+			if err != nil && waiter.err == nil {
+				waiter.err = err
+			}
+			if waiter.end > end {
+				break
+			}
+			waiter.done <- waiter.err
+			waiters = waiters[1:]
+		}
+	}
+}
+
+// saveIngestBatch writes one batch, trying again with a growing delay when it fails
+func (s *Service) saveIngestBatch(ctx context.Context, batch []model.SensorData) error {
+	var err error
+	for attempt := 1; attempt <= ingestRetries; attempt++ {
+		var result model.SaveResult
+		if result, err = s.SaveSensorData(ctx, batch, model.DuplicateSkip); err == nil {
+			log.Printf("Ingested %d records, %d duplicates", result.Inserted, result.Duplicates)
+			return nil
+		}
+		log.Printf("Error writing %d ingested records, attempt %d of %d: %v", len(batch), attempt, ingestRetries, err)
+		if attempt == ingestRetries {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt) * time.Second):
+		}
+	}
+	return err
+}
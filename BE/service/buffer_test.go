@@ -0,0 +1,47 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gomongoviz/config"
+	"gomongoviz/model"
+	"gomongoviz/repository"
+)
+
+func TestIngestFlusherStop(t *testing.T) {
+	cfg := config.Default()
+	cfg.Ingest.FlushInterval = time.Hour
+	s := NewService(repository.NewMemoryRepository(), cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	flushed := s.StartIngestFlusher(ctx)
+
+	written, err := s.Ingest([]model.SensorData{
+		{Timestamp: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), ObjectID: 1, PortNum: 1, Voltage: 12},
+		{Timestamp: time.Date(2024, 1, 1, 0, 1, 0, 0, time.UTC), ObjectID: 1, PortNum: 1, Voltage: 13},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is due before the hour is up, so only stopping writes the records
+	cancel()
+	select {
+	case <-flushed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("flusher did not stop")
+	}
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatal(err)
+		}
+	default:
+		t.Fatal("records not reported written when the flusher stopped")
+	}
+	res, err := s.GetDataByObjectID(context.Background(), model.SensorDataQuery{ObjectID: "1"})
+	if err != nil || res.Total != 2 {
+		t.Fatalf("got %v, %v, want the 2 records stored", res, err)
+	}
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"slices"
+	"time"
+
+	"gomongoviz/model"
+	"gomongoviz/repository"
+)
+
+// ErrInvalidField wraps the problems found in a computed field sent by a client
+var ErrInvalidField = errors.New("invalid computed field")
+
+// ErrFieldExists is returned when creating a computed field under a name already in use
+var ErrFieldExists = errors.New("computed field already exists")
+
+// ErrUnknownField is returned when a query requests a field that is neither stored,
+// derived nor computed
+var ErrUnknownField = errors.New("unknown field")
+
+// computedNamePattern matches the names accepted for computed fields
+var computedNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// isComputedName reports whether name may be the name of a computed field
+func isComputedName(name string) bool {
+	return computedNamePattern.MatchString(name) && !model.IsField(name) && !IsDerivedField(name)
+}
+
+// CreateComputedField validates and stores a new computed field
+// It returns an error wrapping ErrFieldExists if the name is already in use
+func (s *Service) CreateComputedField(ctx context.Context, field model.ComputedField) (*model.ComputedField, error) {
+	if err := validateComputedField(&field); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidField, err)
+	}
+	field.CreatedAt = time.Now()
+	field.UpdatedAt = field.CreatedAt
+	err := s.repo.CreateComputedField(ctx, field)
+	if errors.Is(err, repository.ErrExists) {
+		return nil, fmt.Errorf("%w: %s", ErrFieldExists, field.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Created computed field %s = %s", field.Name, field.Expression)
+	return &field, nil
+}
+
+// UpdateComputedField replaces the formula and description of a computed field, keeping
+// its name and creation time
+// It returns repository.ErrNotFound if no field has that name
+func (s *Service) UpdateComputedField(ctx context.Context, name string, field model.ComputedField) (*model.ComputedField, error) {
+	field.Name = name
+	if err := validateComputedField(&field); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidField, err)
+	}
+	existing, err := s.repo.GetComputedField(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	field.CreatedAt = existing.CreatedAt
+	field.UpdatedAt = time.Now()
+	if err := s.repo.SaveComputedField(ctx, field); err != nil {
+		return nil, err
+	}
+	return &field, nil
+}
+
+// DeleteComputedField removes a computed field
+// It returns repository.ErrNotFound if no field has that name
+func (s *Service) DeleteComputedField(ctx context.Context, name string) error {
+	return s.repo.DeleteComputedField(ctx, name)
+}
+
+// GetComputedField retrieves a computed field by name
+func (s *Service) GetComputedField(ctx context.Context, name string) (*model.ComputedField, error) {
+	return s.repo.GetComputedField(ctx, name)
+}
+
+// ListComputedFields retrieves every computed field in name order
+func (s *Service) ListComputedFields(ctx context.Context) ([]model.ComputedField, error) {
+	return s.repo.ListComputedFields(ctx)
+}
+
+// CheckFields verifies that every field of a list can be returned by queries, so
+// endpoints that stream their response can refuse unknown fields before they start
+func (s *Service) CheckFields(ctx context.Context, fields []string) error {
+	_, _, err := s.splitFields(ctx, fields)
+	return err
+}
+
+// validateComputedField checks the name and formula of a computed field and sets the
+// fields the formula reads
+func validateComputedField(field *model.ComputedField) error {
+	var errs []error
+	switch {
+	case !computedNamePattern.MatchString(field.Name):
+		errs = append(errs, fmt.Errorf("name %q must start with a lowercase letter and only hold lowercase letters, digits and underscores, up to 64 characters", field.Name))
+	case model.IsField(field.Name) || IsDerivedField(field.Name):
+		errs = append(errs, fmt.Errorf("name %q is already a stored or derived field", field.Name))
+	}
+	expr, err := compileExpression(field.Expression)
+	if err != nil {
+		errs = append(errs, err)
+	} else {
+		field.Fields = expr.fields
+	}
+	return errors.Join(errs...)
+}
+
+// computedField returns the definition of a stored computed field for queries
+// Formulas with an undefined result for a reading, such as a division by zero, give 0
+// like efficiency without supply power, so every value can be written as JSON
+func (s *Service) computedField(ctx context.Context, name string) (derivedField, error) {
+	if !computedNamePattern.MatchString(name) {
+		return derivedField{}, fmt.Errorf("%w %q", ErrUnknownField, name)
+	}
+	stored, err := s.repo.GetComputedField(ctx, name)
+	if errors.Is(err, repository.ErrNotFound) {
+		return derivedField{}, fmt.Errorf("%w %q: not a stored, derived or computed field", ErrUnknownField, name)
+	}
+	if err != nil {
+		return derivedField{}, err
+	}
+	expr, err := compileExpression(stored.Expression)
+	if err != nil {
+		return derivedField{}, fmt.Errorf("computed field %s: %w", name, err)
+	}
+
+	// Derived fields in the formula are read through their own inputs
+	var inputs []string
+	for _, field := range expr.fields {
+		reads := []string{field}
+		if derived, ok := derivedFields[field]; ok {
+			reads = derived.inputs
+		}
+		for _, input := range reads {
+			if !slices.Contains(inputs, input) {
+				inputs = append(inputs, input)
+			}
+		}
+	}
+	return derivedField{
+		inputs: inputs,
+		value: func(d *model.SensorData) float64 {
+			value := expr.eval(d)
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				return 0
+			}
+			return value
+		},
+	}, nil
+}
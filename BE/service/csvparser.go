@@ -0,0 +1,217 @@
+package service
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"gomongoviz/model"
+)
+
+// RequiredCSVColumns lists the columns every uploaded CSV file must contain
+var RequiredCSVColumns = []string{
+	"timestamp", "object_id", "port_num", "voltage", "current",
+	"supply_current", "supply_volt", "voltage_drop", "voc",
+}
+
+// MissingColumnsError is returned when the CSV header lacks required columns
+type MissingColumnsError struct {
+	Columns []string // Required columns that are not in the header
+}
+
+// Error implements the error interface
+func (e *MissingColumnsError) Error() string {
+	return fmt.Sprintf("The following required fields are missing: %v", e.Columns)
+}
+
+// CSVParser reads sensor data records from a CSV file one row at a time
+// It never holds more than the current row in memory
+type CSVParser struct {
+	reader    *csv.Reader
+	headerMap map[string]int
+	line      int
+}
+
+// NewCSVParser reads the header of a CSV file and checks the required columns
+// A *MissingColumnsError is returned if required columns are absent
+func NewCSVParser(r io.Reader) (*CSVParser, error) {
+	reader := csv.NewReader(r)
+
+	// Read header
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	// Map column indices
+	headerMap := make(map[string]int)
+	for i, column := range header {
+		headerMap[column] = i
+	}
+
+	// Validate required fields
+	missingFields := []string{}
+	for _, field := range RequiredCSVColumns {
+		if _, exists := headerMap[field]; !exists {
+			missingFields = append(missingFields, field)
+		}
+	}
+	if len(missingFields) > 0 {
+		return nil, &MissingColumnsError{Columns: missingFields}
+	}
+
+	return &CSVParser{reader: reader, headerMap: headerMap, line: 1}, nil
+}
+
+// Header returns the column indices of the CSV header keyed by column name
+func (p *CSVParser) Header() map[string]int {
+	return p.headerMap
+}
+
+// Next parses the next row into a SensorData record
+// It returns io.EOF after the last row and model.RowErrors listing every problem
+// of a row that cannot be used
+func (p *CSVParser) Next() (model.SensorData, error) {
+	p.line++
+	record, err := p.reader.Read()
+	if err == io.EOF {
+		return model.SensorData{}, io.EOF
+	}
+	if err != nil {
+		return model.SensorData{}, model.RowErrors{{Line: p.line, Reason: err.Error()}}
+	}
+	return p.parseRecord(record)
+}
+
+// optionalNumericColumns lists the optional CSV columns holding numbers
+var optionalNumericColumns = []string{
+	"state", "controller_error", "ai1", "ai2", "ai3", "ai4", "ai5",
+	"q_charge", "voltage_set_point", "command", "target_q",
+	"step_number", "voc_mode", "target_voc", "voc_state", "voc_exit",
+}
+
+// parseRecord converts one CSV row into a SensorData record
+// Every column is checked so all problems of the row are reported together
+func (p *CSVParser) parseRecord(record []string) (model.SensorData, error) {
+	var problems model.RowErrors
+	addProblem := func(column string, reason string) {
+		problems = append(problems, model.RowError{Line: p.line, Column: column, Value: record[p.headerMap[column]], Reason: reason})
+	}
+
+	// Parse data from CSV row
+	timestamp, err := time.Parse(time.RFC3339, record[p.headerMap["timestamp"]])
+	if err != nil {
+		addProblem("timestamp", "timestamp should be in RFC3339 format")
+	}
+
+	// object_id and port_num must always be numbers, the other numeric columns may be empty
+	values := make(map[string]float64, len(RequiredCSVColumns)+len(optionalNumericColumns))
+	for _, field := range RequiredCSVColumns[1:] {
+		value := record[p.headerMap[field]]
+		if value == "" && field != "object_id" && field != "port_num" {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			addProblem(field, field+" should be a number")
+			continue
+		}
+		values[field] = parsed
+	}
+
+	// Optional numeric columns are checked the same way when present
+	for _, field := range optionalNumericColumns {
+		if index, exists := p.headerMap[field]; exists && index < len(record) && record[index] != "" {
+			parsed, err := strconv.ParseFloat(record[index], 64)
+			if err != nil {
+				addProblem(field, field+" should be a number")
+				continue
+			}
+			values[field] = parsed
+		}
+	}
+
+	// Handle read_error boolean field if present
+	var readError bool
+	if index, exists := p.headerMap["read_error"]; exists && index < len(record) && record[index] != "" {
+		readError, err = strconv.ParseBool(record[index])
+		if err != nil {
+			addProblem("read_error", "read_error should be true or false")
+		}
+	}
+
+	if len(problems) > 0 {
+		return model.SensorData{}, problems
+	}
+
+	// Create new SensorData object
+	data := model.SensorData{
+		Timestamp:     timestamp,
+		ObjectID:      values["object_id"],
+		PortNum:       values["port_num"],
+		Voltage:       values["voltage"],
+		Current:       values["current"],
+		SupplyCurrent: values["supply_current"],
+		SupplyVolt:    values["supply_volt"],
+		VoltageDrop:   values["voltage_drop"],
+		VOC:           values["voc"],
+		ReadError:     readError,
+		CreatedAt:     time.Now(),
+	}
+	for _, field := range optionalNumericColumns {
+		setNumericField(&data, field, values[field])
+	}
+
+	// Add optional string fields if present
+	for field, dst := range map[string]*string{
+		"fw_version": &data.FWVersion,
+		"vendor_id":  &data.VendorID,
+		"lite_id":    &data.LiteID,
+	} {
+		if index, exists := p.headerMap[field]; exists && index < len(record) {
+			*dst = record[index]
+		}
+	}
+
+	return data, nil
+}
+
+// setNumericField assigns an optional numeric CSV column to the matching SensorData field
+func setNumericField(data *model.SensorData, field string, value float64) {
+	switch field {
+	case "state":
+		data.State = value
+	case "controller_error":
+		data.ControllerError = value
+	case "ai1":
+		data.AI1 = value
+	case "ai2":
+		data.AI2 = value
+	case "ai3":
+		data.AI3 = value
+	case "ai4":
+		data.AI4 = value
+	case "ai5":
+		data.AI5 = value
+	case "q_charge":
+		data.QCharge = value
+	case "voltage_set_point":
+		data.VoltageSetPoint = value
+	case "command":
+		data.Command = value
+	case "target_q":
+		data.TargetQ = value
+	case "step_number":
+		data.StepNumber = value
+	case "voc_mode":
+		data.VOCMode = value
+	case "target_voc":
+		data.TargetVOC = value
+	case "voc_state":
+		data.VOCState = value
+	case "voc_exit":
+		data.VOCExit = value
+	}
+}
@@ -0,0 +1,288 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"gomongoviz/model"
+	"gomongoviz/repository"
+)
+
+// derivedField is a virtual field computed from the stored fields of a reading
+type derivedField struct {
+	inputs []string                          // Stored fields it is computed from
+	value  func(d *model.SensorData) float64 // Value of a reading, or the integrand of an integrated field
+
+	// Integrated fields are the trapezoidal integral of value over the time since the
+	// previous reading of the same port, in hours, so summing them over a time window
+	// gives the energy or charge of that window
+	integrated bool
+}
+
+// derivedFields maps the name of every virtual field to its definition
+var derivedFields = map[string]derivedField{
+	"power":         {inputs: []string{"voltage", "current"}, value: power},
+	"supply_power":  {inputs: []string{"supply_volt", "supply_current"}, value: supplyPower},
+	"efficiency":    {inputs: []string{"voltage", "current", "supply_volt", "supply_current"}, value: efficiency},
+	"energy":        {inputs: []string{"voltage", "current"}, value: power, integrated: true},
+	"supply_energy": {inputs: []string{"supply_volt", "supply_current"}, value: supplyPower, integrated: true},
+	"charge":        {inputs: []string{"current"}, value: func(d *model.SensorData) float64 { return d.Current }, integrated: true},
+}
+
+// power returns the power delivered to the port in watts
+func power(d *model.SensorData) float64 {
+	return d.Voltage * d.Current
+}
+
+// supplyPower returns the power drawn from the supply in watts
+func supplyPower(d *model.SensorData) float64 {
+	return d.SupplyVolt * d.SupplyCurrent
+}
+
+// efficiency returns the ratio of the port power to the supply power, 0 without supply power
+func efficiency(d *model.SensorData) float64 {
+	supply := supplyPower(d)
+	if supply == 0 {
+		return 0
+	}
+	return power(d) / supply
+}
+
+// IsDerivedField reports whether name is a virtual field computed from the stored fields
+func IsDerivedField(name string) bool {
+	_, ok := derivedFields[name]
+	return ok
+}
+
+// DerivedFieldNames returns the names of all virtual fields in sorted order
+func DerivedFieldNames() []string {
+	names := make([]string, 0, len(derivedFields))
+	for name := range derivedFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// virtualField is a virtual field requested by a query, built in or computed
+type virtualField struct {
+	name string
+	derivedField
+}
+
+// splitFields separates the virtual fields of a field list from the stored ones
+// Names that are neither stored nor built in are looked up among the computed fields;
+// an error wrapping ErrUnknownField is returned if one is not found
+func (s *Service) splitFields(ctx context.Context, fields []string) (stored []string, virtual []virtualField, err error) {
+	for _, name := range fields {
+		if model.IsField(name) {
+			stored = append(stored, name)
+			continue
+		}
+		if field, ok := derivedFields[name]; ok {
+			virtual = append(virtual, virtualField{name: name, derivedField: field})
+			continue
+		}
+		field, err := s.computedField(ctx, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		virtual = append(virtual, virtualField{name: name, derivedField: field})
+	}
+	return stored, virtual, nil
+}
+
+// virtualNames returns the names of virtual fields
+func virtualNames(fields []virtualField) []string {
+	names := make([]string, len(fields))
+	for i, field := range fields {
+		names[i] = field.name
+	}
+	return names
+}
+
+// derivedInputs adds the stored fields the virtual fields are computed from to fields
+func derivedInputs(fields []string, virtual []virtualField) []string {
+	inputs := slices.Clone(fields)
+	for _, field := range virtual {
+		for _, input := range field.inputs {
+			if !slices.Contains(inputs, input) {
+				inputs = append(inputs, input)
+			}
+		}
+	}
+	return inputs
+}
+
+// deriver computes the virtual fields of readings fed to it in timestamp order
+type deriver struct {
+	fields     []virtualField               // Virtual fields to compute
+	maxGap     time.Duration                // Longest gap integrated fields are integrated over, any if zero
+	integrated bool                         // Some fields need the previous reading of the port
+	previous   map[float64]model.SensorData // Last reading of every port
+}
+
+// newDeriver returns a deriver of the listed virtual fields
+func (s *Service) newDeriver(fields []virtualField) *deriver {
+	d := &deriver{fields: fields, maxGap: s.derivedCfg.MaxGap, previous: make(map[float64]model.SensorData)}
+	for _, field := range fields {
+		d.integrated = d.integrated || field.integrated
+	}
+	return d
+}
+
+// derive sets the virtual fields of a reading
+// Integrated fields are zero for the first reading of a port and after a gap longer than
+// derived.max_gap
+func (d *deriver) derive(data *model.SensorData) {
+	previous, hasPrevious := d.previous[data.PortNum]
+	elapsed := data.Timestamp.Sub(previous.Timestamp)
+	integrate := hasPrevious && elapsed > 0 && (d.maxGap == 0 || elapsed <= d.maxGap)
+
+	if data.Derived == nil {
+		data.Derived = make(map[string]float64, len(d.fields))
+	}
+	for _, field := range d.fields {
+		switch {
+		case !field.integrated:
+			data.Derived[field.name] = field.value(data)
+		case integrate:
+			data.Derived[field.name] = (field.value(&previous) + field.value(data)) / 2 * elapsed.Hours()
+		default:
+			data.Derived[field.name] = 0
+		}
+	}
+	if d.integrated {
+		d.previous[data.PortNum] = *data
+	}
+}
+
+// deriveRecords computes the virtual fields of a page of readings sorted by timestamp
+// The first reading of every port is integrated from the reading stored before it, so
+// values do not depend on where a page or time range starts
+func (s *Service) deriveRecords(ctx context.Context, objectID string, records []model.SensorData, desc bool, fields []virtualField, inputs []string) error {
+	d := s.newDeriver(fields)
+	for n := range records {
+		i := n
+		if desc {
+			i = len(records) - 1 - n
+		}
+		data := &records[i]
+		if _, seen := d.previous[data.PortNum]; d.integrated && !seen {
+			previous, err := s.previousReading(ctx, objectID, strconv.FormatFloat(data.PortNum, 'f', -1, 64), data.Timestamp, inputs)
+			if err != nil {
+				return err
+			}
+			if previous != nil {
+				d.previous[data.PortNum] = *previous
+			}
+		}
+		d.derive(data)
+	}
+	return nil
+}
+
+// streamDerived calls fn for every reading matching the query like StreamData, with the
+// virtual fields of the projection computed
+// Readings must be streamed in ascending timestamp order
+func (s *Service) streamDerived(ctx context.Context, query model.SensorDataQuery, fn func(*model.SensorData) error) error {
+	stored, virtual, err := s.splitFields(ctx, query.Projection)
+	if err != nil {
+		return err
+	}
+	if len(virtual) == 0 {
+		return s.repo.StreamData(ctx, query, fn)
+	}
+	query.Projection = derivedInputs(stored, virtual)
+	d := s.newDeriver(virtual)
+
+	// The previous readings are read before streaming, as some backends cannot run a
+	// query while they stream
+	if d.integrated && !query.From.IsZero() {
+		ports, err := s.queryPorts(ctx, query)
+		if err != nil {
+			return err
+		}
+		for _, port := range ports {
+			previous, err := s.previousReading(ctx, query.ObjectID, port, query.From, query.Projection)
+			if err != nil {
+				return err
+			}
+			if previous != nil {
+				d.previous[previous.PortNum] = *previous
+			}
+		}
+	}
+
+	return s.repo.StreamData(ctx, query, func(data *model.SensorData) error {
+		d.derive(data)
+		return fn(data)
+	})
+}
+
+// aggregateDerived computes time-bucketed aggregates of virtual fields per port
+// The readings are streamed and folded into the running aggregates of their bucket by
+// the server, as the database does not know these fields
+func (s *Service) aggregateDerived(ctx context.Context, query model.AggregateQuery) ([]model.AggregateBucket, error) {
+	stream := query.SensorDataQuery
+	stream.Projection = query.Fields
+	stream.Limit = 0
+	stream.SortDesc = false
+	stream.After = nil
+
+	aggregator := repository.NewAggregator(query)
+	if err := s.streamDerived(ctx, stream, aggregator.Add); err != nil {
+		return nil, err
+	}
+	return aggregator.Buckets(), nil
+}
+
+// previousReading returns the last reading of a port stored before a time, nil if there
+// is none within derived.max_gap
+func (s *Service) previousReading(ctx context.Context, objectID string, portNum string, before time.Time, fields []string) (*model.SensorData, error) {
+	query := model.SensorDataQuery{
+		ObjectID:   objectID,
+		PortNum:    portNum,
+		To:         before,
+		Limit:      2, // The bound is inclusive, so one more reading is read in case it sits on before
+		SortDesc:   true,
+		Projection: fields,
+	}
+	if s.derivedCfg.MaxGap > 0 {
+		query.From = before.Add(-s.derivedCfg.MaxGap)
+	}
+	res, err := s.repo.GetDataByObjectID(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	for i := range res.SensorData {
+		if res.SensorData[i].Timestamp.Before(before) {
+			return &res.SensorData[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// queryPorts returns the port of the query, or every port of its object when it has none
+func (s *Service) queryPorts(ctx context.Context, query model.SensorDataQuery) ([]string, error) {
+	if query.PortNum != "" {
+		return []string{query.PortNum}, nil
+	}
+	objectID, err := strconv.Atoi(query.ObjectID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid object ID %q: %w", query.ObjectID, err)
+	}
+	infos, err := s.repo.GetPorts(ctx, objectID)
+	if err != nil {
+		return nil, err
+	}
+	ports := make([]string, 0, len(infos))
+	for _, info := range infos {
+		ports = append(ports, strconv.FormatFloat(info.PortNum, 'f', -1, 64))
+	}
+	return ports, nil
+}
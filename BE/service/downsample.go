@@ -0,0 +1,223 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gomongoviz/model"
+)
+
+// Supported downsampling modes
+const (
+	ModeLTTB   = "lttb"   // Largest-Triangle-Three-Buckets, keeps the visual shape of the series
+	ModeMinMax = "minmax" // Minimum and maximum sample of every time bucket, keeps spikes
+	ModeAvg    = "avg"    // Average of every time bucket, smooths noise
+)
+
+// Limits applied to the max_points parameter
+const (
+	DefaultMaxPoints = 1000
+	MaxMaxPoints     = 20000
+)
+
+// ParseSeriesOptions parses the fields, max_points and mode query parameters into the query
+// fields is a comma separated list of numeric SensorData fields and is required
+func ParseSeriesOptions(fields string, maxPoints string, mode string, query *model.SeriesQuery) error {
+	parsed, err := parseFieldList(fields)
+	if err != nil {
+		return err
+	}
+	query.Fields = parsed
+
+	query.MaxPoints = DefaultMaxPoints
+	if maxPoints != "" {
+		n, err := strconv.Atoi(maxPoints)
+		if err != nil || n < 3 || n > MaxMaxPoints {
+			return fmt.Errorf("invalid max_points %q: must be between 3 and %d", maxPoints, MaxMaxPoints)
+		}
+		query.MaxPoints = n
+	}
+
+	switch strings.ToLower(mode) {
+	case "", ModeLTTB:
+		query.Mode = ModeLTTB
+	case ModeMinMax, ModeAvg:
+		query.Mode = strings.ToLower(mode)
+	default:
+		return fmt.Errorf("invalid mode %q: use %s, %s or %s", mode, ModeLTTB, ModeMinMax, ModeAvg)
+	}
+
+	return nil
+}
+
+// Downsample reduces points to at most maxPoints using the given mode
+// Series that are already small enough are returned unchanged
+func Downsample(points []model.SeriesPoint, maxPoints int, mode string) []model.SeriesPoint {
+	if len(points) <= maxPoints {
+		return points
+	}
+	switch mode {
+	case ModeMinMax:
+		return downsampleMinMax(points, maxPoints/2)
+	case ModeAvg:
+		return downsampleAvg(points, maxPoints)
+	default:
+		return LTTB(points, maxPoints)
+	}
+}
+
+// LTTB implements the Largest-Triangle-Three-Buckets algorithm
+// It always keeps the first and last point and, for every bucket in between, the point
+// forming the largest triangle with the previously selected point and the next bucket's average
+func LTTB(points []model.SeriesPoint, threshold int) []model.SeriesPoint {
+	if threshold >= len(points) || threshold < 3 {
+		return points
+	}
+
+	x := func(p model.SeriesPoint) float64 { return float64(p.X.UnixMilli()) }
+
+	sampled := make([]model.SeriesPoint, 0, threshold)
+	sampled = append(sampled, points[0])
+
+	// Buckets cover every point except the first and the last
+	every := float64(len(points)-2) / float64(threshold-2)
+	a := 0
+
+	for i := 0; i < threshold-2; i++ {
+		// Average of the next bucket is the third vertex of the triangle
+		avgStart := int(float64(i+1)*every) + 1
+		avgEnd := int(float64(i+2)*every) + 1
+		if avgEnd > len(points) {
+			avgEnd = len(points)
+		}
+		var avgX, avgY float64
+		for j := avgStart; j < avgEnd; j++ {
+			avgX += x(points[j])
+			avgY += points[j].Y
+		}
+		if n := float64(avgEnd - avgStart); n > 0 {
+			avgX /= n
+			avgY /= n
+		}
+
+		// Pick the point of the current bucket with the largest triangle area
+		rangeStart := int(float64(i)*every) + 1
+		rangeEnd := int(float64(i+1)*every) + 1
+		ax, ay := x(points[a]), points[a].Y
+		maxArea := -1.0
+		next := rangeStart
+		for j := rangeStart; j < rangeEnd; j++ {
+			area := math.Abs((ax-avgX)*(points[j].Y-ay) - (ax-x(points[j]))*(avgY-ay))
+			if area > maxArea {
+				maxArea = area
+				next = j
+			}
+		}
+
+		sampled = append(sampled, points[next])
+		a = next
+	}
+
+	return append(sampled, points[len(points)-1])
+}
+
+// downsampleMinMax keeps the minimum and maximum point of every time bucket in time order
+func downsampleMinMax(points []model.SeriesPoint, buckets int) []model.SeriesPoint {
+	sampled := make([]model.SeriesPoint, 0, buckets*2)
+	for _, bucket := range timeBuckets(points, buckets) {
+		lo, hi := bucket[0], bucket[0]
+		for _, p := range bucket[1:] {
+			if p.Y < lo.Y {
+				lo = p
+			}
+			if p.Y > hi.Y {
+				hi = p
+			}
+		}
+		switch {
+		case lo.X.Equal(hi.X):
+			sampled = append(sampled, lo)
+		case lo.X.Before(hi.X):
+			sampled = append(sampled, lo, hi)
+		default:
+			sampled = append(sampled, hi, lo)
+		}
+	}
+	return sampled
+}
+
+// downsampleAvg replaces every time bucket with a point at its mean time and mean value
+func downsampleAvg(points []model.SeriesPoint, buckets int) []model.SeriesPoint {
+	sampled := make([]model.SeriesPoint, 0, buckets)
+	for _, bucket := range timeBuckets(points, buckets) {
+		var sumX, sumY float64
+		for _, p := range bucket {
+			sumX += float64(p.X.UnixMilli())
+			sumY += p.Y
+		}
+		n := float64(len(bucket))
+		sampled = append(sampled, model.SeriesPoint{
+			X: time.UnixMilli(int64(sumX / n)).UTC(),
+			Y: sumY / n,
+		})
+	}
+	return sampled
+}
+
+// timeBuckets splits time-ordered points into equally wide time buckets
+// Empty buckets are dropped so gaps in the data stay visible
+func timeBuckets(points []model.SeriesPoint, buckets int) [][]model.SeriesPoint {
+	if buckets < 1 {
+		buckets = 1
+	}
+	start := points[0].X
+	span := points[len(points)-1].X.Sub(start)
+	width := span / time.Duration(buckets)
+
+	grouped := make([][]model.SeriesPoint, buckets)
+	for _, p := range points {
+		idx := 0
+		if width > 0 {
+			idx = int(p.X.Sub(start) / width)
+		}
+		if idx >= buckets {
+			idx = buckets - 1
+		}
+		grouped[idx] = append(grouped[idx], p)
+	}
+
+	result := grouped[:0]
+	for _, bucket := range grouped {
+		if len(bucket) > 0 {
+			result = append(result, bucket)
+		}
+	}
+	return result
+}
+
+// parseFieldList parses a comma separated list of numeric SensorData fields or virtual fields
+// Names of computed fields are checked when the query runs. At least one field is required
+func parseFieldList(fields string) ([]string, error) {
+	var parsed []string
+	for _, field := range strings.Split(fields, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !model.IsNumericField(field) && !IsDerivedField(field) && !isComputedName(field) {
+			valid := append(model.NumericFieldNames(), DerivedFieldNames()...)
+			sort.Strings(valid)
+			return nil, fmt.Errorf("unknown field %q: valid fields are %s and computed fields", field, strings.Join(valid, ", "))
+		}
+		parsed = append(parsed, field)
+	}
+	if len(parsed) == 0 {
+		return nil, errors.New("fields is required")
+	}
+	return parsed, nil
+}
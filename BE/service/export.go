@@ -0,0 +1,199 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"gomongoviz/model"
+)
+
+// Supported export formats
+const (
+	FormatCSV    = "csv"    // Comma separated values, using the same columns as the CSV upload
+	FormatNDJSON = "ndjson" // One JSON object per line
+	FormatJSON   = "json"   // A single JSON array, accepted by the JSON upload
+)
+
+// exportContentTypes maps every export format to its Content-Type header
+var exportContentTypes = map[string]string{
+	FormatCSV:    "text/csv",
+	FormatNDJSON: "application/x-ndjson",
+	FormatJSON:   "application/json",
+}
+
+// ParseExportFormat validates the format query parameter, defaulting to CSV
+func ParseExportFormat(format string) (string, error) {
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format == "" {
+		return FormatCSV, nil
+	}
+	if _, ok := exportContentTypes[format]; !ok {
+		return "", fmt.Errorf("invalid format %q: use %s, %s or %s", format, FormatCSV, FormatNDJSON, FormatJSON)
+	}
+	return format, nil
+}
+
+// ExportContentType returns the Content-Type header for an export format
+func ExportContentType(format string) string {
+	return exportContentTypes[format]
+}
+
+// ExportFilename builds the download file name for an export of the given query
+func ExportFilename(query model.SensorDataQuery, format string, now time.Time) string {
+	name := "sensor_data_" + query.ObjectID
+	if query.PortNum != "" {
+		name += "_port" + query.PortNum
+	}
+	return name + "_" + now.UTC().Format("20060102T150405Z") + "." + format
+}
+
+// ExportData streams every record matching the query to w in the given format
+// Records are written as they are read from the repository, nothing is buffered in memory
+// Cancelling ctx, for example when the client disconnects, closes the database cursor
+// Virtual fields in the projection are computed as the records are written
+func (s *Service) ExportData(ctx context.Context, query model.SensorDataQuery, format string, w io.Writer) error {
+	writer := newExportWriter(format, w, query.Projection)
+	if err := s.streamDerived(ctx, query, writer.Write); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// exportWriter encodes sensor data records in one export format
+type exportWriter interface {
+	Write(data *model.SensorData) error
+	Close() error
+}
+
+// newExportWriter returns the writer for a validated export format
+func newExportWriter(format string, w io.Writer, fields []string) exportWriter {
+	switch format {
+	case FormatNDJSON:
+		return &jsonExportWriter{w: w, fields: fields, newline: true}
+	case FormatJSON:
+		return &jsonExportWriter{w: w, fields: fields}
+	default:
+		return newCSVExportWriter(w, fields)
+	}
+}
+
+// jsonExportWriter writes records as NDJSON lines or as the elements of a JSON array
+type jsonExportWriter struct {
+	w       io.Writer
+	fields  []string
+	newline bool // NDJSON when true, JSON array otherwise
+	count   int
+}
+
+// Write encodes one record
+func (j *jsonExportWriter) Write(data *model.SensorData) error {
+	var record interface{} = data
+	if len(j.fields) > 0 {
+		record = data.Project(j.fields)
+	}
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	// Open the array before the first element and separate the following ones
+	prefix := ""
+	if !j.newline {
+		prefix = ","
+		if j.count == 0 {
+			prefix = "["
+		}
+	}
+	suffix := ""
+	if j.newline {
+		suffix = "\n"
+	}
+	j.count++
+
+	_, err = io.WriteString(j.w, prefix+string(encoded)+suffix)
+	return err
+}
+
+// Close terminates the JSON array, an empty export is written as []
+func (j *jsonExportWriter) Close() error {
+	if j.newline {
+		return nil
+	}
+	closing := "]\n"
+	if j.count == 0 {
+		closing = "[]\n"
+	}
+	_, err := io.WriteString(j.w, closing)
+	return err
+}
+
+// csvExportWriter writes records as CSV rows with a header line
+type csvExportWriter struct {
+	w       *csv.Writer
+	columns []string
+	header  bool
+}
+
+// newCSVExportWriter creates a CSV writer for the projected fields, or all upload columns
+func newCSVExportWriter(w io.Writer, fields []string) *csvExportWriter {
+	columns := model.CSVColumns
+	if len(fields) > 0 {
+		// The document ID is not an upload column, so it is left out of the CSV
+		columns = []string{"timestamp", "object_id", "port_num"}
+		for _, field := range fields {
+			if field != "id" && field != "timestamp" && field != "object_id" && field != "port_num" {
+				columns = append(columns, field)
+			}
+		}
+	}
+	return &csvExportWriter{w: csv.NewWriter(w), columns: columns}
+}
+
+// Write encodes one record, writing the header before the first one
+func (c *csvExportWriter) Write(data *model.SensorData) error {
+	if !c.header {
+		if err := c.w.Write(c.columns); err != nil {
+			return err
+		}
+		c.header = true
+	}
+
+	row := make([]string, len(c.columns))
+	for i, column := range c.columns {
+		value, _ := data.FieldValue(column)
+		row[i] = formatCSVValue(value)
+	}
+	return c.w.Write(row)
+}
+
+// Close writes the header of an empty export and flushes buffered rows
+func (c *csvExportWriter) Close() error {
+	if !c.header {
+		if err := c.w.Write(c.columns); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// formatCSVValue renders a field value the way the CSV upload parses it
+func formatCSVValue(value interface{}) string {
+	switch v := value.(type) {
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return v
+	}
+	return ""
+}
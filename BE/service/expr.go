@@ -0,0 +1,340 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gomongoviz/model"
+)
+
+// Limits applied to the formulas of computed fields
+const (
+	MaxExpressionLength = 1000 // Characters in a formula
+	maxExpressionDepth  = 32   // Nested parentheses, calls and signs
+)
+
+// expression is a formula compiled into a function of one reading
+// Formulas only read numeric fields and call the functions of exprFunctions, so
+// evaluating them has no effect outside the reading
+type expression struct {
+	eval   func(d *model.SensorData) float64
+	fields []string // Fields read by the formula, in order of appearance
+}
+
+// exprFunction is a function that formulas may call
+type exprFunction struct {
+	minArgs int // Fewest arguments accepted
+	maxArgs int // Most arguments accepted, any number if negative
+	call    func(args []float64) float64
+}
+
+// exprFunctions lists the functions that formulas may call
+var exprFunctions = map[string]exprFunction{
+	"abs":  {minArgs: 1, maxArgs: 1, call: func(a []float64) float64 { return math.Abs(a[0]) }},
+	"sqrt": {minArgs: 1, maxArgs: 1, call: func(a []float64) float64 { return math.Sqrt(a[0]) }},
+	"pow":  {minArgs: 2, maxArgs: 2, call: func(a []float64) float64 { return math.Pow(a[0], a[1]) }},
+	"min":  {minArgs: 2, maxArgs: -1, call: func(a []float64) float64 { return slices.Min(a) }},
+	"max":  {minArgs: 2, maxArgs: -1, call: func(a []float64) float64 { return slices.Max(a) }},
+}
+
+// ExpressionFunctions returns the names of the functions formulas may call in sorted order
+func ExpressionFunctions() []string {
+	names := make([]string, 0, len(exprFunctions))
+	for name := range exprFunctions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// compileExpression parses a formula over the numeric fields of a reading
+// Formulas combine numbers, fields, + - * / with the usual precedence, signs,
+// parentheses and the functions of exprFunctions. Fields are the numeric stored fields
+// and the virtual fields computed from one reading, such as power
+func compileExpression(source string) (*expression, error) {
+	if strings.TrimSpace(source) == "" {
+		return nil, errors.New("expression must not be empty")
+	}
+	if len(source) > MaxExpressionLength {
+		return nil, fmt.Errorf("expression must not be longer than %d characters", MaxExpressionLength)
+	}
+	p := &exprParser{source: source}
+	eval, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpaces(); p.pos < len(p.source) {
+		return nil, p.unexpected()
+	}
+	return &expression{eval: eval, fields: p.fields}, nil
+}
+
+// exprParser is a recursive descent parser turning a formula into nested functions
+type exprParser struct {
+	source string
+	pos    int      // Offset of the next character to read
+	depth  int      // Current nesting, limited to maxExpressionDepth
+	fields []string // Fields read so far
+}
+
+// sum parses terms separated by + and -
+func (p *exprParser) sum() (func(*model.SensorData) float64, error) {
+	left, err := p.product()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.skipSpaces()
+		if p.pos >= len(p.source) || (p.source[p.pos] != '+' && p.source[p.pos] != '-') {
+			return left, nil
+		}
+		op := p.source[p.pos]
+		p.pos++
+		right, err := p.product()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		if op == '+' {
+			left = func(d *model.SensorData) float64 { return l(d) + right(d) }
+		} else {
+			left = func(d *model.SensorData) float64 { return l(d) - right(d) }
+		}
+	}
+}
+
+// product parses factors separated by * and /
+func (p *exprParser) product() (func(*model.SensorData) float64, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.skipSpaces()
+		if p.pos >= len(p.source) || (p.source[p.pos] != '*' && p.source[p.pos] != '/') {
+			return left, nil
+		}
+		op := p.source[p.pos]
+		p.pos++
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		if op == '*' {
+			left = func(d *model.SensorData) float64 { return l(d) * right(d) }
+		} else {
+			left = func(d *model.SensorData) float64 { return l(d) / right(d) }
+		}
+	}
+}
+
+// unary parses a factor preceded by any number of signs
+func (p *exprParser) unary() (func(*model.SensorData) float64, error) {
+	p.skipSpaces()
+	if p.pos < len(p.source) && (p.source[p.pos] == '-' || p.source[p.pos] == '+') {
+		negate := p.source[p.pos] == '-'
+		p.pos++
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		operand, err := p.unary()
+		p.depth--
+		if err != nil || !negate {
+			return operand, err
+		}
+		return func(d *model.SensorData) float64 { return -operand(d) }, nil
+	}
+	return p.primary()
+}
+
+// primary parses a number, a field, a function call or a parenthesized sum
+func (p *exprParser) primary() (func(*model.SensorData) float64, error) {
+	p.skipSpaces()
+	if p.pos >= len(p.source) {
+		return nil, errors.New("unexpected end of expression")
+	}
+	c := p.source[p.pos]
+	switch {
+	case c == '(':
+		p.pos++
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		inner, err := p.sum()
+		p.depth--
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(')'); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	case isDigit(c) || c == '.':
+		return p.number()
+	case isIdentStart(c):
+		return p.identifier()
+	}
+	return nil, p.unexpected()
+}
+
+// number parses a decimal number with an optional exponent, such as 0.0125 or 1e-3
+func (p *exprParser) number() (func(*model.SensorData) float64, error) {
+	start := p.pos
+	for p.pos < len(p.source) && (isDigit(p.source[p.pos]) || p.source[p.pos] == '.') {
+		p.pos++
+	}
+	if p.pos < len(p.source) && (p.source[p.pos] == 'e' || p.source[p.pos] == 'E') {
+		p.pos++
+		if p.pos < len(p.source) && (p.source[p.pos] == '+' || p.source[p.pos] == '-') {
+			p.pos++
+		}
+		for p.pos < len(p.source) && isDigit(p.source[p.pos]) {
+			p.pos++
+		}
+	}
+	value, err := strconv.ParseFloat(p.source[start:p.pos], 64)
+	if err != nil || math.IsInf(value, 0) {
+		return nil, fmt.Errorf("invalid number %q at position %d", p.source[start:p.pos], start+1)
+	}
+	return func(*model.SensorData) float64 { return value }, nil
+}
+
+// identifier parses a field or a function call
+func (p *exprParser) identifier() (func(*model.SensorData) float64, error) {
+	start := p.pos
+	for p.pos < len(p.source) && (isIdentStart(p.source[p.pos]) || isDigit(p.source[p.pos])) {
+		p.pos++
+	}
+	name := p.source[start:p.pos]
+
+	if p.skipSpaces(); p.pos < len(p.source) && p.source[p.pos] == '(' {
+		function, ok := exprFunctions[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown function %q at position %d: use %s", name, start+1, strings.Join(ExpressionFunctions(), ", "))
+		}
+		p.pos++
+		return p.call(name, start, function)
+	}
+
+	get, err := readingField(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w at position %d", err, start+1)
+	}
+	if !slices.Contains(p.fields, name) {
+		p.fields = append(p.fields, name)
+	}
+	return get, nil
+}
+
+// call parses the arguments of a function call after its opening parenthesis
+func (p *exprParser) call(name string, start int, function exprFunction) (func(*model.SensorData) float64, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+
+	var args []func(*model.SensorData) float64
+	if p.skipSpaces(); p.pos < len(p.source) && p.source[p.pos] == ')' {
+		p.pos++
+	} else {
+		for {
+			arg, err := p.sum()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.skipSpaces(); p.pos < len(p.source) && p.source[p.pos] == ',' {
+				p.pos++
+				continue
+			}
+			if err := p.expect(')'); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	if len(args) < function.minArgs || (function.maxArgs >= 0 && len(args) > function.maxArgs) {
+		want := strconv.Itoa(function.minArgs)
+		switch {
+		case function.maxArgs < 0:
+			want = "at least " + want
+		case function.maxArgs != function.minArgs:
+			want += " to " + strconv.Itoa(function.maxArgs)
+		}
+		return nil, fmt.Errorf("%s at position %d takes %s arguments, got %d", name, start+1, want, len(args))
+	}
+
+	return func(d *model.SensorData) float64 {
+		values := make([]float64, len(args))
+		for i, arg := range args {
+			values[i] = arg(d)
+		}
+		return function.call(values)
+	}, nil
+}
+
+// readingField returns the accessor of a field formulas may read
+// Integrated virtual fields need the previous reading and other computed fields could
+// refer back to the formula, so neither may be used
+func readingField(name string) (func(*model.SensorData) float64, error) {
+	if model.IsNumericField(name) {
+		return func(d *model.SensorData) float64 {
+			value, _ := d.NumericValue(name)
+			return value
+		}, nil
+	}
+	if field, ok := derivedFields[name]; ok {
+		if field.integrated {
+			return nil, fmt.Errorf("field %q is integrated over time and cannot be used in an expression", name)
+		}
+		return field.value, nil
+	}
+	return nil, fmt.Errorf("unknown field %q", name)
+}
+
+// enter starts a nested part of the formula
+func (p *exprParser) enter() error {
+	p.depth++
+	if p.depth > maxExpressionDepth {
+		return fmt.Errorf("expression is nested more than %d levels deep", maxExpressionDepth)
+	}
+	return nil
+}
+
+// expect consumes the character c or reports what was found instead
+func (p *exprParser) expect(c byte) error {
+	if p.skipSpaces(); p.pos < len(p.source) && p.source[p.pos] == c {
+		p.pos++
+		return nil
+	}
+	if p.pos >= len(p.source) {
+		return fmt.Errorf("missing %q at end of expression", c)
+	}
+	return fmt.Errorf("expected %q at position %d, found %q", c, p.pos+1, p.source[p.pos])
+}
+
+// unexpected reports the character at the current position
+func (p *exprParser) unexpected() error {
+	return fmt.Errorf("unexpected %q at position %d", p.source[p.pos], p.pos+1)
+}
+
+// skipSpaces moves past blanks between tokens
+func (p *exprParser) skipSpaces() {
+	for p.pos < len(p.source) && (p.source[p.pos] == ' ' || p.source[p.pos] == '\t' || p.source[p.pos] == '\n' || p.source[p.pos] == '\r') {
+		p.pos++
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
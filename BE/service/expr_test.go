@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+
+	"gomongoviz/config"
+	"gomongoviz/model"
+	"gomongoviz/repository"
+)
+
+// reading is the record formulas are evaluated against in the tests
+var reading = model.SensorData{Voltage: 12, Current: 2, SupplyVolt: 15, SupplyCurrent: 2, AI3: 100}
+
+func TestCompileExpression(t *testing.T) {
+	tests := []struct {
+		source string
+		want   float64
+		fields []string
+	}{
+		// Precedence and associativity
+		{source: "1 + 2 * 3", want: 7},
+		{source: "(1 + 2) * 3", want: 9},
+		{source: "10 - 4 - 3", want: 3},
+		{source: "8 / 4 / 2", want: 1},
+		{source: "2 * 3 + 4 * 5", want: 26},
+		{source: "2 * (3 + 4) * 5", want: 70},
+
+		// Signs
+		{source: "-2 * 3", want: -6},
+		{source: "2 * -3", want: -6},
+		{source: "--2", want: 2},
+		{source: "+-2", want: -2},
+		{source: "-(1 + 2)", want: -3},
+		{source: "1 - -1", want: 2},
+
+		// Numbers
+		{source: "1e-3 * 1000", want: 1},
+		{source: "1.5E2", want: 150},
+		{source: "2e+1", want: 20},
+		{source: ".5 + .5", want: 1},
+		{source: "5.", want: 5},
+		{source: "0.0125", want: 0.0125},
+
+		// Fields, listed once in order of appearance
+		{source: "ai3 * 0.0125 + 0.4", want: 1.65, fields: []string{"ai3"}},
+		{source: "(supply_volt - voltage) / supply_current", want: 1.5, fields: []string{"supply_volt", "voltage", "supply_current"}},
+		{source: "voltage * voltage + current", want: 146, fields: []string{"voltage", "current"}},
+		{source: "power / supply_power", want: 0.8, fields: []string{"power", "supply_power"}},
+		{source: "efficiency * 100", want: 80, fields: []string{"efficiency"}},
+
+		// Functions
+		{source: "abs(-3)", want: 3},
+		{source: "sqrt(16)", want: 4},
+		{source: "pow(2, 10)", want: 1024},
+		{source: "min(3, 1, 2)", want: 1},
+		{source: "max(voltage, current, 5)", want: 12, fields: []string{"voltage", "current"}},
+		{source: "abs(min(-1, -2)) + max(1, 2)", want: 4},
+
+		// White space
+		{source: "  voltage\t*\n2\r ", want: 24, fields: []string{"voltage"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			expr, err := compileExpression(tt.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := expr.eval(&reading); math.Abs(got-tt.want) > 1e-12 {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if !slices.Equal(expr.fields, tt.fields) {
+				t.Errorf("fields %v, want %v", expr.fields, tt.fields)
+			}
+		})
+	}
+}
+
+func TestCompileExpressionErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"", "must not be empty"},
+		{"  \t", "must not be empty"},
+
+		// Syntax
+		{"1 +", "unexpected end of expression"},
+		{"(1 + 2", `missing ')' at end of expression`},
+		{"max(1, 2", `missing ')' at end of expression`},
+		{"(1 + 2]", `expected ')' at position 7, found ']'`},
+		{"1 + * 2", `unexpected '*' at position 5`},
+		{"1 2", `unexpected '2' at position 3`},
+		{"1 + 2)", `unexpected ')' at position 6`},
+		{"voltage; current", `unexpected ';' at position 8`},
+		{"2 ^ 3", `unexpected '^' at position 3`},
+		{"1..2", `invalid number "1..2" at position 1`},
+		{"1e", `invalid number "1e" at position 1`},
+		{"1e999", `invalid number "1e999" at position 1`},
+
+		// Function arity
+		{"pow(1)", "pow at position 1 takes 2 arguments, got 1"},
+		{"abs(1, 2)", "abs at position 1 takes 1 arguments, got 2"},
+		{"abs()", "abs at position 1 takes 1 arguments, got 0"},
+		{"1 + min(1)", "min at position 5 takes at least 2 arguments, got 1"},
+
+		// Unknown names
+		{"exec(1)", `unknown function "exec" at position 1: use abs, max, min, pow, sqrt`},
+		{"Abs(1)", `unknown function "Abs"`},
+		{"foo + 1", `unknown field "foo" at position 1`},
+		{"1 + fw_version", `unknown field "fw_version" at position 5`},
+		{"timestamp", `unknown field "timestamp"`},
+		{"drop_ohms * 2", `unknown field "drop_ohms"`},
+
+		// Integrated fields
+		{"energy * 2", `field "energy" is integrated over time and cannot be used in an expression at position 1`},
+		{"supply_energy", "is integrated over time"},
+		{"abs(charge)", `field "charge" is integrated over time`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			_, err := compileExpression(tt.source)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileExpressionLimits(t *testing.T) {
+	nested := func(open string, close string, depth int) string {
+		return strings.Repeat(open, depth) + "1" + strings.Repeat(close, depth)
+	}
+	tests := []struct {
+		name   string
+		source string
+		ok     bool
+		want   float64
+	}{
+		{"32 parentheses", nested("(", ")", maxExpressionDepth), true, 1},
+		{"33 parentheses", nested("(", ")", maxExpressionDepth+1), false, 0},
+		{"32 signs", nested("-", "", maxExpressionDepth), true, 1},
+		{"33 signs", nested("-", "", maxExpressionDepth+1), false, 0},
+		{"32 calls", nested("abs(", ")", maxExpressionDepth), true, 1},
+		{"33 calls", nested("abs(", ")", maxExpressionDepth+1), false, 0},
+		{"33 levels of signs, parentheses and calls", nested("-(abs(", "))", maxExpressionDepth/3+1), false, 0},
+		{"1000 characters", strings.Repeat("1+", MaxExpressionLength/2-1) + "1 ", true, MaxExpressionLength / 2},
+		{"1001 characters", strings.Repeat("1+", MaxExpressionLength/2) + "1", false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := compileExpression(tt.source)
+			if !tt.ok {
+				if err == nil {
+					t.Fatalf("%d characters compiled, want an error", len(tt.source))
+				}
+				if !strings.Contains(err.Error(), "nested more than 32 levels") && !strings.Contains(err.Error(), "longer than 1000 characters") {
+					t.Errorf("got error %v, want a limit error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := expr.eval(&reading); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComputedField(t *testing.T) {
+	repo := repository.NewMemoryRepository()
+	s := NewService(repo, config.Default())
+	ctx := context.Background()
+	for name, expression := range map[string]string{
+		"per_supply_amp": "voltage / supply_current",
+		"zero_by_zero":   "(voltage - 12) / (current - 2)",
+		"negative_root":  "sqrt(voltage - 20)",
+		"overflow":       "pow(voltage, 400)",
+		"watts_per_volt": "power / voltage",
+	} {
+		if err := repo.SaveComputedField(ctx, model.ComputedField{Name: name, Expression: expression}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		data   model.SensorData
+		want   float64
+		inputs []string
+	}{
+		{"per_supply_amp", reading, 6, []string{"voltage", "supply_current"}},
+		{"per_supply_amp", model.SensorData{Voltage: 12}, 0, nil},    // +Inf
+		{"per_supply_amp", model.SensorData{Voltage: -12}, 0, nil},   // -Inf
+		{"zero_by_zero", reading, 0, []string{"voltage", "current"}}, // NaN
+		{"negative_root", reading, 0, nil},                           // NaN
+		{"overflow", reading, 0, nil},                                // +Inf
+		{"watts_per_volt", reading, 2, []string{"voltage", "current"}},
+	}
+	for _, tt := range tests {
+		field, err := s.computedField(ctx, tt.name)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := field.value(&tt.data); got != tt.want {
+			t.Errorf("%s of %+v: got %v, want %v", tt.name, tt.data, got, tt.want)
+		}
+		if tt.inputs != nil && !slices.Equal(field.inputs, tt.inputs) {
+			t.Errorf("%s: inputs %v, want %v", tt.name, field.inputs, tt.inputs)
+		}
+	}
+
+	for _, name := range []string{"undefined", "Bad-Name"} {
+		if _, err := s.computedField(ctx, name); !errors.Is(err, ErrUnknownField) {
+			t.Errorf("%s: got %v, want ErrUnknownField", name, err)
+		}
+	}
+}
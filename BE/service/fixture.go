@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gomongoviz/model"
+)
+
+// ValidateSensorRecord checks the fields every uploaded JSON record must have
+func ValidateSensorRecord(record model.SensorData) error {
+	switch {
+	case record.Timestamp.IsZero():
+		return errors.New("missing or invalid timestamp")
+	case record.ObjectID == 0:
+		return errors.New("missing object_id")
+	case record.PortNum == 0:
+		return errors.New("missing port_num")
+	}
+	return nil
+}
+
+// LoadFixture loads a CSV or JSON file into the storage, chosen by its extension
+// CSV files use the CSV upload format and JSON files the JSON upload format; records
+// that are already stored are skipped so a fixture can be loaded on every start
+func (s *Service) LoadFixture(ctx context.Context, path string) (model.SaveResult, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return model.SaveResult{}, fmt.Errorf("opening fixture: %w", err)
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		report, err := s.ImportCSV(ctx, file, ImportOptions{Mode: model.ImportStrict, OnDuplicate: model.DuplicateSkip})
+		if err != nil {
+			return model.SaveResult{}, fmt.Errorf("loading fixture %s: %w", path, err)
+		}
+		return model.SaveResult{Inserted: report.RowsInserted, Duplicates: report.RowsDuplicate}, nil
+	case ".json":
+		var records []model.SensorData
+		if err := json.NewDecoder(file).Decode(&records); err != nil {
+			return model.SaveResult{}, fmt.Errorf("parsing fixture %s: %w", path, err)
+		}
+		for i := range records {
+			if err := ValidateSensorRecord(records[i]); err != nil {
+				return model.SaveResult{}, fmt.Errorf("fixture %s: record at index %d has %w", path, i, err)
+			}
+			records[i].CreatedAt = time.Now()
+		}
+		return s.SaveSensorData(ctx, records, model.DuplicateSkip)
+	}
+	return model.SaveResult{}, fmt.Errorf("unsupported fixture extension %q: use .csv or .json", filepath.Ext(path))
+}
@@ -0,0 +1,334 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"gomongoviz/model"
+	"gomongoviz/repository"
+)
+
+// ErrSlowConsumer is the reason a live client is dropped when it does not read its
+// records as fast as they arrive
+var ErrSlowConsumer = errors.New("client is not reading records fast enough")
+
+// slowConsumerGrace is how long a live client's queue may stay full before the client
+// is dropped
+const slowConsumerGrace = 5 * time.Second
+
+// LiveReading is a record delivered to a live client
+type LiveReading struct {
+	Data   *model.SensorData // The newly stored record, shared between clients and never modified
+	Fields []string          // Fields to send in addition to model.KeyFields, all fields when empty
+}
+
+// liveHub fans the records of one upstream feed out to every live client whose
+// subscriptions match them
+// The feed runs only while at least one client is connected
+type liveHub struct {
+	svc     *Service
+	mu      sync.Mutex
+	clients map[*LiveClient]bool
+	cancel  context.CancelFunc // Stops the running feed, nil when no feed runs
+}
+
+// LiveClient is one connection subscribed to the live records of any number of objects
+// and ports
+// Records are queued up to stream.client_buffer; a client that stays that far behind is
+// dropped with ErrSlowConsumer rather than holding up the others
+type LiveClient struct {
+	hub           *liveHub
+	subscriptions []model.Subscription // Guarded by hub.mu
+	readings      chan LiveReading
+	done          chan struct{} // Closed when the client is dropped or closed
+	err           error         // Why the client was dropped, set before done is closed
+}
+
+// NewLiveClient registers a live client without subscriptions, starting the upstream
+// feed if it is the first one
+// The client must be closed once its connection ends
+func (s *Service) NewLiveClient() *LiveClient {
+	client := &LiveClient{
+		hub:      s.hub,
+		readings: make(chan LiveReading, s.streamCfg.ClientBuffer),
+		done:     make(chan struct{}),
+	}
+	s.hub.register(client)
+	return client
+}
+
+// Subscribe adds subscriptions to the client, replacing those for the same object and
+// port, and returns all of its subscriptions
+func (c *LiveClient) Subscribe(subscriptions []model.Subscription) ([]model.Subscription, error) {
+	for _, subscription := range subscriptions {
+		for _, field := range subscription.Fields {
+			if !model.IsField(field) {
+				return nil, fmt.Errorf("unknown field %q", field)
+			}
+		}
+	}
+
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+	updated := slices.Clone(c.subscriptions)
+	for _, subscription := range subscriptions {
+		subscription.Fields = slices.Clone(subscription.Fields)
+		if i := slices.IndexFunc(updated, subscription.SameTarget); i >= 0 {
+			updated[i] = subscription
+		} else {
+			updated = append(updated, subscription)
+		}
+	}
+	if limit := c.hub.svc.streamCfg.MaxSubscriptions; len(updated) > limit {
+		return nil, fmt.Errorf("a client can have at most %d subscriptions", limit)
+	}
+	c.subscriptions = updated
+	return slices.Clone(c.subscriptions), nil
+}
+
+// Unsubscribe removes the subscriptions for the same object and port as the given ones
+// and returns the remaining subscriptions
+func (c *LiveClient) Unsubscribe(subscriptions []model.Subscription) []model.Subscription {
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+	c.subscriptions = slices.DeleteFunc(c.subscriptions, func(existing model.Subscription) bool {
+		return slices.ContainsFunc(subscriptions, existing.SameTarget)
+	})
+	return slices.Clone(c.subscriptions)
+}
+
+// Readings returns the channel delivering the records matching the subscriptions
+func (c *LiveClient) Readings() <-chan LiveReading {
+	return c.readings
+}
+
+// Done returns a channel that is closed when the client is dropped or closed
+func (c *LiveClient) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the client was dropped once Done is closed, nil if it was closed
+func (c *LiveClient) Err() error {
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+	return c.err
+}
+
+// Close unregisters the client, stopping the upstream feed if it was the last one
+func (c *LiveClient) Close() {
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+	c.hub.drop(c, nil)
+}
+
+// match returns the fields to send for a record and whether any subscription matches it
+// The fields of every matching subscription are combined; one asking for all fields wins
+func (c *LiveClient) match(data *model.SensorData) ([]string, bool) {
+	var fields []string
+	matched := false
+	for _, subscription := range c.subscriptions {
+		if !subscription.Matches(data) {
+			continue
+		}
+		if len(subscription.Fields) == 0 {
+			return nil, true
+		}
+		if !matched {
+			fields = subscription.Fields
+		} else {
+			for _, field := range subscription.Fields {
+				if !slices.Contains(fields, field) {
+					fields = append(slices.Clip(fields), field)
+				}
+			}
+		}
+		matched = true
+	}
+	return fields, matched
+}
+
+// register adds a client and starts the upstream feed if none is running
+func (h *liveHub) register(client *LiveClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients[client] = true
+	if h.cancel == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		h.cancel = cancel
+		go h.run(ctx)
+	}
+}
+
+// drop removes a client, recording err as the reason, and stops the upstream feed when
+// no client is left
+// The caller must hold h.mu
+func (h *liveHub) drop(client *LiveClient, err error) {
+	if !h.clients[client] {
+		return
+	}
+	delete(h.clients, client)
+	client.err = err
+	close(client.done)
+	if len(h.clients) == 0 && h.cancel != nil {
+		h.cancel()
+		h.cancel = nil
+	}
+}
+
+// dispatch queues a record for every client subscribed to it
+// Clients whose queue is full are waited for together, and those still full after
+// slowConsumerGrace are dropped, so the others are delayed at most by one grace period
+// however many clients are slow
+func (h *liveHub) dispatch(data *model.SensorData) {
+	type delivery struct {
+		client  *LiveClient
+		reading LiveReading
+	}
+	h.mu.Lock()
+	deliveries := make([]delivery, 0, len(h.clients))
+	for client := range h.clients {
+		if fields, ok := client.match(data); ok {
+			deliveries = append(deliveries, delivery{client, LiveReading{Data: data, Fields: fields}})
+		}
+	}
+	h.mu.Unlock()
+
+	var blocked []delivery
+	for _, d := range deliveries {
+		select {
+		case d.client.readings <- d.reading:
+		default:
+			blocked = append(blocked, d)
+		}
+	}
+	if len(blocked) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), slowConsumerGrace)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, d := range blocked {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case d.client.readings <- d.reading:
+			case <-d.client.done:
+			case <-ctx.Done():
+				log.Printf("Dropping live client after %d queued records: %v", cap(d.client.readings), ErrSlowConsumer)
+				h.mu.Lock()
+				h.drop(d.client, ErrSlowConsumer)
+				h.mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// objects returns the objects subscribed to by any client
+func (h *liveHub) objects() map[float64]bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	objects := make(map[float64]bool)
+	for client := range h.clients {
+		for _, subscription := range client.subscriptions {
+			objects[subscription.ObjectID] = true
+		}
+	}
+	return objects
+}
+
+// run feeds the hub until ctx is cancelled, restarting the feed after failures
+func (h *liveHub) run(ctx context.Context) {
+	positions := make(map[float64]*model.PageCursor)
+	for {
+		err := h.follow(ctx, positions)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Live feed stopped, restarting in %s: %v", h.svc.streamCfg.PollInterval, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(h.svc.streamCfg.PollInterval):
+		}
+	}
+}
+
+// follow dispatches the records of one change stream over the whole collection when
+// the storage supports it, and polls the subscribed objects otherwise
+func (h *liveHub) follow(ctx context.Context, positions map[float64]*model.PageCursor) error {
+	if h.svc.streamCfg.ChangeStreams {
+		watch, err := h.svc.repo.WatchData(ctx, model.SensorDataQuery{})
+		if err == nil {
+			defer watch.Close(context.WithoutCancel(ctx))
+			log.Printf("Live feed following the change stream")
+			for {
+				data, err := watch.Next(ctx)
+				if err != nil {
+					return err
+				}
+				h.dispatch(data)
+			}
+		}
+		if !errors.Is(err, repository.ErrWatchUnsupported) {
+			return err
+		}
+	}
+
+	log.Printf("Live feed polling every %s", h.svc.streamCfg.PollInterval)
+	ticker := time.NewTicker(h.svc.streamCfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := h.poll(ctx, positions); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll dispatches the records stored for every subscribed object since the last poll
+// An object is read once for all the clients and ports subscribed to it, starting
+// after its newest record when it is first subscribed to
+func (h *liveHub) poll(ctx context.Context, positions map[float64]*model.PageCursor) error {
+	objects := h.objects()
+	for objectID := range positions {
+		if !objects[objectID] {
+			delete(positions, objectID)
+		}
+	}
+
+	for objectID := range objects {
+		query := model.SensorDataQuery{ObjectID: strconv.FormatFloat(objectID, 'f', -1, 64), Limit: liveBatchSize}
+		after, known := positions[objectID]
+		if !known {
+			latest, err := h.svc.latestPosition(ctx, query)
+			if err != nil {
+				return err
+			}
+			positions[objectID] = latest
+			continue
+		}
+
+		after, err := h.svc.sendAfter(ctx, query, after, func(event LiveEvent) error {
+			h.dispatch(event.Data)
+			return nil
+		})
+		positions[objectID] = after
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
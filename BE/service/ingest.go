@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"gomongoviz/model"
+)
+
+// MaxReportedRowErrors caps the number of row problems kept in an import report
+const MaxReportedRowErrors = 1000
+
+// ImportOptions controls how a streaming import treats invalid rows and reports progress
+type ImportOptions struct {
+	Mode        string                     // One of the model.Import* modes, strict when empty
+	OnDuplicate string                     // One of the model.Duplicate* policies, skip when empty
+	Progress    func(model.ImportProgress) // Called after every inserted batch when not nil
+}
+
+// ParseImportMode validates the mode query parameter of the upload endpoints
+func ParseImportMode(mode string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "", model.ImportStrict:
+		return model.ImportStrict, nil
+	case model.ImportValidOnly:
+		return model.ImportValidOnly, nil
+	case model.ImportDryRun:
+		return model.ImportDryRun, nil
+	}
+	return "", fmt.Errorf("invalid mode %q: use %s, %s or %s", mode, model.ImportStrict, model.ImportValidOnly, model.ImportDryRun)
+}
+
+// ParseImportOptions validates the mode and duplicates query parameters of the upload endpoints
+func ParseImportOptions(mode string, duplicates string) (ImportOptions, error) {
+	var opts ImportOptions
+	var err error
+	if opts.Mode, err = ParseImportMode(mode); err != nil {
+		return ImportOptions{}, err
+	}
+	if opts.OnDuplicate, err = ParseDuplicatePolicy(duplicates); err != nil {
+		return ImportOptions{}, err
+	}
+	return opts, nil
+}
+
+// ImportCSV streams a CSV file into the database in batches of import.batch_size rows
+// Parsing runs ahead of the inserts by at most import.queue_depth batches, after which
+// reading from r pauses until the database catches up, so memory use stays flat
+// In strict mode the import stops at the first invalid row and returns its model.RowErrors;
+// every row before it stays in the database and the report tells how many there are
+// In valid-only mode invalid rows are skipped and listed in the report, and in
+// dry-run mode every row is validated without inserting anything
+// Cancelling ctx stops the import after the batch being written
+func (s *Service) ImportCSV(ctx context.Context, r io.Reader, opts ImportOptions) (model.ImportReport, error) {
+	if opts.Mode == "" {
+		opts.Mode = model.ImportStrict
+	}
+	if opts.OnDuplicate == "" {
+		opts.OnDuplicate = model.DuplicateSkip
+	}
+	parser, err := NewCSVParser(r)
+	if err != nil {
+		return model.ImportReport{Mode: opts.Mode, Errors: []model.RowError{}}, err
+	}
+	return s.importStream(ctx, parser.Next, opts)
+}
+
+// importStream reads records from next until io.EOF and writes them in bounded batches
+// The reader and the inserter run concurrently and are connected by a bounded channel
+func (s *Service) importStream(ctx context.Context, next func() (model.SensorData, error), opts ImportOptions) (model.ImportReport, error) {
+	var rowsRead, rowsInserted, rowsRejected, rowsDuplicate, batchCount atomic.Int64
+	snapshot := func() model.ImportProgress {
+		return model.ImportProgress{
+			RowsRead:      rowsRead.Load(),
+			RowsInserted:  rowsInserted.Load(),
+			RowsRejected:  rowsRejected.Load(),
+			RowsDuplicate: rowsDuplicate.Load(),
+			Batches:       batchCount.Load(),
+		}
+	}
+	report := model.ImportReport{Mode: opts.Mode, Errors: []model.RowError{}}
+
+	batches := make(chan []model.SensorData, s.importCfg.QueueDepth)
+	stop := make(chan struct{}) // Closed by the inserter when a write fails
+	var insertErr error
+	var wg sync.WaitGroup
+
+	// Inserter: writes batches in order and reports progress after each one
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for batch := range batches {
+			result, err := s.SaveSensorData(ctx, batch, opts.OnDuplicate)
+			if err != nil {
+				insertErr = err
+				close(stop)
+				// Drain so the reader never blocks on a full channel
+				for range batches {
+				}
+				return
+			}
+			rowsInserted.Add(result.Inserted)
+			rowsDuplicate.Add(result.Duplicates)
+			batchCount.Add(1)
+			if opts.Progress != nil {
+				opts.Progress(snapshot())
+			}
+		}
+	}()
+
+	// send hands a batch to the inserter, blocking while the queue is full
+	send := func(batch []model.SensorData) bool {
+		select {
+		case batches <- batch:
+			return true
+		case <-stop:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	// Reader: parses records and groups them into batches
+	var readErr error
+	batch := make([]model.SensorData, 0, s.importCfg.BatchSize)
+	for {
+		data, err := next()
+		if err == io.EOF {
+			break
+		}
+
+		var problems model.RowErrors
+		if errors.As(err, &problems) {
+			rowsRead.Add(1)
+			rowsRejected.Add(1)
+			report.ErrorsTruncated = report.ErrorsTruncated || len(report.Errors)+len(problems) > MaxReportedRowErrors
+			for _, problem := range problems {
+				if len(report.Errors) < MaxReportedRowErrors {
+					report.Errors = append(report.Errors, problem)
+				}
+			}
+			if opts.Mode == model.ImportStrict {
+				readErr = err
+				break
+			}
+			continue
+		}
+		if err != nil {
+			readErr = err
+			break
+		}
+
+		rowsRead.Add(1)
+		if opts.Mode == model.ImportDryRun {
+			continue
+		}
+		batch = append(batch, data)
+		if len(batch) == s.importCfg.BatchSize {
+			if !send(batch) {
+				break
+			}
+			batch = make([]model.SensorData, 0, s.importCfg.BatchSize)
+		}
+	}
+
+	// Rows before a bad row are still written, so a failed import stops exactly at that row
+	if len(batch) > 0 {
+		send(batch)
+	}
+	close(batches)
+	wg.Wait()
+
+	report.ImportProgress = snapshot()
+	if insertErr != nil {
+		return report, insertErr
+	}
+	if readErr == nil {
+		readErr = ctx.Err()
+	}
+	return report, readErr
+}
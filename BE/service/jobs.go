@@ -0,0 +1,204 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gomongoviz/model"
+)
+
+// ErrImportQueueFull is returned when too many import jobs are already waiting
+var ErrImportQueueFull = errors.New("import queue is full, try again later")
+
+// SubmitImport spools an uploaded CSV file to disk and queues an import job for it
+// opts decides how invalid and duplicate rows are handled; its Progress callback is not used
+// It returns as soon as the file is stored; the job is processed by a worker in the background
+// ctx only covers storing the file and the job record, the job itself outlives the request
+func (s *Service) SubmitImport(ctx context.Context, filename string, opts ImportOptions, r io.Reader) (*model.ImportJob, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	// Store the upload on disk so the HTTP request can finish before the import runs
+	if err := os.MkdirAll(s.importCfg.SpoolDir, 0o755); err != nil {
+		return nil, err
+	}
+	spoolPath := filepath.Join(s.importCfg.SpoolDir, id+".csv")
+	file, err := os.Create(spoolPath)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(spoolPath)
+		return nil, err
+	}
+
+	job := model.ImportJob{
+		ID:          id,
+		State:       model.ImportQueued,
+		Filename:    filename,
+		SpoolPath:   spoolPath,
+		Mode:        opts.Mode,
+		OnDuplicate: opts.OnDuplicate,
+		Errors:      []string{},
+		RowErrors:   []model.RowError{},
+		CreatedAt:   time.Now(),
+	}
+	if err := s.repo.SaveImportJob(ctx, job); err != nil {
+		os.Remove(spoolPath)
+		return nil, err
+	}
+
+	// Refuse the job rather than block the request when every slot is taken
+	select {
+	case s.jobQueue <- id:
+	default:
+		job.State = model.ImportFailed
+		job.Errors = append(job.Errors, ErrImportQueueFull.Error())
+		job.FinishedAt = time.Now()
+		s.saveJob(job)
+		os.Remove(spoolPath)
+		return nil, ErrImportQueueFull
+	}
+
+	log.Printf("Queued import job %s for file %s", id, filename)
+	return &job, nil
+}
+
+// GetImportJob retrieves the current status of an import job
+func (s *Service) GetImportJob(ctx context.Context, id string) (*model.ImportJob, error) {
+	return s.repo.GetImportJob(ctx, id)
+}
+
+// ListImportJobs retrieves import jobs, newest first, optionally filtered by state
+func (s *Service) ListImportJobs(ctx context.Context, states []string) ([]model.ImportJob, error) {
+	return s.repo.ListImportJobs(ctx, states)
+}
+
+// StartImportWorkers recovers jobs left over from a previous run and starts the worker pool
+// Queued jobs whose file is still spooled are resumed; jobs that were running when the
+// server stopped are marked failed, since some of their rows may already be stored
+func (s *Service) StartImportWorkers(ctx context.Context) error {
+	pending, err := s.repo.ListImportJobs(ctx, []string{model.ImportQueued, model.ImportRunning})
+	if err != nil {
+		return err
+	}
+
+	// Oldest jobs first so they keep their place in the queue
+	for i := len(pending) - 1; i >= 0; i-- {
+		job := pending[i]
+		_, statErr := os.Stat(job.SpoolPath)
+		if job.State == model.ImportQueued && statErr == nil {
+			select {
+			case s.jobQueue <- job.ID:
+				log.Printf("Resumed import job %s", job.ID)
+				continue
+			default:
+			}
+		}
+		job.State = model.ImportFailed
+		job.Errors = append(job.Errors, "interrupted by server restart")
+		job.FinishedAt = time.Now()
+		s.saveJob(job)
+		s.notify(model.EventImportFailed, job)
+		os.Remove(job.SpoolPath)
+	}
+
+	for i := 0; i < s.importCfg.Workers; i++ {
+		go s.importWorker()
+	}
+	log.Printf("Started %d import workers", s.importCfg.Workers)
+	return nil
+}
+
+// importWorker processes queued import jobs one at a time
+// Jobs are not tied to any request, so they run without a cancellable context
+func (s *Service) importWorker() {
+	for id := range s.jobQueue {
+		job, err := s.repo.GetImportJob(context.Background(), id)
+		if err != nil {
+			log.Printf("Error loading import job %s: %v", id, err)
+			continue
+		}
+		s.runImportJob(*job)
+	}
+}
+
+// runImportJob imports the spooled file of a job and records its progress and outcome
+func (s *Service) runImportJob(job model.ImportJob) {
+	defer os.Remove(job.SpoolPath)
+
+	job.State = model.ImportRunning
+	job.StartedAt = time.Now()
+	s.saveJob(job)
+
+	file, err := os.Open(job.SpoolPath)
+	if err != nil {
+		s.finishJob(job, err)
+		return
+	}
+	defer file.Close()
+
+	report, err := s.ImportCSV(context.Background(), file, ImportOptions{
+		Mode:        job.Mode,
+		OnDuplicate: job.OnDuplicate,
+		Progress: func(p model.ImportProgress) {
+			job.RowsProcessed = p.RowsRead
+			job.RowsInserted = p.RowsInserted
+			job.RowsRejected = p.RowsRejected
+			job.RowsDuplicate = p.RowsDuplicate
+			s.saveJob(job)
+		},
+	})
+	job.RowsProcessed = report.RowsRead
+	job.RowsInserted = report.RowsInserted
+	job.RowsRejected = report.RowsRejected
+	job.RowsDuplicate = report.RowsDuplicate
+	job.RowErrors = report.Errors
+	s.finishJob(job, err)
+}
+
+// finishJob records the final state of a job and notifies it
+func (s *Service) finishJob(job model.ImportJob, err error) {
+	job.State = model.ImportSucceeded
+	event := model.EventImportSucceeded
+	if err != nil {
+		job.State = model.ImportFailed
+		job.Errors = append(job.Errors, err.Error())
+		event = model.EventImportFailed
+	}
+	job.FinishedAt = time.Now()
+	s.saveJob(job)
+	s.notify(event, job)
+	log.Printf("Import job %s %s: %d rows inserted, %d duplicates, %d rejected",
+		job.ID, job.State, job.RowsInserted, job.RowsDuplicate, job.RowsRejected)
+}
+
+// saveJob persists a job record, logging failures since the import itself is unaffected
+func (s *Service) saveJob(job model.ImportJob) {
+	if err := s.repo.SaveImportJob(context.Background(), job); err != nil {
+		log.Printf("Error saving import job %s: %v", job.ID, err)
+	}
+}
+
+// newID generates a random identifier for an import job, alert rule or alert event
+func newID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
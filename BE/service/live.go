@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"gomongoviz/model"
+	"gomongoviz/repository"
+)
+
+// liveBatchSize caps the records read per query while a live stream catches up
+const liveBatchSize = 500
+
+// LiveEvent is one record delivered to a live stream subscriber
+type LiveEvent struct {
+	ID   string            // Position of the record, sent back as Last-Event-ID to resume after it
+	Data *model.SensorData // The newly stored record
+}
+
+// ParseEventID validates the Last-Event-ID of a reconnecting live stream client
+// It returns nil when the client has not received any event yet
+func ParseEventID(id string) (*model.PageCursor, error) {
+	if id == "" {
+		return nil, nil
+	}
+	after, err := model.DecodePageCursor(id)
+	if err != nil || after.Desc {
+		return nil, errors.New("invalid Last-Event-ID")
+	}
+	return after, nil
+}
+
+// eventID returns the ID of the live event carrying a record
+func eventID(data *model.SensorData) string {
+	return model.EncodePageCursor(model.PageCursor{Timestamp: data.Timestamp, ID: data.ID})
+}
+
+// StreamHeartbeat returns the delay between keep-alive messages on idle live streams
+func (s *Service) StreamHeartbeat() time.Duration {
+	return s.streamCfg.Heartbeat
+}
+
+// WatchData calls fn for every record of the object and port of query stored after the
+// stream starts, until ctx is cancelled or fn fails
+// With after set, the records following that position are sent first, so a client that
+// reconnects with its Last-Event-ID misses nothing
+// New records come from a change stream when the storage supports one; otherwise the
+// timestamp index is polled for records newer than the last one sent, which does not
+// see records stored later with an older timestamp
+func (s *Service) WatchData(ctx context.Context, query model.SensorDataQuery, after *model.PageCursor, fn func(LiveEvent) error) error {
+	query.Limit = liveBatchSize
+	query.SortDesc = false
+	query.From = time.Time{}
+	query.To = time.Time{}
+	query.Projection = nil
+
+	if s.streamCfg.ChangeStreams {
+		watch, err := s.repo.WatchData(ctx, query)
+		if err == nil {
+			defer watch.Close(context.WithoutCancel(ctx))
+			return s.watchChanges(ctx, query, after, watch, fn)
+		}
+		if !errors.Is(err, repository.ErrWatchUnsupported) {
+			return err
+		}
+		log.Printf("Streaming object %s by polling: %v", query.ObjectID, err)
+	}
+	return s.pollData(ctx, query, after, fn)
+}
+
+// watchChanges sends the records after the client's position, then every record
+// delivered by the change stream
+// The stream is opened before catching up, so records inserted meanwhile are not lost;
+// those already sent while catching up are skipped
+func (s *Service) watchChanges(ctx context.Context, query model.SensorDataQuery, after *model.PageCursor, watch repository.DataWatch, fn func(LiveEvent) error) error {
+	sent := make(map[string]bool)
+	if after != nil {
+		_, err := s.sendAfter(ctx, query, after, func(event LiveEvent) error {
+			sent[event.Data.ID] = true
+			return fn(event)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		data, err := watch.Next(ctx)
+		if err != nil {
+			return err
+		}
+		if sent[data.ID] {
+			continue
+		}
+		if err := fn(LiveEvent{ID: eventID(data), Data: data}); err != nil {
+			return err
+		}
+	}
+}
+
+// pollData queries for records after the last one sent every stream.poll_interval
+// Without a position to resume from it starts after the newest stored record
+func (s *Service) pollData(ctx context.Context, query model.SensorDataQuery, after *model.PageCursor, fn func(LiveEvent) error) error {
+	if after == nil {
+		var err error
+		if after, err = s.latestPosition(ctx, query); err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(s.streamCfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		var err error
+		if after, err = s.sendAfter(ctx, query, after, fn); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// latestPosition returns the position of the newest record matching the object and
+// port of query, or nil if there is none
+func (s *Service) latestPosition(ctx context.Context, query model.SensorDataQuery) (*model.PageCursor, error) {
+	query.Limit = 1
+	query.SortDesc = true
+	query.After = nil
+	res, err := s.repo.GetDataByObjectID(ctx, query)
+	if err != nil || len(res.SensorData) == 0 {
+		return nil, err
+	}
+	newest := &res.SensorData[0]
+	return &model.PageCursor{Timestamp: newest.Timestamp, ID: newest.ID}, nil
+}
+
+// sendAfter calls fn for every stored record after the position, in (timestamp, _id)
+// order, and returns the position of the last record sent
+// A nil position starts from the oldest record
+func (s *Service) sendAfter(ctx context.Context, query model.SensorDataQuery, after *model.PageCursor, fn func(LiveEvent) error) (*model.PageCursor, error) {
+	for {
+		// Bounding the range from below keeps the count of every page small
+		query.After = after
+		if after != nil {
+			query.From = after.Timestamp
+		}
+		res, err := s.repo.GetDataByObjectID(ctx, query)
+		if err != nil {
+			return after, err
+		}
+		for i := range res.SensorData {
+			data := &res.SensorData[i]
+			if err := fn(LiveEvent{ID: eventID(data), Data: data}); err != nil {
+				return after, err
+			}
+			after = &model.PageCursor{Timestamp: data.Timestamp, ID: data.ID}
+		}
+		if res.NextCursor == "" {
+			return after, nil
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gomongoviz/model"
+)
+
+// MaxPageSize is the largest page a client may request with the limit parameter
+const MaxPageSize = 10000
+
+// ParsePaging parses the limit, sort and cursor query parameters into the query
+// sort accepts "asc" (default) or "desc" and orders records by timestamp
+// A cursor must be used with the same sort order it was produced with
+func ParsePaging(limit string, sort string, cursor string, query *model.SensorDataQuery) error {
+	if limit != "" {
+		n, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid limit %q: must be a positive integer", limit)
+		}
+		if n > MaxPageSize {
+			return fmt.Errorf("invalid limit %d: must not exceed %d", n, MaxPageSize)
+		}
+		query.Limit = n
+	}
+
+	switch strings.ToLower(sort) {
+	case "", "asc":
+		query.SortDesc = false
+	case "desc":
+		query.SortDesc = true
+	default:
+		return fmt.Errorf("invalid sort %q: use asc or desc", sort)
+	}
+
+	if cursor != "" {
+		after, err := model.DecodePageCursor(cursor)
+		if err != nil {
+			return err
+		}
+		// Without an explicit sort the cursor keeps the order it was created with
+		if sort == "" {
+			query.SortDesc = after.Desc
+		} else if after.Desc != query.SortDesc {
+			return errors.New("cursor was created with a different sort order")
+		}
+		query.After = after
+	}
+
+	return nil
+}
+
+// ParseProjection parses the fields query parameter of the data endpoint
+// It accepts a comma separated list of any SensorData field names and virtual fields;
+// names of computed fields are checked when the query runs
+func ParseProjection(fields string, query *model.SensorDataQuery) error {
+	for _, field := range strings.Split(fields, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !model.IsField(field) && !IsDerivedField(field) && !isComputedName(field) {
+			return fmt.Errorf("unknown field %q", field)
+		}
+		query.Projection = append(query.Projection, field)
+	}
+	return nil
+}
@@ -1,6 +1,12 @@
 package service
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gomongoviz/config"
 	"gomongoviz/model"
 	"gomongoviz/repository"
 )
@@ -9,13 +15,24 @@ import (
 // It sits between the handler and repository layers, processing data from the repository
 // before passing it to the handlers
 type Service struct {
-	repo repository.Repository // Repository interface for data access
+	repo       repository.Repository // Repository interface for data access
+	importCfg  config.ImportConfig   // Batching settings for streaming imports
+	streamCfg  config.StreamConfig   // Settings of live data streams
+	alertsCfg  config.AlertsConfig   // Settings of alert rule evaluation
+	ingestCfg  config.IngestConfig   // Batching settings for device ingestion
+	derivedCfg config.DerivedConfig  // Settings of the virtual fields computed from stored ones
+	hub        *liveHub              // Fans new records out to live clients
+	alerts     *alertEngine          // Alert rules and the state of their alerts
+	anomalies  *anomalyDetector      // Baselines scoring saved readings for anomalies
+	webhooks   *webhookDispatcher    // Notifications posted to other systems
+	ingest     *ingestBuffer         // Records pushed by devices waiting for insert
+	jobQueue   chan string           // IDs of import jobs waiting for a worker
 }
 
 // GetPorts retrieves all ports associated with a specific object ID
 // It delegates to the repository layer and returns the port information
-func (s *Service) GetPorts(objectID int) (any, error) {
-	ports, err := s.repo.GetPorts(objectID)
+func (s *Service) GetPorts(ctx context.Context, objectID int) (any, error) {
+	ports, err := s.repo.GetPorts(ctx, objectID)
 	if err != nil {
 		return nil, err
 	}
@@ -24,8 +41,8 @@ func (s *Service) GetPorts(objectID int) (any, error) {
 
 // GetUniqueObjectIDs retrieves all unique object IDs from the repository
 // This is used to populate selection dropdowns and filters in the UI
-func (s *Service) GetUniqueObjectIDs() ([]model.ObjectInfo, error) {
-	objectIDs, err := s.repo.GetUniqueObjectIDs()
+func (s *Service) GetUniqueObjectIDs(ctx context.Context) ([]model.ObjectInfo, error) {
+	objectIDs, err := s.repo.GetUniqueObjectIDs(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -33,25 +50,160 @@ func (s *Service) GetUniqueObjectIDs() ([]model.ObjectInfo, error) {
 }
 
 // GetDataByObjectID retrieves sensor data for a specific object ID
-// Optionally filtered by port number if provided
-func (s *Service) GetDataByObjectID(objectID string, portNum string) (*model.SensorDataRes, error) {
-	data, err := s.repo.GetDataByObjectID(objectID, portNum)
+// Optionally filtered by port number and time range if provided
+// Virtual fields in the projection, such as power, are computed from the stored fields
+func (s *Service) GetDataByObjectID(ctx context.Context, query model.SensorDataQuery) (*model.SensorDataRes, error) {
+	stored, virtual, err := s.splitFields(ctx, query.Projection)
+	if err != nil {
+		return nil, err
+	}
+	if len(virtual) == 0 {
+		return s.repo.GetDataByObjectID(ctx, query)
+	}
+
+	inputs := derivedInputs(stored, virtual)
+	read := query
+	read.Projection = inputs
+	data, err := s.repo.GetDataByObjectID(ctx, read)
 	if err != nil {
 		return nil, err
 	}
+	if err := s.deriveRecords(ctx, query.ObjectID, data.SensorData, query.SortDesc, virtual, inputs); err != nil {
+		return nil, err
+	}
+	data.Projection = query.Projection
 	return data, nil
 }
 
+// GetSeries retrieves the requested fields as chart series downsampled to at most
+// query.MaxPoints points each, so the payload size stays fixed however large the history is
+func (s *Service) GetSeries(ctx context.Context, query model.SeriesQuery) (*model.SeriesRes, error) {
+	// Series are always built from the full, time-ordered range
+	// and only the plotted fields are loaded
+	query.Limit = 0
+	query.SortDesc = false
+	query.After = nil
+	query.Projection = query.Fields
+
+	data, err := s.GetDataByObjectID(ctx, query.SensorDataQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &model.SeriesRes{
+		Series: make(map[string][]model.SeriesPoint, len(query.Fields)),
+		Mode:   query.Mode,
+		Total:  data.Total,
+	}
+	for _, field := range query.Fields {
+		points := make([]model.SeriesPoint, 0, len(data.SensorData))
+		for i := range data.SensorData {
+			value, _ := data.SensorData[i].NumericValue(field)
+			points = append(points, model.SeriesPoint{X: data.SensorData[i].Timestamp, Y: value})
+		}
+		res.Series[field] = Downsample(points, query.MaxPoints, query.Mode)
+	}
+	return res, nil
+}
+
+// AggregateData computes time-bucketed aggregates such as hourly averages per port
+// The query is expected to be validated with ParseAggregateOptions
+// Stored fields are aggregated by the database and virtual fields by the server, and the
+// values of both are merged into the same buckets
+func (s *Service) AggregateData(ctx context.Context, query model.AggregateQuery) ([]model.AggregateBucket, error) {
+	stored, virtual, err := s.splitFields(ctx, query.Fields)
+	if err != nil {
+		return nil, err
+	}
+	if len(virtual) == 0 {
+		return s.repo.AggregateData(ctx, query)
+	}
+
+	computed := query
+	computed.Fields = virtualNames(virtual)
+	buckets, err := s.aggregateDerived(ctx, computed)
+	if err != nil || len(stored) == 0 {
+		return buckets, err
+	}
+
+	query.Fields = stored
+	results, err := s.repo.AggregateData(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	type bucketKey struct {
+		start   int64
+		portNum float64
+	}
+	values := make(map[bucketKey]map[string]map[string]float64, len(buckets))
+	for _, bucket := range buckets {
+		values[bucketKey{start: bucket.Timestamp.UnixMilli(), portNum: bucket.PortNum}] = bucket.Values
+	}
+	for _, result := range results {
+		for field, functions := range values[bucketKey{start: result.Timestamp.UnixMilli(), portNum: result.PortNum}] {
+			result.Values[field] = functions
+		}
+	}
+	return results, nil
+}
+
 // SaveSensorData saves a batch of sensor data records to the database
 // This is used for the CSV upload feature
-func (s *Service) SaveSensorData(data []model.SensorData) error {
-	return s.repo.SaveSensorData(data)
+// onDuplicate is one of the model.Duplicate* policies for records that are already stored
+// The saved records are evaluated against the alert rules and scored for anomalies
+func (s *Service) SaveSensorData(ctx context.Context, data []model.SensorData, onDuplicate string) (model.SaveResult, error) {
+	result, err := s.repo.SaveSensorData(ctx, data, onDuplicate)
+	if err == nil {
+		s.evaluateAlerts(ctx, data)
+		s.evaluateAnomalies(ctx, data)
+	}
+	return result, err
+}
+
+// EnsureIndexes creates the declared database indexes that do not exist yet
+func (s *Service) EnsureIndexes(ctx context.Context) ([]model.IndexStatus, error) {
+	return s.repo.EnsureIndexes(ctx)
+}
+
+// IndexStatus reports which declared database indexes exist and which other indexes are present
+func (s *Service) IndexStatus(ctx context.Context) ([]model.IndexStatus, error) {
+	return s.repo.IndexStatus(ctx)
+}
+
+// ParseDuplicatePolicy validates the duplicates query parameter of the upload endpoints
+// Duplicates are skipped unless another policy is requested, so repeated uploads are safe
+func ParseDuplicatePolicy(policy string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(policy)) {
+	case "", model.DuplicateSkip:
+		return model.DuplicateSkip, nil
+	case model.DuplicateUpsert:
+		return model.DuplicateUpsert, nil
+	case model.DuplicateError:
+		return model.DuplicateError, nil
+	}
+	return "", fmt.Errorf("invalid duplicates %q: use %s, %s or %s", policy, model.DuplicateSkip, model.DuplicateUpsert, model.DuplicateError)
 }
 
-// NewService creates a new service instance with the provided repository
+// NewService creates a new service instance with the provided repository and configuration
 // This follows the dependency injection pattern, allowing for easier testing
-func NewService(repo repository.Repository) *Service {
-	return &Service{
-		repo: repo,
+func NewService(repo repository.Repository, cfg *config.Config) *Service {
+	s := &Service{
+		repo:       repo,
+		importCfg:  cfg.Import,
+		streamCfg:  cfg.Stream,
+		alertsCfg:  cfg.Alerts,
+		ingestCfg:  cfg.Ingest,
+		derivedCfg: cfg.Derived,
+		alerts:     newAlertEngine(),
+		anomalies:  newAnomalyDetector(cfg.Anomalies),
+		webhooks: &webhookDispatcher{
+			cfg:    cfg.Webhooks,
+			client: &http.Client{},
+			slots:  make(chan struct{}, cfg.Webhooks.Workers),
+		},
+		ingest:   &ingestBuffer{full: make(chan struct{}, 1)},
+		jobQueue: make(chan string, cfg.Import.MaxQueuedJobs),
 	}
+	s.hub = &liveHub{svc: s, clients: make(map[*LiveClient]bool)}
+	return s
 }
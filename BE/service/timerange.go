@@ -0,0 +1,68 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseTimeBound parses a from/to query parameter into an absolute time
+// Accepted forms are RFC3339 timestamps ("2023-09-01T10:00:00Z"), "now" and
+// durations relative to now such as "-3h", "-90m" or "-7d"
+// An empty value returns the zero time, meaning the bound is not applied
+func ParseTimeBound(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if value == "now" {
+		return now, nil
+	}
+
+	// Relative forms always carry an explicit sign
+	if strings.HasPrefix(value, "-") || strings.HasPrefix(value, "+") {
+		offset, err := parseRelativeDuration(value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid relative time %q: %w", value, err)
+		}
+		return now.Add(offset), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: use RFC3339 or a relative form like -3h", value)
+	}
+	return t, nil
+}
+
+// ParseTimeRange parses both bounds of a time range and checks that they are ordered
+func ParseTimeRange(from string, to string, now time.Time) (time.Time, time.Time, error) {
+	fromTime, err := ParseTimeBound(from, now)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	toTime, err := ParseTimeBound(to, now)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if !fromTime.IsZero() && !toTime.IsZero() && fromTime.After(toTime) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from (%s) must not be after to (%s)",
+			fromTime.Format(time.RFC3339), toTime.Format(time.RFC3339))
+	}
+	return fromTime, toTime, nil
+}
+
+// parseRelativeDuration extends time.ParseDuration with day ("d") and week ("w") units
+func parseRelativeDuration(value string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if strings.HasSuffix(value, suffix) {
+			count, err := strconv.ParseFloat(strings.TrimSuffix(value, suffix), 64)
+			if err != nil {
+				return 0, err
+			}
+			return time.Duration(count * float64(unit)), nil
+		}
+	}
+	return time.ParseDuration(value)
+}
@@ -0,0 +1,282 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"gomongoviz/config"
+	"gomongoviz/model"
+)
+
+// Headers sent with every webhook request
+const (
+	HeaderWebhookEvent     = "X-GoMongoViz-Event"     // Event name, e.g. alert.firing
+	HeaderWebhookDelivery  = "X-GoMongoViz-Delivery"  // Delivery ID, the same on every attempt
+	HeaderWebhookTimestamp = "X-GoMongoViz-Timestamp" // Unix time of the attempt, in seconds
+	HeaderWebhookSignature = "X-GoMongoViz-Signature" // "sha256=" and the hex HMAC of the timestamp, a dot and the body
+)
+
+// ErrDeliveryPending is returned when redelivering a delivery that is still being attempted
+var ErrDeliveryPending = errors.New("delivery is still pending")
+
+// webhookDispatcher posts notifications to the configured endpoints
+// Every delivery is stored before its first attempt and after each one, so deliveries
+// pending when the server stops are resumed at the next start
+type webhookDispatcher struct {
+	cfg    config.WebhooksConfig
+	client *http.Client
+	slots  chan struct{}   // Limits the attempts made at the same time to webhooks.workers
+	ctx    context.Context // Set by StartWebhooks; deliveries are only attempted once it is set
+}
+
+// SetWebhookClient replaces the HTTP client posting notifications, e.g. to trust the
+// certificate of a test receiver
+// It must be called before StartWebhooks
+func (s *Service) SetWebhookClient(client *http.Client) {
+	s.webhooks.client = client
+}
+
+// StartWebhooks resumes the deliveries left pending by a previous run and lets new
+// notifications be delivered until ctx is cancelled
+func (s *Service) StartWebhooks(ctx context.Context) error {
+	pending, err := s.repo.ListWebhookDeliveries(ctx, model.WebhookDeliveryQuery{States: []string{model.DeliveryPending}})
+	if err != nil {
+		return err
+	}
+	s.webhooks.ctx = ctx
+	for _, delivery := range pending {
+		s.scheduleDelivery(delivery, time.Until(delivery.NextAttempt))
+	}
+	log.Printf("Webhook notifications to %d endpoints, %d deliveries resumed", len(s.webhooks.cfg.Endpoints), len(pending))
+	return nil
+}
+
+// GetWebhookDelivery retrieves a webhook delivery and its attempts
+func (s *Service) GetWebhookDelivery(ctx context.Context, id string) (*model.WebhookDelivery, error) {
+	return s.repo.GetWebhookDelivery(ctx, id)
+}
+
+// ListWebhookDeliveries retrieves the webhook delivery history, newest first
+// Deliveries in the dead state form the dead-letter log
+func (s *Service) ListWebhookDeliveries(ctx context.Context, query model.WebhookDeliveryQuery) ([]model.WebhookDelivery, error) {
+	return s.repo.ListWebhookDeliveries(ctx, query)
+}
+
+// RedeliverWebhook queues a dead or delivered notification again, with the same payload
+// and a fresh series of webhooks.max_attempts attempts
+// It returns repository.ErrNotFound if no delivery has that ID and ErrDeliveryPending if
+// the delivery is still being attempted
+func (s *Service) RedeliverWebhook(ctx context.Context, id string) (*model.WebhookDelivery, error) {
+	delivery, err := s.repo.GetWebhookDelivery(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if delivery.State == model.DeliveryPending {
+		return nil, ErrDeliveryPending
+	}
+	delivery.State = model.DeliveryPending
+	delivery.QueuedAt = time.Now()
+	delivery.NextAttempt = delivery.QueuedAt
+	delivery.DeliveredAt = time.Time{}
+	if err := s.repo.SaveWebhookDelivery(ctx, *delivery); err != nil {
+		return nil, err
+	}
+	s.scheduleDelivery(*delivery, 0)
+	return delivery, nil
+}
+
+// notify queues a notification for every endpoint subscribed to the event
+// Notifications are sent in the background; failures to queue them are logged
+func (s *Service) notify(event string, data interface{}) {
+	var endpoints []config.WebhookEndpoint
+	for _, endpoint := range s.webhooks.cfg.Endpoints {
+		if len(endpoint.Events) == 0 || slices.Contains(endpoint.Events, event) {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	if len(endpoints) == 0 {
+		return
+	}
+
+	id, err := newID()
+	if err != nil {
+		log.Printf("Error notifying %s: %v", event, err)
+		return
+	}
+	now := time.Now()
+	payload, err := json.Marshal(model.Notification{ID: id, Event: event, CreatedAt: now, Data: data})
+	if err != nil {
+		log.Printf("Error notifying %s: %v", event, err)
+		return
+	}
+
+	for _, endpoint := range endpoints {
+		deliveryID, err := newID()
+		if err != nil {
+			log.Printf("Error notifying %s to %s: %v", event, endpoint.Name, err)
+			continue
+		}
+		delivery := model.WebhookDelivery{
+			ID:          deliveryID,
+			Endpoint:    endpoint.Name,
+			URL:         endpoint.URL,
+			Event:       event,
+			Payload:     payload,
+			State:       model.DeliveryPending,
+			Attempts:    []model.WebhookAttempt{},
+			QueuedAt:    now,
+			NextAttempt: now,
+			CreatedAt:   now,
+		}
+		if err := s.repo.SaveWebhookDelivery(context.Background(), delivery); err != nil {
+			log.Printf("Error queueing %s notification to %s: %v", event, endpoint.Name, err)
+			continue
+		}
+		s.scheduleDelivery(delivery, 0)
+	}
+}
+
+// scheduleDelivery attempts a delivery after delay
+// Before StartWebhooks the delivery stays stored as pending and is resumed by it
+func (s *Service) scheduleDelivery(delivery model.WebhookDelivery, delay time.Duration) {
+	d := s.webhooks
+	if d.ctx == nil {
+		return
+	}
+	time.AfterFunc(max(delay, 0), func() {
+		select {
+		case <-d.ctx.Done():
+			return
+		case d.slots <- struct{}{}:
+		}
+		defer func() { <-d.slots }()
+		s.attemptDelivery(delivery)
+	})
+}
+
+// attemptDelivery posts a delivery once and stores the outcome
+// Failed attempts are scheduled again with an exponential backoff, until
+// webhooks.max_attempts have failed since the delivery was queued or a response shows
+// that sending it again will not help; the delivery is then dead-lettered
+func (s *Service) attemptDelivery(delivery model.WebhookDelivery) {
+	d := s.webhooks
+	attempt, retry, retryAfter := d.post(delivery)
+	attempt.DurationMs = time.Since(attempt.At).Milliseconds()
+	delivery.Attempts = append(delivery.Attempts, attempt)
+
+	failures := 0
+	for _, previous := range delivery.Attempts {
+		if !previous.At.Before(delivery.QueuedAt) {
+			failures++
+		}
+	}
+	switch {
+	case attempt.Error == "":
+		delivery.State = model.DeliveryDelivered
+		delivery.DeliveredAt = time.Now()
+		delivery.NextAttempt = time.Time{}
+	case !retry || failures >= d.cfg.MaxAttempts:
+		delivery.State = model.DeliveryDead
+		delivery.NextAttempt = time.Time{}
+		log.Printf("Dead-lettered %s notification %s to %s after %d attempts: %s",
+			delivery.Event, delivery.ID, delivery.Endpoint, failures, attempt.Error)
+	default:
+		delivery.NextAttempt = time.Now().Add(d.backoff(failures, retryAfter))
+	}
+
+	if err := s.repo.SaveWebhookDelivery(context.Background(), delivery); err != nil {
+		log.Printf("Error saving webhook delivery %s: %v", delivery.ID, err)
+	}
+	if delivery.State == model.DeliveryPending {
+		s.scheduleDelivery(delivery, time.Until(delivery.NextAttempt))
+	}
+}
+
+// post sends a delivery to its endpoint and reports whether a failure is worth retrying,
+// with the delay asked for in a Retry-After header if any
+// Network errors, timeouts, 408, 429 and 5xx responses are retried; other responses
+// outside 2xx mean the endpoint refuses the notification
+func (d *webhookDispatcher) post(delivery model.WebhookDelivery) (model.WebhookAttempt, bool, time.Duration) {
+	attempt := model.WebhookAttempt{At: time.Now()}
+
+	i := slices.IndexFunc(d.cfg.Endpoints, func(e config.WebhookEndpoint) bool { return e.Name == delivery.Endpoint })
+	if i < 0 {
+		attempt.Error = "endpoint is no longer configured"
+		return attempt, false, 0
+	}
+	endpoint := d.cfg.Endpoints[i]
+
+	ctx, cancel := context.WithTimeout(d.ctx, d.cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, false, 0
+	}
+	timestamp := strconv.FormatInt(attempt.At.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gomongoviz-webhooks")
+	req.Header.Set(HeaderWebhookEvent, delivery.Event)
+	req.Header.Set(HeaderWebhookDelivery, delivery.ID)
+	req.Header.Set(HeaderWebhookTimestamp, timestamp)
+	if endpoint.Secret != "" {
+		req.Header.Set(HeaderWebhookSignature, SignWebhook(endpoint.Secret, timestamp, delivery.Payload))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, true, 0
+	}
+	defer resp.Body.Close()
+	// Reading the body lets the connection be reused; only its start is kept
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return attempt, false, 0
+	}
+	attempt.Error = fmt.Sprintf("unexpected status %s", resp.Status)
+	if len(body) > 0 {
+		attempt.Error += ": " + string(bytes.TrimSpace(body))
+	}
+	retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests
+	return attempt, retry, time.Duration(retryAfter) * time.Second
+}
+
+// backoff returns the delay before the next attempt after failures failed attempts
+// The delay doubles from webhooks.initial_backoff up to webhooks.max_backoff; a longer
+// Retry-After given by the endpoint is honoured within the same limit
+func (d *webhookDispatcher) backoff(failures int, retryAfter time.Duration) time.Duration {
+	delay := d.cfg.InitialBackoff
+	for i := 1; i < failures && delay < d.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	delay = max(delay, retryAfter)
+	return min(delay, d.cfg.MaxBackoff)
+}
+
+// SignWebhook returns the value of the signature header of a webhook request
+// Receivers recompute it with the shared secret over the timestamp header, a dot and
+// the raw body, and compare it in constant time
+func SignWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
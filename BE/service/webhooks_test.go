@@ -0,0 +1,239 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"gomongoviz/config"
+	"gomongoviz/model"
+	"gomongoviz/repository"
+)
+
+// webhookRequest is a request received by a test endpoint
+type webhookRequest struct {
+	header http.Header
+	body   []byte
+	at     time.Time
+}
+
+// webhookReceiver is an HTTPS endpoint answering with a scripted series of statuses
+// and 200 once they run out
+type webhookReceiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	requests []webhookRequest
+}
+
+func newWebhookReceiver(t *testing.T, statuses ...int) *webhookReceiver {
+	r := &webhookReceiver{statuses: statuses}
+	r.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		r.requests = append(r.requests, webhookRequest{header: req.Header.Clone(), body: body, at: time.Now()})
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		r.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// received returns the requests received so far
+func (r *webhookReceiver) received() []webhookRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]webhookRequest(nil), r.requests...)
+}
+
+// newWebhookService returns a service on in-memory storage posting to the receiver
+// through its TLS client, with backoffs short enough for tests
+func newWebhookService(t *testing.T, receiver *webhookReceiver, endpoint config.WebhookEndpoint, maxAttempts int) *Service {
+	cfg := config.Default()
+	cfg.Storage.Backend = config.StorageMemory
+	endpoint.URL = receiver.URL
+	cfg.Webhooks.Endpoints = []config.WebhookEndpoint{endpoint}
+	cfg.Webhooks.MaxAttempts = maxAttempts
+	cfg.Webhooks.InitialBackoff = 20 * time.Millisecond
+	cfg.Webhooks.MaxBackoff = time.Second
+
+	s := NewService(repository.NewMemoryRepository(), cfg)
+	s.SetWebhookClient(receiver.Client())
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if err := s.StartWebhooks(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// waitForDelivery waits until the only delivery of the service is no longer pending
+func waitForDelivery(t *testing.T, s *Service) model.WebhookDelivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		deliveries, err := s.ListWebhookDeliveries(context.Background(), model.WebhookDeliveryQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deliveries) > 1 {
+			t.Fatalf("got %d deliveries, want 1", len(deliveries))
+		}
+		if len(deliveries) == 1 && deliveries[0].State != model.DeliveryPending {
+			return deliveries[0]
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("delivery still pending")
+	return model.WebhookDelivery{}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	s := newWebhookService(t, receiver, config.WebhookEndpoint{Name: "ops", Secret: "s3cret"}, 3)
+
+	s.notify(model.EventImportSucceeded, map[string]string{"id": "job-1"})
+	delivery := waitForDelivery(t, s)
+	if delivery.State != model.DeliveryDelivered || len(delivery.Attempts) != 1 {
+		t.Fatalf("got %+v, want delivered at the first attempt", delivery)
+	}
+
+	requests := receiver.received()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	req := requests[0]
+	timestamp := req.header.Get(HeaderWebhookTimestamp)
+	if sent, err := strconv.ParseInt(timestamp, 10, 64); err != nil || time.Since(time.Unix(sent, 0)) > time.Minute {
+		t.Errorf("timestamp header %q is not the current Unix time", timestamp)
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(timestamp + "." + string(req.body)))
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); req.header.Get(HeaderWebhookSignature) != want {
+		t.Errorf("signature %q, want %q", req.header.Get(HeaderWebhookSignature), want)
+	}
+	if got := req.header.Get(HeaderWebhookEvent); got != model.EventImportSucceeded {
+		t.Errorf("event header %q", got)
+	}
+	if got := req.header.Get(HeaderWebhookDelivery); got != delivery.ID {
+		t.Errorf("delivery header %q, want %q", got, delivery.ID)
+	}
+
+	var notification model.Notification
+	if err := json.Unmarshal(req.body, &notification); err != nil {
+		t.Fatal(err)
+	}
+	if notification.Event != model.EventImportSucceeded || notification.ID == "" {
+		t.Errorf("got notification %+v", notification)
+	}
+}
+
+func TestWebhookUnsigned(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	s := newWebhookService(t, receiver, config.WebhookEndpoint{Name: "ops"}, 3)
+
+	s.notify(model.EventImportFailed, nil)
+	waitForDelivery(t, s)
+	if got := receiver.received()[0].header.Get(HeaderWebhookSignature); got != "" {
+		t.Errorf("signature %q sent without a secret", got)
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusInternalServerError)
+	s := newWebhookService(t, receiver, config.WebhookEndpoint{Name: "ops", Secret: "s3cret"}, 5)
+
+	s.notify(model.EventImportSucceeded, nil)
+	delivery := waitForDelivery(t, s)
+	if delivery.State != model.DeliveryDelivered {
+		t.Fatalf("got state %s, want delivered", delivery.State)
+	}
+	wantStatuses := []int{503, 429, 500, 200}
+	if len(delivery.Attempts) != len(wantStatuses) {
+		t.Fatalf("got %d attempts, want %d", len(delivery.Attempts), len(wantStatuses))
+	}
+	for i, attempt := range delivery.Attempts {
+		if attempt.StatusCode != wantStatuses[i] || (attempt.Error == "") != (i == len(wantStatuses)-1) {
+			t.Errorf("attempt %d: got %+v, want status %d", i+1, attempt, wantStatuses[i])
+		}
+	}
+
+	// The delay doubles after every failure, and every attempt is signed again
+	requests := receiver.received()
+	for i := 1; i < len(requests); i++ {
+		want := 20 * time.Millisecond << (i - 1)
+		if gap := requests[i].at.Sub(requests[i-1].at); gap < want {
+			t.Errorf("attempt %d came %s after the previous one, want at least %s", i+1, gap, want)
+		}
+		if requests[i].header.Get(HeaderWebhookDelivery) != delivery.ID || requests[i].header.Get(HeaderWebhookSignature) == "" {
+			t.Errorf("attempt %d is not a signed attempt of the same delivery", i+1)
+		}
+	}
+}
+
+func TestWebhookDeadLetter(t *testing.T) {
+	t.Run("after max attempts", func(t *testing.T) {
+		receiver := newWebhookReceiver(t, 500, 502, 503, 504)
+		s := newWebhookService(t, receiver, config.WebhookEndpoint{Name: "ops"}, 3)
+
+		s.notify(model.EventImportSucceeded, nil)
+		delivery := waitForDelivery(t, s)
+		if delivery.State != model.DeliveryDead || len(delivery.Attempts) != 3 || !delivery.NextAttempt.IsZero() {
+			t.Fatalf("got %+v, want dead after 3 attempts", delivery)
+		}
+		time.Sleep(100 * time.Millisecond)
+		if n := len(receiver.received()); n != 3 {
+			t.Errorf("got %d requests, want no attempt after the last one", n)
+		}
+
+		dead, err := s.ListWebhookDeliveries(context.Background(), model.WebhookDeliveryQuery{States: []string{model.DeliveryDead}})
+		if err != nil || len(dead) != 1 {
+			t.Fatalf("got dead letters %v, %v, want the delivery", dead, err)
+		}
+
+		// Redelivery starts a fresh series of attempts
+		if _, err := s.RedeliverWebhook(context.Background(), delivery.ID); err != nil {
+			t.Fatal(err)
+		}
+		delivery = waitForDelivery(t, s)
+		if delivery.State != model.DeliveryDelivered || len(delivery.Attempts) != 5 {
+			t.Errorf("got %+v, want delivered at the second attempt after redelivery", delivery)
+		}
+	})
+
+	t.Run("refused", func(t *testing.T) {
+		receiver := newWebhookReceiver(t, http.StatusBadRequest)
+		s := newWebhookService(t, receiver, config.WebhookEndpoint{Name: "ops"}, 3)
+
+		s.notify(model.EventImportSucceeded, nil)
+		delivery := waitForDelivery(t, s)
+		if delivery.State != model.DeliveryDead || len(delivery.Attempts) != 1 || delivery.Attempts[0].StatusCode != http.StatusBadRequest {
+			t.Errorf("got %+v, want dead after the refused attempt", delivery)
+		}
+	})
+}
+
+func TestWebhookEvents(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	s := newWebhookService(t, receiver, config.WebhookEndpoint{Name: "ops", Events: []string{model.EventAlertFiring}}, 3)
+
+	s.notify(model.EventImportSucceeded, nil)
+	s.notify(model.EventAlertFiring, nil)
+	delivery := waitForDelivery(t, s)
+	if delivery.Event != model.EventAlertFiring {
+		t.Errorf("got a delivery of %s, want only the subscribed event", delivery.Event)
+	}
+}